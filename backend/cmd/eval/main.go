@@ -0,0 +1,89 @@
+// eval 运行场景评测：依次执行场景目录下的 YAML 场景并打分，可以与之前保存的报告对比
+//
+//	go run ./cmd/eval -scenarios ./eval/scenarios -baseline ./eval/baseline.json -out ./eval/report.json
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/eval"
+	"glata-backend/internal/service"
+	"glata-backend/pkg/logger"
+)
+
+func main() {
+	var (
+		configPath   string
+		scenarioDir  string
+		baselinePath string
+		outPath      string
+		filter       string
+		timeout      time.Duration
+	)
+	flag.StringVar(&configPath, "config", "./configs/config.yaml", "配置文件路径")
+	flag.StringVar(&scenarioDir, "scenarios", "./eval/scenarios", "场景目录")
+	flag.StringVar(&baselinePath, "baseline", "", "用于对比的基线报告，为空时不对比")
+	flag.StringVar(&outPath, "out", "", "评测报告输出路径，为空时不保存")
+	flag.StringVar(&filter, "run", "", "只运行名称包含该字符串的场景")
+	flag.DurationVar(&timeout, "timeout", 5*time.Minute, "场景未设置超时时使用的超时")
+	flag.Parse()
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := logger.Init(cfg.Log.Level, cfg.Log.Format); err != nil {
+		log.Fatalf("Failed to init logger: %v", err)
+	}
+
+	scenarios, err := eval.LoadScenarios(scenarioDir, filter)
+	if err != nil {
+		log.Fatalf("Failed to load scenarios: %v", err)
+	}
+	if len(scenarios) == 0 {
+		log.Fatalf("No scenarios found in %s", scenarioDir)
+	}
+
+	var baseline *eval.Report
+	if baselinePath != "" {
+		if baseline, err = eval.LoadReport(baselinePath); err != nil {
+			log.Fatalf("Failed to load baseline: %v", err)
+		}
+	}
+
+	runner, err := eval.NewRunner(timeout)
+	if err != nil {
+		log.Fatalf("Failed to prepare eval runner: %v", err)
+	}
+	defer runner.Close()
+
+	ctx := context.Background()
+	if err := service.InitPromptRegistry(ctx); err != nil {
+		log.Fatalf("Failed to init prompts: %v", err)
+	}
+	results := make([]*eval.Result, 0, len(scenarios))
+	for _, sc := range scenarios {
+		results = append(results, runner.Run(ctx, sc))
+	}
+
+	report := eval.NewReport(results)
+	report.Print(os.Stdout, baseline)
+
+	if outPath != "" {
+		if err := report.Save(outPath); err != nil {
+			log.Fatalf("Failed to save report: %v", err)
+		}
+		logger.Infof("🧪 Eval report saved to %s", outPath)
+	}
+
+	// 有失败的场景或相对基线退化时以非零状态退出，便于在CI中使用
+	if report.Failed > 0 || (baseline != nil && eval.HasRegression(report.Diff(baseline))) {
+		runner.Close()
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -13,6 +14,7 @@ import (
 	"glata-backend/internal/config"
 	"glata-backend/internal/handler"
 	"glata-backend/internal/service"
+	"glata-backend/internal/telemetry"
 	"glata-backend/pkg/logger"
 
 	"github.com/gin-contrib/cors"
@@ -42,12 +44,25 @@ func main() {
 		log.Fatalf("Failed to init logger: %v", err)
 	}
 
+	// 初始化链路追踪，未启用时不做任何设置
+	shutdownTelemetry, err := telemetry.Init(cfg.Telemetry)
+	if err != nil {
+		log.Fatalf("Failed to init telemetry: %v", err)
+	}
+
 	// 初始化服务
 	chatService := service.NewChatService(cfg)
 
 	// 初始化 Agent 存储（使用与聊天服务相同的存储实例）
 	service.InitAgentStorage(chatService.GetStorage())
 
+	// 加载提示注册表，提示文件修改后自动重新加载
+	promptCtx, stopPromptWatch := context.WithCancel(context.Background())
+	defer stopPromptWatch()
+	if err := service.InitPromptRegistry(promptCtx); err != nil {
+		log.Fatalf("Failed to init prompts: %v", err)
+	}
+
 	// 初始化处理器
 	chatHandler := handler.NewChatHandler(chatService)
 
@@ -80,6 +95,12 @@ func main() {
 	if err := server.Close(); err != nil {
 		logger.Errorf("服务器关闭失败: %v", err)
 	}
+	// 退出前导出剩余的span
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTelemetry(shutdownCtx); err != nil {
+		logger.Errorf("链路追踪关闭失败: %v", err)
+	}
 	logger.Info("服务器已关闭")
 }
 
@@ -88,10 +109,17 @@ func setupRouter(cfg *config.Config, chatHandler *handler.ChatHandler) *gin.Engi
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
+	// 未配置可信代理时不采信 X-Forwarded-For，客户端 IP 无法通过请求头伪造
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatalf("可信代理配置无效: %v", err)
+	}
 
 	// 中间件
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
+	if cfg.Telemetry.Enabled {
+		router.Use(telemetry.Middleware())
+	}
 
 	// CORS配置
 	corsConfig := cors.Config{
@@ -131,7 +159,37 @@ func setupRouter(cfg *config.Config, chatHandler *handler.ChatHandler) *gin.Engi
 			chat.PUT("/session/:session_id", chatHandler.UpdateSessionTitle)
 			// 新增渲染相关接口
 			chat.PUT("/message/:message_id/render", chatHandler.UpdateMessageRender)
+			chat.GET("/message/:message_id/trace", chatHandler.GetMessageTrace)
 			chat.GET("/session/:session_id/pending-renders", chatHandler.GetPendingRenders)
+			// 工具调用审批接口
+			chat.POST("/approval/:approval_id", chatHandler.ResolveApproval)
+			chat.GET("/session/:session_id/approvals", chatHandler.GetPendingApprovals)
+			// 运行记录与中断恢复接口
+			chat.GET("/session/:session_id/runs", chatHandler.GetSessionRuns)
+			// 计划查看与编辑接口
+			chat.GET("/session/:session_id/plan", chatHandler.GetSessionPlan)
+			chat.PUT("/session/:session_id/plan", chatHandler.UpdateSessionPlan)
+			chat.GET("/session/:session_id/plan/versions", chatHandler.GetPlanVersions)
+			chat.GET("/session/:session_id/plan/versions/:version", chatHandler.GetPlanVersion)
+			chat.GET("/session/:session_id/plan/diff", chatHandler.DiffPlanVersions)
+			chat.GET("/session/:session_id/artifacts/:artifact_id", chatHandler.GetArtifact)
+			chat.POST("/run/:run_id/resume", chatHandler.ResumeRun)
+			chat.POST("/run/:run_id/abort", chatHandler.AbortRun)
+			chat.POST("/session/:session_id/cancel", chatHandler.CancelRun)
+		}
+
+		// 长期记忆管理接口
+		memory := api.Group("/memory")
+		{
+			memory.GET("", chatHandler.ListMemories)
+			memory.PUT("/:memory_id", chatHandler.UpdateMemory)
+			memory.DELETE("/:memory_id", chatHandler.DeleteMemory)
+		}
+
+		// 管理接口，需要携带 server.admin_token
+		admin := api.Group("/admin", handler.AdminAuth(cfg.Server.AdminToken))
+		{
+			admin.GET("/scheduler", chatHandler.GetSchedulerMetrics)
 		}
 	}
 
@@ -16,12 +16,36 @@ type ModelConfig interface {
 	GetMaxTokens() int
 	GetTemperature() float32
 	GetTimeout() time.Duration
+	GetContextTokens() int
 	Validate() error
 }
 
 // ModelSelector 模型选择器
 type ModelSelector struct {
-	Provider string `mapstructure:"provider"` // doubao | openai | qwen
+	Provider string                      `mapstructure:"provider"` // doubao | openai | qwen
+	Stages   map[string]StageModelConfig `mapstructure:"stages"`   // 图的阶段 -> 模型，未配置的阶段使用 provider 的配置
+}
+
+// 图中使用模型的阶段
+const (
+	StagePlan    = "plan"    // 规划、重新规划
+	StageExecute = "execute" // 任务执行、意图专用子图
+	StageUpdate  = "update"  // 任务状态更新
+	StageSummary = "summary" // 总结、意图分析、上下文压缩、记忆提取
+)
+
+// ModelStages 所有使用模型的阶段
+var ModelStages = []string{StagePlan, StageExecute, StageUpdate, StageSummary}
+
+// StageModelConfig 单个阶段的模型配置，未填写的字段沿用所选提供商的配置
+type StageModelConfig struct {
+	Provider      string        `mapstructure:"provider"` // doubao | openai | qwen，为空时使用 model.provider
+	Model         string        `mapstructure:"model"`
+	MaxTokens     int           `mapstructure:"max_tokens"`
+	Temperature   *float32      `mapstructure:"temperature"`
+	TopP          *float32      `mapstructure:"top_p"` // 仅 qwen 生效
+	Timeout       time.Duration `mapstructure:"timeout"`
+	ContextTokens int           `mapstructure:"context_tokens"`
 }
 
 type Config struct {
@@ -36,6 +60,7 @@ type Config struct {
 	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
 	Session   SessionConfig   `mapstructure:"session"`
 	Storage   StorageConfig   `mapstructure:"storage"`
+	Telemetry TelemetryConfig `mapstructure:"telemetry"`
 }
 
 type ServerConfig struct {
@@ -43,6 +68,8 @@ type ServerConfig struct {
 	ReadTimeout    time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout   time.Duration `mapstructure:"write_timeout"`
 	MaxHeaderBytes int           `mapstructure:"max_header_bytes"`
+	TrustedProxies []string      `mapstructure:"trusted_proxies"` // 可信的反向代理，只采信来自这些地址的 X-Forwarded-For，未配置时客户端 IP 取连接的对端地址
+	AdminToken     string        `mapstructure:"admin_token"`     // 管理接口的访问令牌，未配置时管理接口不可用
 }
 
 type DoubaoConfig struct {
@@ -52,6 +79,7 @@ type DoubaoConfig struct {
 	MaxTokens   int           `mapstructure:"max_tokens"`
 	Temperature float32       `mapstructure:"temperature"`
 	Timeout     time.Duration `mapstructure:"timeout"`
+	ContextTokens int         `mapstructure:"context_tokens"` // 模型上下文窗口大小
 }
 
 // OpenAIConfig OpenAI模型配置
@@ -62,6 +90,7 @@ type OpenAIConfig struct {
 	MaxTokens   int           `mapstructure:"max_tokens"`
 	Temperature float32       `mapstructure:"temperature"`
 	Timeout     time.Duration `mapstructure:"timeout"`
+	ContextTokens int         `mapstructure:"context_tokens"` // 模型上下文窗口大小
 }
 
 // QwenConfig Qwen模型配置
@@ -72,6 +101,7 @@ type QwenConfig struct {
 	MaxTokens   int           `mapstructure:"max_tokens"`
 	Temperature float32       `mapstructure:"temperature"`
 	Timeout     time.Duration `mapstructure:"timeout"`
+	ContextTokens int         `mapstructure:"context_tokens"` // 模型上下文窗口大小
 	TopP        float32       `mapstructure:"top_p"`        // Qwen特有参数
 	DebugRequest bool         `mapstructure:"debug_request"` // 调试请求开关
 }
@@ -82,14 +112,123 @@ type AgentConfig struct {
 	PlanPrompt            string `mapstructure:"plan_prompt"`
 	ExecutePrompt         string `mapstructure:"execute_prompt"`
 	UpdateTodoListPrompt  string `mapstructure:"update_todo_list_prompt"`
+	ReplanPrompt          string `mapstructure:"replan_prompt"`
 	SummaryPrompt         string `mapstructure:"summary_prompt"`
+	ContextSummaryPrompt  string `mapstructure:"context_summary_prompt"`
+	ContextKeepRecent     int    `mapstructure:"context_keep_recent"`
 	IntentAnalysisPrompt  string `mapstructure:"intent_analysis_prompt"`
+	IntentRouting         IntentRoutingConfig `mapstructure:"intent_routing"`
+	MaxParallelTasks      int    `mapstructure:"max_parallel_tasks"`
+	ReplanEveryNTasks     int    `mapstructure:"replan_every_n_tasks"`
+	MaxReplans            int    `mapstructure:"max_replans"`
+	ToolApproval          ToolApprovalConfig `mapstructure:"tool_approval"`
+	ToolBudget            ToolBudgetConfig   `mapstructure:"tool_budget"`
+	LoopDetection         LoopDetectionConfig `mapstructure:"loop_detection"`
+	ToolOutput            ToolOutputConfig    `mapstructure:"tool_output"`
+	Trace                 TraceConfig         `mapstructure:"trace"`
+	Replay                ReplayConfig        `mapstructure:"replay"`
+	Prompts               PromptsConfig       `mapstructure:"prompts"`
+	SessionRun            SessionRunConfig    `mapstructure:"session_run"`
+	Scheduler             SchedulerConfig     `mapstructure:"scheduler"`
 	EnableTools           bool   `mapstructure:"enable_tools"`
 	EnableMemory          bool   `mapstructure:"enable_memory"`
+	MemoryExtractPrompt   string `mapstructure:"memory_extract_prompt"`
+	MemoryMaxInject       int    `mapstructure:"memory_max_inject"`
 	LogDetail             bool   `mapstructure:"log_detail"`
 	LogDebug              bool   `mapstructure:"log_debug"`
 }
 
+// IntentRoutingConfig 意图路由配置，命中意图的请求交给专用子图处理，未命中时使用通用的规划执行流程
+type IntentRoutingConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Routes  []IntentRoute `mapstructure:"routes"`
+}
+
+// IntentRoute 单个意图的专用子图配置
+type IntentRoute struct {
+	Name         string   `mapstructure:"name"`
+	Description  string   `mapstructure:"description"`    // 提供给意图分析模型的意图说明
+	Prompt       string   `mapstructure:"prompt"`         // 专用子图的系统提示
+	Tools        []string `mapstructure:"tools"`          // 专用子图可以使用的工具
+	MaxToolCalls int      `mapstructure:"max_tool_calls"` // 专用子图的工具调用上限
+}
+
+// TraceConfig 执行追踪配置
+type TraceConfig struct {
+	Enabled       bool `mapstructure:"enabled"`
+	MaxFieldChars int  `mapstructure:"max_field_chars"` // 单个输入、输出字段保存的最大字符数
+}
+
+// ReplayConfig 录制与回放配置
+type ReplayConfig struct {
+	Mode     string `mapstructure:"mode"`     // off | record | replay
+	Dir      string `mapstructure:"dir"`      // 录音目录，默认为 {data_dir}/cassettes
+	Cassette string `mapstructure:"cassette"` // replay 模式下回放的录音文件，相对于录音目录
+	Strict   bool   `mapstructure:"strict"`   // 回放时只接受请求指纹完全相同的录音
+}
+
+// PromptsConfig 提示注册表配置，目录中存在的提示优先于上面的同名配置项
+type PromptsConfig struct {
+	Dir            string        `mapstructure:"dir"`             // 提示目录，默认为 ./prompts
+	ReloadInterval time.Duration `mapstructure:"reload_interval"` // 检查提示文件变化的间隔，默认 10s
+}
+
+// SessionRunConfig 同一会话同时只执行一个运行，运行期间收到新请求时的处理方式
+type SessionRunConfig struct {
+	Policy       string        `mapstructure:"policy"`        // queue（默认）| reject | interrupt
+	QueueTimeout time.Duration `mapstructure:"queue_timeout"` // 排队等待的最长时间，默认 10m
+}
+
+// SchedulerConfig 全局运行调度配置，限制同时执行的运行数，超出的运行排队
+type SchedulerConfig struct {
+	Workers      int            `mapstructure:"workers"`       // 同时执行的运行数，默认 8
+	QueueSize    int            `mapstructure:"queue_size"`    // 排队的运行数上限，默认 100，队列满时返回 503
+	PerUser      int            `mapstructure:"per_user"`      // 每个用户同时执行的运行数，0 表示不限制
+	PerProvider  map[string]int `mapstructure:"per_provider"`  // 每个模型提供商同时执行的运行数，未配置的提供商不限制
+	QueueTimeout time.Duration  `mapstructure:"queue_timeout"` // 排队等待的最长时间，默认 10m
+}
+
+// ToolApprovalConfig 工具调用审批策略配置
+type ToolApprovalConfig struct {
+	DefaultPolicy string            `mapstructure:"default_policy"` // auto | require_approval | deny
+	Timeout       time.Duration     `mapstructure:"timeout"`        // 等待审批的超时时间，超时视为拒绝
+	Policies      map[string]string `mapstructure:"policies"`       // 工具名 -> 策略
+}
+
+// ToolBudgetConfig 工具调用次数预算配置
+type ToolBudgetConfig struct {
+	DefaultMaxCalls int                  `mapstructure:"default_max_calls"` // 未匹配到类别的任务的调用上限
+	MaxCallsPerRun  int                  `mapstructure:"max_calls_per_run"` // 单次运行所有任务的调用总上限
+	Categories      []ToolBudgetCategory `mapstructure:"categories"`        // 按顺序匹配，第一个命中的类别生效
+}
+
+// ToolBudgetCategory 按任务标题关键词划分的任务类别
+type ToolBudgetCategory struct {
+	Name     string   `mapstructure:"name"`
+	MaxCalls int      `mapstructure:"max_calls"`
+	Keywords []string `mapstructure:"keywords"`
+}
+
+// LoopDetectionConfig 重复工具调用检测配置
+type LoopDetectionConfig struct {
+	Enabled         bool `mapstructure:"enabled"`
+	RepeatThreshold int  `mapstructure:"repeat_threshold"` // 同一调用在窗口内出现的次数达到该值视为循环
+	Window          int  `mapstructure:"window"`           // 参与检测的最近工具调用数
+	MaxCorrections  int  `mapstructure:"max_corrections"`  // 纠正提示的次数上限，之后再出现循环直接判定任务失败，未配置时为1
+}
+
+// ToolOutputConfig 工具输出处理配置
+type ToolOutputConfig struct {
+	Default ToolOutputLimit            `mapstructure:"default"`
+	Tools   map[string]ToolOutputLimit `mapstructure:"tools"` // 工具名 -> 限制，未配置的字段使用默认值
+}
+
+// ToolOutputLimit 单个工具的输出限制，超出 MaxModelChars 时完整结果保存为 artifact
+type ToolOutputLimit struct {
+	MaxModelChars  int `mapstructure:"max_model_chars"`  // 进入模型上下文的最大字符数
+	MaxStreamChars int `mapstructure:"max_stream_chars"` // 推送给前端的最大字符数
+}
+
 type CORSConfig struct {
 	AllowedOrigins   []string `mapstructure:"allowed_origins"`
 	AllowedMethods   []string `mapstructure:"allowed_methods"`
@@ -104,6 +243,19 @@ type LogConfig struct {
 	Format string `mapstructure:"format"`
 }
 
+// TelemetryConfig OpenTelemetry 链路追踪配置
+type TelemetryConfig struct {
+	Enabled           bool              `mapstructure:"enabled"`
+	ServiceName       string            `mapstructure:"service_name"`
+	Exporter          string            `mapstructure:"exporter"`            // otlp | stdout | file
+	Endpoint          string            `mapstructure:"endpoint"`            // OTLP/HTTP 接收地址，如 localhost:4318
+	Insecure          bool              `mapstructure:"insecure"`            // 使用 http 而不是 https
+	Headers           map[string]string `mapstructure:"headers"`             // OTLP 请求头，如认证信息
+	FilePath          string            `mapstructure:"file_path"`           // file 导出器写入的文件
+	SampleRatio       float64           `mapstructure:"sample_ratio"`        // 采样比例，0 或不填表示全部采样
+	MaxAttributeChars int               `mapstructure:"max_attribute_chars"` // 工具参数等文本属性的最大字符数
+}
+
 type RateLimitConfig struct {
 	Enabled            bool `mapstructure:"enabled"`
 	RequestsPerMinute  int  `mapstructure:"requests_per_minute"`
@@ -162,6 +314,10 @@ func Load(configPath string) (*Config, error) {
 		}
 	}
 	
+	if cfg.Server.AdminToken == "" {
+		cfg.Server.AdminToken = os.Getenv("ADMIN_TOKEN")
+	}
+	
 	// 配置验证
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
@@ -185,14 +341,15 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("unsupported model provider: %s, supported providers: %v", c.Model.Provider, supportedProviders)
 	}
 	
-	// 验证对应模型的配置
-	switch c.Model.Provider {
-	case "doubao":
-		return c.Doubao.Validate()
-	case "openai":
-		return c.OpenAI.Validate()
-	case "qwen":
-		return c.Qwen.Validate()
+	// 验证各阶段使用的模型提供商及其配置
+	for _, stage := range ModelStages {
+		provider, modelCfg := c.StageModel(stage)
+		if modelCfg == nil {
+			return fmt.Errorf("unsupported model provider for stage %s: %s, supported providers: %v", stage, provider, supportedProviders)
+		}
+		if err := modelCfg.Validate(); err != nil {
+			return fmt.Errorf("stage %s: %w", stage, err)
+		}
 	}
 	
 	return nil
@@ -202,6 +359,54 @@ func Get() *Config {
 	return cfg
 }
 
+// StageModel 返回阶段使用的模型提供商及合并了阶段覆盖项的配置，提供商不支持时配置为 nil
+func (c *Config) StageModel(stage string) (string, ModelConfig) {
+	stageCfg := c.Model.Stages[stage]
+	provider := c.Model.Provider
+	if stageCfg.Provider != "" {
+		provider = stageCfg.Provider
+	}
+
+	switch provider {
+	case "doubao":
+		m := c.Doubao
+		stageCfg.apply(&m.Model, &m.MaxTokens, &m.Temperature, &m.Timeout, &m.ContextTokens)
+		return provider, m
+	case "openai":
+		m := c.OpenAI
+		stageCfg.apply(&m.Model, &m.MaxTokens, &m.Temperature, &m.Timeout, &m.ContextTokens)
+		return provider, m
+	case "qwen":
+		m := c.Qwen
+		stageCfg.apply(&m.Model, &m.MaxTokens, &m.Temperature, &m.Timeout, &m.ContextTokens)
+		if stageCfg.TopP != nil {
+			m.TopP = *stageCfg.TopP
+		}
+		return provider, m
+	default:
+		return provider, nil
+	}
+}
+
+// apply 用阶段配置中填写的字段覆盖提供商的配置
+func (s StageModelConfig) apply(model *string, maxTokens *int, temperature *float32, timeout *time.Duration, contextTokens *int) {
+	if s.Model != "" {
+		*model = s.Model
+	}
+	if s.MaxTokens > 0 {
+		*maxTokens = s.MaxTokens
+	}
+	if s.Temperature != nil {
+		*temperature = *s.Temperature
+	}
+	if s.Timeout > 0 {
+		*timeout = s.Timeout
+	}
+	if s.ContextTokens > 0 {
+		*contextTokens = s.ContextTokens
+	}
+}
+
 // DoubaoConfig 实现 ModelConfig 接口
 func (d DoubaoConfig) GetAPIKey() string     { return d.APIKey }
 func (d DoubaoConfig) GetBaseURL() string    { return d.BaseURL }
@@ -209,6 +414,7 @@ func (d DoubaoConfig) GetModel() string      { return d.Model }
 func (d DoubaoConfig) GetMaxTokens() int     { return d.MaxTokens }
 func (d DoubaoConfig) GetTemperature() float32 { return d.Temperature }
 func (d DoubaoConfig) GetTimeout() time.Duration { return d.Timeout }
+func (d DoubaoConfig) GetContextTokens() int { return d.ContextTokens }
 
 func (d DoubaoConfig) Validate() error {
 	if d.APIKey == "" {
@@ -227,6 +433,7 @@ func (o OpenAIConfig) GetModel() string      { return o.Model }
 func (o OpenAIConfig) GetMaxTokens() int     { return o.MaxTokens }
 func (o OpenAIConfig) GetTemperature() float32 { return o.Temperature }
 func (o OpenAIConfig) GetTimeout() time.Duration { return o.Timeout }
+func (o OpenAIConfig) GetContextTokens() int { return o.ContextTokens }
 
 func (o OpenAIConfig) Validate() error {
 	if o.APIKey == "" {
@@ -245,6 +452,7 @@ func (q QwenConfig) GetModel() string      { return q.Model }
 func (q QwenConfig) GetMaxTokens() int     { return q.MaxTokens }
 func (q QwenConfig) GetTemperature() float32 { return q.Temperature }
 func (q QwenConfig) GetTimeout() time.Duration { return q.Timeout }
+func (q QwenConfig) GetContextTokens() int { return q.ContextTokens }
 
 func (q QwenConfig) Validate() error {
 	if q.APIKey == "" {
@@ -0,0 +1,135 @@
+package eval
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"glata-backend/internal/model"
+)
+
+// CheckResult 单项期望的检查结果
+type CheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// check 按场景的期望逐项检查运行结果
+func check(expect Expectations, result *Result) []CheckResult {
+	var checks []CheckResult
+	add := func(name string, passed bool, format string, args ...interface{}) {
+		checks = append(checks, CheckResult{Name: name, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	// 运行本身出错时也算一项检查，避免只因没有配置期望而通过
+	add("run", result.Error == "", "%s", result.Error)
+
+	counts := make(map[string]int)
+	for _, call := range result.ToolCalls {
+		counts[call.Name]++
+	}
+
+	if expect.Intent != "" {
+		add("intent", result.Intent == expect.Intent, "expected %q, got %q", expect.Intent, result.Intent)
+	}
+	for _, name := range expect.ToolsCalled {
+		add("tool_called:"+name, counts[name] > 0, "called %d times", counts[name])
+	}
+	for _, name := range expect.ToolsNotCalled {
+		add("tool_not_called:"+name, counts[name] == 0, "called %d times", counts[name])
+	}
+	if expect.MaxToolCalls != nil {
+		total := len(result.ToolCalls)
+		add("max_tool_calls", total <= *expect.MaxToolCalls, "%d calls, limit %d", total, *expect.MaxToolCalls)
+	}
+	for name, fragment := range expect.ToolArgs {
+		add("tool_args:"+name, toolArgsContain(result.ToolCalls, name, fragment), "arguments should contain %q", fragment)
+	}
+
+	if expect.Plan != nil {
+		checks = append(checks, checkPlan(expect.Plan, result.Plan)...)
+	}
+	if expect.Summary != nil {
+		checks = append(checks, checkSummary(expect.Summary, result.Summary)...)
+	}
+	return checks
+}
+
+func toolArgsContain(calls []ToolCall, name, fragment string) bool {
+	for _, call := range calls {
+		if call.Name == name && strings.Contains(call.Arguments, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPlan 检查最终计划的状态
+func checkPlan(expect *PlanExpect, plan *model.Plan) []CheckResult {
+	var checks []CheckResult
+	if expect.Exists != nil {
+		exists := plan != nil
+		checks = append(checks, CheckResult{Name: "plan_exists", Passed: exists == *expect.Exists,
+			Detail: fmt.Sprintf("expected exists=%v, got %v", *expect.Exists, exists)})
+		if !exists {
+			return checks
+		}
+	}
+	if plan == nil {
+		if expect.MinTasks > 0 || expect.MaxTasks > 0 || expect.AllDone || expect.NoFailedTasks {
+			checks = append(checks, CheckResult{Name: "plan", Passed: false, Detail: "no plan was generated"})
+		}
+		return checks
+	}
+
+	count := len(plan.Tasks)
+	if expect.MinTasks > 0 {
+		checks = append(checks, CheckResult{Name: "plan_min_tasks", Passed: count >= expect.MinTasks,
+			Detail: fmt.Sprintf("%d tasks, expected at least %d", count, expect.MinTasks)})
+	}
+	if expect.MaxTasks > 0 {
+		checks = append(checks, CheckResult{Name: "plan_max_tasks", Passed: count <= expect.MaxTasks,
+			Detail: fmt.Sprintf("%d tasks, expected at most %d", count, expect.MaxTasks)})
+	}
+
+	var notDone, failed []string
+	for _, task := range plan.Tasks {
+		if task.Status != model.TaskDone {
+			notDone = append(notDone, fmt.Sprintf("%s(%s)", task.Title, task.Status))
+		}
+		if task.Status == model.TaskFailed {
+			failed = append(failed, task.Title)
+		}
+	}
+	if expect.AllDone {
+		checks = append(checks, CheckResult{Name: "plan_all_done", Passed: len(notDone) == 0,
+			Detail: strings.Join(notDone, ", ")})
+	}
+	if expect.NoFailedTasks {
+		checks = append(checks, CheckResult{Name: "plan_no_failed_tasks", Passed: len(failed) == 0,
+			Detail: strings.Join(failed, ", ")})
+	}
+	return checks
+}
+
+// checkSummary 检查最终回复的内容
+func checkSummary(expect *SummaryExpect, summary string) []CheckResult {
+	var checks []CheckResult
+	for _, s := range expect.Contains {
+		checks = append(checks, CheckResult{Name: "summary_contains:" + s, Passed: strings.Contains(summary, s)})
+	}
+	for _, s := range expect.NotContains {
+		checks = append(checks, CheckResult{Name: "summary_not_contains:" + s, Passed: !strings.Contains(summary, s)})
+	}
+	for _, pattern := range expect.Matches {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			checks = append(checks, CheckResult{Name: "summary_matches:" + pattern, Passed: false,
+				Detail: fmt.Sprintf("invalid pattern: %v", err)})
+			continue
+		}
+		checks = append(checks, CheckResult{Name: "summary_matches:" + pattern, Passed: re.MatchString(summary)})
+	}
+	return checks
+}
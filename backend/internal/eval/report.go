@@ -0,0 +1,229 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"glata-backend/internal/model"
+)
+
+// Result 一个场景的评测结果，Score 为通过的检查项占比
+type Result struct {
+	Name       string        `json:"name"`
+	File       string        `json:"file"`
+	Passed     bool          `json:"passed"`
+	Score      float64       `json:"score"`
+	DurationMs int64         `json:"duration_ms"`
+	Error      string        `json:"error,omitempty"`
+	Checks     []CheckResult `json:"checks"`
+	Intent     string        `json:"intent,omitempty"`
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`
+	Summary    string        `json:"summary,omitempty"`
+	Plan       *model.Plan   `json:"plan,omitempty"`
+
+	PromptVersions map[string]string `json:"prompt_versions,omitempty"` // 本次运行使用的提示版本
+}
+
+// finish 根据检查项计算是否通过和得分
+func (r *Result) finish() {
+	if len(r.Checks) == 0 {
+		r.Checks = []CheckResult{{Name: "run", Passed: r.Error == "", Detail: r.Error}}
+	}
+	passed := 0
+	for _, c := range r.Checks {
+		if c.Passed {
+			passed++
+		}
+	}
+	r.Score = float64(passed) / float64(len(r.Checks))
+	r.Passed = passed == len(r.Checks)
+}
+
+// Report 一次评测的全部结果
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Passed      int       `json:"passed"`
+	Failed      int       `json:"failed"`
+	Results     []*Result `json:"results"`
+}
+
+// NewReport 汇总场景结果
+func NewReport(results []*Result) *Report {
+	report := &Report{GeneratedAt: time.Now(), Results: results}
+	for _, r := range results {
+		if r.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// Result 按场景名查找结果
+func (r *Report) Result(name string) *Result {
+	for _, result := range r.Results {
+		if result.Name == name {
+			return result
+		}
+	}
+	return nil
+}
+
+// LoadReport 读取之前保存的报告，用作对比的基线
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report %s: %w", path, err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// Save 写入报告，先写临时文件再重命名，避免中断时留下不完整的基线
+func (r *Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create report directory: %w", err)
+		}
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 场景相对基线的变化
+const (
+	ChangeRegressed = "regressed" // 基线通过，本次失败
+	ChangeFixed     = "fixed"     // 基线失败，本次通过
+	ChangeNew       = "new"       // 基线中没有的场景
+	ChangeRemoved   = "removed"   // 本次没有运行的场景
+)
+
+// ScenarioDiff 一个场景相对基线的差异，只记录有变化的场景
+type ScenarioDiff struct {
+	Name          string   `json:"name"`
+	Change        string   `json:"change,omitempty"`
+	BaselineScore float64  `json:"baseline_score"`
+	Score         float64  `json:"score"`
+	NewFailures   []string `json:"new_failures,omitempty"` // 基线中通过、本次失败的检查项
+	NewPasses     []string `json:"new_passes,omitempty"`   // 基线中失败、本次通过的检查项
+}
+
+// Diff 与基线报告对比
+func (r *Report) Diff(baseline *Report) []ScenarioDiff {
+	var diffs []ScenarioDiff
+	for _, result := range r.Results {
+		base := baseline.Result(result.Name)
+		if base == nil {
+			diffs = append(diffs, ScenarioDiff{Name: result.Name, Change: ChangeNew, Score: result.Score})
+			continue
+		}
+
+		diff := ScenarioDiff{Name: result.Name, BaselineScore: base.Score, Score: result.Score}
+		switch {
+		case base.Passed && !result.Passed:
+			diff.Change = ChangeRegressed
+		case !base.Passed && result.Passed:
+			diff.Change = ChangeFixed
+		}
+
+		baseChecks := make(map[string]bool, len(base.Checks))
+		for _, c := range base.Checks {
+			baseChecks[c.Name] = c.Passed
+		}
+		for _, c := range result.Checks {
+			basePassed, existed := baseChecks[c.Name]
+			if !existed {
+				continue
+			}
+			if basePassed && !c.Passed {
+				diff.NewFailures = append(diff.NewFailures, c.Name)
+			}
+			if !basePassed && c.Passed {
+				diff.NewPasses = append(diff.NewPasses, c.Name)
+			}
+		}
+
+		if diff.Change != "" || diff.Score != diff.BaselineScore || len(diff.NewFailures) > 0 || len(diff.NewPasses) > 0 {
+			diffs = append(diffs, diff)
+		}
+	}
+
+	for _, base := range baseline.Results {
+		if r.Result(base.Name) == nil {
+			diffs = append(diffs, ScenarioDiff{Name: base.Name, Change: ChangeRemoved, BaselineScore: base.Score})
+		}
+	}
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+// HasRegression 对比结果中是否有退化的场景
+func HasRegression(diffs []ScenarioDiff) bool {
+	for _, d := range diffs {
+		if d.Change == ChangeRegressed || len(d.NewFailures) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Print 输出可读的评测结果，baseline 不为空时附带与基线的差异
+func (r *Report) Print(w io.Writer, baseline *Report) {
+	for _, result := range r.Results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s  %-40s score=%.2f  %dms\n", status, result.Name, result.Score, result.DurationMs)
+		for _, c := range result.Checks {
+			if c.Passed {
+				continue
+			}
+			if c.Detail != "" {
+				fmt.Fprintf(w, "      ✗ %s: %s\n", c.Name, c.Detail)
+			} else {
+				fmt.Fprintf(w, "      ✗ %s\n", c.Name)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed\n", r.Passed, r.Failed)
+
+	if baseline == nil {
+		return
+	}
+	diffs := r.Diff(baseline)
+	fmt.Fprintf(w, "\nCompared with baseline generated at %s:\n", baseline.GeneratedAt.Format(time.RFC3339))
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, "  no changes")
+		return
+	}
+	for _, d := range diffs {
+		change := d.Change
+		if change == "" {
+			change = "changed"
+		}
+		fmt.Fprintf(w, "  %-9s %-40s score %.2f -> %.2f\n", change, d.Name, d.BaselineScore, d.Score)
+		for _, name := range d.NewFailures {
+			fmt.Fprintf(w, "      - %s\n", name)
+		}
+		for _, name := range d.NewPasses {
+			fmt.Fprintf(w, "      + %s\n", name)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/service"
+	"glata-backend/internal/storage"
+	"glata-backend/internal/tools"
+	"glata-backend/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// 未设置超时的场景使用的默认超时
+const defaultScenarioTimeout = 5 * time.Minute
+
+// ToolCall 场景运行中的一次工具调用
+type ToolCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Mocked    bool   `json:"mocked"` // 是否命中了场景中的模拟结果
+}
+
+// Runner 依次执行评测场景
+// 评测使用内存存储和临时数据目录，工具调用全部由场景模拟，不会执行真实的工具，也不需要审批
+type Runner struct {
+	timeout time.Duration
+	dataDir string
+}
+
+// NewRunner 准备评测环境，必须在 config.Load 之后调用；timeout 为场景未设置超时时使用的超时
+// 会修改全局配置：关闭录制与回放、工具审批全部自动通过、数据目录指向临时目录
+func NewRunner(timeout time.Duration) (*Runner, error) {
+	cfg := config.Get()
+	if cfg == nil {
+		return nil, fmt.Errorf("config is not loaded")
+	}
+
+	dataDir, err := os.MkdirTemp("", "glata-eval-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create eval data directory: %w", err)
+	}
+	cfg.Storage.DataDir = dataDir
+	cfg.Agent.Replay.Mode = "off"
+	cfg.Agent.ToolApproval.DefaultPolicy = "auto"
+	cfg.Agent.ToolApproval.Policies = nil
+
+	if timeout <= 0 {
+		timeout = defaultScenarioTimeout
+	}
+	return &Runner{timeout: timeout, dataDir: dataDir}, nil
+}
+
+// Close 删除评测使用的临时数据目录
+func (r *Runner) Close() error {
+	return os.RemoveAll(r.dataDir)
+}
+
+// Run 执行一个场景并检查期望，运行失败也会返回结果，错误记录在 Result.Error 中
+func (r *Runner) Run(ctx context.Context, sc *Scenario) *Result {
+	start := time.Now()
+	result := &Result{Name: sc.Name, File: sc.File}
+	defer func() {
+		result.DurationMs = time.Since(start).Milliseconds()
+	}()
+
+	logger.Infof("🧪 Running scenario %s", sc.Name)
+
+	sessionID := uuid.New().String()
+	store, err := r.prepareSession(sessionID, sc)
+	if err != nil {
+		result.Error = err.Error()
+		result.finish()
+		return result
+	}
+
+	mock := newToolMock(sc.Tools)
+	timeout := sc.Timeout
+	if timeout <= 0 {
+		timeout = r.timeout
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	messageID := uuid.New().String()
+	_, progressChan, err := service.RunAgent(service.WithToolInterceptor(runCtx, mock.intercept), sessionID, messageID, sc.Query, nil)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to start agent: %v", err)
+		result.finish()
+		return result
+	}
+
+	var summary strings.Builder
+	var runErrors []string
+loop:
+	for {
+		select {
+		case event, ok := <-progressChan:
+			if !ok {
+				break loop
+			}
+			switch event.EventType {
+			case "result_chunk":
+				summary.WriteString(event.Message)
+			case "intent_classified":
+				if intent, ok := event.Data["intent"].(string); ok {
+					result.Intent = intent
+				}
+			case "error":
+				runErrors = append(runErrors, strings.TrimSpace(event.Message+" "+event.Error))
+			}
+		case <-runCtx.Done():
+			// 超时后取消运行，继续读取进度直到通道关闭，保证运行结束后再开始下一个场景
+			if _, err := service.CancelRun(sessionID, ""); err != nil {
+				logger.Warnf("Failed to cancel scenario %s: %v", sc.Name, err)
+			}
+			runErrors = append(runErrors, fmt.Sprintf("scenario timed out after %s", timeout))
+			for range progressChan {
+			}
+			break loop
+		}
+	}
+
+	result.Summary = summary.String()
+	result.ToolCalls = mock.calls()
+	if plan, err := service.GetSessionPlan(sessionID); err == nil {
+		result.Plan = plan
+	}
+	if run, err := store.GetRun(messageID); err == nil {
+		result.PromptVersions = run.PromptVersions
+	}
+	if len(runErrors) > 0 {
+		result.Error = strings.Join(runErrors, "; ")
+	}
+
+	result.Checks = check(sc.Expect, result)
+	result.finish()
+	logger.Infof("🧪 Scenario %s finished: passed=%v score=%.2f", sc.Name, result.Passed, result.Score)
+	return result
+}
+
+// prepareSession 为每个场景使用新的内存存储，创建会话并写入场景预置的历史消息
+func (r *Runner) prepareSession(sessionID string, sc *Scenario) (storage.Storage, error) {
+	store := storage.NewMemoryStorage()
+	service.InitAgentStorage(store)
+
+	now := time.Now()
+	if err := store.CreateSession(&model.Session{ID: sessionID, Title: sc.Name, CreatedAt: now, UpdatedAt: now}); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	for i, msg := range sc.History {
+		role := strings.ToLower(msg.Role)
+		if role != "user" && role != "assistant" {
+			return nil, fmt.Errorf("history message %d has unsupported role %q", i, msg.Role)
+		}
+		err := store.AddMessage(sessionID, &model.Message{
+			ID:          uuid.New().String(),
+			SessionID:   sessionID,
+			Role:        role,
+			Content:     msg.Content,
+			ContentType: "content",
+			Timestamp:   now.Add(time.Duration(i-len(sc.History)) * time.Second),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add history message: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// toolMock 按场景返回模拟的工具结果并记录调用
+type toolMock struct {
+	mocks map[string][]ToolMock
+
+	mu     sync.Mutex
+	called []ToolCall
+}
+
+func newToolMock(mocks map[string][]ToolMock) *toolMock {
+	return &toolMock{mocks: mocks}
+}
+
+// intercept 拦截所有工具调用，没有模拟结果的工具返回失败结果，避免评测执行真实的工具
+func (m *toolMock) intercept(ctx context.Context, toolName, argumentsInJSON string) (string, bool, error) {
+	mock, ok := m.match(toolName, argumentsInJSON)
+
+	m.mu.Lock()
+	m.called = append(m.called, ToolCall{Name: toolName, Arguments: argumentsInJSON, Mocked: ok})
+	m.mu.Unlock()
+
+	if !ok {
+		return tools.NewErrorResult(toolName, tools.ErrCodeNotFound, "评测场景没有为该工具提供模拟结果", false).String(), true, nil
+	}
+	if mock.Error != "" {
+		code := mock.ErrorCode
+		if code == "" {
+			code = tools.ErrCodeUpstreamError
+		}
+		return tools.NewErrorResult(toolName, code, mock.Error, mock.Retryable).String(), true, nil
+	}
+	return tools.NewSuccessResult(toolName, responsePayload(mock.Response)).String(), true, nil
+}
+
+func (m *toolMock) match(toolName, argumentsInJSON string) (ToolMock, bool) {
+	for _, mock := range m.mocks[toolName] {
+		if mock.Match == "" || strings.Contains(argumentsInJSON, mock.Match) {
+			return mock, true
+		}
+	}
+	return ToolMock{}, false
+}
+
+func (m *toolMock) calls() []ToolCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ToolCall(nil), m.called...)
+}
@@ -0,0 +1,166 @@
+// Package eval 用场景评测 Agent 的效果：每个场景给出用户问题、模拟的工具结果和期望，
+// 通过 RunAgent 使用真实的模型执行后逐项检查并打分，结果可以与之前的基线报告对比
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario 一个评测场景
+type Scenario struct {
+	Name        string                `yaml:"name"`
+	Description string                `yaml:"description"`
+	Query       string                `yaml:"query"`
+	History     []HistoryMessage      `yaml:"history"` // 本次问题之前的对话
+	Tools       map[string][]ToolMock `yaml:"tools"`   // 工具名 -> 模拟结果，按顺序匹配
+	Expect      Expectations          `yaml:"expect"`
+	Timeout     time.Duration         `yaml:"timeout"`
+
+	File string `yaml:"-"`
+}
+
+// HistoryMessage 场景中预置的历史消息
+type HistoryMessage struct {
+	Role    string `yaml:"role"` // user | assistant
+	Content string `yaml:"content"`
+}
+
+// ToolMock 工具的模拟结果：参数包含 Match 的第一条生效，Match 为空时匹配任意参数
+// 设置 Error 时返回失败结果，否则将 Response 作为成功结果的 payload
+type ToolMock struct {
+	Match     string      `yaml:"match"`
+	Response  interface{} `yaml:"response"`
+	Error     string      `yaml:"error"`
+	ErrorCode string      `yaml:"error_code"`
+	Retryable bool        `yaml:"retryable"`
+}
+
+// Expectations 场景的期望，未填写的项不检查
+type Expectations struct {
+	Intent         string            `yaml:"intent"`           // 识别出的意图，general 表示通用流程
+	ToolsCalled    []string          `yaml:"tools_called"`     // 必须调用的工具
+	ToolsNotCalled []string          `yaml:"tools_not_called"` // 不能调用的工具
+	MaxToolCalls   *int              `yaml:"max_tool_calls"`   // 工具调用总次数上限，0 表示不应调用任何工具
+	Plan           *PlanExpect       `yaml:"plan"`
+	Summary        *SummaryExpect    `yaml:"summary"`
+	ToolArgs       map[string]string `yaml:"tool_args"` // 工具名 -> 至少一次调用的参数中包含的内容
+}
+
+// PlanExpect 对最终计划状态的期望
+type PlanExpect struct {
+	Exists        *bool `yaml:"exists"` // false 表示应当直接回复，不生成计划
+	MinTasks      int   `yaml:"min_tasks"`
+	MaxTasks      int   `yaml:"max_tasks"`
+	AllDone       bool  `yaml:"all_done"`        // 所有任务都已完成
+	NoFailedTasks bool  `yaml:"no_failed_tasks"` // 没有失败的任务
+}
+
+// SummaryExpect 对最终回复的期望
+type SummaryExpect struct {
+	Contains    []string `yaml:"contains"`
+	NotContains []string `yaml:"not_contains"`
+	Matches     []string `yaml:"matches"` // 正则表达式
+}
+
+// LoadScenarios 读取目录下所有 .yaml / .yml 场景，按文件名排序；filter 不为空时只保留名称包含它的场景
+func LoadScenarios(dir, filter string) ([]*Scenario, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario directory %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+
+	var scenarios []*Scenario
+	names := make(map[string]string)
+	for _, file := range files {
+		sc, err := loadScenario(file)
+		if err != nil {
+			return nil, err
+		}
+		if prev, exists := names[sc.Name]; exists {
+			return nil, fmt.Errorf("duplicate scenario name %q in %s and %s", sc.Name, prev, file)
+		}
+		names[sc.Name] = file
+		if filter != "" && !strings.Contains(sc.Name, filter) {
+			continue
+		}
+		scenarios = append(scenarios, sc)
+	}
+	return scenarios, nil
+}
+
+func loadScenario(file string) (*Scenario, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario %s: %w", file, err)
+	}
+
+	var sc Scenario
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %w", file, err)
+	}
+	sc.File = file
+	if sc.Name == "" {
+		sc.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	if strings.TrimSpace(sc.Query) == "" {
+		return nil, fmt.Errorf("scenario %s has no query", file)
+	}
+	return &sc, nil
+}
+
+// responsePayload 将 YAML 中的模拟结果转换为可以序列化为 JSON 的值
+// yaml.v3 解析出的对象是 map[string]interface{}，嵌套的值同样需要转换
+func responsePayload(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = responsePayload(item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = responsePayload(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = responsePayload(item)
+		}
+		return out
+	case string:
+		// 写成JSON字符串的模拟结果按JSON解析，便于直接粘贴接口的真实返回
+		var parsed interface{}
+		if json.Valid([]byte(val)) && json.Unmarshal([]byte(val), &parsed) == nil {
+			if _, isObject := parsed.(map[string]interface{}); isObject {
+				return parsed
+			}
+			if _, isArray := parsed.([]interface{}); isArray {
+				return parsed
+			}
+		}
+		return val
+	default:
+		return val
+	}
+}
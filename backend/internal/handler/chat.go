@@ -3,12 +3,16 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"glata-backend/internal/model"
+	"glata-backend/internal/scheduler"
 	"glata-backend/internal/service"
+	"glata-backend/internal/storage"
 	"glata-backend/internal/utils"
 	"glata-backend/pkg/logger"
 
@@ -38,6 +42,40 @@ func (h *ChatHandler) StreamChat(c *gin.Context) {
 	fmt.Printf("收到聊天请求 - SessionID: %s, Message: %s, BackgroundMode: %v\n", 
 		req.SessionID, req.Message, req.BackgroundMode)
 
+	fmt.Println("调用 chatService.StreamChat...")
+	respChan, errChan, err := h.chatService.StreamChat(c.Request.Context(), requestUser(c), req.SessionID, req.Message)
+	if err != nil {
+		h.writeAdmitError(c, err)
+		return
+	}
+	h.writeSSE(c, respChan, errChan, req.BackgroundMode)
+}
+
+// requestUser 全局调度按用户限流使用的用户标识
+// 服务没有用户认证，客户端自报的标识可以随意伪造，按客户端 IP 区分用户（只采信可信代理转发的地址）
+func requestUser(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// writeAdmitError 运行未能开始时返回对应的状态码，调度队列已满时带上 Retry-After
+func (h *ChatHandler) writeAdmitError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrRunNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrSessionBusy):
+		status = http.StatusConflict
+	case errors.Is(err, scheduler.ErrQueueFull):
+		status = http.StatusServiceUnavailable
+		if retryAfter := h.chatService.QueueRetryAfter(); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		}
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// writeSSE 将聊天响应以SSE形式写回客户端，包含心跳和超时处理
+func (h *ChatHandler) writeSSE(c *gin.Context, respChan <-chan model.ChatResponse, errChan <-chan error, backgroundMode bool) {
 	sseWriter := utils.NewSSEWriter(c.Writer)
 	
 	// ✅ 设置连接超时和心跳机制
@@ -68,9 +106,6 @@ func (h *ChatHandler) StreamChat(c *gin.Context) {
 		}
 	}()
 
-	fmt.Println("调用 chatService.StreamChat...")
-	respChan, errChan := h.chatService.StreamChat(req.SessionID, req.Message)
-	
 	// ✅ 添加处理开始通知
 	startData, _ := json.Marshal(gin.H{
 		"type": "processing_start",
@@ -95,7 +130,7 @@ func (h *ChatHandler) StreamChat(c *gin.Context) {
 			}
 
 			// ✅ 约束3：在响应中标识是否为后台模式
-			resp.IsBackground = req.BackgroundMode
+			resp.IsBackground = backgroundMode
 
 			data, err := json.Marshal(resp)
 			if err != nil {
@@ -103,7 +138,13 @@ func (h *ChatHandler) StreamChat(c *gin.Context) {
 				continue
 			}
 
-			if err := sseWriter.Write("message", string(data)); err != nil {
+			// 🔐 审批事件和模型输出的 token 使用独立的SSE事件名，其余消息保持为 message
+			eventName := "message"
+			if resp.Type == "approval_required" || resp.Type == "approval_resolved" || resp.Type == "token" {
+				eventName = resp.Type
+			}
+
+			if err := sseWriter.Write(eventName, string(data)); err != nil {
 				logger.Errorf("Failed to write SSE: %v", err)
 				return
 			}
@@ -284,6 +325,344 @@ func (h *ChatHandler) GetPendingRenders(c *gin.Context) {
 	})
 }
 
+// ResolveApproval 审批工具调用：approve / reject / edit
+func (h *ChatHandler) ResolveApproval(c *gin.Context) {
+	approvalID := c.Param("approval_id")
+
+	var req model.ApprovalDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	approval, err := h.chatService.ResolveApproval(approvalID, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrApprovalNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, service.ErrInvalidApprovalInput) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Approval resolved successfully",
+		"approval": approval,
+	})
+}
+
+// GetPendingApprovals 获取会话中等待审批的工具调用
+func (h *ChatHandler) GetPendingApprovals(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"approvals":  h.chatService.GetPendingApprovals(sessionID),
+	})
+}
+
+// GetSessionRuns 获取会话的运行记录（不包含检查点上下文）
+func (h *ChatHandler) GetSessionRuns(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	runs, err := h.chatService.ListRuns(sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]model.RunRecord, len(runs))
+	for i, run := range runs {
+		result[i] = *run
+		result[i].History = nil
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"runs":       result,
+	})
+}
+
+// GetSessionPlan 获取会话最新版本的计划
+func (h *ChatHandler) GetSessionPlan(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	plan, err := h.chatService.GetPlan(sessionID)
+	if err != nil {
+		if errors.Is(err, service.ErrPlanNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// UpdateSessionPlan 编辑会话的计划：调整顺序、跳过、添加或改写任务
+// base_version 不是最新版本时返回 409 和最新的计划，客户端需基于最新版本重新编辑
+func (h *ChatHandler) UpdateSessionPlan(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	var req model.UpdatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := h.chatService.UpdatePlan(sessionID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPlanNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrPlanVersionConflict):
+			latest, _ := h.chatService.GetPlan(sessionID)
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "plan": latest})
+		case errors.Is(err, service.ErrInvalidPlanEdit):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// GetPlanVersions 列出会话计划的全部版本：时间、写入该版本的节点、修订原因和各状态的任务数
+func (h *ChatHandler) GetPlanVersions(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	versions, err := h.chatService.ListPlanVersions(sessionID)
+	if err != nil {
+		if errors.Is(err, service.ErrPlanNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"versions":   versions,
+	})
+}
+
+// GetPlanVersion 获取会话计划的指定版本
+func (h *ChatHandler) GetPlanVersion(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	plan, err := h.chatService.GetPlanVersion(sessionID, version)
+	if err != nil {
+		if errors.Is(err, service.ErrPlanNotFound) || errors.Is(err, service.ErrPlanVersionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// DiffPlanVersions 比较会话计划的两个版本，from、to 不填时比较最新版本和它的前一个版本
+// 返回每个任务的变化以及期间每一次状态变化发生的版本和节点
+func (h *ChatHandler) DiffPlanVersions(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	var from, to int
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil || from <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from version"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil || to <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to version"})
+			return
+		}
+	}
+
+	diff, err := h.chatService.DiffPlan(sessionID, from, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPlanNotFound) || errors.Is(err, service.ErrPlanVersionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidPlanRange):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// GetArtifact 获取被截断的工具输出的完整内容
+func (h *ChatHandler) GetArtifact(c *gin.Context) {
+	sessionID := c.Param("session_id")
+	artifactID := c.Param("artifact_id")
+
+	artifact, err := h.chatService.GetArtifact(sessionID, artifactID)
+	if err != nil {
+		if errors.Is(err, storage.ErrArtifactNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, artifact)
+}
+
+// GetMessageTrace 获取助手消息的执行追踪：节点、模型请求、工具调用的输入输出、耗时和token用量
+func (h *ChatHandler) GetMessageTrace(c *gin.Context) {
+	messageID := c.Param("message_id")
+
+	trace, err := h.chatService.GetMessageTrace(messageID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTraceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, trace)
+}
+
+// ListMemories 列出长期记忆，支持 category 和 q 查询参数过滤
+func (h *ChatHandler) ListMemories(c *gin.Context) {
+	memories, err := h.chatService.ListMemories(c.Query("category"), c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"memories": memories})
+}
+
+// UpdateMemory 编辑长期记忆
+func (h *ChatHandler) UpdateMemory(c *gin.Context) {
+	memoryID := c.Param("memory_id")
+
+	var req model.UpdateMemoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	memory, err := h.chatService.UpdateMemory(memoryID, &req)
+	if err != nil {
+		if errors.Is(err, storage.ErrMemoryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidData) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, memory)
+}
+
+// DeleteMemory 删除长期记忆
+func (h *ChatHandler) DeleteMemory(c *gin.Context) {
+	memoryID := c.Param("memory_id")
+
+	if err := h.chatService.DeleteMemory(memoryID); err != nil {
+		if errors.Is(err, storage.ErrMemoryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Memory deleted successfully"})
+}
+
+// ResumeRun 从检查点恢复中断的运行，以SSE形式返回后续进度
+func (h *ChatHandler) ResumeRun(c *gin.Context) {
+	runID := c.Param("run_id")
+
+	respChan, errChan, err := h.chatService.ResumeRun(c.Request.Context(), requestUser(c), runID)
+	if err != nil {
+		h.writeAdmitError(c, err)
+		return
+	}
+	h.writeSSE(c, respChan, errChan, false)
+}
+
+// AbortRun 终止中断的运行
+func (h *ChatHandler) AbortRun(c *gin.Context) {
+	runID := c.Param("run_id")
+
+	run, err := h.chatService.AbortRun(runID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrRunNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, service.ErrRunNotResumable) || errors.Is(err, service.ErrSessionBusy) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Run aborted successfully",
+		"run_id":  run.ID,
+		"status":  run.Status,
+	})
+}
+
+// CancelRun 取消会话中正在执行的运行
+func (h *ChatHandler) CancelRun(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	// 允许空的请求体，默认取消会话中所有运行
+	var req model.CancelRunRequest
+	_ = c.ShouldBindJSON(&req)
+
+	runIDs, err := h.chatService.CancelRun(sessionID, req.MessageID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrRunNotActive) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Run cancelled successfully",
+		"run_ids": runIDs,
+	})
+}
+
+// GetSchedulerMetrics 全局运行调度器的状态：执行中和排队的运行数、按用户和提供商的占用、累计计数和平均时长
+func (h *ChatHandler) GetSchedulerMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, h.chatService.SchedulerMetrics())
+}
 
 // 转换指针切片为值切片
 func convertMessages(messages []*model.Message) []model.Message {
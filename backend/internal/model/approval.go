@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// ApprovalStatus 工具调用审批状态
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"  // 等待审批
+	ApprovalApproved ApprovalStatus = "approved" // 已批准
+	ApprovalEdited   ApprovalStatus = "edited"   // 修改参数后批准
+	ApprovalRejected ApprovalStatus = "rejected" // 已拒绝
+	ApprovalExpired  ApprovalStatus = "expired"  // 超时未处理
+)
+
+// ToolApproval 一次等待人工审批的工具调用
+type ToolApproval struct {
+	ID         string         `json:"id"`
+	SessionID  string         `json:"session_id"`
+	TaskID     string         `json:"task_id,omitempty"`
+	ToolName   string         `json:"tool_name"`
+	Arguments  string         `json:"arguments"`
+	Status     ApprovalStatus `json:"status"`
+	Reason     string         `json:"reason,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ResolvedAt *time.Time     `json:"resolved_at,omitempty"`
+}
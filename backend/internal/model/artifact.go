@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Artifact 工具调用的完整输出，模型上下文中只保留截断后的内容和对它的引用
+type Artifact struct {
+	ID         string    `json:"id"`
+	SessionID  string    `json:"session_id"`
+	TaskID     string    `json:"task_id,omitempty"`
+	ToolName   string    `json:"tool_name"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	Content    string    `json:"content"`
+	Size       int       `json:"size"` // 完整内容的字符数
+	CreatedAt  time.Time `json:"created_at"`
+}
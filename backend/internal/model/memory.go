@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// MemoryCategory 长期记忆的类别
+type MemoryCategory string
+
+const (
+	MemoryProfile    MemoryCategory = "profile"    // 个人信息，如所在楼宇、部门
+	MemoryLocation   MemoryCategory = "location"   // 常用地点，如常用会议室
+	MemoryDevice     MemoryCategory = "device"     // 持有或借用的设备
+	MemoryResolution MemoryCategory = "resolution" // 历史问题的解决方案
+	MemoryPreference MemoryCategory = "preference" // 使用习惯和偏好
+)
+
+// IsValid 判断类别是否合法
+func (c MemoryCategory) IsValid() bool {
+	switch c {
+	case MemoryProfile, MemoryLocation, MemoryDevice, MemoryResolution, MemoryPreference:
+		return true
+	}
+	return false
+}
+
+// Memory 从会话中提取的长期记忆，在新会话的规划阶段注入上下文
+type Memory struct {
+	ID              string         `json:"id"`
+	Category        MemoryCategory `json:"category"`
+	Content         string         `json:"content"`
+	Keywords        []string       `json:"keywords,omitempty"`
+	SourceSessionID string         `json:"source_session_id,omitempty"` // 最近一次提取到该记忆的会话
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+// Clone 复制记忆
+func (m *Memory) Clone() *Memory {
+	cp := *m
+	cp.Keywords = append([]string(nil), m.Keywords...)
+	return &cp
+}
@@ -21,45 +21,39 @@ import (
 
 // NewPlanModel 创建计划模型（支持工具绑定）
 func NewPlanModel(ctx context.Context, tools []tool.BaseTool) einoModel.ChatModel {
-	cfg := config.Get()
-
-	var chatModel einoModel.ChatModel
+	return newStageModel(ctx, config.StagePlan, tools)
+}
 
-	switch cfg.Model.Provider {
-	case "doubao":
-		chatModel = createDoubaoModel(ctx, cfg.Doubao)
-	case "openai":
-		chatModel = createOpenAIModel(ctx, cfg.OpenAI)
-	case "qwen":
-		chatModel = createQwenModel(ctx, cfg.Qwen)
-	default:
-		log.Fatalf("Unsupported model provider: %s", cfg.Model.Provider)
-		return nil
-	}
+// NewExecuteModel 创建执行模型（支持工具绑定）
+func NewExecuteModel(ctx context.Context, tools []tool.BaseTool) einoModel.ChatModel {
+	return newStageModel(ctx, config.StageExecute, tools)
+}
 
-	// 绑定工具
-	if len(tools) > 0 {
-		bindToolsToModel(ctx, chatModel, tools)
-	}
+// NewUpdateModel 创建更新模型（支持工具绑定）
+func NewUpdateModel(ctx context.Context, tools []tool.BaseTool) einoModel.ChatModel {
+	return newStageModel(ctx, config.StageUpdate, tools)
+}
 
-	return chatModel
+// NewSummaryModel 创建总结模型（不需要工具绑定）
+func NewSummaryModel(ctx context.Context) einoModel.ChatModel {
+	return newStageModel(ctx, config.StageSummary, nil)
 }
 
-// NewExecuteModel 创建执行模型（支持工具绑定）
-func NewExecuteModel(ctx context.Context, tools []tool.BaseTool) einoModel.ChatModel {
-	cfg := config.Get()
+// newStageModel 按阶段的配置创建模型，阶段未单独配置时使用 model.provider 对应的模型
+func newStageModel(ctx context.Context, stage string, tools []tool.BaseTool) einoModel.ChatModel {
+	provider, modelCfg := config.Get().StageModel(stage)
 
 	var chatModel einoModel.ChatModel
 
-	switch cfg.Model.Provider {
-	case "doubao":
-		chatModel = createDoubaoModel(ctx, cfg.Doubao)
-	case "openai":
-		chatModel = createOpenAIModel(ctx, cfg.OpenAI)
-	case "qwen":
-		chatModel = createQwenModel(ctx, cfg.Qwen)
+	switch m := modelCfg.(type) {
+	case config.DoubaoConfig:
+		chatModel = createDoubaoModel(ctx, m)
+	case config.OpenAIConfig:
+		chatModel = createOpenAIModel(ctx, m)
+	case config.QwenConfig:
+		chatModel = createQwenModel(ctx, m)
 	default:
-		log.Fatalf("Unsupported model provider: %s", cfg.Model.Provider)
+		log.Fatalf("Unsupported model provider for stage %s: %s", stage, provider)
 		return nil
 	}
 
@@ -71,28 +65,6 @@ func NewExecuteModel(ctx context.Context, tools []tool.BaseTool) einoModel.ChatM
 	return chatModel
 }
 
-// NewUpdateModel 创建更新模型（支持工具绑定）
-func NewUpdateModel(ctx context.Context, tools []tool.BaseTool) einoModel.ChatModel {
-	return NewPlanModel(ctx, tools) // 复用相同逻辑，同样需要工具绑定
-}
-
-// NewSummaryModel 创建总结模型（不需要工具绑定）
-func NewSummaryModel(ctx context.Context) einoModel.ChatModel {
-	cfg := config.Get()
-
-	switch cfg.Model.Provider {
-	case "doubao":
-		return createDoubaoModel(ctx, cfg.Doubao)
-	case "openai":
-		return createOpenAIModel(ctx, cfg.OpenAI)
-	case "qwen":
-		return createQwenModel(ctx, cfg.Qwen)
-	default:
-		log.Fatalf("Unsupported model provider: %s", cfg.Model.Provider)
-		return nil
-	}
-}
-
 // 内部辅助函数
 func createDoubaoModel(ctx context.Context, config config.DoubaoConfig) einoModel.ChatModel {
 	if len(config.APIKey) > 10 {
@@ -339,13 +311,28 @@ func createQwenModel(ctx context.Context, cfg config.QwenConfig) einoModel.ChatM
 }
 
 func bindToolsToModel(ctx context.Context, chatModel einoModel.ChatModel, tools []tool.BaseTool) {
+	toolsInfo, err := ToolInfos(ctx, tools)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(toolsInfo) > 0 {
+		err := chatModel.BindTools(toolsInfo)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// ToolInfos 获取绑定到模型的工具信息，清理工具描述中的误导性引用
+func ToolInfos(ctx context.Context, tools []tool.BaseTool) ([]*schema.ToolInfo, error) {
 	var toolsInfo []*schema.ToolInfo
 	cleanedCount := 0
 	
 	for _, t := range tools {
 		info, err := t.Info(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		
 		// 🎯 清理工具描述中的误导性 execute_command 引用
@@ -366,10 +353,5 @@ func bindToolsToModel(ctx context.Context, chatModel einoModel.ChatModel, tools
 		log.Printf("Tool description cleaning completed: cleaned %d out of %d tools", cleanedCount, len(tools))
 	}
 
-	if len(toolsInfo) > 0 {
-		err := chatModel.BindTools(toolsInfo)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-}
\ No newline at end of file
+	return toolsInfo, nil
+}
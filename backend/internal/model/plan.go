@@ -0,0 +1,249 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TaskStatus 任务状态
+type TaskStatus string
+
+const (
+	TaskPending   TaskStatus = "pending"   // 待执行
+	TaskRunning   TaskStatus = "running"   // 执行中
+	TaskDone      TaskStatus = "done"      // 已完成
+	TaskFailed    TaskStatus = "failed"    // 失败
+	TaskSkipped   TaskStatus = "skipped"   // 已跳过
+	TaskCancelled TaskStatus = "cancelled" // 运行被取消
+)
+
+// IsValid 判断状态值是否合法
+func (s TaskStatus) IsValid() bool {
+	switch s {
+	case TaskPending, TaskRunning, TaskDone, TaskFailed, TaskSkipped, TaskCancelled:
+		return true
+	}
+	return false
+}
+
+// IsTerminal 判断任务是否已进入终态（不会再被执行）
+func (s TaskStatus) IsTerminal() bool {
+	return s == TaskDone || s == TaskFailed || s == TaskSkipped || s == TaskCancelled
+}
+
+// Task 计划中的单个任务，ID 在整个计划生命周期内保持稳定
+type Task struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Status    TaskStatus `json:"status"`
+	Attempts  int        `json:"attempts"`             // 已执行次数
+	Result    string     `json:"result,omitempty"`     // 执行结果或失败原因
+	DependsOn []string   `json:"depends_on,omitempty"` // 依赖的任务ID，全部完成后才可执行
+}
+
+// Plan 结构化的执行计划，markdown 仅作为其渲染形式
+type Plan struct {
+	SessionID string    `json:"session_id"`
+	Version   int       `json:"version"`
+	Tasks     []*Task   `json:"tasks"`
+	Reason    string    `json:"reason,omitempty"` // 本版本的修订原因，仅重新规划和用户编辑时填写
+	Source    string    `json:"source,omitempty"` // 写入本版本的节点或操作
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Task 按ID查找任务
+func (p *Plan) Task(id string) *Task {
+	for _, t := range p.Tasks {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// NextPending 返回第一个待执行的任务
+func (p *Plan) NextPending() *Task {
+	for _, t := range p.Tasks {
+		if t.Status == TaskPending {
+			return t
+		}
+	}
+	return nil
+}
+
+// ReadyTasks 返回所有依赖已满足的待执行任务，这些任务之间可以并行执行
+func (p *Plan) ReadyTasks() []*Task {
+	var ready []*Task
+	for _, t := range p.Tasks {
+		if t.Status != TaskPending {
+			continue
+		}
+		satisfied := true
+		for _, dep := range t.DependsOn {
+			if d := p.Task(dep); d != nil && d.Status != TaskDone {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			ready = append(ready, t)
+		}
+	}
+	return ready
+}
+
+// SkipBlocked 将依赖已失败、跳过或取消的待执行任务标记为跳过，返回被跳过的任务
+// 跳过会沿依赖链传递，直到没有新的任务被跳过
+func (p *Plan) SkipBlocked() []*Task {
+	var skipped []*Task
+	for changed := true; changed; {
+		changed = false
+		for _, t := range p.Tasks {
+			if t.Status != TaskPending {
+				continue
+			}
+			for _, dep := range t.DependsOn {
+				d := p.Task(dep)
+				if d != nil && d.Status.IsTerminal() && d.Status != TaskDone {
+					t.Status = TaskSkipped
+					t.Result = fmt.Sprintf("依赖任务 %s 未完成", d.ID)
+					skipped = append(skipped, t)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return skipped
+}
+
+// Running 返回当前执行中的任务
+func (p *Plan) Running() *Task {
+	for _, t := range p.Tasks {
+		if t.Status == TaskRunning {
+			return t
+		}
+	}
+	return nil
+}
+
+// IsFinished 所有任务都已进入终态
+func (p *Plan) IsFinished() bool {
+	for _, t := range p.Tasks {
+		if !t.Status.IsTerminal() {
+			return false
+		}
+	}
+	return true
+}
+
+// CountByStatus 统计各状态的任务数量
+func (p *Plan) CountByStatus() map[TaskStatus]int {
+	counts := make(map[TaskStatus]int)
+	for _, t := range p.Tasks {
+		counts[t.Status]++
+	}
+	return counts
+}
+
+// Clone 深拷贝计划，避免并发修改共享的任务指针
+func (p *Plan) Clone() *Plan {
+	cp := *p
+	cp.Tasks = make([]*Task, len(p.Tasks))
+	for i, t := range p.Tasks {
+		task := *t
+		task.DependsOn = append([]string(nil), t.DependsOn...)
+		cp.Tasks[i] = &task
+	}
+	return &cp
+}
+
+// Markdown 将计划渲染为 markdown todo list
+func (p *Plan) Markdown() string {
+	var sb strings.Builder
+	for i, t := range p.Tasks {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(t.Markdown())
+	}
+	return sb.String()
+}
+
+// Markdown 将单个任务渲染为 markdown todo 行
+func (t *Task) Markdown() string {
+	line := fmt.Sprintf("- %s %s：%s", t.Status.checkbox(), t.ID, t.Title)
+	if len(t.DependsOn) > 0 {
+		line += fmt.Sprintf("（依赖：%s）", strings.Join(t.DependsOn, "、"))
+	}
+	switch t.Status {
+	case TaskRunning:
+		line += " ⏳"
+	case TaskCancelled:
+		line += " ⛔"
+	}
+	return line
+}
+
+func (s TaskStatus) checkbox() string {
+	switch s {
+	case TaskDone:
+		return "[x]"
+	case TaskFailed:
+		return "[!]"
+	case TaskSkipped, TaskCancelled:
+		return "[-]"
+	default:
+		return "[ ]"
+	}
+}
+
+// PlanVersionSummary 计划某个版本的概要
+type PlanVersionSummary struct {
+	Version   int                `json:"version"`
+	CreatedAt time.Time          `json:"created_at"`
+	Source    string             `json:"source,omitempty"` // 写入该版本的节点或操作
+	Reason    string             `json:"reason,omitempty"`
+	TaskCount int                `json:"task_count"`
+	Counts    map[TaskStatus]int `json:"counts"`
+}
+
+// PlanDiff 两个计划版本之间的差异
+type PlanDiff struct {
+	SessionID string     `json:"session_id"`
+	From      int        `json:"from"`
+	To        int        `json:"to"`
+	Tasks     []TaskDiff `json:"tasks"`
+}
+
+// 任务在两个版本之间的变化类型
+const (
+	TaskAdded     = "added"
+	TaskRemoved   = "removed"
+	TaskModified  = "modified"
+	TaskUnchanged = "unchanged"
+)
+
+// TaskDiff 单个任务在两个版本之间的变化
+type TaskDiff struct {
+	TaskID      string             `json:"task_id"`
+	Title       string             `json:"title"`
+	Change      string             `json:"change"` // added | removed | modified | unchanged
+	FromStatus  TaskStatus         `json:"from_status,omitempty"`
+	ToStatus    TaskStatus         `json:"to_status,omitempty"`
+	FromTitle   string             `json:"from_title,omitempty"`      // 标题被改写时为原标题
+	FromDepends []string           `json:"from_depends_on,omitempty"` // 依赖有变化时为原依赖
+	ToDepends   []string           `json:"to_depends_on,omitempty"`   // 依赖有变化时为新依赖
+	Transitions []StatusTransition `json:"transitions,omitempty"`     // 两个版本之间每一次状态变化
+}
+
+// StatusTransition 任务状态的一次变化，发生在写入 Version 的时候
+type StatusTransition struct {
+	Version int        `json:"version"`
+	Source  string     `json:"source,omitempty"`
+	From    TaskStatus `json:"from,omitempty"` // 为空表示任务在该版本中新增
+	To      TaskStatus `json:"to"`
+	Result  string     `json:"result,omitempty"`
+	At      time.Time  `json:"at"`
+}
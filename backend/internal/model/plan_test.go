@@ -0,0 +1,154 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+// testPlan 按 "id:status:依赖1,依赖2" 的格式构造计划
+func testPlan(specs ...string) *Plan {
+	plan := &Plan{SessionID: "s1"}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 3)
+		task := &Task{ID: parts[0], Title: "任务" + parts[0], Status: TaskStatus(parts[1])}
+		if len(parts) == 3 && parts[2] != "" {
+			task.DependsOn = strings.Split(parts[2], ",")
+		}
+		plan.Tasks = append(plan.Tasks, task)
+	}
+	return plan
+}
+
+func ids(tasks []*Task) string {
+	out := make([]string, len(tasks))
+	for i, t := range tasks {
+		out[i] = t.ID
+	}
+	return strings.Join(out, ",")
+}
+
+func TestPlanReadyTasks(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []string
+		want  string
+	}{
+		{
+			name:  "没有依赖的任务都可以执行",
+			tasks: []string{"1:pending", "2:pending", "3:done"},
+			want:  "1,2",
+		},
+		{
+			name:  "依赖全部完成",
+			tasks: []string{"1:done", "2:done", "3:pending:1,2"},
+			want:  "3",
+		},
+		{
+			name:  "依赖还在执行",
+			tasks: []string{"1:running", "2:pending:1", "3:pending"},
+			want:  "3",
+		},
+		{
+			name:  "依赖失败的任务不能执行",
+			tasks: []string{"1:failed", "2:pending:1"},
+			want:  "",
+		},
+		{
+			name:  "依赖链只放行第一层",
+			tasks: []string{"1:pending", "2:pending:1", "3:pending:2"},
+			want:  "1",
+		},
+		{
+			name:  "不存在的依赖视为已满足",
+			tasks: []string{"1:pending:missing"},
+			want:  "1",
+		},
+		{
+			name:  "环上的任务都不能执行",
+			tasks: []string{"1:pending:2", "2:pending:1", "3:pending"},
+			want:  "3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(testPlan(tt.tasks...).ReadyTasks()); got != tt.want {
+				t.Errorf("ReadyTasks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSkipBlocked(t *testing.T) {
+	tests := []struct {
+		name        string
+		tasks       []string
+		wantSkipped string
+		wantStatus  string // 跳过后按顺序排列的任务状态
+	}{
+		{
+			name:        "依赖失败",
+			tasks:       []string{"1:failed", "2:pending:1", "3:pending"},
+			wantSkipped: "2",
+			wantStatus:  "failed,skipped,pending",
+		},
+		{
+			name:        "依赖被跳过或取消",
+			tasks:       []string{"1:skipped", "2:cancelled", "3:pending:1", "4:pending:2"},
+			wantSkipped: "3,4",
+			wantStatus:  "skipped,cancelled,skipped,skipped",
+		},
+		{
+			name:        "沿依赖链传递，与任务顺序无关",
+			tasks:       []string{"3:pending:2", "2:pending:1", "1:failed"},
+			wantSkipped: "2,3",
+			wantStatus:  "skipped,skipped,failed",
+		},
+		{
+			name:        "依赖已完成或未结束时不跳过",
+			tasks:       []string{"1:done", "2:running", "3:pending:1", "4:pending:2"},
+			wantSkipped: "",
+			wantStatus:  "done,running,pending,pending",
+		},
+		{
+			name:        "只跳过待执行的任务",
+			tasks:       []string{"1:failed", "2:running:1", "3:done:1"},
+			wantSkipped: "",
+			wantStatus:  "failed,running,done",
+		},
+		{
+			name:        "环上的任务不会被跳过",
+			tasks:       []string{"1:pending:2", "2:pending:1"},
+			wantSkipped: "",
+			wantStatus:  "pending,pending",
+		},
+		{
+			name:        "环依赖失败的任务时整个环被跳过",
+			tasks:       []string{"1:failed", "2:pending:1,3", "3:pending:2"},
+			wantSkipped: "2,3",
+			wantStatus:  "failed,skipped,skipped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := testPlan(tt.tasks...)
+			skipped := plan.SkipBlocked()
+			if got := ids(skipped); got != tt.wantSkipped {
+				t.Errorf("SkipBlocked() = %q, want %q", got, tt.wantSkipped)
+			}
+			for _, task := range skipped {
+				if task.Result == "" {
+					t.Errorf("skipped task %s has no reason", task.ID)
+				}
+			}
+			statuses := make([]string, len(plan.Tasks))
+			for i, task := range plan.Tasks {
+				statuses[i] = string(task.Status)
+			}
+			if got := strings.Join(statuses, ","); got != tt.wantStatus {
+				t.Errorf("statuses = %s, want %s", got, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package model
 
+import "encoding/json"
+
 type ChatRequest struct {
 	Message        string `json:"message" binding:"required"`
 	SessionID      string `json:"session_id"`
@@ -17,3 +19,36 @@ type RenderRequest struct {
 	RenderTimeMs int    `json:"render_time_ms"`
 }
 
+// ApprovalDecisionRequest 工具调用审批请求
+type ApprovalDecisionRequest struct {
+	Action    string          `json:"action" binding:"required,oneof=approve reject edit"` // approve | reject | edit
+	Arguments json.RawMessage `json:"arguments,omitempty"`                                 // action 为 edit 时替换的工具参数
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// UpdateMemoryRequest 编辑长期记忆请求，未填写的字段保持不变
+type UpdateMemoryRequest struct {
+	Category MemoryCategory `json:"category"`
+	Content  string         `json:"content"`
+	Keywords []string       `json:"keywords"`
+}
+
+// CancelRunRequest 取消运行请求，message_id 为空时取消会话中所有正在执行的运行
+type CancelRunRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+// UpdatePlanRequest 编辑计划请求，tasks 为编辑后按执行顺序排列的完整任务列表，未列出的任务被移除
+type UpdatePlanRequest struct {
+	BaseVersion int            `json:"base_version" binding:"required"` // 编辑所基于的计划版本，不是最新版本时拒绝
+	Tasks       []PlanTaskEdit `json:"tasks" binding:"required"`
+	Reason      string         `json:"reason,omitempty"` // 修订原因，记录在新版本中
+}
+
+// PlanTaskEdit 编辑后的单个任务，id 为空或不存在时作为新任务添加
+type PlanTaskEdit struct {
+	ID        string     `json:"id,omitempty"`
+	Title     string     `json:"title,omitempty"`      // 为空时保持不变
+	Status    TaskStatus `json:"status,omitempty"`     // 只能为 pending 或 skipped，为空时保持不变
+	DependsOn []string   `json:"depends_on,omitempty"` // 不填时保持不变，空数组表示清除依赖
+}
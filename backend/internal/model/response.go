@@ -16,6 +16,18 @@ type ChatResponse struct {
 	Mode            string `json:"mode,omitempty"`              // "DIRECT_REPLY" | "TODO_LIST" - 解决前端渲染截断问题
 	ContentStage    string `json:"content_stage,omitempty"`     // "thinking" | "answer" - 内容阶段标识
 	StreamType      string `json:"stream_type,omitempty"`       // "real" | "fake" - 流式类型标识
+	Approval        *ToolApproval `json:"approval,omitempty"`    // 工具调用审批信息（type 为 approval_required / approval_resolved 时）
+	Plan            *Plan         `json:"plan,omitempty"`        // 用户编辑后的计划（type 为 plan_updated 时）
+	Queue           *QueueStatus  `json:"queue,omitempty"`       // 排队位置和预计等待时间（type 为 queue_position 时）
+	Node            string        `json:"node,omitempty"`        // 产生输出的图节点（type 为 token 时）
+	TaskID          string        `json:"task_id,omitempty"`     // 产生输出的任务（execute 节点的 token）
+	Delta           string        `json:"delta,omitempty"`       // 模型输出的增量（type 为 token 时），只有直接回复的增量同时计入 content
+}
+
+// QueueStatus 运行在全局调度队列中的位置
+type QueueStatus struct {
+	Position   int `json:"position"`              // 从 1 开始
+	ETASeconds int `json:"eta_seconds,omitempty"` // 预计开始执行前的等待秒数，为空表示暂时无法估计
 }
 
 type SessionResponse struct {
@@ -36,6 +48,7 @@ type Message struct {
 	HTMLContent     string    `json:"html_content,omitempty"`       // 渲染后的HTML内容
 	IsRendered      bool      `json:"is_rendered"`                  // 是否已渲染
 	RenderTimeMs    int       `json:"render_time_ms,omitempty"`     // 渲染时间(毫秒)
+	PromptVersions  map[string]string `json:"prompt_versions,omitempty"` // 生成本条回复使用的提示版本，提示名 -> 版本
 	Timestamp       time.Time `json:"timestamp"`
 }
 
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// RunStatus Agent 运行状态
+type RunStatus string
+
+const (
+	RunRunning     RunStatus = "running"     // 执行中
+	RunCompleted   RunStatus = "completed"   // 已完成
+	RunFailed      RunStatus = "failed"      // 执行失败
+	RunInterrupted RunStatus = "interrupted" // 服务重启等原因中断，可恢复
+	RunAborted     RunStatus = "aborted"     // 已终止，不可恢复
+	RunCancelled   RunStatus = "cancelled"   // 用户取消
+)
+
+// RunRecord 一次 Agent 运行的持久化记录，每个图节点执行后更新检查点
+type RunRecord struct {
+	ID             string                     `json:"id"`
+	SessionID      string                     `json:"session_id"`
+	MessageID      string                     `json:"message_id"` // 对应的助手消息ID
+	Query          string                     `json:"query"`
+	Status         RunStatus                  `json:"status"`
+	LastNode       string                     `json:"last_node,omitempty"` // 最近完成的节点
+	History        []*schema.Message          `json:"history,omitempty"`   // 图状态中的上下文消息
+	Error          string                     `json:"error,omitempty"`
+	ResumeCount    int                        `json:"resume_count"`
+	PromptVersions map[string]string          `json:"prompt_versions,omitempty"` // 本次运行使用的提示版本，恢复时沿用
+	State          *RunState                  `json:"state,omitempty"`           // 主图中除上下文之外的状态，恢复时还原
+	Tasks          map[string]*TaskCheckpoint `json:"tasks,omitempty"`           // 执行中的任务的检查点，所在批次完成后清除
+	CreatedAt      time.Time                  `json:"created_at"`
+	UpdatedAt      time.Time                  `json:"updated_at"`
+}
+
+// RunState 主图中需要与上下文一起保存的状态
+type RunState struct {
+	ContextSummary   string   `json:"context_summary,omitempty"`    // 已压缩历史的滚动摘要
+	SummarizedLen    int      `json:"summarized_len,omitempty"`     // 上下文中已压缩进摘要的消息数
+	FailedTaskIDs    []string `json:"failed_task_ids,omitempty"`    // 上次重新规划以来最终失败的任务
+	ToolOutputs      []string `json:"tool_outputs,omitempty"`       // 上次重新规划以来的工具输出摘要
+	TasksSinceReplan int      `json:"tasks_since_replan,omitempty"` // 上次重新规划以来完成的任务数
+	ReplanCount      int      `json:"replan_count,omitempty"`       // 已重新规划的次数
+}
+
+// TaskCheckpoint 执行中的任务的检查点，每批工具调用完成后保存
+// 恢复时任务从检查点继续，已完成的工具调用不会重复执行
+type TaskCheckpoint struct {
+	TaskID          string              `json:"task_id"`
+	History         []*schema.Message   `json:"history,omitempty"` // 任务开始以来新增的上下文，包含已完成的工具调用结果
+	ToolCalls       int                 `json:"tool_calls"`
+	ToolSignatures  []string            `json:"tool_signatures,omitempty"`
+	LoopCorrections int                 `json:"loop_corrections,omitempty"`
+	CompletedCalls  map[string][]string `json:"completed_calls,omitempty"` // 已完成的工具调用结果，按调用签名索引
+	UpdatedAt       time.Time           `json:"updated_at"`
+}
+
+// IsResumable 只有中断的运行可以恢复
+func (r *RunRecord) IsResumable() bool {
+	return r.Status == RunInterrupted
+}
+
+// Clone 复制运行记录，运行中检查点会持续修改记录，存储保存和返回的都是副本
+func (r *RunRecord) Clone() *RunRecord {
+	cp := *r
+	cp.History = cloneMessages(r.History)
+	if r.PromptVersions != nil {
+		cp.PromptVersions = make(map[string]string, len(r.PromptVersions))
+		for k, v := range r.PromptVersions {
+			cp.PromptVersions[k] = v
+		}
+	}
+	if r.State != nil {
+		state := *r.State
+		state.FailedTaskIDs = append([]string(nil), r.State.FailedTaskIDs...)
+		state.ToolOutputs = append([]string(nil), r.State.ToolOutputs...)
+		cp.State = &state
+	}
+	if r.Tasks != nil {
+		cp.Tasks = make(map[string]*TaskCheckpoint, len(r.Tasks))
+		for id, t := range r.Tasks {
+			cp.Tasks[id] = t.Clone()
+		}
+	}
+	return &cp
+}
+
+// Clone 复制任务检查点
+func (t *TaskCheckpoint) Clone() *TaskCheckpoint {
+	cp := *t
+	cp.History = cloneMessages(t.History)
+	cp.ToolSignatures = append([]string(nil), t.ToolSignatures...)
+	if t.CompletedCalls != nil {
+		cp.CompletedCalls = make(map[string][]string, len(t.CompletedCalls))
+		for sig, results := range t.CompletedCalls {
+			cp.CompletedCalls[sig] = append([]string(nil), results...)
+		}
+	}
+	return &cp
+}
+
+// cloneMessages 复制消息列表，消息本身和其中的工具调用也一并复制
+func cloneMessages(msgs []*schema.Message) []*schema.Message {
+	if msgs == nil {
+		return nil
+	}
+	cp := make([]*schema.Message, len(msgs))
+	for i, m := range msgs {
+		if m == nil {
+			continue
+		}
+		msg := *m
+		msg.ToolCalls = append([]schema.ToolCall(nil), m.ToolCalls...)
+		cp[i] = &msg
+	}
+	return cp
+}
@@ -0,0 +1,89 @@
+package model
+
+import "time"
+
+// 追踪片段的类型
+const (
+	SpanGraph     = "graph"      // 图或子图
+	SpanNode      = "node"       // 图节点（Lambda、分支处理等）
+	SpanChatModel = "chat_model" // 模型调用
+	SpanTool      = "tool"       // 工具调用
+	SpanToolsNode = "tools_node" // 一批工具调用
+)
+
+// Trace 一次 Agent 运行的执行追踪，ID 与运行ID、助手消息ID一致
+type Trace struct {
+	ID         string     `json:"id"`
+	SessionID  string     `json:"session_id"`
+	MessageID  string     `json:"message_id"`
+	Query      string     `json:"query"`
+	Status     RunStatus  `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	TokenUsage TokenUsage `json:"token_usage"` // 所有模型调用的用量合计
+	Spans      []*Span    `json:"spans"`
+	StartedAt  time.Time  `json:"started_at"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
+// Span 追踪中的一个片段：进入的节点、模型请求或工具调用，通过 ParentID 组成树
+type Span struct {
+	ID         string      `json:"id"`
+	ParentID   string      `json:"parent_id,omitempty"`
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Component  string      `json:"component,omitempty"` // eino 组件类型，如 ChatModel、Lambda
+	Model      string      `json:"model,omitempty"`
+	Input      string      `json:"input,omitempty"`
+	Output     string      `json:"output,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	TokenUsage *TokenUsage `json:"token_usage,omitempty"`
+	Streaming  bool        `json:"streaming,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	EndedAt    *time.Time  `json:"ended_at,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// Clone 深拷贝追踪，运行中追踪会持续追加片段，存储保存和返回的都是副本
+func (t *Trace) Clone() *Trace {
+	cp := *t
+	if t.EndedAt != nil {
+		ended := *t.EndedAt
+		cp.EndedAt = &ended
+	}
+	if t.Spans != nil {
+		cp.Spans = make([]*Span, len(t.Spans))
+		for i, span := range t.Spans {
+			cp.Spans[i] = span.Clone()
+		}
+	}
+	return &cp
+}
+
+// Clone 复制片段
+func (s *Span) Clone() *Span {
+	cp := *s
+	if s.TokenUsage != nil {
+		usage := *s.TokenUsage
+		cp.TokenUsage = &usage
+	}
+	if s.EndedAt != nil {
+		ended := *s.EndedAt
+		cp.EndedAt = &ended
+	}
+	return &cp
+}
+
+// TokenUsage 模型调用的token用量
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Add 累加用量
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
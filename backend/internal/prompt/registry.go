@@ -0,0 +1,265 @@
+// Package prompt 从提示目录加载带版本的提示模板，支持按权重分流的 A/B 变体和不重启的热加载
+//
+// 每个提示一个 YAML 文件，文件名（去掉扩展名）为提示名：
+//
+//	variants:
+//	  - version: plan-v1
+//	    weight: 90
+//	    template: |
+//	      你是任务规划助手……{{with .Plan}}当前计划：{{.}}{{end}}
+//	  - version: plan-v2
+//	    weight: 10
+//	    template: |
+//	      ……
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"glata-backend/pkg/logger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Variant 提示的一个版本，权重不填时为 1；权重为 0 的版本不参与分流，只保留用于对比
+type Variant struct {
+	Prompt   string `yaml:"-"`
+	Version  string `yaml:"version"`
+	Weight   int    `yaml:"-"`
+	Template string `yaml:"template"`
+
+	RawWeight *int `yaml:"weight"`
+	tmpl      *template.Template
+}
+
+// Render 用变量渲染模板
+func (v *Variant) Render(data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := v.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s@%s: %w", v.Prompt, v.Version, err)
+	}
+	return buf.String(), nil
+}
+
+// promptFile 提示文件的结构
+type promptFile struct {
+	Variants []*Variant `yaml:"variants"`
+}
+
+// Registry 提示注册表，读写并发安全；重新加载失败时保留上一次成功加载的内容
+type Registry struct {
+	dir      string
+	required []string
+
+	mu        sync.RWMutex
+	prompts   map[string][]*Variant
+	signature string
+}
+
+// New 创建空的注册表，调用 Reload 后才会加载目录中的提示
+func New(dir string) *Registry {
+	return &Registry{dir: dir, prompts: make(map[string][]*Variant)}
+}
+
+// Load 加载目录下的全部提示，目录不存在时返回空的注册表
+func Load(dir string) (*Registry, error) {
+	r := New(dir)
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Require 设置必须存在的提示，加载结果缺少其中任何一个时 Reload 返回错误，不替换已加载的内容
+func (r *Registry) Require(names ...string) {
+	r.required = append(r.required, names...)
+}
+
+// Dir 提示目录
+func (r *Registry) Dir() string {
+	return r.dir
+}
+
+// Names 已加载的提示名
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.prompts))
+	for name := range r.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Variants 返回提示的全部版本
+func (r *Registry) Variants(name string) []*Variant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Variant(nil), r.prompts[name]...)
+}
+
+// Select 按权重为 key 选择提示的版本，同一个 key 在提示内容不变时总是得到同一个版本
+// 提示不存在或没有可分流的版本时返回 nil
+func (r *Registry) Select(name, key string) *Variant {
+	r.mu.RLock()
+	variants := r.prompts[name]
+	r.mu.RUnlock()
+
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + key))
+	point := int(h.Sum32() % uint32(total))
+	for _, v := range variants {
+		if point < v.Weight {
+			return v
+		}
+		point -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
+
+// Reload 目录内容有变化时重新加载，返回是否加载了新内容
+func (r *Registry) Reload() (bool, error) {
+	files, signature, err := r.scan()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := signature == r.signature
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	prompts := make(map[string][]*Variant, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		variants, err := loadFile(name, file)
+		if err != nil {
+			return false, err
+		}
+		prompts[name] = variants
+	}
+	for _, name := range r.required {
+		if _, ok := prompts[name]; !ok {
+			return false, fmt.Errorf("required prompt %s is missing from %s", name, r.dir)
+		}
+	}
+
+	r.mu.Lock()
+	r.prompts = prompts
+	r.signature = signature
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch 按间隔检查目录，文件有增删改时重新加载，直到 ctx 结束
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				logger.Errorf("Failed to reload prompts from %s, keeping previous versions: %v", r.dir, err)
+				continue
+			}
+			if reloaded {
+				logger.Infof("📝 Reloaded prompts from %s: %v", r.dir, r.Names())
+			}
+		}
+	}
+}
+
+// scan 列出提示文件，并用文件名、大小和修改时间生成目录内容的签名
+func (r *Registry) scan() ([]string, string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to read prompt directory %s: %w", r.dir, err)
+	}
+
+	var files []string
+	var signature strings.Builder
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to stat prompt file %s: %w", entry.Name(), err)
+		}
+		files = append(files, filepath.Join(r.dir, entry.Name()))
+		fmt.Fprintf(&signature, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return files, signature.String(), nil
+}
+
+// loadFile 解析提示文件并编译全部版本的模板
+func loadFile(name, file string) ([]*Variant, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt file %s: %w", file, err)
+	}
+
+	var pf promptFile
+	if err := yaml.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt file %s: %w", file, err)
+	}
+	if len(pf.Variants) == 0 {
+		return nil, fmt.Errorf("prompt file %s has no variants", file)
+	}
+
+	versions := make(map[string]bool, len(pf.Variants))
+	for _, v := range pf.Variants {
+		if v.Version == "" {
+			return nil, fmt.Errorf("prompt %s has a variant without version", name)
+		}
+		if versions[v.Version] {
+			return nil, fmt.Errorf("prompt %s has duplicate version %s", name, v.Version)
+		}
+		versions[v.Version] = true
+		v.Weight = 1
+		if v.RawWeight != nil {
+			v.Weight = *v.RawWeight
+		}
+		if v.Weight < 0 {
+			return nil, fmt.Errorf("prompt %s@%s has negative weight", name, v.Version)
+		}
+
+		tmpl, err := template.New(name + "@" + v.Version).Option("missingkey=zero").Parse(v.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template of prompt %s@%s: %w", name, v.Version, err)
+		}
+		v.Prompt = name
+		v.tmpl = tmpl
+	}
+	return pf.Variants, nil
+}
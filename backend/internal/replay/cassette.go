@@ -0,0 +1,108 @@
+// Package replay 录制一次运行中所有模型和工具的交互，并在回放时用假模型、假工具返回录制的结果，
+// 使完整的 Agent 运行可以在没有网络的情况下重复执行
+package replay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 录音文件格式版本
+const cassetteVersion = 1
+
+// 交互类型
+const (
+	KindModel = "model"
+	KindTool  = "tool"
+)
+
+// Cassette 一次运行的录音
+type Cassette struct {
+	Version      int            `json:"version"`
+	RecordedAt   time.Time      `json:"recorded_at"`
+	Tools        []ToolSpec     `json:"tools"` // 录制时可用的工具，回放时据此创建假工具
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// ToolSpec 录制时工具的名称和描述
+type ToolSpec struct {
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+}
+
+// Interaction 一次模型调用或工具调用
+type Interaction struct {
+	Seq       int               `json:"seq"`  // 完成顺序
+	Kind      string            `json:"kind"` // model | tool
+	Name      string            `json:"name"` // 模型的阶段名或工具名
+	Key       string            `json:"key"`  // 请求指纹，回放时优先按指纹匹配
+	Messages  []*schema.Message `json:"messages,omitempty"`
+	Arguments string            `json:"arguments,omitempty"`
+	Response  *schema.Message   `json:"response,omitempty"`
+	Result    string            `json:"result,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Load 读取录音文件
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette %s: %w", path, err)
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	if c.Version != cassetteVersion {
+		return nil, fmt.Errorf("unsupported cassette version %d in %s", c.Version, path)
+	}
+	return &c, nil
+}
+
+// Save 保存录音文件，先写临时文件再重命名，避免留下写了一半的文件
+func (c *Cassette) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return os.Rename(tempPath, path)
+}
+
+// modelKey 模型请求的指纹：按顺序取每条消息的角色、内容和工具调用
+func modelKey(messages []*schema.Message) string {
+	h := sha256.New()
+	for _, msg := range messages {
+		if msg == nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", msg.Role, msg.Content, msg.ToolCallID)
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(h, "%s\x00%s\x00", call.Function.Name, call.Function.Arguments)
+		}
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// toolKey 工具调用的指纹
+func toolKey(name, arguments string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + arguments))
+	return hex.EncodeToString(sum[:])[:16]
+}
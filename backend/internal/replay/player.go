@@ -0,0 +1,174 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+// ErrNoRecording 录音中没有可以返回的交互
+var ErrNoRecording = errors.New("no recorded interaction")
+
+// Player 用录音中的结果回放模型和工具调用
+// 每次调用优先取同名、请求指纹相同且未使用过的交互；请求中含有随机ID等内容导致指纹不同时，
+// 非严格模式下按录制顺序取同名的下一条交互。并行执行的任务各自按指纹匹配，不受完成顺序影响
+type Player struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+	strict   bool
+	misses   []string
+}
+
+// NewPlayer 创建回放器，strict 为 true 时只接受指纹完全相同的交互
+func NewPlayer(cassette *Cassette, strict bool) *Player {
+	return &Player{
+		cassette: cassette,
+		used:     make([]bool, len(cassette.Interactions)),
+		strict:   strict,
+	}
+}
+
+// LoadPlayer 读取录音文件并创建回放器
+func LoadPlayer(path string, strict bool) (*Player, error) {
+	cassette, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPlayer(cassette, strict), nil
+}
+
+// Model 返回按录音回复的模型，name 与录制时 WrapModel 使用的名称一致
+func (p *Player) Model(name string) einoModel.ChatModel {
+	return &replayModel{player: p, name: name}
+}
+
+// Tools 按录音中的工具列表创建假工具
+func (p *Player) Tools() []tool.BaseTool {
+	tools := make([]tool.BaseTool, 0, len(p.cassette.Tools))
+	for _, spec := range p.cassette.Tools {
+		tools = append(tools, &replayTool{player: p, spec: spec})
+	}
+	return tools
+}
+
+// Unused 返回还没有被回放的交互，可以用来断言运行走完了录制时的全部调用
+func (p *Player) Unused() []*Interaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var unused []*Interaction
+	for i, interaction := range p.cassette.Interactions {
+		if !p.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// Misses 返回指纹不同、按顺序回退匹配的调用，用于排查请求与录制时的差异
+func (p *Player) Misses() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.misses...)
+}
+
+// next 取出下一条匹配的交互
+func (p *Player) next(kind, name, key string) (*Interaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fallback := -1
+	for i, interaction := range p.cassette.Interactions {
+		if p.used[i] || interaction.Kind != kind || interaction.Name != name {
+			continue
+		}
+		if interaction.Key == key {
+			p.used[i] = true
+			return interaction, nil
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+
+	if fallback < 0 || p.strict {
+		return nil, fmt.Errorf("%w: %s %s (key %s)", ErrNoRecording, kind, name, key)
+	}
+	p.used[fallback] = true
+	p.misses = append(p.misses, fmt.Sprintf("%s %s: key %s replayed as #%d (key %s)",
+		kind, name, key, p.cassette.Interactions[fallback].Seq, p.cassette.Interactions[fallback].Key))
+	return p.cassette.Interactions[fallback], nil
+}
+
+// replayModel 按录音回复的假模型，流式调用一次返回完整的回复
+type replayModel struct {
+	player *Player
+	name   string
+}
+
+func (m *replayModel) Generate(ctx context.Context, input []*schema.Message, opts ...einoModel.Option) (*schema.Message, error) {
+	interaction, err := m.player.next(KindModel, m.name, modelKey(input))
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	if interaction.Response == nil {
+		return nil, fmt.Errorf("%w: model %s #%d has no response", ErrNoRecording, m.name, interaction.Seq)
+	}
+	out := *interaction.Response
+	return &out, nil
+}
+
+func (m *replayModel) Stream(ctx context.Context, input []*schema.Message, opts ...einoModel.Option) (*schema.StreamReader[*schema.Message], error) {
+	out, err := m.Generate(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{out}), nil
+}
+
+// BindTools 回放的回复中已经包含工具调用，绑定的工具只用于保持接口一致
+func (m *replayModel) BindTools(tools []*schema.ToolInfo) error {
+	return nil
+}
+
+func (m *replayModel) WithTools(tools []*schema.ToolInfo) (einoModel.ToolCallingChatModel, error) {
+	return m, nil
+}
+
+func (m *replayModel) GetType() string {
+	return "Replay"
+}
+
+// replayTool 按录音返回结果的假工具
+type replayTool struct {
+	player *Player
+	spec   ToolSpec
+}
+
+func (t *replayTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: t.spec.Name, Desc: t.spec.Desc}, nil
+}
+
+func (t *replayTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	interaction, err := t.player.next(KindTool, t.spec.Name, toolKey(t.spec.Name, argumentsInJSON))
+	if err != nil {
+		return "", err
+	}
+	if interaction.Error != "" {
+		return "", errors.New(interaction.Error)
+	}
+	return interaction.Result, nil
+}
+
+func (t *replayTool) GetType() string {
+	return "Replay"
+}
@@ -0,0 +1,78 @@
+package replay
+
+import (
+	"errors"
+	"testing"
+)
+
+func testCassette() *Cassette {
+	return &Cassette{
+		Version: cassetteVersion,
+		Interactions: []*Interaction{
+			{Seq: 1, Kind: KindModel, Name: "execute", Key: "k1"},
+			{Seq: 2, Kind: KindTool, Name: "execute", Key: "k2"},
+			{Seq: 3, Kind: KindModel, Name: "update", Key: "k2"},
+			{Seq: 4, Kind: KindModel, Name: "execute", Key: "k2"},
+		},
+	}
+}
+
+func TestPlayerNextMatchesKey(t *testing.T) {
+	p := NewPlayer(testCassette(), false)
+
+	// 指纹相同的交互优先，不受录制顺序影响，也不会取到类型或名称不同的交互
+	got, err := p.next(KindModel, "execute", "k2")
+	if err != nil || got.Seq != 4 {
+		t.Fatalf("next(k2) = %v, %v; want #4", got, err)
+	}
+	got, err = p.next(KindModel, "execute", "k1")
+	if err != nil || got.Seq != 1 {
+		t.Fatalf("next(k1) = %v, %v; want #1", got, err)
+	}
+	if misses := p.Misses(); len(misses) != 0 {
+		t.Errorf("misses = %v, want none", misses)
+	}
+
+	// 同一条交互只回放一次
+	if _, err := p.next(KindModel, "execute", "k1"); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("next after all execute interactions used: err = %v, want ErrNoRecording", err)
+	}
+	if unused := p.Unused(); len(unused) != 2 || unused[0].Seq != 2 || unused[1].Seq != 3 {
+		t.Errorf("unused = %v, want #2 and #3", unused)
+	}
+}
+
+func TestPlayerNextStrict(t *testing.T) {
+	p := NewPlayer(testCassette(), true)
+
+	if _, err := p.next(KindModel, "execute", "other"); !errors.Is(err, ErrNoRecording) {
+		t.Fatalf("strict next with unknown key: err = %v, want ErrNoRecording", err)
+	}
+	if unused := p.Unused(); len(unused) != 4 {
+		t.Errorf("strict miss consumed an interaction: %d unused, want 4", len(unused))
+	}
+
+	got, err := p.next(KindModel, "execute", "k1")
+	if err != nil || got.Seq != 1 {
+		t.Fatalf("strict next(k1) = %v, %v; want #1", got, err)
+	}
+}
+
+func TestPlayerNextFallsBackInOrder(t *testing.T) {
+	p := NewPlayer(testCassette(), false)
+
+	// 指纹都不同时按录制顺序取同名的下一条
+	for _, want := range []int{1, 4} {
+		got, err := p.next(KindModel, "execute", "changed")
+		if err != nil || got.Seq != want {
+			t.Fatalf("fallback next = %v, %v; want #%d", got, err, want)
+		}
+	}
+	if misses := p.Misses(); len(misses) != 2 {
+		t.Errorf("misses = %v, want 2 entries", misses)
+	}
+
+	if _, err := p.next(KindModel, "execute", "changed"); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("next after fallback exhausted: err = %v, want ErrNoRecording", err)
+	}
+}
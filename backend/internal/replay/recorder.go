@@ -0,0 +1,221 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/components"
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+// Recorder 包装真实的模型和工具，把每次调用的请求和结果写入录音
+type Recorder struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	path     string
+	streams  sync.WaitGroup
+}
+
+// NewRecorder 创建录制器，Save 时写入 path
+func NewRecorder(path string) *Recorder {
+	return &Recorder{
+		cassette: &Cassette{Version: cassetteVersion, RecordedAt: time.Now()},
+		path:     path,
+	}
+}
+
+// Path 录音文件路径
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Save 等待仍在输出的流式调用录制完成后保存录音
+func (r *Recorder) Save() error {
+	r.streams.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Save(r.path)
+}
+
+// Cassette 返回已录制的内容
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette
+}
+
+func (r *Recorder) add(interaction *Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interaction.Seq = len(r.cassette.Interactions) + 1
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+}
+
+// WrapModel 包装模型，name 为回放时匹配用的模型名（通常是阶段名）
+func (r *Recorder) WrapModel(name string, m einoModel.BaseChatModel) einoModel.ChatModel {
+	return &recordingModel{recorder: r, name: name, inner: m}
+}
+
+// WrapTools 包装工具并记录可用工具的列表，不支持同步调用的工具原样返回
+func (r *Recorder) WrapTools(ctx context.Context, tools []tool.BaseTool) []tool.BaseTool {
+	wrapped := make([]tool.BaseTool, 0, len(tools))
+	var specs []ToolSpec
+	for _, t := range tools {
+		info, err := t.Info(ctx)
+		if err != nil {
+			wrapped = append(wrapped, t)
+			continue
+		}
+		specs = append(specs, ToolSpec{Name: info.Name, Desc: info.Desc})
+
+		invokable, ok := t.(tool.InvokableTool)
+		if !ok {
+			wrapped = append(wrapped, t)
+			continue
+		}
+		wrapped = append(wrapped, &recordingTool{recorder: r, name: info.Name, inner: invokable})
+	}
+
+	r.mu.Lock()
+	r.cassette.Tools = specs
+	r.mu.Unlock()
+	return wrapped
+}
+
+// recordingModel 录制模型调用，流式调用在流结束后记录拼接后的完整回复
+type recordingModel struct {
+	recorder *Recorder
+	name     string
+	inner    einoModel.BaseChatModel
+}
+
+func (m *recordingModel) Generate(ctx context.Context, input []*schema.Message, opts ...einoModel.Option) (*schema.Message, error) {
+	out, err := m.inner.Generate(ctx, input, opts...)
+	m.record(input, out, err)
+	return out, err
+}
+
+func (m *recordingModel) Stream(ctx context.Context, input []*schema.Message, opts ...einoModel.Option) (*schema.StreamReader[*schema.Message], error) {
+	sr, err := m.inner.Stream(ctx, input, opts...)
+	if err != nil {
+		m.record(input, nil, err)
+		return nil, err
+	}
+
+	copies := sr.Copy(2)
+	m.recorder.streams.Add(1)
+	go func() {
+		defer m.recorder.streams.Done()
+		defer copies[1].Close()
+
+		var chunks []*schema.Message
+		for {
+			chunk, err := copies[1].Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					m.record(input, nil, err)
+					return
+				}
+				break
+			}
+			chunks = append(chunks, chunk)
+		}
+
+		out, err := schema.ConcatMessages(chunks)
+		m.record(input, out, err)
+	}()
+	return copies[0], nil
+}
+
+func (m *recordingModel) BindTools(tools []*schema.ToolInfo) error {
+	chatModel, ok := m.inner.(einoModel.ChatModel)
+	if !ok {
+		return fmt.Errorf("model %s does not support binding tools", m.name)
+	}
+	return chatModel.BindTools(tools)
+}
+
+func (m *recordingModel) WithTools(tools []*schema.ToolInfo) (einoModel.ToolCallingChatModel, error) {
+	toolCalling, ok := m.inner.(einoModel.ToolCallingChatModel)
+	if !ok {
+		return nil, fmt.Errorf("model %s does not support WithTools", m.name)
+	}
+	inner, err := toolCalling.WithTools(tools)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingModel{recorder: m.recorder, name: m.name, inner: inner}, nil
+}
+
+// IsCallbacksEnabled 与被包装的模型一致，避免回调被重复触发
+func (m *recordingModel) IsCallbacksEnabled() bool {
+	return components.IsCallbacksEnabled(m.inner)
+}
+
+func (m *recordingModel) GetType() string {
+	if typ, ok := components.GetType(m.inner); ok {
+		return typ
+	}
+	return "Recording"
+}
+
+func (m *recordingModel) record(input []*schema.Message, out *schema.Message, err error) {
+	interaction := &Interaction{
+		Kind:     KindModel,
+		Name:     m.name,
+		Key:      modelKey(input),
+		Messages: input,
+		Response: out,
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+	m.recorder.add(interaction)
+}
+
+// recordingTool 录制工具调用
+type recordingTool struct {
+	recorder *Recorder
+	name     string
+	inner    tool.InvokableTool
+}
+
+func (t *recordingTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return t.inner.Info(ctx)
+}
+
+func (t *recordingTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	result, err := t.inner.InvokableRun(ctx, argumentsInJSON, opts...)
+
+	interaction := &Interaction{
+		Kind:      KindTool,
+		Name:      t.name,
+		Key:       toolKey(t.name, argumentsInJSON),
+		Arguments: argumentsInJSON,
+		Result:    result,
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+	t.recorder.add(interaction)
+	return result, err
+}
+
+// IsCallbacksEnabled 与被包装的工具一致，避免回调被重复触发
+func (t *recordingTool) IsCallbacksEnabled() bool {
+	return components.IsCallbacksEnabled(t.inner)
+}
+
+func (t *recordingTool) GetType() string {
+	if typ, ok := components.GetType(t.inner); ok {
+		return typ
+	}
+	return "Recording"
+}
@@ -0,0 +1,354 @@
+// Package scheduler 全局运行调度：限制同时执行的运行数，按用户和模型提供商分别限流，超出的运行在有界队列中排队
+//
+// 队列按到达顺序调度，排在前面的运行受用户或提供商限制无法开始时，后面不受限制的运行可以先开始，
+// 避免一个用户的大量请求堵住其他用户。
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("run queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting in run queue")
+)
+
+// avgSamples 平均等待和执行时长按最近约多少个运行做指数滑动平均
+const avgSamples = 10
+
+// Config 调度限制，Workers 小于 1 时按 1 处理，其余限制为 0 表示不限制
+type Config struct {
+	Workers      int            // 同时执行的运行数
+	QueueSize    int            // 排队的运行数上限
+	PerUser      int            // 每个用户同时执行的运行数
+	PerProvider  map[string]int // 每个模型提供商同时执行的运行数
+	QueueTimeout time.Duration  // 排队等待的最长时间
+}
+
+// Job 待调度的运行，Providers 为运行会用到的全部模型提供商
+type Job struct {
+	User      string
+	Providers []string
+}
+
+// Status 排队中的运行的位置和预计开始等待时间，ETA 为 0 表示还没有可供估计的历史
+type Status struct {
+	Position int
+	ETA      time.Duration
+}
+
+// 凭证的状态，只在持有调度器的锁时读写
+const (
+	ticketQueued = iota
+	ticketRunning
+	ticketDone
+)
+
+// Ticket 提交后得到的调度凭证，开始执行后 ready 关闭；无论是否开始执行，最后都必须调用 Done
+type Ticket struct {
+	s         *Scheduler
+	job       Job
+	state     int
+	queuedAt  time.Time
+	startedAt time.Time
+	ready     chan struct{}
+	updates   chan Status
+}
+
+// Queued 是否还在排队
+func (t *Ticket) Queued() bool {
+	select {
+	case <-t.ready:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait 等待开始执行，排队位置变化时调用 onStatus；ctx 结束或超过排队时间时退出队列并返回错误
+func (t *Ticket) Wait(ctx context.Context, onStatus func(Status)) error {
+	var timeout <-chan time.Time
+	if t.s.cfg.QueueTimeout > 0 {
+		timer := time.NewTimer(t.s.cfg.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case <-t.ready:
+			return nil
+		case status := <-t.updates:
+			if onStatus != nil {
+				onStatus(status)
+			}
+		case <-ctx.Done():
+			if t.s.leave(t, false) {
+				return ctx.Err()
+			}
+			// 退出的同时刚好轮到执行
+			return nil
+		case <-timeout:
+			if t.s.leave(t, true) {
+				return fmt.Errorf("%w: waited %s", ErrQueueTimeout, t.s.cfg.QueueTimeout)
+			}
+			return nil
+		}
+	}
+}
+
+// Done 运行结束后释放占用的名额；还在排队时退出队列。可以重复调用
+func (t *Ticket) Done() {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch t.state {
+	case ticketQueued:
+		s.remove(t)
+		s.abandoned++
+	case ticketRunning:
+		s.release(t)
+	default:
+		return
+	}
+	t.state = ticketDone
+	s.dispatch()
+}
+
+// Metrics 调度器的当前状态和累计计数
+type Metrics struct {
+	Workers           int            `json:"workers"`
+	QueueSize         int            `json:"queue_size"`
+	PerUserLimit      int            `json:"per_user_limit"`
+	ProviderLimits    map[string]int `json:"provider_limits"`
+	Running           int            `json:"running"`
+	Queued            int            `json:"queued"`
+	RunningByUser     map[string]int `json:"running_by_user"`
+	RunningByProvider map[string]int `json:"running_by_provider"`
+	Submitted         uint64         `json:"submitted"`
+	Started           uint64         `json:"started"`
+	Completed         uint64         `json:"completed"`
+	Rejected          uint64         `json:"rejected"`  // 队列已满被拒绝
+	TimedOut          uint64         `json:"timed_out"` // 排队超时
+	Abandoned         uint64         `json:"abandoned"` // 排队中放弃
+	AvgWaitMs         int64          `json:"avg_wait_ms"`
+	AvgRunMs          int64          `json:"avg_run_ms"`
+}
+
+// Scheduler 运行调度器，并发安全
+type Scheduler struct {
+	cfg Config
+
+	mu                sync.Mutex
+	queue             []*Ticket
+	running           int
+	runningByUser     map[string]int
+	runningByProvider map[string]int
+	avgWait           time.Duration
+	avgRun            time.Duration
+
+	submitted, started, completed, rejected, timedOut, abandoned uint64
+}
+
+// New 创建调度器
+func New(cfg Config) *Scheduler {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	limits := make(map[string]int, len(cfg.PerProvider))
+	for provider, limit := range cfg.PerProvider {
+		limits[provider] = limit
+	}
+	cfg.PerProvider = limits
+
+	return &Scheduler{
+		cfg:               cfg,
+		runningByUser:     make(map[string]int),
+		runningByProvider: make(map[string]int),
+	}
+}
+
+// Submit 提交运行：有空闲名额时直接开始，否则进入队列；队列已满时返回 ErrQueueFull
+func (s *Scheduler) Submit(job Job) (*Ticket, error) {
+	t := &Ticket{
+		s:        s,
+		job:      job,
+		state:    ticketQueued,
+		queuedAt: time.Now(),
+		ready:    make(chan struct{}),
+		updates:  make(chan Status, 1),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg.QueueSize > 0 && len(s.queue) >= s.cfg.QueueSize && !s.canStart(t) {
+		s.rejected++
+		return nil, fmt.Errorf("%w: %d runs queued", ErrQueueFull, len(s.queue))
+	}
+
+	s.submitted++
+	s.queue = append(s.queue, t)
+	s.dispatch()
+	return t, nil
+}
+
+// RetryAfter 队列已满时建议客户端等待的时间，没有历史时为 0
+func (s *Scheduler) RetryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return 0
+	}
+	return s.statusAt(len(s.queue) - 1).ETA
+}
+
+// Metrics 返回调度器状态的快照
+func (s *Scheduler) Metrics() Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := Metrics{
+		Workers:           s.cfg.Workers,
+		QueueSize:         s.cfg.QueueSize,
+		PerUserLimit:      s.cfg.PerUser,
+		ProviderLimits:    make(map[string]int, len(s.cfg.PerProvider)),
+		Running:           s.running,
+		Queued:            len(s.queue),
+		RunningByUser:     make(map[string]int, len(s.runningByUser)),
+		RunningByProvider: make(map[string]int, len(s.runningByProvider)),
+		Submitted:         s.submitted,
+		Started:           s.started,
+		Completed:         s.completed,
+		Rejected:          s.rejected,
+		TimedOut:          s.timedOut,
+		Abandoned:         s.abandoned,
+		AvgWaitMs:         s.avgWait.Milliseconds(),
+		AvgRunMs:          s.avgRun.Milliseconds(),
+	}
+	for provider, limit := range s.cfg.PerProvider {
+		m.ProviderLimits[provider] = limit
+	}
+	for user, n := range s.runningByUser {
+		m.RunningByUser[user] = n
+	}
+	for provider, n := range s.runningByProvider {
+		m.RunningByProvider[provider] = n
+	}
+	return m
+}
+
+// leave 排队中的运行退出队列，已经开始执行时不做任何事并返回 false
+func (s *Scheduler) leave(t *Ticket, timedOut bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.state != ticketQueued {
+		return false
+	}
+	s.remove(t)
+	t.state = ticketDone
+	if timedOut {
+		s.timedOut++
+	} else {
+		s.abandoned++
+	}
+	s.dispatch()
+	return true
+}
+
+func (s *Scheduler) remove(t *Ticket) {
+	for i, q := range s.queue {
+		if q == t {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// release 释放运行占用的名额并记录执行时长
+func (s *Scheduler) release(t *Ticket) {
+	s.running--
+	if s.runningByUser[t.job.User]--; s.runningByUser[t.job.User] <= 0 {
+		delete(s.runningByUser, t.job.User)
+	}
+	for _, provider := range t.job.Providers {
+		if s.runningByProvider[provider]--; s.runningByProvider[provider] <= 0 {
+			delete(s.runningByProvider, provider)
+		}
+	}
+	s.completed++
+	s.avgRun = movingAverage(s.avgRun, time.Since(t.startedAt), s.completed)
+}
+
+// canStart 是否还有空闲的执行名额，并且运行所属用户和用到的提供商都没有达到限制
+func (s *Scheduler) canStart(t *Ticket) bool {
+	if s.running >= s.cfg.Workers {
+		return false
+	}
+	if s.cfg.PerUser > 0 && s.runningByUser[t.job.User] >= s.cfg.PerUser {
+		return false
+	}
+	for _, provider := range t.job.Providers {
+		if limit := s.cfg.PerProvider[provider]; limit > 0 && s.runningByProvider[provider] >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch 按到达顺序开始可以执行的运行，然后通知仍在排队的运行新的位置，调用方持有锁
+func (s *Scheduler) dispatch() {
+	for i := 0; i < len(s.queue) && s.running < s.cfg.Workers; {
+		t := s.queue[i]
+		if !s.canStart(t) {
+			i++
+			continue
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.start(t)
+	}
+
+	for i, t := range s.queue {
+		// 只保留最新的位置，前端不需要每一次变化
+		select {
+		case <-t.updates:
+		default:
+		}
+		t.updates <- s.statusAt(i)
+	}
+}
+
+func (s *Scheduler) start(t *Ticket) {
+	s.running++
+	s.runningByUser[t.job.User]++
+	for _, provider := range t.job.Providers {
+		s.runningByProvider[provider]++
+	}
+	s.started++
+	t.state = ticketRunning
+	t.startedAt = time.Now()
+	s.avgWait = movingAverage(s.avgWait, t.startedAt.Sub(t.queuedAt), s.started)
+	close(t.ready)
+}
+
+// statusAt 队列中第 i 个运行的位置，预计等待按前面的运行分批执行、每批用时为平均执行时长估算
+func (s *Scheduler) statusAt(i int) Status {
+	position := i + 1
+	rounds := (position + s.cfg.Workers - 1) / s.cfg.Workers
+	return Status{Position: position, ETA: s.avgRun * time.Duration(rounds)}
+}
+
+// movingAverage 前 avgSamples 个样本取算术平均，之后按指数滑动平均，更接近最近的负载
+func movingAverage(avg, sample time.Duration, n uint64) time.Duration {
+	if n > avgSamples {
+		n = avgSamples
+	}
+	return avg + (sample-avg)/time.Duration(n)
+}
@@ -0,0 +1,263 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// job 按 "用户/提供商1,提供商2" 的格式构造运行
+func job(spec string) Job {
+	user, providers, _ := strings.Cut(spec, "/")
+	j := Job{User: user}
+	if providers != "" {
+		j.Providers = strings.Split(providers, ",")
+	}
+	return j
+}
+
+// started 按提交顺序列出已经开始执行的运行序号（从 1 开始）
+func started(tickets []*Ticket) string {
+	var out []string
+	for i, t := range tickets {
+		if t != nil && !t.Queued() {
+			out = append(out, strconv.Itoa(i+1))
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		jobs      []string
+		want      string // 提交后立即开始的运行
+		wantAfter string // 第一个运行结束后开始的运行（含第一个）
+	}{
+		{
+			name:      "执行名额",
+			cfg:       Config{Workers: 2},
+			jobs:      []string{"a", "b", "c"},
+			want:      "1,2",
+			wantAfter: "1,2,3",
+		},
+		{
+			name:      "名额小于 1 时按 1 处理",
+			cfg:       Config{Workers: 0},
+			jobs:      []string{"a", "b"},
+			want:      "1",
+			wantAfter: "1,2",
+		},
+		{
+			name:      "每个用户的限制，后面其他用户的运行先开始",
+			cfg:       Config{Workers: 4, PerUser: 1},
+			jobs:      []string{"a", "a", "b", "a"},
+			want:      "1,3",
+			wantAfter: "1,2,3",
+		},
+		{
+			name:      "用户限制为 0 表示不限制",
+			cfg:       Config{Workers: 3},
+			jobs:      []string{"a", "a", "a", "a"},
+			want:      "1,2,3",
+			wantAfter: "1,2,3,4",
+		},
+		{
+			name:      "每个提供商的限制",
+			cfg:       Config{Workers: 4, PerProvider: map[string]int{"qwen": 1}},
+			jobs:      []string{"a/qwen", "b/qwen", "c/openai", "d/openai,qwen"},
+			want:      "1,3",
+			wantAfter: "1,2,3",
+		},
+		{
+			name:      "用到多个提供商时各占一个名额",
+			cfg:       Config{Workers: 4, PerProvider: map[string]int{"qwen": 2, "openai": 1}},
+			jobs:      []string{"a/qwen,openai", "b/qwen", "c/openai", "d/qwen"},
+			want:      "1,2",
+			wantAfter: "1,2,3,4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.cfg)
+			tickets := make([]*Ticket, len(tt.jobs))
+			for i, spec := range tt.jobs {
+				ticket, err := s.Submit(job(spec))
+				if err != nil {
+					t.Fatalf("Submit(%s) error = %v", spec, err)
+				}
+				tickets[i] = ticket
+			}
+			if got := started(tickets); got != tt.want {
+				t.Fatalf("started = %s, want %s", got, tt.want)
+			}
+
+			tickets[0].Done()
+			tickets[0].Done() // 重复调用不会多释放名额
+			if got := started(tickets); got != tt.wantAfter {
+				t.Errorf("started after first run done = %s, want %s", got, tt.wantAfter)
+			}
+
+			for _, ticket := range tickets {
+				ticket.Done()
+			}
+			m := s.Metrics()
+			if m.Running != 0 || m.Queued != 0 || len(m.RunningByUser) != 0 || len(m.RunningByProvider) != 0 {
+				t.Errorf("metrics after all runs done = %+v, want idle", m)
+			}
+		})
+	}
+}
+
+func TestSchedulerQueueSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		jobs    []string
+		wantErr []bool
+	}{
+		{
+			name:    "队列已满时拒绝",
+			cfg:     Config{Workers: 1, QueueSize: 1},
+			jobs:    []string{"a", "b", "c"},
+			wantErr: []bool{false, false, true},
+		},
+		{
+			name:    "队列已满但可以直接开始的运行不拒绝",
+			cfg:     Config{Workers: 2, QueueSize: 1, PerUser: 1},
+			jobs:    []string{"a", "a", "b", "c"},
+			wantErr: []bool{false, false, false, true},
+		},
+		{
+			name:    "队列大小为 0 表示不限制",
+			cfg:     Config{Workers: 1},
+			jobs:    []string{"a", "b", "c", "d"},
+			wantErr: []bool{false, false, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.cfg)
+			rejected := 0
+			for i, spec := range tt.jobs {
+				ticket, err := s.Submit(job(spec))
+				if tt.wantErr[i] {
+					rejected++
+					if !errors.Is(err, ErrQueueFull) {
+						t.Errorf("Submit #%d error = %v, want ErrQueueFull", i+1, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("Submit #%d error = %v", i+1, err)
+					continue
+				}
+				defer ticket.Done()
+			}
+			if m := s.Metrics(); m.Rejected != uint64(rejected) || m.Submitted != uint64(len(tt.jobs)-rejected) {
+				t.Errorf("metrics rejected %d submitted %d, want %d and %d", m.Rejected, m.Submitted, rejected, len(tt.jobs)-rejected)
+			}
+		})
+	}
+}
+
+func TestSchedulerETA(t *testing.T) {
+	s := New(Config{Workers: 2})
+
+	// 没有历史时不估计等待时间
+	a, _ := s.Submit(job("a"))
+	b, _ := s.Submit(job("b"))
+	c, _ := s.Submit(job("c"))
+	if status := <-c.updates; status.Position != 1 || status.ETA != 0 {
+		t.Fatalf("status without history = %+v, want position 1 and no ETA", status)
+	}
+	if eta := s.RetryAfter(); eta != 0 {
+		t.Fatalf("RetryAfter() without history = %s, want 0", eta)
+	}
+
+	// 排队的运行先退出，平均执行时间只来自 a 和 b
+	c.Done()
+	time.Sleep(20 * time.Millisecond)
+	a.Done()
+	b.Done()
+	avg := time.Duration(s.Metrics().AvgRunMs) * time.Millisecond
+	if avg < 20*time.Millisecond {
+		t.Fatalf("average run time = %s, want at least 20ms", avg)
+	}
+
+	// 两个名额：排第 1、2 位的等一批，排第 3 位的等两批
+	var tickets []*Ticket
+	for _, spec := range []string{"a", "b", "c", "d", "e"} {
+		ticket, _ := s.Submit(job(spec))
+		defer ticket.Done()
+		tickets = append(tickets, ticket)
+	}
+	tests := []struct {
+		ticket   *Ticket
+		position int
+		rounds   int
+	}{
+		{tickets[2], 1, 1},
+		{tickets[3], 2, 1},
+		{tickets[4], 3, 2},
+	}
+	var etas []time.Duration
+	for _, tt := range tests {
+		status := <-tt.ticket.updates
+		if status.Position != tt.position {
+			t.Errorf("position = %d, want %d", status.Position, tt.position)
+		}
+		etas = append(etas, status.ETA)
+		if status.ETA/time.Duration(tt.rounds) != etas[0] {
+			t.Errorf("ETA at position %d = %s, want %d x %s", tt.position, status.ETA, tt.rounds, etas[0])
+		}
+	}
+	if eta := s.RetryAfter(); eta != etas[len(etas)-1] {
+		t.Errorf("RetryAfter() = %s, want ETA of the last queued run %s", eta, etas[len(etas)-1])
+	}
+}
+
+func TestSchedulerWait(t *testing.T) {
+	s := New(Config{Workers: 1, QueueTimeout: 50 * time.Millisecond})
+	running, _ := s.Submit(job("a"))
+
+	// 排队超时
+	queued, _ := s.Submit(job("b"))
+	var positions []int
+	err := queued.Wait(context.Background(), func(status Status) { positions = append(positions, status.Position) })
+	if !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("Wait() error = %v, want ErrQueueTimeout", err)
+	}
+	if len(positions) == 0 || positions[0] != 1 {
+		t.Errorf("reported positions = %v, want [1]", positions)
+	}
+
+	// ctx 结束时退出队列
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned, _ := s.Submit(job("c"))
+	cancel()
+	if err := abandoned.Wait(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait() after cancel error = %v, want context.Canceled", err)
+	}
+
+	// 前面的运行结束后开始执行
+	next, _ := s.Submit(job("d"))
+	done := make(chan error, 1)
+	go func() { done <- next.Wait(context.Background(), nil) }()
+	running.Done()
+	if err := <-done; err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	next.Done()
+
+	m := s.Metrics()
+	if m.TimedOut != 1 || m.Abandoned != 1 || m.Started != 2 || m.Completed != 2 || m.Queued != 0 || m.Running != 0 {
+		t.Errorf("metrics = %+v, want 1 timed out, 1 abandoned, 2 started and completed", m)
+	}
+}
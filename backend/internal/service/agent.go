@@ -2,7 +2,7 @@ package service
 
 import (
 	"context"
-	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"glata-backend/internal/config"
 	"glata-backend/internal/model"
@@ -11,23 +11,17 @@ import (
 	"glata-backend/pkg/logger"
 	"io"
 	"log"
-	"os"
-	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/cloudwego/eino-examples/quickstart/eino_assistant/pkg/mem"
-	"github.com/cloudwego/eino/callbacks"
 	einoModel "github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/compose"
 	"github.com/cloudwego/eino/schema"
 )
 
-var memory = mem.GetDefaultMemory()
-var cbHandler callbacks.Handler
 var globalStorage storage.Storage
 
 // MessageCleaner 消息清理器，用于过滤无效的消息
@@ -103,7 +97,9 @@ type ProgressEvent struct {
 type ProgressManager struct {
 	progressChan chan ProgressEvent
 	sessionID    string
-	closed       bool // 添加标志防止重复关闭
+	closed       bool           // 添加标志防止重复关闭
+	mu           sync.Mutex     // 并行任务会同时发送事件
+	tokenStreams sync.WaitGroup // 还在推送 token 的模型输出流
 }
 
 // NewProgressManager 创建新的进度管理器
@@ -116,6 +112,9 @@ func NewProgressManager(sessionID string) *ProgressManager {
 
 // SendEvent 发送进度事件
 func (pm *ProgressManager) SendEvent(eventType, nodeName, message string, data map[string]interface{}, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
 	// 如果channel已关闭，直接返回
 	if pm.closed {
 		return
@@ -144,6 +143,41 @@ func (pm *ProgressManager) SendEvent(eventType, nodeName, message string, data m
 	}
 }
 
+// SendToken 发送模型输出的增量，通道已用过半时不发送并返回 false，为节点完成等事件留出空间
+// 调用方应保留未发送的内容，与下一个增量合并后再发送
+func (pm *ProgressManager) SendToken(nodeName, delta string, data map[string]interface{}) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.closed || len(pm.progressChan) >= cap(pm.progressChan)/2 {
+		return false
+	}
+
+	pm.progressChan <- ProgressEvent{
+		EventType: "token",
+		NodeName:  nodeName,
+		SessionID: pm.sessionID,
+		Message:   delta,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	return true
+}
+
+// WaitTokens 等待正在推送的 token 发送完，避免最终结果或完成事件先于 token 到达，最多等待 timeout
+func (pm *ProgressManager) WaitTokens(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pm.tokenStreams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Warnf("💬 Timed out waiting for token streams of session %s", pm.sessionID)
+	}
+}
+
 // GetProgressChannel 获取进度通道
 func (pm *ProgressManager) GetProgressChannel() <-chan ProgressEvent {
 	return pm.progressChan
@@ -151,6 +185,9 @@ func (pm *ProgressManager) GetProgressChannel() <-chan ProgressEvent {
 
 // Close 关闭进度通道
 func (pm *ProgressManager) Close() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
 	if !pm.closed {
 		pm.closed = true
 		close(pm.progressChan)
@@ -162,229 +199,6 @@ func InitAgentStorage(store storage.Storage) {
 	globalStorage = store
 }
 
-// getTodoListStoragePath 获取 TODO list 存储路径
-func getTodoListStoragePath() string {
-	cfg := config.Get()
-	if cfg != nil && cfg.Storage.DataDir != "" {
-		return filepath.Join(cfg.Storage.DataDir, "todolists")
-	}
-	return "./data/todolists"
-}
-
-// cleanTodoListContent 清理 TODO list 内容，只保留任务列表，并提取最后一个完整的todolist
-func cleanTodoListContent(content string) string {
-	if content == "" {
-		return ""
-	}
-
-	// 先移除思考标签
-	content = removeThinkingTags(content)
-
-	lines := strings.Split(content, "\n")
-	var allTodoLines []string
-
-	// 收集所有符合格式的TODO行
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		// 跳过空行
-		if line == "" {
-			continue
-		}
-
-		// 使用更宽松的TODO格式检测，支持多种变体
-		isTodoLine := false
-
-		// 检查各种TODO格式
-		if strings.HasPrefix(line, "- [ ]") || strings.HasPrefix(line, "- [x]") || strings.HasPrefix(line, "- [!]") ||
-			strings.HasPrefix(line, "* [ ]") || strings.HasPrefix(line, "* [x]") || strings.HasPrefix(line, "* [!]") ||
-			strings.HasPrefix(line, "-  [ ]") || strings.HasPrefix(line, "-  [x]") || strings.HasPrefix(line, "-  [!]") ||
-			strings.HasPrefix(line, "*  [ ]") || strings.HasPrefix(line, "*  [x]") || strings.HasPrefix(line, "*  [!]") {
-			isTodoLine = true
-		}
-
-		// 使用正则表达式匹配更复杂的格式，支持[!]失败状态
-		if !isTodoLine {
-			todoRegex := regexp.MustCompile(`^\s*[-*]\s*\[\s*[x\s!]*\s*\]\s*.+`)
-			if todoRegex.MatchString(line) {
-				isTodoLine = true
-			}
-		}
-
-		// 🎯 关键修复：增强内容过滤，检测格式错误的混乱行
-		if isTodoLine {
-			// 检查是否是格式错误的混乱行
-			isCorruptedLine := false
-
-			// 检测混乱模式：包含多个checkbox标记或格式混乱
-			checkboxCount := strings.Count(line, "[x]") + strings.Count(line, "[ ]") + strings.Count(line, "[!]")
-			if checkboxCount > 1 {
-				isCorruptedLine = true
-				logger.Warnf("Filtering corrupted TODO line with multiple checkboxes: %s", line)
-			}
-
-			// 检测异常长度（超过200字符可能是格式错误）
-			if len(line) > 200 {
-				isCorruptedLine = true
-				logger.Warnf("Filtering corrupted TODO line (too long): %s", line[:100]+"...")
-			}
-
-			// 检测混乱的编号格式（如包含多个数字后跟冒号的模式）
-			colonNumberPattern := regexp.MustCompile(`\d+：.*\d+：`)
-			if colonNumberPattern.MatchString(line) {
-				isCorruptedLine = true
-				logger.Warnf("Filtering corrupted TODO line with mixed numbering: %s", line)
-			}
-
-			// 过滤掉明显不是任务的行和格式错误的行
-			if !isCorruptedLine &&
-				!strings.Contains(line, "已完成任务") &&
-				!strings.Contains(line, "未完成任务") &&
-				!strings.HasSuffix(line, "任务4") { // 过滤掉截断的任务行
-				allTodoLines = append(allTodoLines, line)
-			}
-		}
-	}
-
-	if len(allTodoLines) == 0 {
-		return ""
-	}
-
-	// 从所有TODO行中提取最后一个完整的todolist
-	finalTodoList := extractFinalTodoList(allTodoLines)
-
-	return strings.TrimSpace(finalTodoList)
-}
-
-// extractFinalTodoList 从所有TODO行中提取最后一个完整的todolist
-func extractFinalTodoList(allTodoLines []string) string {
-	if len(allTodoLines) == 0 {
-		return ""
-	}
-
-	// 如果任务数量较少，可能是单个正常的todolist，直接返回
-	if len(allTodoLines) <= 10 {
-		return strings.Join(allTodoLines, "\n")
-	}
-
-	// 使用有序map来维护任务顺序，同时进行去重
-	taskMap := make(map[string]*TaskInfo)
-	order := 0
-
-	for _, line := range allTodoLines {
-		taskKey := extractTaskKey(line)
-		if taskKey != "" {
-			isCompleted := strings.Contains(line, "[x]")
-
-			// 如果任务已存在
-			if existingTask, exists := taskMap[taskKey]; exists {
-				// 如果新的是已完成状态，或者现有的是未完成状态，则更新
-				if isCompleted || !existingTask.IsCompleted {
-					taskMap[taskKey] = &TaskInfo{
-						Key:         taskKey,
-						Line:        line,
-						Order:       existingTask.Order, // 保持原有顺序
-						IsCompleted: isCompleted,
-					}
-				}
-			} else {
-				// 新任务，添加到map中
-				taskMap[taskKey] = &TaskInfo{
-					Key:         taskKey,
-					Line:        line,
-					Order:       order,
-					IsCompleted: isCompleted,
-				}
-				order++
-			}
-		}
-	}
-
-	// 按原始顺序排序所有任务
-	var taskList []*TaskInfo
-	for _, task := range taskMap {
-		taskList = append(taskList, task)
-	}
-
-	// 按Order字段排序
-	for i := 0; i < len(taskList); i++ {
-		for j := i + 1; j < len(taskList); j++ {
-			if taskList[i].Order > taskList[j].Order {
-				taskList[i], taskList[j] = taskList[j], taskList[i]
-			}
-		}
-	}
-
-	// 提取所有任务行
-	var result []string
-	for _, task := range taskList {
-		result = append(result, task.Line)
-	}
-
-	return strings.Join(result, "\n")
-}
-
-// extractTaskKey 从TODO行中提取任务键值 - 统一标识符算法
-func extractTaskKey(line string) string {
-	// 🎯 关键修复：使用更严格和一致的任务编号提取逻辑
-	// 优先匹配明确的任务编号格式，确保一致性
-
-	// 清理输入行，移除多余空格
-	line = strings.TrimSpace(line)
-
-	// 格式1：严格匹配数字后跟冒号或点的格式（如 "1：" 或 "1." 或 "1 "）
-	// 使用更精确的正则表达式，避免误匹配
-	strictNumRegex := regexp.MustCompile(`^[*\-]\s*\[[x\s!]\]\s*(\d+)[：.\s]\s*`)
-	if match := strictNumRegex.FindStringSubmatch(line); len(match) > 1 {
-		taskNum := match[1]
-		logger.Debugf("Extracted task key (strict format): %s from line: %s", taskNum, line)
-		return taskNum
-	}
-
-	// 格式2：兼容任务+数字格式（如 "任务1"、"任务2"）
-	taskWordRegex := regexp.MustCompile(`^[*\-]\s*\[[x\s!]\]\s*任务(\d+)`)
-	if match := taskWordRegex.FindStringSubmatch(line); len(match) > 1 {
-		taskNum := match[1]
-		logger.Debugf("Extracted task key (task word format): %s from line: %s", taskNum, line)
-		return taskNum
-	}
-
-	// 格式3：提取行首第一个数字作为兜底方案
-	firstNumRegex := regexp.MustCompile(`(\d+)`)
-	if match := firstNumRegex.FindStringSubmatch(line); len(match) > 1 {
-		taskNum := match[1]
-		logger.Debugf("Extracted task key (first number): %s from line: %s", taskNum, line)
-		return taskNum
-	}
-
-	// 🎯 关键修复：如果没有找到数字编号，使用内容哈希确保一致性
-	// 清理任务内容：移除checkbox标记和符号
-	content := line
-
-	// 按顺序移除各种格式的checkbox标记
-	checkboxPatterns := []string{"- [x]", "- [ ]", "- [!]", "* [x]", "* [ ]", "* [!]"}
-	for _, pattern := range checkboxPatterns {
-		content = strings.TrimSpace(strings.TrimPrefix(content, pattern))
-	}
-
-	// 移除前导符号
-	content = strings.TrimSpace(strings.TrimPrefix(content, "-"))
-	content = strings.TrimSpace(strings.TrimPrefix(content, "*"))
-
-	// 🎯 增强一致性：使用内容的前50个字符，确保相同内容始终产生相同key
-	if len(content) > 50 {
-		content = content[:50]
-	}
-
-	// 如果内容为空，使用行的哈希
-	if content == "" {
-		content = fmt.Sprintf("hash_%x", md5.Sum([]byte(line)))[:16]
-	}
-
-	logger.Debugf("Extracted task key (content-based): %s from line: %s", content, line)
-	return content
-}
-
 // removeThinkingTags 移除思考标签（与 chat_service.go 中的函数保持一致）
 func removeThinkingTags(content string) string {
 	// 移除 <think>...</think> 标签及其内容
@@ -428,913 +242,695 @@ func removeThinkingTagsForStream(content string) string {
 	return content
 }
 
-// getTodoListFilePath 获取指定会话的 TODO list 文件路径
-func getTodoListFilePath(sessionID string) string {
-	return filepath.Join(getTodoListStoragePath(), sessionID+".md")
-}
-
-// getNextVersionNumber 获取下一个版本号
-func getNextVersionNumber(sessionID string) (int, error) {
-	filePath := getTodoListFilePath(sessionID)
+// 规划模型输出开头的模式标识
+const (
+	modeDirectReplyMarker = "[MODE:DIRECT_REPLY]"
+	modeTodoListMarker    = "[MODE:TODO_LIST]"
+)
 
-	// 如果文件不存在，返回版本1
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return 1, nil
+// containTodoList 检查规划模型输出是否为结构化计划，同时支持模式标识检测
+func containTodoList(content string) bool {
+	if content == "" {
+		return false
 	}
 
-	// 读取文件内容
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return 1, nil // 读取失败时从版本1开始
+	// 优先检查明确的模式标识
+	if strings.Contains(content, modeDirectReplyMarker) {
+		return false // 明确标识为直接回复模式
 	}
 
-	// 使用正则表达式查找最大版本号
-	re := regexp.MustCompile(`## Version v(\d+)`)
-	matches := re.FindAllStringSubmatch(string(content), -1)
-
-	maxVersion := 0
-	for _, match := range matches {
-		if len(match) > 1 {
-			if version, err := strconv.Atoi(match[1]); err == nil {
-				if version > maxVersion {
-					maxVersion = version
-				}
-			}
+	// 必须能解析出至少一个任务才进入计划模式，避免残缺输出产生幽灵任务
+	if _, err := parsePlanOutput("", content); err != nil {
+		if strings.Contains(content, modeTodoListMarker) {
+			logger.Warnf("Planner declared TODO_LIST mode but output is not a valid plan: %v", err)
 		}
+		return false
 	}
-
-	return maxVersion + 1, nil
+	return true
 }
 
-// writePlanToDisk 将 TODO list 写入磁盘
-func writePlanToDisk(sessionID, todoListContent string) error {
-	// 🎯 清理 TODO list 内容，只保留纯粹的任务列表
-	cleanedContent := cleanTodoListContent(todoListContent)
-	if cleanedContent == "" {
-		logger.Warn("No valid TODO items found after cleaning, skipping write")
-		return nil
-	}
-
-	// 确保存储目录存在
-	storageDir := getTodoListStoragePath()
-	if err := os.MkdirAll(storageDir, 0755); err != nil {
-		return fmt.Errorf("failed to create todolists directory: %w", err)
-	}
-
-	// 🎯 关键修复：合并新内容与现有任务列表
-	mergedContent := mergeWithExistingTodoList(sessionID, cleanedContent)
-	if mergedContent == "" {
-		logger.Warn("Merged content is empty, using cleaned content")
-		mergedContent = cleanedContent
-	}
-
-	// 获取下一个版本号
-	version, err := getNextVersionNumber(sessionID)
-	if err != nil {
-		return fmt.Errorf("failed to get next version number: %w", err)
+// min 返回两个整数中的较小值
+func min(a, b int) int {
+	if a < b {
+		return a
 	}
+	return b
+}
 
-	// 准备版本化的内容 - 只包含合并后的 TODO list
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	versionedContent := fmt.Sprintf("\n## Version v%d - %s\n\n%s\n", version, timestamp, mergedContent)
-
-	// 获取文件路径
-	filePath := getTodoListFilePath(sessionID)
-
-	// 追加写入文件
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open todo list file: %w", err)
-	}
-	defer file.Close()
+// createWritePlanLambda 创建带进度报告的 writePlan lambda 函数
+func createWritePlanLambda(sessionID string, progressManager *ProgressManager) *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
+		logger.Infof("WritePlan node processing plan content for session %s, plan: %s", sessionID, input.Content)
 
-	// 如果是第一次写入，添加文件头
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
+		plan, err := parsePlanOutput(sessionID, input.Content)
+		if err != nil {
+			logger.Warnf("Failed to parse plan output: %v", err)
+			return input, nil
+		}
 
-	if fileInfo.Size() == 0 {
-		header := fmt.Sprintf("# TODO List for Session: %s\n\nThis file contains versioned TODO lists generated by the AI agent.\n", sessionID)
-		if _, err := file.WriteString(header); err != nil {
-			return fmt.Errorf("failed to write file header: %w", err)
+		// 新的计划作为新版本写入，历史版本保留在存储中
+		if _, err := writeNewPlan(sessionID, planSourceWritePlan, plan); err != nil {
+			logger.Errorf("Failed to save plan: %v", err)
 		}
-	}
 
-	// 写入版本化的内容
-	if _, err := file.WriteString(versionedContent); err != nil {
-		return fmt.Errorf("failed to write versioned content: %w", err)
-	}
+		markdown := plan.Markdown()
+		progressManager.SendEvent("node_complete", "", "## 💡 执行计划: \n\n"+markdown+"\n\n",
+			map[string]interface{}{"content_length": len(markdown), "plan": plan}, nil)
 
-	logger.Infof("Successfully wrote TODO list version v%d for session %s", version, sessionID)
-	return nil
+		return input, nil
+	})
 }
 
-// mergeWithExistingTodoList 将新的TODO内容与现有任务列表合并，保护已完成任务状态
-func mergeWithExistingTodoList(sessionID, newContent string) string {
-	// 尝试读取现有的任务列表
-	existingContent, _, err := readLatestPlan(sessionID)
-	if err != nil {
-		// 如果没有现有内容，直接返回新内容
-		logger.Infof("No existing TODO list found for session %s, using new content", sessionID)
-		return newContent
-	}
-
-	// 解析现有任务和新任务
-	existingTasks := parseTasksFromContent(existingContent)
-	newTasks := parseTasksFromContent(newContent)
-
-	logger.Infof("Merging TODO lists: existing=%d tasks, new=%d tasks", len(existingTasks), len(newTasks))
-
-	// 🎯 关键修复：严格保护已完成和失败任务的状态
-	// 新的合并策略：只允许更新待执行任务的状态
-
-	// 创建合并结果，从现有任务开始
-	mergedTasks := make(map[string]*TaskInfo)
-	order := 0
+// createScanTodoListLambda 创建带进度报告的扫描计划 lambda 函数，取出本轮可以并行执行的任务
+func createScanTodoListLambda(sessionID string, progressManager *ProgressManager, maxParallel int) *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
+		logger.Infof("ScanTodoList node processing for session %s", sessionID)
 
-	// 首先添加所有现有任务，严格保持其状态
-	for _, task := range existingTasks {
-		mergedTasks[task.Key] = &TaskInfo{
-			Key:         task.Key,
-			Line:        task.Line,
-			Order:       task.Order,
-			IsCompleted: task.IsCompleted,
+		// 运行已取消时不再领取新任务
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		if task.Order >= order {
-			order = task.Order + 1
-		}
-	}
-
-	// 检查新任务，只允许特定的状态更新
-	for _, newTask := range newTasks {
-		if existingTask, exists := mergedTasks[newTask.Key]; exists {
-			// 🎯 关键防护：增强的状态更新规则和验证
-			canUpdate := false
-			updateReason := "no update needed"
-
-			// 🎯 关键修复：添加双重验证，确保状态转换的正确性
-			existingCompleted := strings.Contains(existingTask.Line, "[x]") || strings.Contains(existingTask.Line, "[!]")
-			newCompleted := strings.Contains(newTask.Line, "[x]") || strings.Contains(newTask.Line, "[!]")
-
-			// 记录详细的状态信息用于调试
-			logger.Infof("🔍 Task '%s' state check: existing=[%v] new=[%v] existingLine='%s' newLine='%s'",
-				newTask.Key, existingCompleted, newCompleted, existingTask.Line, newTask.Line)
-
-			// 🛡️ 严格限制：只允许当前正在执行的任务状态变更
-			// 通过检查任务顺序，确保只有第一个未完成的任务可以被更新
-			isCurrentTask := false
-
-			// 寻找第一个未完成的任务
-			var sortedTasks []*TaskInfo
-			for _, task := range mergedTasks {
-				sortedTasks = append(sortedTasks, task)
-			}
-
-			// 按Order字段排序
-			for i := 0; i < len(sortedTasks); i++ {
-				for j := i + 1; j < len(sortedTasks); j++ {
-					if sortedTasks[i].Order > sortedTasks[j].Order {
-						sortedTasks[i], sortedTasks[j] = sortedTasks[j], sortedTasks[i]
-					}
-				}
-			}
 
-			// 找到第一个未完成的任务
-			for _, task := range sortedTasks {
-				if !task.IsCompleted {
-					if task.Key == newTask.Key {
-						isCurrentTask = true
-						logger.Infof("🎯 Found current executing task: %s", newTask.Key)
-					}
-					break // 只检查第一个未完成的任务
-				}
-			}
+		// 所有任务都已完成时返回空内容，进入总结流程
+		emptyMessage := &schema.Message{
+			Role:    schema.Assistant,
+			Content: "",
+		}
 
-			if !isCurrentTask {
-				// 🛡️ 严格禁止：不是当前任务的状态变更
-				canUpdate = false
-				updateReason = "BLOCKED: only current executing task can be updated"
-				logger.Warnf("🛡️ CRITICAL PROTECTION: Prevented non-current task update for '%s' (not the current executing task)", newTask.Key)
-			} else {
-				// 只允许以下状态转换：
-				// 1. 待执行 → 已完成/失败
-				// 2. 保持已完成/失败状态不变
-				// 禁止的转换：
-				// - 已完成/失败 → 待执行 (防止重复执行)
-				// - 成功 ↔ 失败 (状态类型变更)
-
-				if !existingCompleted && newCompleted {
-					// 允许：待执行 → 已完成/失败
-					canUpdate = true
-					if strings.Contains(newTask.Line, "[x]") {
-						updateReason = "task completed successfully (pending → success)"
-					} else if strings.Contains(newTask.Line, "[!]") {
-						updateReason = "task failed (pending → failed)"
-					}
-				} else if existingCompleted && !newCompleted {
-					// 🛡️ 严格禁止：已完成/失败 → 待执行
-					canUpdate = false
-					updateReason = "BLOCKED: cannot rollback completed/failed task to pending"
-					logger.Warnf("🛡️ CRITICAL PROTECTION: Prevented dangerous status rollback for task '%s' from '%s' to '%s'",
-						newTask.Key, existingTask.Line, newTask.Line)
-				} else if existingCompleted && newCompleted {
-					// 两个都是完成状态，检查是否是相同类型
-					existingSuccess := strings.Contains(existingTask.Line, "[x]")
-					newSuccess := strings.Contains(newTask.Line, "[x]")
-
-					if existingSuccess != newSuccess {
-						// 🛡️ 禁止状态类型变化 (成功<->失败)
-						canUpdate = false
-						updateReason = "BLOCKED: cannot change between success and failure states"
-						logger.Warnf("🛡️ PROTECTION: Prevented status type change for task '%s' from %s to %s",
-							newTask.Key,
-							map[bool]string{true: "success", false: "failed"}[existingSuccess],
-							map[bool]string{true: "success", false: "failed"}[newSuccess])
-					} else {
-						// 相同完成状态，允许内容更新（如添加更多详情）
-						if existingTask.Line != newTask.Line {
-							canUpdate = true
-							updateReason = "updated content while maintaining same completion status"
-						} else {
-							updateReason = "same completion status and content, no update needed"
-						}
-					}
-				} else {
-					// 两个都是待执行状态，允许内容更新
-					if existingTask.Line != newTask.Line {
-						canUpdate = true
-						updateReason = "updated pending task content"
-					} else {
-						updateReason = "same pending status and content, no update needed"
-					}
-				}
+		var batch []*model.Task
+		plan, err := updatePlan(sessionID, planSourceScan, func(plan *model.Plan) error {
+			skipped := plan.SkipBlocked()
+			for _, t := range skipped {
+				logger.Warnf("⏭️ Skipping task [%s] %s: %s", t.ID, t.Title, t.Result)
 			}
 
-			// 🎯 关键修复：添加状态变更前的最终验证
-			if canUpdate {
-				// 最终安全检查：确保不会意外破坏已完成的任务状态
-				if existingTask.IsCompleted && !newTask.IsCompleted {
-					logger.Errorf("🚨 CRITICAL ERROR: Final validation failed - attempted to rollback completed task '%s'", newTask.Key)
-					canUpdate = false
-					updateReason = "BLOCKED: final validation prevented rollback"
-				}
+			batch = plan.ReadyTasks()
+			if len(batch) == 0 && len(skipped) == 0 {
+				return errPlanUnchanged
 			}
-
-			if canUpdate {
-				// 记录状态变更用于审计
-				logger.Infof("✅ Applying task update: %s (%s)", newTask.Key, updateReason)
-				logger.Infof("   Before: %s (IsCompleted: %v)", existingTask.Line, existingTask.IsCompleted)
-				logger.Infof("   After:  %s (IsCompleted: %v)", newTask.Line, newTask.IsCompleted)
-
-				// 允许更新
-				existingTask.Line = newTask.Line
-				existingTask.IsCompleted = newTask.IsCompleted
-			} else {
-				// 记录被保护的更新尝试
-				logger.Infof("🛡️ Protected task from update: %s (%s)", newTask.Key, updateReason)
-				logger.Infof("   Existing: %s (IsCompleted: %v)", existingTask.Line, existingTask.IsCompleted)
-				logger.Infof("   Rejected: %s (IsCompleted: %v)", newTask.Line, newTask.IsCompleted)
+			if len(batch) > maxParallel {
+				batch = batch[:maxParallel]
 			}
-		} else {
-			// 新任务，直接添加
-			mergedTasks[newTask.Key] = &TaskInfo{
-				Key:         newTask.Key,
-				Line:        newTask.Line,
-				Order:       order,
-				IsCompleted: newTask.IsCompleted,
+			for _, t := range batch {
+				t.Status = model.TaskRunning
+				t.Attempts++
 			}
-			order++
-			logger.Infof("➕ Added new task: %s", newTask.Key)
+			return nil
+		})
+		if err != nil {
+			logger.Errorf("Failed to read latest plan: %v", err)
+			return emptyMessage, nil
 		}
-	}
 
-	// 按顺序重新组装任务列表
-	var taskList []*TaskInfo
-	for _, task := range mergedTasks {
-		taskList = append(taskList, task)
-	}
+		logger.Infof("Read plan version v%d for session %s", plan.Version, sessionID)
 
-	// 按Order字段排序
-	for i := 0; i < len(taskList); i++ {
-		for j := i + 1; j < len(taskList); j++ {
-			if taskList[i].Order > taskList[j].Order {
-				taskList[i], taskList[j] = taskList[j], taskList[i]
-			}
+		if len(batch) == 0 {
+			logger.Info("All tasks completed, proceeding to summary")
+			return emptyMessage, nil
 		}
-	}
 
-	// 提取所有任务行
-	var result []string
-	for _, task := range taskList {
-		result = append(result, task.Line)
-	}
-
-	finalResult := strings.Join(result, "\n")
-
-	// 🎯 添加质量检查：确保没有状态倒退
-	completedCount := 0
-	failedCount := 0
-	pendingCount := 0
-	for _, task := range taskList {
-		if strings.Contains(task.Line, "[x]") {
-			completedCount++
-		} else if strings.Contains(task.Line, "[!]") {
-			failedCount++
-		} else if strings.Contains(task.Line, "[ ]") {
-			pendingCount++
+		taskIDs := make([]string, 0, len(batch))
+		titles := make([]string, 0, len(batch))
+		for _, t := range batch {
+			taskIDs = append(taskIDs, t.ID)
+			titles = append(titles, t.Title)
+			progressManager.SendEvent("node_complete", "\n\n##### ⚡️ 开始执行: \n\n", t.Title+"\n",
+				map[string]interface{}{"task_id": t.ID, "attempt": t.Attempts}, nil)
+			logger.Infof("Found task to execute: [%s] %s (attempt %d)", t.ID, t.Title, t.Attempts)
 		}
-	}
 
-	logger.Infof("📊 Merged result quality: %d total tasks (%d completed, %d failed, %d pending)",
-		len(result), completedCount, failedCount, pendingCount)
+		err = compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			state.batchTaskIDs = taskIDs
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	return finalResult
+		return &schema.Message{
+			Role:    schema.User,
+			Content: strings.Join(titles, "\n"),
+		}, nil
+	})
 }
 
-// TaskInfo 任务信息结构体
-type TaskInfo struct {
-	Key         string
-	Line        string
-	Order       int
-	IsCompleted bool
+// taskInput 单个任务子图的输入
+type taskInput struct {
+	Task           *model.Task
+	History        []*schema.Message
+	ContextSummary string // 主图已有的滚动摘要，任务无需重复压缩相同的历史
+	SummarizedLen  int
+	Resume         *model.TaskCheckpoint // 中断前保存的检查点，任务从检查点继续
 }
 
-// parseTasksFromContent 从内容中解析任务，增强状态识别逻辑
-func parseTasksFromContent(content string) map[string]*TaskInfo {
-	tasks := make(map[string]*TaskInfo)
-	lines := strings.Split(content, "\n")
-	order := 0
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// 检查是否是TODO行，支持失败状态
-		if strings.HasPrefix(line, "- [ ]") || strings.HasPrefix(line, "- [x]") || strings.HasPrefix(line, "- [!]") ||
-			strings.HasPrefix(line, "* [ ]") || strings.HasPrefix(line, "* [x]") || strings.HasPrefix(line, "* [!]") {
-			taskKey := extractTaskKey(line)
-			if taskKey != "" {
-				// 🎯 精确的完成状态判断：只有成功完成([x])和失败([!])才被视为已完成
-				isCompleted := strings.Contains(line, "[x]") || strings.Contains(line, "[!]")
-
-				tasks[taskKey] = &TaskInfo{
-					Key:         taskKey,
-					Line:        line,
-					Order:       order,
-					IsCompleted: isCompleted,
-				}
-				order++
-
-				// 添加调试日志
-				statusType := "pending"
-				if strings.Contains(line, "[x]") {
-					statusType = "completed"
-				} else if strings.Contains(line, "[!]") {
-					statusType = "failed"
-				}
-				logger.Debugf("Parsed task: %s -> %s (IsCompleted: %v)", taskKey, statusType, isCompleted)
-			}
-		}
-	}
-
-	logger.Infof("Parsed %d tasks from content", len(tasks))
-	return tasks
+// taskResult 单个任务子图的输出
+type taskResult struct {
+	TaskID  string
+	Status  model.TaskStatus
+	History []*schema.Message // 任务执行期间新增的上下文消息
 }
 
-// readLatestPlan 读取最新版本的 TODO list
-func readLatestPlan(sessionID string) (string, int, error) {
-	filePath := getTodoListFilePath(sessionID)
+// createTaskStartLambda 创建任务子图的入口，把任务标题作为用户消息交给执行模型
+// 从检查点继续的任务，上下文中已经有任务标题和中断前的工具调用，提示模型继续完成剩余的步骤
+func createTaskStartLambda() *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *taskInput) ([]*schema.Message, error) {
+		if input.Resume != nil && len(input.Resume.History) > 0 {
+			return []*schema.Message{{
+				Role:    schema.User,
+				Content: fmt.Sprintf("继续执行任务：%s\n上文是运行中断前已经完成的工具调用和结果，不要重复已经完成的操作。", input.Task.Title),
+			}}, nil
+		}
+		return []*schema.Message{{
+			Role:    schema.User,
+			Content: input.Task.Title,
+		}}, nil
+	})
+}
 
-	// 检查文件是否存在
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return "", 0, fmt.Errorf("no todo list found for session %s", sessionID)
-	}
+// createWriteUpdatedPlanLambda 创建带进度报告的写入更新后计划的 lambda 函数
+func createWriteUpdatedPlanLambda(sessionID string, progressManager *ProgressManager) *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*taskResult, error) {
+		logger.Infof("WriteUpdatedPlan node processing for session %s", sessionID)
 
-	// 读取文件内容
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to read todo list file: %w", err)
-	}
+		result := &taskResult{}
+		var maxRetries int
+		var budgetExhausted, loopFailure string
+		var toolErr *tools.ToolResult
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			result.TaskID = state.currentTaskID
+			result.History = state.history[state.baseHistoryLen:]
+			maxRetries = state.maxRetries
+			budgetExhausted = state.budgetExhausted
+			loopFailure = state.loopFailure
+			toolErr = state.lastToolError
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	contentStr := string(content)
-
-	// 使用简化的正则表达式来匹配版本
-	lines := strings.Split(contentStr, "\n")
-
-	var latestVersion int
-	var latestContent strings.Builder
-	var isInLatestContent bool
-
-	for _, line := range lines {
-		// 匹配版本头 "## Version v1 - timestamp"
-		if strings.HasPrefix(line, "## Version v") {
-			// 提取版本号
-			parts := strings.Split(line, " ")
-			if len(parts) >= 3 {
-				versionStr := strings.TrimPrefix(parts[2], "v")
-				if version, err := strconv.Atoi(versionStr); err == nil {
-					if version > latestVersion {
-						latestVersion = version
-						latestContent.Reset()
-						isInLatestContent = true
-						continue // 跳过版本头行
-					} else {
-						isInLatestContent = false
-					}
-				}
-			}
-		} else if isInLatestContent {
-			// 如果遇到下一个版本头，停止收集
-			if strings.HasPrefix(line, "## Version v") {
-				break
+		// 🎯 只更新当前执行的任务，模型输出中的其他内容一律忽略
+		update, err := parseTaskUpdate(input.Content)
+		if err != nil {
+			// 无法判断任务是否完成时不能当作完成，按失败处理，是否重试由重试次数决定
+			logger.Warnf("🚨 Invalid update output for task %s, counting as a failed attempt: %v", result.TaskID, err)
+			update = &taskUpdateOutput{TaskID: result.TaskID, Status: model.TaskFailed, Result: "任务状态更新结果无法解析"}
+		} else if update.TaskID != "" && update.TaskID != result.TaskID {
+			logger.Warnf("🛡️ Update targeted task %s but current task is %s, applying to current task", update.TaskID, result.TaskID)
+		}
+
+		// 纠正后仍然循环的任务直接判定失败，预算用完导致的失败不再重试，重试只会继续消耗预算
+		// 最近一次工具调用失败的任务判定失败，工具结果标记为不可重试时不再重试
+		if loopFailure != "" {
+			update.Status = model.TaskFailed
+			update.Result = loopFailure + "；" + update.Result
+			maxRetries = 0
+		} else if budgetExhausted != "" {
+			update.Result = budgetExhausted + "；" + update.Result
+			if update.Status == model.TaskFailed {
+				maxRetries = 0
 			}
-			// 跳过第一个空行
-			if latestContent.Len() == 0 && line == "" {
-				continue
+		} else if toolErr != nil {
+			if update.Status != model.TaskFailed {
+				logger.Warnf("🔧 Update marked task %s as %s but its last tool call failed, marking as failed", result.TaskID, update.Status)
 			}
-			if latestContent.Len() > 0 {
-				latestContent.WriteString("\n")
+			update.Status = model.TaskFailed
+			update.Result = describeToolError(toolErr) + "；" + update.Result
+			if !toolErr.Retryable {
+				maxRetries = 0
 			}
-			latestContent.WriteString(line)
 		}
-	}
-
-	if latestVersion == 0 {
-		return "", 0, fmt.Errorf("no versioned content found in todo list")
-	}
-
-	content_text := strings.TrimSpace(latestContent.String())
-	return content_text, latestVersion, nil
-}
-
-// containTodoList 检查内容是否包含markdown todo list，现在同时支持模式标识检测
-func containTodoList(content string) bool {
-	if content == "" {
-		return false
-	}
-
-	// 优先检查明确的模式标识
-	if strings.Contains(content, "[MODE:DIRECT_REPLY]") {
-		return false // 明确标识为直接回复模式
-	}
-	if strings.Contains(content, "[MODE:TODO_LIST]") {
-		return true // 明确标识为任务列表模式
-	}
-
-	// 兜底：使用现有的todolist特征检测逻辑
-	// 检查是否包含markdown todo list的特征模式
-	todoPatterns := []string{
-		"- [ ]", // - [ ] 任务
-		"- [x]", // - [x] 任务
-		"* [ ]", // * [ ] 任务
-		"* [x]", // * [x] 任务
-		"- [] ", // - [] 任务 (可能有空格差异)
-		"* [] ", // * [] 任务 (可能有空格差异)
-	}
 
-	// 转换为小写进行匹配，提高匹配准确性
-	lowerContent := strings.ToLower(content)
-
-	// 检查基本的markdown todo list模式
-	for _, pattern := range todoPatterns {
-		if strings.Contains(lowerContent, strings.ToLower(pattern)) {
-			return true
+		plan, err := applyTaskOutcome(sessionID, planSourceUpdate, result.TaskID, update.Status, update.Result, maxRetries)
+		if err != nil {
+			logger.Errorf("Failed to write updated plan to disk: %v", err)
+			result.Status = update.Status
+			return result, nil
 		}
-	}
+		result.Status = plan.Task(result.TaskID).Status
 
-	// 使用正则表达式进行更精确的匹配
-	// 匹配行首的todo list格式：^\s*[-*]\s*\[[\sx]\]\s*\S+
-	todoRegex := regexp.MustCompile(`(?m)^\s*[-*]\s*\[[\sx ]*\]\s*\S+`)
-	if todoRegex.MatchString(content) {
-		return true
-	}
+		markdown := plan.Markdown()
+		progressManager.SendEvent("node_complete", "#### 🔄 更新计划: \n", markdown+"\n\n",
+			map[string]interface{}{"content_length": len(markdown), "task_id": result.TaskID, "status": result.Status}, nil)
 
-	// 检查是否包含"任务"、"步骤"等关键词，并且有列表格式
-	keywords := []string{"任务", "步骤", "操作", "执行"}
-	listIndicators := []string{"- ", "* ", "1.", "2.", "3."}
+		return result, nil
+	})
+}
 
-	hasKeyword := false
-	hasList := false
+// routeToolCalls 执行模型输出后的路由：没有工具调用时进入 noToolsNode；检测到循环时纠正或强制停止；超出预算时强制停止；否则执行工具
+func routeToolCalls(loopDetector *loopDetector, toolBudget *runBudget, noToolsNode string) func(ctx context.Context, in *schema.Message) (string, error) {
+	return func(ctx context.Context, in *schema.Message) (endNode string, err error) {
+		if len(in.ToolCalls) == 0 {
+			return noToolsNode, nil
+		}
+		endNode = "tools"
+		err = compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			// 🔁 重复或来回交替的调用：先提示模型纠正，纠正次数用完后直接判定失败
+			if warning := loopDetector.detect(state.toolSignatures, in.ToolCalls); warning != "" {
+				if state.loopCorrections < loopDetector.maxCorrections {
+					state.loopCorrections++
+					state.loopWarning = warning
+					endNode = "loopCorrection"
+				} else {
+					state.loopFailure = warning
+					endNode = "stopTools"
+				}
+				return nil
+			}
 
-	for _, keyword := range keywords {
-		if strings.Contains(lowerContent, keyword) {
-			hasKeyword = true
-			break
-		}
+			// 🎯 超出任务或运行的工具调用预算时不再执行工具，直接进入更新
+			if reason := checkToolBudget(state, toolBudget, len(in.ToolCalls)); reason != "" {
+				state.budgetExhausted = reason
+				endNode = "stopTools"
+				return nil
+			}
+			state.toolCalls += len(in.ToolCalls)
+			for _, call := range in.ToolCalls {
+				state.toolSignatures = append(state.toolSignatures, toolCallSignature(call))
+			}
+			return nil
+		})
+		return endNode, err
 	}
+}
 
-	for _, indicator := range listIndicators {
-		if strings.Contains(content, indicator) {
-			hasList = true
-			break
+// toolsPreHandle 工具执行前记录工具调用并将调用消息加入上下文
+func toolsPreHandle(ctx context.Context, in *schema.Message, state *myState) (*schema.Message, error) {
+	// 🎯 新增：在工具调用前打印工具名称和参数
+	if in != nil && len(in.ToolCalls) > 0 {
+		logger.Infof("🔧 [工具调用开始] 会话: %s | 共 %d 个工具调用", state.sessionID, len(in.ToolCalls))
+		for i, toolCall := range in.ToolCalls {
+			logger.Infof("🔧 [工具%d] 名称: %s", i+1, toolCall.Function.Name)
+
+			// 格式化参数输出，限制长度避免日志过长
+			args := toolCall.Function.Arguments
+			if len(args) > 500 {
+				args = args[:500] + "... (参数过长已截断)"
+			}
+			logger.Infof("📄 [参数%d] %s", i+1, args)
 		}
 	}
 
-	// 如果同时包含任务关键词和列表格式，也认为是todo list
-	return hasKeyword && hasList
-}
-
-// min 返回两个整数中的较小值
-func min(a, b int) int {
-	if a < b {
-		return a
+	// 验证输入消息的有效性
+	if in != nil && in.Role != "" && strings.TrimSpace(in.Content) != "" {
+		state.history = append(state.history, in)
+	} else {
+		logger.Warnf("🧹 ToolsNode PreHandler: Skipping invalid message - Role: '%s', Content: '%s'",
+			in.Role, messageCleaner.truncateContent(in.Content))
 	}
-	return b
+	return in, nil
 }
 
-// createWritePlanLambdaWithProgress 创建带进度报告的 writePlan lambda 函数
-func createWritePlanLambda(sessionID string, progressManager *ProgressManager) *compose.Lambda {
-	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
-		// 读取输入流中的消息
-
-		logger.Infof("WritePlan node processing plan content for session %s, plan: %s", sessionID, input.Content)
+// toolsPostHandle 工具执行后记录失败结果，截断超长输出并推送给前端
+func toolsPostHandle(progressManager *ProgressManager) compose.StatePostHandler[[]*schema.Message, *myState] {
+	return func(ctx context.Context, in []*schema.Message, state *myState) ([]*schema.Message, error) {
+		// 🧹 清理消息切片，过滤无效消息
+		cleanedMessages := messageCleaner.CleanMessages(in)
+		logger.Infof("🧹 ToolsNode PostHandler: Cleaned messages from %d to %d", len(in), len(cleanedMessages))
 
-		// 检查输入内容是否包含 TODO list
-		if input.Content == "" {
-			logger.Warn("Empty plan content, skipping plan write")
-			return input, nil
+		// 🎯 按工具返回的统一结果记录本批调用是否失败，供任务更新节点判断
+		state.lastToolError = batchToolError(cleanedMessages)
+		if state.lastToolError != nil {
+			logger.Warnf("🔧 Tool call failed in task %s: %s", state.currentTaskID, describeToolError(state.lastToolError))
 		}
 
-		// 🎯 清理计划内容，只保留纯粹的 TODO list
-		cleanedContent := cleanTodoListContent(input.Content)
-		if cleanedContent == "" {
-			logger.Warn("No valid TODO items found in plan after cleaning")
-			return input, nil
-		}
+		// ✂️ 超长的工具输出保存为 artifact，进入上下文和推送给前端的内容都经过截断
+		processed := make([]*schema.Message, 0, len(cleanedMessages))
+		for _, msg := range cleanedMessages {
+			out := processToolOutput(state.sessionID, state.currentTaskID, msg)
+			processed = append(processed, out.message)
 
-		// 写入 TODO list 到磁盘后，从文件读取完整格式的内容发送给前端
-		err := writePlanToDisk(sessionID, cleanedContent)
-		if err != nil {
-			logger.Errorf("Failed to write plan to disk: %v", err)
-			// 如果写入失败，使用清理后的内容作为fallback
-			progressManager.SendEvent("node_complete", "", "## 💡 执行计划: \n\n"+cleanedContent+"\n\n",
-				map[string]interface{}{"content_length": len(cleanedContent)}, nil)
-		} else {
-			logger.Infof("Successfully wrote plan to disk for session %s", sessionID)
-			// 从文件读取完整格式的内容发送给前端
-			fileContent, _, err := readLatestPlan(sessionID)
-			if err != nil {
-				logger.Errorf("Failed to read plan from disk for frontend display: %v", err)
-				// fallback到清理后的内容
-				progressManager.SendEvent("node_complete", "", "## 💡 执行计划: \n\n"+cleanedContent+"\n\n",
-					map[string]interface{}{"content_length": len(cleanedContent)}, nil)
-			} else {
-				// 使用文件中的完整格式内容
-				progressManager.SendEvent("node_complete", "", "## 💡 执行计划: \n\n"+fileContent+"\n\n",
-					map[string]interface{}{"content_length": len(fileContent)}, nil)
+			data := map[string]interface{}{"content_length": out.size, "task_id": state.currentTaskID}
+			if out.artifactID != "" {
+				data["artifact_id"] = out.artifactID
 			}
+			progressManager.SendEvent("node_complete", "", "> "+out.stream+"\n\n", data, nil)
 		}
-
-		// 返回StreamReader包装的消息
-		return input, nil
-	})
+		return processed, nil
+	}
 }
 
-// findFirstIncompleteTodo 从 TODO list 内容中找到第一个未完成的任务
-func findFirstIncompleteTodo(todoContent string) string {
-	lines := strings.Split(todoContent, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		// 匹配未完成的 TODO 项：以 "- [ ]" 开头的行
-		if strings.HasPrefix(line, "- [ ]") || strings.HasPrefix(line, "-  [ ]") || strings.HasPrefix(line, "* [ ]") {
-			// 提取任务内容，去掉 checkbox 标记
-			todoText := strings.TrimSpace(strings.TrimPrefix(line, "- [ ]"))
-			todoText = strings.TrimSpace(strings.TrimPrefix(todoText, "-  [ ]"))
-			todoText = strings.TrimSpace(strings.TrimPrefix(todoText, "* [ ]"))
-
-			if todoText != "" {
-				logger.Infof("Found incomplete todo: %s", todoText)
-				return todoText
-			}
+// batchToolError 从一批工具结果中找出失败的结果，优先返回不可重试的失败；不是统一格式的结果视为成功
+func batchToolError(messages []*schema.Message) *tools.ToolResult {
+	var failed *tools.ToolResult
+	for _, msg := range messages {
+		if msg.Role != schema.Tool {
+			continue
 		}
-
-		// 跳过已完成的任务（- [x] 格式）和失败的任务（- [!] 格式）
-		if strings.HasPrefix(line, "- [x]") || strings.HasPrefix(line, "-  [x]") || strings.HasPrefix(line, "* [x]") ||
-			strings.HasPrefix(line, "- [!]") || strings.HasPrefix(line, "-  [!]") || strings.HasPrefix(line, "* [!]") {
+		result, ok := tools.ParseToolResult(msg.Content)
+		if !ok || !result.IsError() {
 			continue
 		}
-	}
-
-	logger.Info("No incomplete todos found, all tasks are completed")
-	return ""
-}
-
-// forceCompleteTask 强制标记任务为完成状态，避免死循环
-func forceCompleteTask(sessionID, taskName string) error {
-	// 读取当前TODO列表
-	todoContent, _, err := readLatestPlan(sessionID)
-	if err != nil {
-		return fmt.Errorf("failed to read current plan: %w", err)
-	}
-
-	// 解析任务
-	tasks := parseTasksFromContent(todoContent)
-
-	// 找到要强制完成的任务
-	taskKey := extractTaskKey("- [ ] " + taskName)
-	if task, exists := tasks[taskKey]; exists {
-		// 标记为完成
-		task.IsCompleted = true
-		task.Line = strings.Replace(task.Line, "- [ ]", "- [x]", 1)
-
-		// 重新组建TODO列表
-		var result []string
-		for _, t := range tasks {
-			result = append(result, t.Line)
+		if result.ToolName == "" {
+			result.ToolName = msg.ToolName
 		}
-
-		// 写入更新后的TODO列表
-		updatedContent := strings.Join(result, "\n")
-		err = writePlanToDisk(sessionID, updatedContent)
-		if err != nil {
-			return fmt.Errorf("failed to write updated plan: %w", err)
+		result.Payload = nil
+		if failed == nil || (failed.Retryable && !result.Retryable) {
+			failed = result
 		}
-
-		logger.Infof("Successfully force completed task: %s", taskName)
-		return nil
 	}
-
-	return fmt.Errorf("task not found: %s", taskName)
+	return failed
 }
 
-// forceFailTask 强制标记任务为失败状态，避免死循环
-func forceFailTask(sessionID, taskName string) error {
-	// 读取当前TODO列表
-	todoContent, _, err := readLatestPlan(sessionID)
-	if err != nil {
-		return fmt.Errorf("failed to read current plan: %w", err)
+// describeToolError 生成工具失败的描述，用于任务结果和更新提示
+func describeToolError(result *tools.ToolResult) string {
+	retry := "可重试"
+	if !result.Retryable {
+		retry = "不可重试"
 	}
+	return fmt.Sprintf("工具 %s 执行失败（%s，%s）：%s", result.ToolName, result.ErrorCode, retry, truncateRunes(result.ErrorMessage, 200))
+}
 
-	// 解析任务
-	tasks := parseTasksFromContent(todoContent)
-
-	// 找到要强制失败的任务
-	taskKey := extractTaskKey("- [ ] " + taskName)
-	if task, exists := tasks[taskKey]; exists {
-		// 标记为失败
-		task.IsCompleted = true // 失败也被视为完成（不再执行）
-		task.Line = strings.Replace(task.Line, "- [ ]", "- [!]", 1)
-		task.Line = strings.Replace(task.Line, "* [ ]", "* [!]", 1)
-
-		// 重新组建任务列表，按顺序排列
-		var taskList []*TaskInfo
-		for _, t := range tasks {
-			taskList = append(taskList, t)
+// createStopToolsLambda 创建强制停止工具调用的处理节点（预算用完或纠正后仍然循环），丢弃未执行的工具调用，直接进入任务更新
+func createStopToolsLambda(progressManager *ProgressManager) *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
+		var taskID, budgetReason, loopReason string
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			taskID = state.currentTaskID
+			budgetReason = state.budgetExhausted
+			loopReason = state.loopFailure
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 
-		// 按Order字段排序
-		for i := 0; i < len(taskList); i++ {
-			for j := i + 1; j < len(taskList); j++ {
-				if taskList[i].Order > taskList[j].Order {
-					taskList[i], taskList[j] = taskList[j], taskList[i]
-				}
-			}
+		status := "预算用完"
+		reason := budgetReason
+		if loopReason != "" {
+			status = "失败"
+			reason = loopReason
+			logger.Warnf("🔁 Task %s force-failed by loop detection, dropping %d pending tool call(s): %s", taskID, len(input.ToolCalls), reason)
+			progressManager.SendEvent("loop_detected", "", fmt.Sprintf("🔁 %s，纠正后仍未停止，任务判定为失败\n\n", reason),
+				map[string]interface{}{"task_id": taskID, "reason": reason, "action": "fail"}, nil)
+		} else {
+			logger.Warnf("💸 Task %s stopped by tool budget, dropping %d pending tool call(s): %s", taskID, len(input.ToolCalls), reason)
+			progressManager.SendEvent("budget_exhausted", "", fmt.Sprintf("⚠️ %s，停止调用工具\n\n", reason),
+				map[string]interface{}{"task_id": taskID, "reason": reason, "dropped_tool_calls": len(input.ToolCalls)}, nil)
 		}
 
-		// 提取所有任务行
-		var result []string
-		for _, t := range taskList {
-			result = append(result, t.Line)
+		// 带 tool_calls 却没有对应工具结果的消息会被模型接口拒绝，转为普通的助手消息
+		content := strings.TrimSpace(input.Content)
+		if content != "" {
+			content += "\n\n"
 		}
+		return &schema.Message{
+			Role:    schema.Assistant,
+			Content: content + "执行状态：" + status + "\n失败原因：" + reason,
+		}, nil
+	})
+}
 
-		// 写入更新后的TODO列表
-		updatedContent := strings.Join(result, "\n")
-		err = writePlanToDisk(sessionID, updatedContent)
+// createLoopCorrectionLambda 创建循环纠正节点，丢弃重复的工具调用，提示执行模型基于已有结果给出结论
+func createLoopCorrectionLambda(progressManager *ProgressManager) *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) ([]*schema.Message, error) {
+		var taskID, warning string
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			taskID = state.currentTaskID
+			warning = state.loopWarning
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("failed to write updated plan: %w", err)
+			return nil, err
 		}
 
-		logger.Infof("🚨 Successfully force failed task: %s", taskName)
-		return nil
-	}
+		logger.Warnf("🔁 Loop detected in task %s, injecting correction: %s", taskID, warning)
+		progressManager.SendEvent("loop_detected", "", fmt.Sprintf("🔁 %s，已提示模型停止重复调用\n\n", warning),
+			map[string]interface{}{"task_id": taskID, "reason": warning, "action": "correct"}, nil)
+
+		return []*schema.Message{schema.SystemMessage(fmt.Sprintf(
+			"检测到重复的工具调用：%s。重复调用不会得到新的结果，本次调用已被取消。"+
+				"请不要再发起相同的调用，直接根据已有的工具结果按要求的格式输出执行结论；如果确实需要其他信息，请换用不同的工具或参数。", warning))}, nil
+	})
+}
 
-	return fmt.Errorf("task not found: %s", taskName)
+// applyTaskOutcome 将任务执行结果作为 source 写入的新版本保存：失败且未达到重试上限时放回待执行队列
+func applyTaskOutcome(sessionID, source, taskID string, status model.TaskStatus, reason string, maxRetries int) (*model.Plan, error) {
+	return updatePlan(sessionID, source, func(plan *model.Plan) error {
+		task := plan.Task(taskID)
+		if task == nil {
+			return fmt.Errorf("task %q not found in plan v%d", taskID, plan.Version)
+		}
+
+		task.Result = reason
+		switch {
+		case status == model.TaskDone:
+			task.Status = model.TaskDone
+		case task.Attempts < maxRetries:
+			task.Status = model.TaskPending
+			logger.Warnf("📊 Task %s failed (attempt %d/%d), will retry: %s", task.ID, task.Attempts, maxRetries, reason)
+		default:
+			task.Status = model.TaskFailed
+			logger.Warnf("🚨 Task %s failed %d times, marking as failed: %s", task.ID, task.Attempts, reason)
+		}
+		return nil
+	})
 }
 
-// createScanTodoListLambda 创建带进度报告和失败检测的扫描 TODO list 的 lambda 函数
-func createScanTodoListLambda(sessionID string, progressManager *ProgressManager) *compose.Lambda {
+// createExecuteBatchLambda 创建并行执行任务的 lambda 函数，每个任务在独立的子图中运行
+func createExecuteBatchLambda(sessionID string, progressManager *ProgressManager, checkpoint *runCheckpoint, taskRunner compose.Runnable[*taskInput, *taskResult], maxParallel int) *compose.Lambda {
 	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
-		logger.Infof("ScanTodoList node processing for session %s", sessionID)
+		var taskIDs []string
+		var history []*schema.Message
+		var maxRetries int
+		var contextSummary string
+		var summarizedLen int
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			taskIDs = state.batchTaskIDs
+			history = append([]*schema.Message(nil), state.history...)
+			maxRetries = state.maxRetries
+			contextSummary = state.contextSummary
+			summarizedLen = state.summarizedLen
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 
-		// 从磁盘读取最新的 TODO list
-		todoContent, version, err := readLatestPlan(sessionID)
+		plan, err := snapshotPlan(sessionID)
 		if err != nil {
-			logger.Errorf("Failed to read latest plan: %v", err)
-			// 如果读取失败，返回空结果进入总结流程
-			emptyMessage := &schema.Message{
-				Role:    schema.Assistant,
-				Content: "",
-			}
-			return emptyMessage, nil
+			return nil, fmt.Errorf("failed to read plan for batch execution: %w", err)
 		}
 
-		logger.Infof("Read TODO list version v%d for session %s", version, sessionID)
-
-		// 查找第一个未完成的任务
-		incompleteTodo := findFirstIncompleteTodo(todoContent)
-
-		var resultMessage *schema.Message
-		if incompleteTodo != "" {
-			// 🎯 关键修复：检查任务是否已经失败过多次
-			// 获取状态（通过context传递）
-			if stateValue := ctx.Value("localState"); stateValue != nil {
-				if state, ok := stateValue.(*myState); ok {
-					// 🎯 关键修复：使用标准化的任务key确保失败计数器一致性
-					standardizedTaskKey := extractTaskKey("- [ ] " + incompleteTodo)
-					logger.Infof("🔍 ScanTodoList task key standardization: raw='%s' -> standardized='%s'", incompleteTodo, standardizedTaskKey)
-
-					// 检查当前任务的失败次数（使用标准化key）
-					failureCount := state.taskFailureCount[standardizedTaskKey]
-					if failureCount >= state.maxRetries {
-						logger.Warnf("Task '%s' (key: %s) has failed %d times, marking as failed to avoid infinite loop",
-							incompleteTodo, standardizedTaskKey, failureCount)
-
-						// 🎯 关键修复：将任务标记为失败而不是完成，避免状态死循环
-						err := forceFailTask(sessionID, incompleteTodo)
-						if err != nil {
-							logger.Errorf("Failed to force fail task: %v", err)
-						} else {
-							progressManager.SendEvent("node_complete", "", fmt.Sprintf("⚠️ 任务失败次数达到上限，已标记为失败: %s", incompleteTodo), nil, nil)
-						}
-
-						// 重新扫描TODO列表
-						todoContent, _, err = readLatestPlan(sessionID)
-						if err == nil {
-							incompleteTodo = findFirstIncompleteTodo(todoContent)
-						}
-					} else {
-						logger.Infof("📊 Task '%s' (key: %s) failure count: %d/%d",
-							incompleteTodo, standardizedTaskKey, failureCount, state.maxRetries)
+		logger.Infof("🚀 ExecuteBatch: running %d task(s) for session %s (max parallel: %d)", len(taskIDs), sessionID, maxParallel)
+
+		results := make([]*taskResult, len(taskIDs))
+		sem := make(chan struct{}, maxParallel)
+		var wg sync.WaitGroup
+		for i, taskID := range taskIDs {
+			task := plan.Task(taskID)
+			if task == nil {
+				logger.Warnf("ExecuteBatch: task %s not found in plan v%d", taskID, plan.Version)
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int, task *model.Task) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				// 🛡️ 单个任务的panic或错误不影响同批次的其他任务
+				defer func() {
+					if r := recover(); r != nil {
+						logger.Errorf("Task %s panic recovered: %v", task.ID, r)
+						results[i] = failTask(sessionID, task.ID, fmt.Sprintf("任务执行异常: %v", r), maxRetries, progressManager)
 					}
+				}()
+
+				res, err := runTask(withTokenTask(ctx, task.ID), taskRunner, &taskInput{
+					Task:           task,
+					History:        history,
+					ContextSummary: contextSummary,
+					SummarizedLen:  summarizedLen,
+					Resume:         checkpoint.task(task.ID),
+				})
+				if err != nil && ctx.Err() != nil {
+					// 运行被取消或超时，任务状态由取消流程统一处理
+					logger.Warnf("Task %s interrupted: %v", task.ID, err)
+					return
 				}
-			}
+				if err != nil {
+					logger.Errorf("Task %s execution failed: %v", task.ID, err)
+					results[i] = failTask(sessionID, task.ID, err.Error(), maxRetries, progressManager)
+					return
+				}
+				results[i] = res
+			}(i, task)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-			if incompleteTodo != "" {
-				// 找到未完成的任务，返回该任务作为用户查询
-				progressManager.SendEvent("node_complete", "\n\n##### ⚡️ 开始执行: \n\n", incompleteTodo+"\n",
-					map[string]interface{}{"content_length": len(input.Content)}, nil)
-				logger.Infof("Found incomplete task to execute: %s", incompleteTodo)
-				resultMessage = &schema.Message{
-					Role:    schema.User,
-					Content: incompleteTodo,
+		// 按任务顺序合并各分支的上下文，保证工具调用消息的顺序完整
+		err = compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			for _, res := range results {
+				if res == nil {
+					continue
 				}
-			} else {
-				// 所有任务都已完成，返回空字符串进入总结流程
-				logger.Info("All tasks completed, proceeding to summary")
-				resultMessage = &schema.Message{
-					Role:    schema.Assistant,
-					Content: "",
+				state.history = append(state.history, res.History...)
+
+				// 记录执行结果，供重新规划节点判断和参考
+				switch res.Status {
+				case model.TaskDone:
+					state.tasksSinceReplan++
+				case model.TaskFailed:
+					state.failedTaskIDs = append(state.failedTaskIDs, res.TaskID)
+				}
+				for _, msg := range res.History {
+					if msg.Role == schema.Tool && strings.TrimSpace(msg.Content) != "" {
+						state.toolOutputs = append(state.toolOutputs, fmt.Sprintf("[任务 %s] %s", res.TaskID, truncateRunes(msg.Content, 500)))
+					}
 				}
 			}
-		} else {
-			// 所有任务都已完成，返回空字符串进入总结流程
-			logger.Info("All tasks completed, proceeding to summary")
-			resultMessage = &schema.Message{
-				Role:    schema.Assistant,
-				Content: "",
+			if len(state.toolOutputs) > maxReplanToolOutputs {
+				state.toolOutputs = state.toolOutputs[len(state.toolOutputs)-maxReplanToolOutputs:]
 			}
+			state.batchTaskIDs = nil
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 
-		return resultMessage, nil
+		return input, nil
 	})
 }
 
-// createWriteUpdatedPlanLambda 创建带进度报告的写入更新后的 TODO list 的 lambda 函数
-func createWriteUpdatedPlanLambda(sessionID string, progressManager *ProgressManager) *compose.Lambda {
+// createWriteReplanLambda 创建写入重新规划结果的 lambda 函数，修订后的计划作为带原因的新版本写入
+func createWriteReplanLambda(sessionID string, progressManager *ProgressManager) *compose.Lambda {
 	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
-		// 读取输入流中的消息
-		logger.Infof("WriteUpdatedPlan node processing for session %s", sessionID)
-
-		// 🎯 关键改进：输出有效性验证和空内容处理
-		if input.Content == "" {
-			logger.Warnf("🚨 Update node returned empty content for session %s - treating current task as completed", sessionID)
-
-			// 读取当前TODO列表并强制完成当前任务
-			if currentTodoList, _, err := readLatestPlan(sessionID); err == nil {
-				currentTask := findFirstIncompleteTodo(currentTodoList)
-				if currentTask != "" {
-					logger.Infof("🔧 Auto-completing current task due to empty update: %s", currentTask)
-					err := forceCompleteTask(sessionID, currentTask)
-					if err != nil {
-						logger.Errorf("Failed to auto-complete current task: %v", err)
-					} else {
-						progressManager.SendEvent("node_complete", "⚡ 自动完成: ",
-							fmt.Sprintf("任务已完成: %s\n\n", currentTask),
-							map[string]interface{}{"auto_completed": true}, nil)
-					}
-				}
-			}
-			return input, nil
+		logger.Infof("WriteReplan node processing for session %s", sessionID)
+
+		// 无论重新规划是否成功，都从头开始累计下一轮的执行情况
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			state.failedTaskIDs = nil
+			state.toolOutputs = nil
+			state.tasksSinceReplan = 0
+			state.replanCount++
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 
-		// 🎯 清理更新后的 TODO list 内容，移除思考标签和额外内容
-		cleanedContent := cleanTodoListContent(input.Content)
-
-		// 🎯 关键改进：更严格的内容验证
-		if cleanedContent == "" {
-			logger.Warnf("🚨 No valid TODO items found after cleaning for session %s - treating as task completion", sessionID)
-
-			// 读取当前TODO列表并强制完成当前任务
-			if currentTodoList, _, err := readLatestPlan(sessionID); err == nil {
-				currentTask := findFirstIncompleteTodo(currentTodoList)
-				if currentTask != "" {
-					logger.Infof("🔧 Auto-completing current task due to invalid content: %s", currentTask)
-					err := forceCompleteTask(sessionID, currentTask)
-					if err != nil {
-						logger.Errorf("Failed to auto-complete current task: %v", err)
-					} else {
-						progressManager.SendEvent("node_complete", "⚡ 自动完成: ",
-							fmt.Sprintf("任务已完成: %s\n\n", currentTask),
-							map[string]interface{}{"auto_completed": true}, nil)
-					}
-				}
-			}
+		out, err := parseReplanOutput(input.Content)
+		if err != nil {
+			logger.Warnf("🚨 Invalid replan output, keeping current plan: %v", err)
 			return input, nil
 		}
 
-		// 🎯 新增：验证TODO列表格式的有效性
-		if !isValidTodoListFormat(cleanedContent) {
-			logger.Warnf("🚨 Invalid TODO list format detected for session %s - treating as task completion", sessionID)
-
-			// 读取当前TODO列表并强制完成当前任务
-			if currentTodoList, _, err := readLatestPlan(sessionID); err == nil {
-				currentTask := findFirstIncompleteTodo(currentTodoList)
-				if currentTask != "" {
-					logger.Infof("🔧 Auto-completing current task due to format issues: %s", currentTask)
-					err := forceCompleteTask(sessionID, currentTask)
-					if err != nil {
-						logger.Errorf("Failed to auto-complete current task: %v", err)
-					} else {
-						progressManager.SendEvent("node_complete", "⚡ 自动完成: ",
-							fmt.Sprintf("任务已完成: %s\n\n", currentTask),
-							map[string]interface{}{"auto_completed": true}, nil)
-					}
-				}
-			}
+		plan, err := updatePlan(sessionID, planSourceReplan, func(plan *model.Plan) error {
+			applyReplan(plan, out)
+			return nil
+		})
+		if err != nil {
+			logger.Errorf("Failed to write replanned plan: %v", err)
 			return input, nil
 		}
+		logger.Infof("🧭 Plan of session %s revised to v%d: %s", sessionID, plan.Version, plan.Reason)
 
-		// 写入更新后的 TODO list 到磁盘
-		err := writePlanToDisk(sessionID, cleanedContent)
-		if err != nil {
-			logger.Errorf("Failed to write updated plan to disk: %v", err)
-			// 不中断流程，继续执行
-		} else {
-			logger.Infof("Successfully wrote updated plan to disk for session %s", sessionID)
-			// 从文件读取完整格式的内容发送给前端
-			fileContent, _, err := readLatestPlan(sessionID)
-			if err != nil {
-				logger.Errorf("Failed to read updated plan from disk for frontend display: %v", err)
-				// fallback到清理后的内容
-				progressManager.SendEvent("node_complete", "#### 🔄 更新计划: \n", cleanedContent+"\n\n",
-					map[string]interface{}{"content_length": len(cleanedContent)}, nil)
-			} else {
-				// 使用文件中的完整格式内容
-				progressManager.SendEvent("node_complete", "#### 🔄 更新计划: \n", fileContent+"\n\n",
-					map[string]interface{}{"content_length": len(fileContent)}, nil)
-			}
-		}
+		markdown := plan.Markdown()
+		progressManager.SendEvent("node_complete", "#### 🧭 调整计划: \n", "> "+plan.Reason+"\n\n"+markdown+"\n\n",
+			map[string]interface{}{"content_length": len(markdown), "plan": plan, "reason": plan.Reason}, nil)
 
 		return input, nil
 	})
 }
 
-// isValidTodoListFormat 验证TODO列表格式的有效性
-func isValidTodoListFormat(content string) bool {
-	if content == "" {
+// needReplan 判断本轮任务执行后是否需要重新规划：有任务最终失败，或完成的任务数达到配置的间隔
+func needReplan(state *myState, plan *model.Plan, everyN, maxReplans int) bool {
+	if state.replanCount >= maxReplans {
 		return false
 	}
+	if len(state.failedTaskIDs) > 0 {
+		return true
+	}
+	return everyN > 0 && state.tasksSinceReplan >= everyN && plan.NextPending() != nil
+}
 
-	lines := strings.Split(content, "\n")
-	validTodoLines := 0
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// buildReplanContext 组装重新规划所需的上下文：当前计划、失败任务的原因以及近期的工具输出
+func buildReplanContext(plan *model.Plan, failedTaskIDs, toolOutputs []string) string {
+	var sb strings.Builder
+	planJSON, _ := json.Marshal(plan.Tasks)
+	sb.WriteString("**当前计划**：\n")
+	sb.WriteString(plan.Markdown())
+	sb.WriteString("\n\n**任务详情（JSON）**：\n")
+	sb.Write(planJSON)
+
+	if len(failedTaskIDs) > 0 {
+		sb.WriteString("\n\n**失败的任务**：\n")
+		for _, id := range failedTaskIDs {
+			if t := plan.Task(id); t != nil {
+				sb.WriteString(fmt.Sprintf("- %s：%s，失败原因：%s\n", t.ID, t.Title, t.Result))
+			}
 		}
+	}
 
-		// 检查是否是有效的TODO格式
-		if strings.HasPrefix(line, "- [ ]") || strings.HasPrefix(line, "- [x]") || strings.HasPrefix(line, "- [!]") ||
-			strings.HasPrefix(line, "* [ ]") || strings.HasPrefix(line, "* [x]") || strings.HasPrefix(line, "* [!]") {
-			validTodoLines++
+	if len(toolOutputs) > 0 {
+		sb.WriteString("\n**近期工具输出**：\n")
+		for _, output := range toolOutputs {
+			sb.WriteString("> " + output + "\n")
 		}
 	}
+	return sb.String()
+}
+
+// truncateRunes 按字符截断文本，避免截断到多字节字符中间
+func truncateRunes(content string, maxRunes int) string {
+	runes := []rune(content)
+	if len(runes) <= maxRunes {
+		return content
+	}
+	return string(runes[:maxRunes]) + "..."
+}
+
+// failTask 将执行出错的任务记为失败，并发送带任务ID的错误事件
+func failTask(sessionID, taskID, reason string, maxRetries int, progressManager *ProgressManager) *taskResult {
+	result := &taskResult{TaskID: taskID, Status: model.TaskFailed}
+	if plan, err := applyTaskOutcome(sessionID, planSourceExecuteBatch, taskID, model.TaskFailed, reason, maxRetries); err != nil {
+		logger.Errorf("Failed to record failure of task %s: %v", taskID, err)
+	} else {
+		result.Status = plan.Task(taskID).Status
+	}
+	progressManager.SendEvent("error", "", fmt.Sprintf("任务 %s 执行失败: %s", taskID, reason),
+		map[string]interface{}{"task_id": taskID, "status": result.Status}, nil)
+	return result
+}
+
+// runTask 以流式方式执行任务子图，执行模型的输出才会逐token推送；子图的结果取流中最后一个非空的输出
+func runTask(ctx context.Context, taskRunner compose.Runnable[*taskInput, *taskResult], input *taskInput) (*taskResult, error) {
+	sr, err := taskRunner.Stream(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer sr.Close()
 
-	// 至少要有一行有效的TODO项目
-	return validTodoLines > 0
+	var result *taskResult
+	for {
+		chunk, err := sr.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunk != nil {
+			result = chunk
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("task %s produced no result", input.Task.ID)
+	}
+	return result, nil
 }
 
 // cleanModeIdentifiers 清理模式标识，返回纯净的内容
 func cleanModeIdentifiers(content string) string {
 	// 移除模式标识
-	content = strings.ReplaceAll(content, "[MODE:DIRECT_REPLY]", "")
-	content = strings.ReplaceAll(content, "[MODE:TODO_LIST]", "")
+	content = strings.ReplaceAll(content, modeDirectReplyMarker, "")
+	content = strings.ReplaceAll(content, modeTodoListMarker, "")
 
 	// 清理多余的空白和换行
 	content = strings.TrimSpace(content)
@@ -1354,7 +950,8 @@ func createDirectReplyLambda(sessionID string, progressManager *ProgressManager)
 		cleanContent := cleanModeIdentifiers(input.Content)
 		logger.Infof("DirectReply: Cleaned content from %d to %d characters", len(input.Content), len(cleanContent))
 
-		// 通过result_chunk事件发送AI回复内容到前端
+		// 通过result_chunk事件发送AI回复内容到前端，已逐token推送的部分由转发方去重
+		progressManager.WaitTokens(tokenStreamWait)
 		logger.Infof("DirectReply: Sending AI response content: %s", cleanContent)
 		progressManager.SendEvent("result_chunk", "directReply", cleanContent,
 			map[string]interface{}{
@@ -1379,6 +976,28 @@ func createDirectReplyLambda(sessionID string, progressManager *ProgressManager)
 		return result, nil
 	})
 }
+
+// finishCancelledRun 运行被取消后更新计划和运行状态，并通知前端
+func finishCancelledRun(run *model.RunRecord, checkpoint *runCheckpoint, progressManager *ProgressManager) {
+	logger.Infof("⛔ Run %s of session %s cancelled", run.ID, run.SessionID)
+	cancelPlanTasks(run.SessionID)
+	checkpoint.finish(model.RunCancelled, context.Canceled)
+
+	progressManager.SendEvent("cancelled", "", "任务已取消", nil, nil)
+	progressManager.Close()
+}
+
+// createResumeLambda 创建恢复节点，上下文已在状态前处理器中恢复
+func createResumeLambda() *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *UserMessage) (*schema.Message, error) {
+		logger.Infof("Resuming session %s with %d checkpoint messages", input.ID, len(input.History))
+		return &schema.Message{
+			Role:    schema.User,
+			Content: input.Query,
+		}, nil
+	})
+}
+
 func createInitialMessageConverter() *compose.Lambda {
 	return compose.InvokableLambda(func(ctx context.Context, input *UserMessage) ([]*schema.Message, error) {
 		logger.Infof("Converting UserMessage to message list for session %s, query: %s", input.ID, input.Query)
@@ -1458,17 +1077,95 @@ type UserMessage struct {
 	ID      string            `json:"id"`
 	Query   string            `json:"query"`
 	History []*schema.Message `json:"history"`
+	Resume  bool              `json:"resume"`          // 从检查点恢复，History 为检查点中的上下文
+	State   *model.RunState   `json:"state,omitempty"` // 从检查点恢复的主图状态
+}
+
+// RunAgent 执行智能体并返回主流和进度通道，messageID 同时作为本次运行的ID
+// onFinish 不为空时在运行结束（包括失败、取消）后调用，启动失败返回错误时不会调用
+func RunAgent(ctx context.Context, sessionID, messageID, userQuery string, onFinish func()) (*schema.StreamReader[*schema.Message], <-chan ProgressEvent, error) {
+	// 从配置获取最大历史消息数量
+	cfg := config.Get()
+	maxHistoryMessages := 20 // 默认值
+	if cfg != nil && cfg.Agent.MaxHistoryMessages > 0 {
+		maxHistoryMessages = cfg.Agent.MaxHistoryMessages
+	}
+
+	// 从持久化存储获取历史消息
+	history, err := getHistoryMessages(ctx, sessionID, maxHistoryMessages)
+	if err != nil {
+		logger.Errorf("failed to get history messages: %v", err)
+		return nil, nil, err
+	}
+
+	run := newRunRecord(sessionID, messageID, userQuery)
+
+	// 准备输入数据
+	input := &UserMessage{
+		ID:      sessionID,
+		Query:   userQuery,
+		History: history,
+	}
+
+	return startAgent(ctx, run, input, onFinish)
+}
+
+// ResumeAgent 从最近的检查点恢复中断的运行
+// 已生成计划的运行跳过规划直接继续执行剩余任务，否则重新开始规划。onFinish 与 RunAgent 相同
+func ResumeAgent(ctx context.Context, runID string, onFinish func()) (*model.RunRecord, <-chan ProgressEvent, error) {
+	run, err := getRun(runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := prepareResume(run); err != nil {
+		return nil, nil, err
+	}
+
+	input := &UserMessage{
+		ID:      run.SessionID,
+		Query:   run.Query,
+		History: run.History,
+		Resume:  true,
+		State:   run.State,
+	}
+
+	switch run.LastNode {
+	case "writePlan", "scanTodoList", "executeBatch", "writeReplan":
+		logger.Infof("🔁 Resuming run %s from checkpoint after node %s", run.ID, run.LastNode)
+	default:
+		logger.Infof("🔁 Run %s was interrupted before planning (last node %q), restarting", run.ID, run.LastNode)
+		maxHistoryMessages := 20
+		if cfg := config.Get(); cfg != nil && cfg.Agent.MaxHistoryMessages > 0 {
+			maxHistoryMessages = cfg.Agent.MaxHistoryMessages
+		}
+		history, err := getHistoryMessages(ctx, run.SessionID, maxHistoryMessages)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 历史消息中包含本次的用户消息和未完成的助手消息，只保留之前的对话
+		input.History = trimRunMessages(history, run.Query)
+		input.Resume = false
+	}
+
+	_, progressChan, err := startAgent(ctx, run, input, onFinish)
+	if err != nil {
+		return nil, nil, err
+	}
+	return run, progressChan, nil
 }
 
-type LogCallbackConfig struct {
-	Detail    bool
-	Debug     bool
-	Writer    io.Writer
-	SessionID string
+// trimRunMessages 去掉历史末尾属于本次运行的消息
+func trimRunMessages(history []*schema.Message, query string) []*schema.Message {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == schema.User && history[i].Content == query {
+			return history[:i]
+		}
+	}
+	return history
 }
 
-// RunAgent 执行智能体并返回主流和进度通道
-func RunAgent(ctx context.Context, sessionID, userQuery string) (*schema.StreamReader[*schema.Message], <-chan ProgressEvent, error) {
+// startAgent 构建图并在后台异步执行，运行状态通过检查点持久化
+func startAgent(ctx context.Context, run *model.RunRecord, input *UserMessage, onFinish func()) (*schema.StreamReader[*schema.Message], <-chan ProgressEvent, error) {
 	// 🛡️ 添加defer恢复机制
 	defer func() {
 		if r := recover(); r != nil {
@@ -1476,50 +1173,50 @@ func RunAgent(ctx context.Context, sessionID, userQuery string) (*schema.StreamR
 		}
 	}()
 
+	sessionID := run.SessionID
+
 	// 创建进度管理器
 	progressManager := NewProgressManager(sessionID)
 
-	// 从配置获取最大历史消息数量
-	cfg := config.Get()
-	maxHistoryMessages := 20 // 默认值
-	if cfg != nil && cfg.Agent.MaxHistoryMessages > 0 {
-		maxHistoryMessages = cfg.Agent.MaxHistoryMessages
-	}
-
-	// 从持久化存储获取历史消息
-	history, err := getHistoryMessages(ctx, sessionID, maxHistoryMessages)
+	// 📼 录制模式下包装真实的模型和工具，回放模式下使用录音中的假模型和假工具
+	rp, err := newRunReplay(run)
 	if err != nil {
-		logger.Errorf("failed to get history messages: %v", err)
-		progressManager.Close() // 出错时立即关闭
-		return nil, nil, err
+		progressManager.Close()
+		return nil, nil, fmt.Errorf("failed to prepare replay: %w", err)
 	}
 
-	// 创建工具并构建图结构
-	tools := getTools()
-	planModel := model.NewPlanModel(ctx, tools)
-	executeModel := model.NewExecuteModel(ctx, tools)
-	updateModel := model.NewUpdateModel(ctx, tools)
-	summaryModel := model.NewSummaryModel(ctx)
+	// 创建工具并构建图结构，评测时工具调用先经过拦截器，需要审批的工具会被包装
+	tools := wrapToolsWithApproval(ctx, interceptTools(ctx, rp.tools(ctx)), sessionID, progressManager)
+	if run.ResumeCount > 0 {
+		// 🔁 恢复的运行中，中断前已完成的工具调用直接返回记录的结果
+		tools = wrapToolsForResume(ctx, tools)
+	}
+	planModel, executeModel, updateModel, summaryModel := rp.models(ctx, tools)
 
 	toolsNode := newToolsNode(ctx, tools)
 
+	checkpoint := newRunCheckpoint(run)
+	trace := newRunTrace(run)
+	otelRun := newRunTelemetry(ctx, run)
+
+	// 📝 选定本次运行的提示版本，记录到运行和助手消息上，用于按版本对比效果
+	prompts := newRunPrompts(ctx, run, tools)
+	run.PromptVersions = prompts.versions()
+	recordPromptVersions(run)
+
 	// 构建图结构（带进度报告）
-	graph, err := composeGraph[*UserMessage, *schema.Message](ctx, planModel, executeModel, updateModel, summaryModel, toolsNode, sessionID, progressManager)
+	graph, err := composeGraph[*UserMessage, *schema.Message](ctx, planModel, executeModel, updateModel, summaryModel, tools, toolsNode, sessionID, progressManager, checkpoint, prompts)
 	if err != nil {
 		logger.Errorf("failed to compose graph: %v", err)
 		progressManager.Close() // 出错时立即关闭
 		return nil, nil, fmt.Errorf("failed to compose graph: %w", err)
 	}
 
-	// 准备输入数据
-	input := &UserMessage{
-		ID:      sessionID,
-		Query:   userQuery,
-		History: history,
-	}
+	// 运行开始前先落盘，服务重启后可以识别出中断的运行
+	checkpoint.finish(model.RunRunning, nil)
 
 	// 执行图并返回流式结果
-	logger.Infof("Executing agent graph for session %s with %d history messages", sessionID, len(history))
+	logger.Infof("Executing agent graph for session %s with %d history messages (run %s, resume: %v)", sessionID, len(input.History), run.ID, input.Resume)
 
 	// 🎯 关键修复：立即返回progressChan，让图异步执行
 	// 这样chat_service.go可以立即开始监听进度消息
@@ -1529,23 +1226,51 @@ func RunAgent(ctx context.Context, sessionID, userQuery string) (*schema.StreamR
 
 	// 在后台goroutine中异步执行图
 	go func() {
+		// 🚦 追踪、录音保存完、运行从注册表移除后才通知调用方运行结束
+		defer func() {
+			if onFinish != nil {
+				onFinish()
+			}
+		}()
+		// 🧾 最后执行：运行的最终状态已写入 run 后保存追踪和录音
+		defer func() {
+			trace.finish(run.Status, run.Error)
+			otelRun.finish(run.Status, run.Error)
+			rp.finish()
+		}()
 		defer func() {
 			if r := recover(); r != nil {
 				logger.Errorf("Graph execution panic recovered: %v", r)
+				checkpoint.finish(model.RunFailed, fmt.Errorf("panic: %v", r))
 				// 不再在panic恢复时发送事件，因为channel可能已关闭
+				progressManager.Close()
 			}
 		}()
 
 		// 为异步执行创建新的context，避免被主函数的defer cancel影响
+		// 取消函数登记到运行注册表，可以通过接口取消模型和工具调用
 		asyncCtx, asyncCancel := context.WithTimeout(context.Background(), 60*time.Minute)
 		defer asyncCancel()
+		activeRuns.register(run, asyncCancel, progressManager)
+		defer activeRuns.unregister(run.ID)
+
+		// 🔭 运行的根 span 挂在发起请求的 span 下，节点、模型和工具调用的 span 通过回调创建
+		asyncCtx = otelRun.start(asyncCtx, run)
 
 		logger.Infof("🚀 开始异步执行图: session %s", sessionID)
 
 		// 执行图
-		sr, streamErr := graph.Stream(asyncCtx, input)
+		// 💬 模型节点的输出逐token推送，与追踪回调一起注册
+		opts := append(trace.graphOptions(), otelRun.graphOptions()...)
+		opts = append(opts, newRunTokenStream(progressManager).graphOptions()...)
+		sr, streamErr := graph.Stream(asyncCtx, input, opts...)
+		if streamErr != nil && activeRuns.isCancelled(run.ID) {
+			finishCancelledRun(run, checkpoint, progressManager)
+			return
+		}
 		if streamErr != nil {
 			logger.Errorf("failed to stream from graph: %v", streamErr)
+			checkpoint.finish(model.RunFailed, streamErr)
 			progressManager.SendEvent("error", "", "图执行失败", nil, streamErr)
 			progressManager.Close()
 			return
@@ -1554,6 +1279,7 @@ func RunAgent(ctx context.Context, sessionID, userQuery string) (*schema.StreamR
 		logger.Infof("✅ 图执行完成，开始处理结果流: session %s", sessionID)
 
 		// 处理结果流并通过progress事件发送
+		var resultErr error
 		if sr != nil {
 			for {
 				chunk, err := sr.Recv()
@@ -1563,6 +1289,7 @@ func RunAgent(ctx context.Context, sessionID, userQuery string) (*schema.StreamR
 						break
 					}
 					logger.Errorf("结果流接收错误: %v", err)
+					resultErr = err
 					progressManager.SendEvent("error", "", fmt.Sprintf("结果流错误: %v", err), nil, err)
 					break
 				}
@@ -1586,7 +1313,18 @@ func RunAgent(ctx context.Context, sessionID, userQuery string) (*schema.StreamR
 			logger.Warn("StreamReader为空，跳过结果流处理")
 		}
 
+		if resultErr != nil && activeRuns.isCancelled(run.ID) {
+			finishCancelledRun(run, checkpoint, progressManager)
+			return
+		}
+		if resultErr != nil {
+			checkpoint.finish(model.RunFailed, resultErr)
+		} else {
+			checkpoint.finish(model.RunCompleted, nil)
+		}
+
 		// 发送完成事件
+		progressManager.WaitTokens(tokenStreamWait)
 		progressManager.SendEvent("completed", "", "任务执行完成", nil, nil)
 
 		// 🎯 关键修复：在发送完所有事件后才关闭channel
@@ -1653,18 +1391,55 @@ func getTools() []tool.BaseTool {
 }
 
 type myState struct {
-	history          []*schema.Message
-	sessionID        string         // 添加会话ID到状态中
-	taskFailureCount map[string]int // 添加任务失败计数器
-	maxRetries       int            // 最大重试次数
+	history        []*schema.Message
+	sessionID      string   // 添加会话ID到状态中
+	batchTaskIDs   []string // 本轮并行执行的任务ID（主图）
+	currentTaskID  string   // 当前执行中的任务ID（任务子图）
+	baseHistoryLen int      // 任务开始前继承的上下文长度（任务子图）
+	maxRetries     int      // 最大重试次数
+	intent         string   // 意图分析命中的专用意图（主图），为空时使用通用的规划流程
+
+	// 上下文压缩
+	contextSummary string // 已压缩历史的滚动摘要
+	summarizedLen  int    // history 中已压缩进摘要的消息数
+
+	// 工具调用预算（任务子图）
+	budget          taskBudget // 当前任务类别对应的预算
+	toolCalls       int        // 当前任务已发起的工具调用次数
+	budgetExhausted string     // 预算用完的原因，非空时任务直接进入更新
+
+	// 工具调用循环检测（任务子图）
+	toolSignatures  []string // 当前任务已执行的工具调用签名
+	loopCorrections int      // 已注入纠正提示的次数
+	loopWarning     string   // 最近一次检测到的循环，用于纠正提示
+	loopFailure     string   // 纠正后仍然循环的原因，非空时任务直接判定失败
+
+	// 工具执行结果（任务子图）
+	lastToolError  *tools.ToolResult   // 最近一批工具结果中的失败结果，之后的调用全部成功时清空
+	pendingCalls   []schema.ToolCall   // 正在执行的一批工具调用
+	toolResults    map[string][]string // 当前任务已完成的工具调用结果，按调用签名索引，随任务检查点保存
+	completedCalls map[string][]string // 恢复的任务中断前已完成、还没有被重复调用取用的结果
+
+	// 重新规划相关（主图）
+	failedTaskIDs    []string // 上次重新规划以来最终失败的任务
+	toolOutputs      []string // 上次重新规划以来的工具输出摘要，供重新规划参考
+	tasksSinceReplan int      // 上次重新规划以来完成的任务数
+	replanCount      int      // 本次运行已重新规划的次数
 }
 
+// maxReplanToolOutputs 重新规划时最多参考的工具输出条数
+const maxReplanToolOutputs = 20
+
 // composeGraph 重构后的简化图构建函数，使用统一的StreamReader架构
-func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel, executeModel einoModel.ChatModel, updateModel einoModel.ChatModel, summaryModel einoModel.ChatModel, tn *compose.ToolsNode, sessionID string, progressManager *ProgressManager) (compose.Runnable[I, O], error) {
+func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel, executeModel einoModel.ChatModel, updateModel einoModel.ChatModel, summaryModel einoModel.ChatModel, allTools []tool.BaseTool, tn *compose.ToolsNode, sessionID string, progressManager *ProgressManager, checkpoint *runCheckpoint, prompts *runPrompts) (compose.Runnable[I, O], error) {
 	cfg := config.Get()
 
+	// 各节点组装上下文时共用的token预算管理，压缩历史使用总结模型
+	contextMgr := newContextManager(summaryModel)
+
 	// 在大模型执行之前，向全局状态中保存上下文，并组装本次的上下文
-	modelPreHandle := func(systemPrompt string) compose.StatePreHandler[[]*schema.Message, *myState] {
+	// 系统提示优先使用提示注册表中本次运行选定的版本，没有时使用配置中的提示
+	modelPreHandle := func(name, fallback string) compose.StatePreHandler[[]*schema.Message, *myState] {
 		return func(ctx context.Context, input []*schema.Message, state *myState) ([]*schema.Message, error) {
 			// 🧹 关键修复：在处理消息前先清理无效消息
 			cleanedInput := messageCleaner.CleanMessages(input)
@@ -1674,11 +1449,9 @@ func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel,
 				state.history = append(state.history, msg)
 			}
 
-			// 🧹 关键修复：也清理整个history，确保发送给模型的消息都是有效的
-			cleanedHistory := messageCleaner.CleanMessages(state.history)
-			logger.Infof("🧹 ModelPreHandle: Cleaned history messages from %d to %d", len(state.history), len(cleanedHistory))
-
-			finalMessages := append([]*schema.Message{schema.SystemMessage(systemPrompt)}, cleanedHistory...)
+			// 📏 按token预算组装上下文（同时清理无效消息），超出预算时压缩较早的历史
+			systemPrompt, _ := prompts.render(name, fallback, prompts.data(state.history))
+			finalMessages := contextMgr.build(ctx, config.StageExecute, state, systemPrompt)
 			return finalMessages, nil
 		}
 	}
@@ -1694,199 +1467,147 @@ func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel,
 				state.history = append(state.history, msg)
 			}
 
-			// 尝试读取当前会话的todolist
-			enhancedPrompt := systemPrompt
-			if todoContent, _, err := readLatestPlan(sessionID); err == nil && todoContent != "" {
-				enhancedPrompt = systemPrompt + "\n\n**当前会话的任务状态**：\n" + todoContent
-			}
+			// 📝 注册表中的提示通过模板变量自行引用当前计划和长期记忆
+			enhancedPrompt, fromRegistry := prompts.render(promptPlan, systemPrompt, prompts.data(state.history))
+			if !fromRegistry {
+				// 尝试读取当前会话的todolist
+				if plan, err := readLatestPlan(sessionID); err == nil {
+					enhancedPrompt = systemPrompt + "\n\n**当前会话的任务状态**：\n" + plan.Markdown()
+				}
 
-			// 🧹 关键修复：也清理整个history，确保发送给模型的消息都是有效的
-			cleanedHistory := messageCleaner.CleanMessages(state.history)
-			logger.Infof("🧹 PlanPreHandle: Cleaned history messages from %d to %d", len(state.history), len(cleanedHistory))
+				// 🧠 注入与用户问题相关的长期记忆
+				enhancedPrompt += buildMemoryPrompt(state.history)
+			}
 
-			finalMessages := append([]*schema.Message{schema.SystemMessage(enhancedPrompt)}, cleanedHistory...)
+			// 📏 按token预算组装上下文（同时清理无效消息），超出预算时压缩较早的历史
+			finalMessages := contextMgr.build(ctx, config.StagePlan, state, enhancedPrompt)
 			return finalMessages, nil
 		}
 	}
 
-	g := compose.NewGraph[I, O](compose.WithGenLocalState(func(ctx context.Context) *myState {
+	genState := func(ctx context.Context) *myState {
 		return &myState{
-			sessionID:        sessionID,
-			taskFailureCount: make(map[string]int),
-			maxRetries:       3, // 最大重试3次
+			sessionID:  sessionID,
+			maxRetries: 3, // 最大重试3次
 		}
-	}))
+	}
 
-	// 1. 初始消息转换：UserMessage → StreamReader[*schema.Message]
-	err := g.AddLambdaNode("preHandler", createInitialMessageConverter())
-	if err != nil {
-		return nil, err
+	// 无依赖任务的最大并行数，未配置时保持串行执行
+	maxParallel := 1
+	if cfg.Agent.MaxParallelTasks > 0 {
+		maxParallel = cfg.Agent.MaxParallelTasks
 	}
 
-	// 2. Planner agent - 使用专用前处理器
-	_ = g.AddChatModelNode("planner", planModel, compose.WithStatePreHandler(planPreHandle(cfg.Agent.PlanPrompt)), compose.WithNodeName("planner"))
+	// 单次运行的重新规划次数上限，避免反复失败时无限重规划
+	maxReplans := 3
+	if cfg.Agent.MaxReplans > 0 {
+		maxReplans = cfg.Agent.MaxReplans
+	}
 
-	// 3. WritePlan - 写入计划到磁盘
-	_ = g.AddLambdaNode("writePlan", createWritePlanLambda(sessionID, progressManager))
+	// 本次运行所有任务共享的工具调用预算
+	toolBudget := newRunBudget()
+	loopDetector := newLoopDetector()
 
-	// 3.5. DirectReply - 直接回复处理器
-	_ = g.AddLambdaNode("directReply", createDirectReplyLambda(sessionID, progressManager))
+	// 单个任务的执行子图：execute ⇄ tools → update → writeUpdatedPlan
+	// 每个任务拥有独立的状态，多个任务可以并行运行
+	tg := compose.NewGraph[*taskInput, *taskResult](compose.WithGenLocalState(genState))
 
-	// 4. ScanTodoList - 扫描TODO列表
-	_ = g.AddLambdaNode("scanTodoList", createScanTodoListLambda(sessionID, progressManager))
+	_ = tg.AddLambdaNode("taskStart", createTaskStartLambda(), compose.WithStatePreHandler(func(ctx context.Context, in *taskInput, state *myState) (*taskInput, error) {
+		state.currentTaskID = in.Task.ID
+		state.budget = classifyTask(in.Task.Title)
+		state.history = append(state.history, in.History...)
+		state.contextSummary = in.ContextSummary
+		state.summarizedLen = in.SummarizedLen
+		state.baseHistoryLen = len(state.history)
+		if in.Resume != nil {
+			logger.Infof("🔁 Task %s continues from checkpoint with %d messages and %d tool calls", in.Task.ID, len(in.Resume.History), in.Resume.ToolCalls)
+			restoreTask(state, in.Resume)
+		}
+		return in, nil
+	}), compose.WithNodeName("taskStart"))
 
 	// 5. ExecuteModel
-	_ = g.AddChatModelNode("execute", executeModel, compose.WithStatePreHandler(modelPreHandle(cfg.Agent.ExecutePrompt)), compose.WithNodeName("execute"))
+	_ = tg.AddChatModelNode("execute", executeModel, compose.WithStatePreHandler(modelPreHandle(promptExecute, cfg.Agent.ExecutePrompt)), compose.WithNodeName("execute"))
 
 	// 6. ToolsNode
-	_ = g.AddToolsNode("tools", tn, compose.WithStatePreHandler(func(ctx context.Context, in *schema.Message, state *myState) (*schema.Message, error) {
-		// 🎯 新增：在工具调用前打印工具名称和参数
-		if in != nil && len(in.ToolCalls) > 0 {
-			logger.Infof("🔧 [工具调用开始] 会话: %s | 共 %d 个工具调用", state.sessionID, len(in.ToolCalls))
-			for i, toolCall := range in.ToolCalls {
-				logger.Infof("🔧 [工具%d] 名称: %s", i+1, toolCall.Function.Name)
-
-				// 格式化参数输出，限制长度避免日志过长
-				args := toolCall.Function.Arguments
-				if len(args) > 500 {
-					args = args[:500] + "... (参数过长已截断)"
-				}
-				logger.Infof("📄 [参数%d] %s", i+1, args)
-			}
-		}
-
-		// 验证输入消息的有效性
-		if in != nil && in.Role != "" && strings.TrimSpace(in.Content) != "" {
-			state.history = append(state.history, in)
-		} else {
-			logger.Warnf("🧹 ToolsNode PreHandler: Skipping invalid message - Role: '%s', Content: '%s'",
-				in.Role, messageCleaner.truncateContent(in.Content))
-		}
-		return in, nil
+	// 每批工具调用完成后保存任务的检查点，中断后恢复时不会重复执行已完成的调用
+	postTools := toolsPostHandle(progressManager)
+	_ = tg.AddToolsNode("tools", tn, compose.WithStatePreHandler(func(ctx context.Context, in *schema.Message, state *myState) (*schema.Message, error) {
+		state.pendingCalls = in.ToolCalls
+		return toolsPreHandle(ctx, in, state)
 	}), compose.WithStatePostHandler(func(ctx context.Context, in []*schema.Message, state *myState) ([]*schema.Message, error) {
-		// 🧹 清理消息切片，过滤无效消息
-		cleanedMessages := messageCleaner.CleanMessages(in)
-		logger.Infof("🧹 ToolsNode PostHandler: Cleaned messages from %d to %d", len(in), len(cleanedMessages))
-
-		// 处理清理后的消息切片
-		for _, msg := range cleanedMessages {
-			progressManager.SendEvent("node_complete", "", "> "+msg.Content+"\n\n",
-				map[string]interface{}{"content_length": len(msg.Content)}, nil)
+		out, err := postTools(ctx, in, state)
+		if err == nil {
+			checkpoint.saveTask(state, out)
 		}
-		return cleanedMessages, nil
-	}))
+		return out, err
+	}), compose.WithNodeName("tools"))
 
 	// 7. Update Plan - 简化版，使用配置文件中的prompt
-	_ = g.AddChatModelNode("update", updateModel, compose.WithStatePreHandler(func(ctx context.Context, input []*schema.Message, state *myState) ([]*schema.Message, error) {
+	_ = tg.AddChatModelNode("update", updateModel, compose.WithStatePreHandler(func(ctx context.Context, input []*schema.Message, state *myState) ([]*schema.Message, error) {
 		// 🧹 关键修复：在处理消息前先清理无效消息
 		cleanedInput := messageCleaner.CleanMessages(input)
 		logger.Infof("🧹 UpdatePreHandle: Cleaned input messages from %d to %d", len(input), len(cleanedInput))
 
-		// 读取当前最新的todo list
-		currentTodoList, _, err := readLatestPlan(sessionID)
+		// 读取当前最新的计划
+		plan, err := snapshotPlan(sessionID)
 		if err != nil {
-			logger.Errorf("Failed to read current todo list for update: %v", err)
+			logger.Errorf("Failed to read current plan for update: %v", err)
 			// 如果读取失败，使用原始处理方式
 			for _, msg := range cleanedInput {
 				state.history = append(state.history, msg)
 			}
-			// 🧹 清理history并返回
-			cleanedHistory := messageCleaner.CleanMessages(state.history)
-			return append([]*schema.Message{schema.SystemMessage(cfg.Agent.UpdateTodoListPrompt)}, cleanedHistory...), nil
+			// 📏 按token预算组装上下文并返回
+			systemPrompt, _ := prompts.render(promptUpdateTodoList, cfg.Agent.UpdateTodoListPrompt, prompts.data(state.history))
+			return contextMgr.build(ctx, config.StageUpdate, state, systemPrompt), nil
 		}
 
-		// 找出当前正在执行的任务（第一个未完成的任务）
-		currentTask := findFirstIncompleteTodo(currentTodoList)
-		if currentTask == "" {
-			logger.Warn("No current task found for update")
-			currentTask = "当前任务"
+		// 当前执行的任务由 scanTodoList 记录在状态中，按稳定ID查找
+		currentTask := plan.Task(state.currentTaskID)
+		if currentTask == nil {
+			logger.Warnf("No current task found for update (task id: %q)", state.currentTaskID)
+			currentTask = &model.Task{ID: state.currentTaskID, Title: "当前任务"}
 		}
 
-		// 🎯 关键修复：使用标准化的任务key确保失败计数器一致性
-		// 将currentTask转换为标准化的key，避免计数器不同步
-		standardizedTaskKey := extractTaskKey("- [ ] " + currentTask)
-		logger.Infof("🔍 Task key standardization: raw='%s' -> standardized='%s'", currentTask, standardizedTaskKey)
-
-		// 🎯 重构错误检测：实现"无明显错误视为成功"的宽松策略
 		lastMessage := input[len(input)-1]
 
-		// 检查是否为MCP工具错误结果
-		isMCPError, mcpErrorResult := tools.IsMCPErrorResult(lastMessage.Content)
-
-		// 🎯 核心改进：简化的明确错误检测 - 只检测系统级严重错误
-		obviousErrorKeywords := []string{
-			// 认证授权错误
-			"401", "403", "authorization failed", "permission denied", "认证失败", "权限不足",
-			// 系统级错误
-			"500", "502", "503", "504", "timeout", "connection failed", "server error",
-			"超时", "连接失败", "网络错误", "服务器错误",
-			// 编译语法错误
-			"syntax error", "compilation failed", "parse error", "语法错误", "编译失败",
-			// 严重的文件系统错误
-			"no such file or directory", "file not found", "access denied", "disk full",
-			"文件不存在", "访问被拒绝", "磁盘空间不足",
-		}
-
-		hasObviousError := false
-		errorKeywordFound := ""
-
-		// 检查明显错误
-		for _, keyword := range obviousErrorKeywords {
-			if strings.Contains(strings.ToLower(lastMessage.Content), strings.ToLower(keyword)) {
-				hasObviousError = true
-				errorKeywordFound = keyword
-				break
-			}
-		}
-
-		// 🎯 关键改进：宽松的成功判断策略
-		// 核心原则：只有明确的错误才标记为失败，其他情况都视为成功
+		// 🎯 根据工具返回的统一结果判断：只有工具明确返回失败才标记为失败，不再匹配输出中的关键词
 
 		var taskOutcome string
 		var outcomeReason string
 
-		if hasObviousError {
-			// 有明显错误 → 失败
+		if state.loopFailure != "" {
+			// 纠正后仍然重复调用工具 → 失败
 			taskOutcome = "failure"
-			outcomeReason = fmt.Sprintf("detected obvious error: %s", errorKeywordFound)
-			state.taskFailureCount[standardizedTaskKey]++
-
-			logger.Warnf("📊 Task '%s' (key: %s) marked as failed (attempt %d/%d): %s",
-				currentTask, standardizedTaskKey, state.taskFailureCount[standardizedTaskKey], state.maxRetries, outcomeReason)
-		} else if isMCPError && strings.Contains(strings.ToLower(mcpErrorResult.ErrorMessage), "error") {
-			// MCP工具返回明确错误 → 失败
+			outcomeReason = fmt.Sprintf("tool call loop: %s", state.loopFailure)
+
+			logger.Warnf("📊 Task [%s] %s marked as failed (attempt %d/%d): %s",
+				currentTask.ID, currentTask.Title, currentTask.Attempts, state.maxRetries, outcomeReason)
+		} else if state.budgetExhausted != "" {
+			// 工具调用预算用完 → 由模型根据已有结果判断是否完成
+			taskOutcome = "budget exhausted"
+			outcomeReason = state.budgetExhausted
+
+			logger.Warnf("📊 Task [%s] %s stopped by tool budget (attempt %d/%d): %s",
+				currentTask.ID, currentTask.Title, currentTask.Attempts, state.maxRetries, outcomeReason)
+		} else if toolErr := state.lastToolError; toolErr != nil {
+			// 最近一次工具调用返回失败结果 → 失败，是否重试由结果中的 retryable 决定
 			taskOutcome = "failure"
-			outcomeReason = fmt.Sprintf("MCP tool returned explicit error: %s", mcpErrorResult.ErrorMessage)
-			state.taskFailureCount[standardizedTaskKey]++
+			outcomeReason = describeToolError(toolErr)
 
-			logger.Warnf("📊 Task '%s' (key: %s) marked as failed (attempt %d/%d): %s",
-				currentTask, standardizedTaskKey, state.taskFailureCount[standardizedTaskKey], state.maxRetries, outcomeReason)
+			logger.Warnf("📊 Task [%s] %s marked as failed (attempt %d/%d): %s",
+				currentTask.ID, currentTask.Title, currentTask.Attempts, state.maxRetries, outcomeReason)
 		} else {
-			// 🎯 关键改进：所有其他情况都视为成功
-			// 包括：工具正常执行、轻微警告、不确定结果、辅助错误等
+			// 🎯 关键改进：工具结果没有失败时视为成功，由模型根据语义做最终判断
 			taskOutcome = "success"
-
-			// 提供更详细的成功原因分析
-			if strings.Contains(strings.ToLower(lastMessage.Content), "successfully") ||
-				strings.Contains(strings.ToLower(lastMessage.Content), "completed") ||
-				strings.Contains(strings.ToLower(lastMessage.Content), "成功") ||
-				strings.Contains(strings.ToLower(lastMessage.Content), "完成") {
-				outcomeReason = "explicit success indicators found"
-			} else if isMCPError {
-				outcomeReason = "MCP tool warning/minor error - treating as success"
+			if state.toolCalls == 0 {
+				outcomeReason = "no tool calls - task answered directly"
 			} else {
-				outcomeReason = "no obvious errors detected - applying lenient success policy"
+				outcomeReason = "all recent tool calls returned success"
 			}
 
-			// 重置失败计数器
-			if state.taskFailureCount[standardizedTaskKey] > 0 {
-				logger.Infof("📊 Task '%s' (key: %s) succeeded, resetting failure count (was %d)",
-					currentTask, standardizedTaskKey, state.taskFailureCount[standardizedTaskKey])
-				state.taskFailureCount[standardizedTaskKey] = 0
-			} else {
-				logger.Infof("📊 Task '%s' (key: %s) completed successfully: %s",
-					currentTask, standardizedTaskKey, outcomeReason)
-			}
+			logger.Infof("📊 Task [%s] %s completed successfully: %s",
+				currentTask.ID, currentTask.Title, outcomeReason)
 		}
 
 		// 记录任务结果的详细分析
@@ -1894,23 +1615,29 @@ func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel,
 		if len(responsePreview) > 200 {
 			responsePreview = responsePreview[:200] + "..."
 		}
-		logger.Infof("🔍 Task result analysis for '%s': outcome=%s, reason=%s, response_preview=%s",
-			currentTask, taskOutcome, outcomeReason, responsePreview)
+		logger.Infof("🔍 Task result analysis for [%s]: outcome=%s, reason=%s, response_preview=%s",
+			currentTask.ID, taskOutcome, outcomeReason, responsePreview)
 
 		// 🎯 优化：增强的任务推进保障机制
 		// 基于新的宽松成功策略，确保任务能够正常推进
-		contextualPrompt := fmt.Sprintf(`%s
-
-当前正在处理的任务：%s
+		// 📝 注册表中的提示通过 Task、TaskOutcome、OutcomeReason 变量自行引用当前任务和评估结果
+		data := prompts.data(state.history)
+		data.Task, data.TaskOutcome, data.OutcomeReason = currentTask, taskOutcome, outcomeReason
+		contextualPrompt, fromRegistry := prompts.render(promptUpdateTodoList, cfg.Agent.UpdateTodoListPrompt, data)
+		if !fromRegistry {
+			contextualPrompt = fmt.Sprintf(`%s
+
+当前正在处理的任务：%s（task_id: %s）
 任务执行结果评估：%s (%s)
 
-请基于AI智能分析和宽松成功策略更新此任务状态，并输出完整的更新后TODO List。`,
-			cfg.Agent.UpdateTodoListPrompt, currentTask, taskOutcome, outcomeReason)
+请基于AI智能分析和宽松成功策略判断此任务的状态，只输出该任务的JSON更新结果。`,
+				cfg.Agent.UpdateTodoListPrompt, currentTask.Title, currentTask.ID, taskOutcome, outcomeReason)
+		}
 
-		// 将当前todolist作为assistant消息添加到历史中，而不是放在system prompt中
+		// 将当前计划作为assistant消息添加到历史中，而不是放在system prompt中
 		state.history = append(state.history, &schema.Message{
 			Role:    schema.Assistant,
-			Content: fmt.Sprintf("当前TODO List：\n%s", currentTodoList),
+			Content: fmt.Sprintf("当前TODO List：\n%s", plan.Markdown()),
 		})
 
 		// 添加输入消息到历史
@@ -1918,16 +1645,97 @@ func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel,
 			state.history = append(state.history, msg)
 		}
 
-		// 🧹 关键修复：最终清理整个history，确保发送给模型的消息都是有效的
-		cleanedHistory := messageCleaner.CleanMessages(state.history)
-		logger.Infof("🧹 UpdatePreHandle: Cleaned final history messages from %d to %d", len(state.history), len(cleanedHistory))
+		// 📏 按token预算组装上下文（同时清理无效消息），超出预算时压缩较早的历史
+		finalMessages := contextMgr.build(ctx, config.StageUpdate, state, contextualPrompt)
 
-		logger.Infof("Update node will process task: %s (failures: %d)", currentTask, state.taskFailureCount[currentTask])
-		return append([]*schema.Message{schema.SystemMessage(contextualPrompt)}, cleanedHistory...), nil
+		logger.Infof("Update node will process task: [%s] %s (attempt %d)", currentTask.ID, currentTask.Title, currentTask.Attempts)
+		return finalMessages, nil
 	}), compose.WithNodeName("update"))
 
 	// 8. WriteUpdatedPlan - 写入更新后的计划
-	_ = g.AddLambdaNode("writeUpdatedPlan", createWriteUpdatedPlanLambda(sessionID, progressManager))
+	_ = tg.AddLambdaNode("writeUpdatedPlan", createWriteUpdatedPlanLambda(sessionID, progressManager), compose.WithNodeName("writeUpdatedPlan"))
+	_ = tg.AddLambdaNode("updateToList", compose.ToList[*schema.Message](), compose.WithNodeName("updateToList"))
+	_ = tg.AddLambdaNode("stopTools", createStopToolsLambda(progressManager), compose.WithNodeName("stopTools"))
+	_ = tg.AddLambdaNode("loopCorrection", createLoopCorrectionLambda(progressManager), compose.WithNodeName("loopCorrection"))
+
+	_ = tg.AddEdge(compose.START, "taskStart")
+	_ = tg.AddEdge("taskStart", "execute")
+	_ = tg.AddBranch("execute", compose.NewGraphBranch(routeToolCalls(loopDetector, toolBudget, "updateToList"),
+		map[string]bool{"tools": true, "updateToList": true, "stopTools": true, "loopCorrection": true}))
+	_ = tg.AddEdge("tools", "execute")
+	_ = tg.AddEdge("stopTools", "updateToList")
+	_ = tg.AddEdge("loopCorrection", "execute")
+	_ = tg.AddEdge("updateToList", "update")
+	_ = tg.AddEdge("update", "writeUpdatedPlan")
+	_ = tg.AddEdge("writeUpdatedPlan", compose.END)
+
+	taskRunner, err := tg.Compile(ctx, compose.WithMaxRunSteps(1000), compose.WithGraphName("task"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile task graph: %w", err)
+	}
+
+	g := compose.NewGraph[I, O](compose.WithGenLocalState(genState))
+
+	// 主图每个节点完成后保存检查点，服务重启后可以从检查点恢复
+	// 1. 初始消息转换：UserMessage → StreamReader[*schema.Message]
+	err = g.AddLambdaNode("preHandler", createInitialMessageConverter(),
+		compose.WithStatePostHandler(checkpointAfter[[]*schema.Message](checkpoint, "preHandler")), compose.WithNodeName("preHandler"))
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.5. Resume - 从检查点恢复上下文，跳过规划直接扫描剩余任务
+	_ = g.AddLambdaNode("resume", createResumeLambda(), compose.WithStatePreHandler(func(ctx context.Context, in *UserMessage, state *myState) (*UserMessage, error) {
+		state.history = append(state.history, in.History...)
+		restoreState(state, in.State)
+		return in, nil
+	}), compose.WithNodeName("resume"))
+
+	// 1.6. IntentAnalysis - 识别请求的意图，命中专用意图时交给只使用受限工具的专用子图处理
+	router := newIntentRouter(sessionID, allTools, executeModel, contextMgr, toolBudget, loopDetector, progressManager)
+	_ = g.AddLambdaNode("intentAnalysis", createIntentAnalysisLambda(router, summaryModel), compose.WithNodeName("intentAnalysis"))
+	_ = g.AddLambdaNode("intentAgent", createIntentAgentLambda(router), compose.WithNodeName("intentAgent"))
+
+	// 2. Planner agent - 使用专用前处理器
+	_ = g.AddChatModelNode("planner", planModel, compose.WithStatePreHandler(planPreHandle(cfg.Agent.PlanPrompt)),
+		compose.WithStatePostHandler(checkpointAfter[*schema.Message](checkpoint, "planner")), compose.WithNodeName("planner"))
+
+	// 3. WritePlan - 写入计划到存储
+	_ = g.AddLambdaNode("writePlan", createWritePlanLambda(sessionID, progressManager),
+		compose.WithStatePostHandler(checkpointAfter[*schema.Message](checkpoint, "writePlan")), compose.WithNodeName("writePlan"))
+
+	// 3.5. DirectReply - 直接回复处理器
+	_ = g.AddLambdaNode("directReply", createDirectReplyLambda(sessionID, progressManager),
+		compose.WithStatePostHandler(checkpointAfter[*schema.Message](checkpoint, "directReply")), compose.WithNodeName("directReply"))
+
+	// 4. ScanTodoList - 扫描TODO列表，取出依赖已满足的任务
+	_ = g.AddLambdaNode("scanTodoList", createScanTodoListLambda(sessionID, progressManager, maxParallel),
+		compose.WithStatePostHandler(checkpointAfter[*schema.Message](checkpoint, "scanTodoList")), compose.WithNodeName("scanTodoList"))
+
+	// 4.5. ExecuteBatch - 并行执行本轮任务
+	_ = g.AddLambdaNode("executeBatch", createExecuteBatchLambda(sessionID, progressManager, checkpoint, taskRunner, maxParallel),
+		compose.WithStatePostHandler(checkpointAfter[*schema.Message](checkpoint, "executeBatch")), compose.WithNodeName("executeBatch"))
+
+	// 4.6. Replanner - 任务失败或完成一定数量后，根据执行情况调整剩余任务
+	_ = g.AddChatModelNode("replanner", planModel, compose.WithStatePreHandler(func(ctx context.Context, input []*schema.Message, state *myState) ([]*schema.Message, error) {
+		plan, err := snapshotPlan(sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plan for replanning: %w", err)
+		}
+
+		logger.Infof("🧭 Replanning session %s (failed tasks: %v, done since last replan: %d, replan %d/%d)",
+			sessionID, state.failedTaskIDs, state.tasksSinceReplan, state.replanCount+1, maxReplans)
+
+		// 重新规划的提示不写入上下文历史，只用于本次调用
+		replanPrompt := cfg.Agent.ReplanPrompt + "\n\n" + buildReplanContext(plan, state.failedTaskIDs, state.toolOutputs)
+		return append(contextMgr.build(ctx, config.StagePlan, state, replanPrompt),
+			schema.UserMessage("请根据以上执行情况调整剩余任务，只输出JSON。")), nil
+	}), compose.WithNodeName("replanner"))
+
+	// 4.7. WriteReplan - 写入重新规划后的计划
+	_ = g.AddLambdaNode("writeReplan", createWriteReplanLambda(sessionID, progressManager),
+		compose.WithStatePostHandler(checkpointAfter[*schema.Message](checkpoint, "writeReplan")), compose.WithNodeName("writeReplan"))
+	_ = g.AddLambdaNode("replanToList", compose.ToList[*schema.Message](), compose.WithNodeName("replanToList"))
 
 	// 9. SummaryModel - 添加调试日志
 	_ = g.AddChatModelNode("summary", summaryModel, compose.WithStatePreHandler(func(ctx context.Context, input []*schema.Message, state *myState) ([]*schema.Message, error) {
@@ -1948,24 +1756,39 @@ func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel,
 			state.history = append(state.history, msg)
 		}
 
-		// 🧹 关键修复：最终清理整个history，确保发送给模型的消息都是有效的
-		cleanedHistory := messageCleaner.CleanMessages(state.history)
-		logger.Infof("🧹 SummaryPreHandle: Cleaned final history messages from %d to %d", len(state.history), len(cleanedHistory))
-
-		systemPrompt := cfg.Agent.SummaryPrompt
-		result := append([]*schema.Message{schema.SystemMessage(systemPrompt)}, cleanedHistory...)
+		// 📏 按token预算组装上下文（同时清理无效消息），超出预算时压缩较早的历史
+		systemPrompt, _ := prompts.render(promptSummary, cfg.Agent.SummaryPrompt, prompts.data(state.history))
+		result := contextMgr.build(ctx, config.StageSummary, state, systemPrompt)
 		logger.Infof("Summary node sending %d messages to model", len(result))
 		return result, nil
 	}), compose.WithNodeName("summary"))
 
-	_ = g.AddLambdaNode("planToList", compose.ToList[*schema.Message]())
-	_ = g.AddLambdaNode("executeToList", compose.ToList[*schema.Message]())
-	_ = g.AddLambdaNode("updateToList", compose.ToList[*schema.Message]())
-	_ = g.AddLambdaNode("summaryToList", compose.ToList[*schema.Message]())
+	_ = g.AddLambdaNode("planToList", compose.ToList[*schema.Message](), compose.WithNodeName("planToList"))
+	_ = g.AddLambdaNode("summaryToList", compose.ToList[*schema.Message](), compose.WithNodeName("summaryToList"))
 
 	// 添加简单的线性图边连接 - 让每个节点内部决定处理逻辑
-	_ = g.AddEdge(compose.START, "preHandler")
-	_ = g.AddEdge("preHandler", "planner")
+	_ = g.AddBranch(compose.START, compose.NewGraphBranch(func(ctx context.Context, input *UserMessage) (endNode string, err error) {
+		if input.Resume {
+			return "resume", nil
+		}
+		return "preHandler", nil
+	}, map[string]bool{"resume": true, "preHandler": true}))
+	_ = g.AddEdge("resume", "scanTodoList")
+	_ = g.AddEdge("preHandler", "intentAnalysis")
+
+	_ = g.AddBranch("intentAnalysis", compose.NewGraphBranch(func(ctx context.Context, input []*schema.Message) (string, error) {
+		var intent string
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			intent = state.intent
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		// 专用子图无法构建时（例如意图的工具都不可用）回退到通用流程
+		return router.nextNode(ctx, intent), nil
+	}, map[string]bool{"planner": true, "intentAgent": true}))
+	_ = g.AddEdge("intentAgent", "directReply") // 专用子图的回复与直接回复一样返回给用户
 
 	_ = g.AddBranch("planner", compose.NewGraphBranch(func(ctx context.Context, input *schema.Message) (endNode string, err error) {
 		fmt.Printf("planmodel result: %s", input.Content)
@@ -1983,28 +1806,32 @@ func composeGraph[I, O any](ctx context.Context, planModel einoModel.ChatModel,
 
 	_ = g.AddBranch("scanTodoList", compose.NewGraphBranch(func(ctx context.Context, input *schema.Message) (endNode string, err error) {
 		if input.Content != "" {
-			return "executeToList", nil
+			return "executeBatch", nil
 		}
 		return "summaryToList", nil
-	}, map[string]bool{"executeToList": true, "summaryToList": true}))
-	_ = g.AddEdge("executeToList", "execute")
+	}, map[string]bool{"executeBatch": true, "summaryToList": true}))
 
-	_ = g.AddBranch("execute", compose.NewGraphBranch(func(ctx context.Context, in *schema.Message) (endNode string, err error) {
-		if len(in.ToolCalls) > 0 {
-			return "tools", nil
+	_ = g.AddBranch("executeBatch", compose.NewGraphBranch(func(ctx context.Context, input *schema.Message) (endNode string, err error) {
+		plan, err := snapshotPlan(sessionID)
+		if err != nil {
+			return "scanTodoList", nil
 		}
-		return "updateToList", nil
-	}, map[string]bool{"tools": true, "updateToList": true}))
-
-	_ = g.AddEdge("tools", "execute")
-	_ = g.AddEdge("updateToList", "update")
-
-	_ = g.AddEdge("update", "writeUpdatedPlan")
-
-	_ = g.AddEdge("writeUpdatedPlan", "scanTodoList")
+		replan := false
+		err = compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			replan = needReplan(state, plan, cfg.Agent.ReplanEveryNTasks, maxReplans)
+			return nil
+		})
+		if err != nil || !replan {
+			return "scanTodoList", nil
+		}
+		return "replanToList", nil
+	}, map[string]bool{"scanTodoList": true, "replanToList": true}))
+	_ = g.AddEdge("replanToList", "replanner")
+	_ = g.AddEdge("replanner", "writeReplan")
+	_ = g.AddEdge("writeReplan", "scanTodoList")
 
 	_ = g.AddEdge("summaryToList", "summary")
 	_ = g.AddEdge("summary", compose.END)
 
-	return g.Compile(ctx, compose.WithMaxRunSteps(1000))
+	return g.Compile(ctx, compose.WithMaxRunSteps(1000), compose.WithGraphName("agent"))
 }
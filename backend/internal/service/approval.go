@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/tools"
+	"glata-backend/pkg/logger"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/google/uuid"
+)
+
+// 工具调用审批策略
+const (
+	ToolPolicyAuto            = "auto"             // 直接执行
+	ToolPolicyRequireApproval = "require_approval" // 暂停等待人工审批
+	ToolPolicyDeny            = "deny"             // 禁止执行
+)
+
+// defaultApprovalTimeout 未配置超时时等待审批的时长
+const defaultApprovalTimeout = 30 * time.Minute
+
+var (
+	ErrApprovalNotFound     = errors.New("approval not found or already resolved")
+	ErrInvalidApprovalInput = errors.New("invalid approval decision")
+)
+
+// approvalDecision 审批结果
+type approvalDecision struct {
+	status    model.ApprovalStatus
+	arguments string
+	reason    string
+}
+
+type pendingApproval struct {
+	approval *model.ToolApproval
+	decision chan approvalDecision
+}
+
+// ApprovalManager 管理等待人工审批的工具调用
+type ApprovalManager struct {
+	mu      sync.Mutex
+	pending map[string]*pendingApproval
+}
+
+// NewApprovalManager 创建审批管理器
+func NewApprovalManager() *ApprovalManager {
+	return &ApprovalManager{
+		pending: make(map[string]*pendingApproval),
+	}
+}
+
+var approvalManager = NewApprovalManager()
+
+// Wait 登记审批请求并阻塞等待结果，超时或上下文取消时视为拒绝
+func (m *ApprovalManager) Wait(ctx context.Context, approval *model.ToolApproval, timeout time.Duration) approvalDecision {
+	p := &pendingApproval{
+		approval: approval,
+		decision: make(chan approvalDecision, 1),
+	}
+
+	m.mu.Lock()
+	m.pending[approval.ID] = p
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, approval.ID)
+		m.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case d := <-p.decision:
+		return d
+	case <-timer.C:
+		return approvalDecision{status: model.ApprovalExpired, reason: fmt.Sprintf("审批超时（%s）", timeout)}
+	case <-ctx.Done():
+		return approvalDecision{status: model.ApprovalExpired, reason: "执行已取消"}
+	}
+}
+
+// Resolve 处理审批请求：approve 直接执行，reject 拒绝执行，edit 使用新参数执行
+func (m *ApprovalManager) Resolve(approvalID string, req *model.ApprovalDecisionRequest) (*model.ToolApproval, error) {
+	d := approvalDecision{reason: req.Reason}
+	switch req.Action {
+	case "approve":
+		d.status = model.ApprovalApproved
+	case "reject":
+		d.status = model.ApprovalRejected
+	case "edit":
+		var args map[string]interface{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("%w: arguments must be a json object: %v", ErrInvalidApprovalInput, err)
+		}
+		d.status = model.ApprovalEdited
+		d.arguments = string(req.Arguments)
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidApprovalInput, req.Action)
+	}
+
+	m.mu.Lock()
+	p, ok := m.pending[approvalID]
+	if ok {
+		delete(m.pending, approvalID)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrApprovalNotFound
+	}
+
+	p.decision <- d
+	return p.approval, nil
+}
+
+// ListPending 列出会话中等待审批的工具调用
+func (m *ApprovalManager) ListPending(sessionID string) []*model.ToolApproval {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	approvals := make([]*model.ToolApproval, 0)
+	for _, p := range m.pending {
+		if sessionID == "" || p.approval.SessionID == sessionID {
+			approvals = append(approvals, p.approval)
+		}
+	}
+	return approvals
+}
+
+// saveApproval 保存审批记录，服务重启后据此把没有处理完的审批标记为过期
+func saveApproval(approval *model.ToolApproval) {
+	if globalStorage == nil {
+		return
+	}
+	if err := globalStorage.SaveApproval(approval); err != nil {
+		logger.Errorf("Failed to save approval %s: %v", approval.ID, err)
+	}
+}
+
+// ExpirePendingApprovals 服务启动时调用，等待审批的运行已随进程结束，上次留下的待审批记录标记为过期
+func ExpirePendingApprovals() {
+	if globalStorage == nil {
+		return
+	}
+
+	approvals, err := globalStorage.ListApprovals("")
+	if err != nil {
+		logger.Errorf("Failed to list approvals for expiry: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, approval := range approvals {
+		if approval.Status != model.ApprovalPending {
+			continue
+		}
+		approval.Status = model.ApprovalExpired
+		approval.Reason = "服务重启，审批已失效"
+		approval.ResolvedAt = &now
+		if err := globalStorage.SaveApproval(approval); err != nil {
+			logger.Errorf("Failed to expire approval %s: %v", approval.ID, err)
+			continue
+		}
+		logger.Warnf("⌛ Approval %s for tool %s of session %s expired by restart", approval.ID, approval.ToolName, approval.SessionID)
+	}
+}
+
+// toolPolicy 获取工具的审批策略
+func toolPolicy(toolName string) string {
+	cfg := config.Get()
+	if cfg == nil {
+		return ToolPolicyAuto
+	}
+	approvalCfg := cfg.Agent.ToolApproval
+	if policy, ok := approvalCfg.Policies[toolName]; ok && policy != "" {
+		return policy
+	}
+	if approvalCfg.DefaultPolicy != "" {
+		return approvalCfg.DefaultPolicy
+	}
+	return ToolPolicyAuto
+}
+
+// approvalTool 为工具调用加上审批策略的包装
+type approvalTool struct {
+	tool.InvokableTool
+	name            string
+	policy          string
+	sessionID       string
+	timeout         time.Duration
+	progressManager *ProgressManager
+}
+
+// wrapToolsWithApproval 按配置的策略包装工具，auto 策略的工具保持不变
+func wrapToolsWithApproval(ctx context.Context, tools []tool.BaseTool, sessionID string, progressManager *ProgressManager) []tool.BaseTool {
+	timeout := defaultApprovalTimeout
+	if cfg := config.Get(); cfg != nil && cfg.Agent.ToolApproval.Timeout > 0 {
+		timeout = cfg.Agent.ToolApproval.Timeout
+	}
+
+	wrapped := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		info, err := t.Info(ctx)
+		if err != nil {
+			logger.Warnf("Failed to get tool info for approval policy: %v", err)
+			wrapped = append(wrapped, t)
+			continue
+		}
+
+		policy := toolPolicy(info.Name)
+		if policy == ToolPolicyAuto {
+			wrapped = append(wrapped, t)
+			continue
+		}
+
+		// 无法加上审批的工具不提供给模型，避免绕过审批直接执行
+		invokable, ok := t.(tool.InvokableTool)
+		if !ok {
+			logger.Errorf("🚫 Tool %s is not invokable and cannot enforce approval policy %s, tool removed", info.Name, policy)
+			continue
+		}
+
+		logger.Infof("🔐 Tool %s uses approval policy: %s", info.Name, policy)
+		wrapped = append(wrapped, &approvalTool{
+			InvokableTool:   invokable,
+			name:            info.Name,
+			policy:          policy,
+			sessionID:       sessionID,
+			timeout:         timeout,
+			progressManager: progressManager,
+		})
+	}
+	return wrapped
+}
+
+func (t *approvalTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	if t.policy == ToolPolicyDeny {
+		logger.Warnf("🚫 Tool %s denied by policy for session %s", t.name, t.sessionID)
+		return toolRefusal(t.name, fmt.Sprintf("工具 %s 已被策略禁止调用", t.name)), nil
+	}
+
+	// 工具在任务子图中执行，从状态中取出所属任务
+	var taskID string
+	_ = compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+		taskID = state.currentTaskID
+		return nil
+	})
+
+	approval := &model.ToolApproval{
+		ID:        uuid.New().String(),
+		SessionID: t.sessionID,
+		TaskID:    taskID,
+		ToolName:  t.name,
+		Arguments: argumentsInJSON,
+		Status:    model.ApprovalPending,
+		CreatedAt: time.Now(),
+	}
+
+	saveApproval(approval)
+	logger.Infof("⏸️ Tool %s waiting for approval %s (session %s)", t.name, approval.ID, t.sessionID)
+	t.progressManager.SendEvent("approval_required", "", fmt.Sprintf("⏸️ 等待审批: %s\n", t.name),
+		map[string]interface{}{"approval": approval, "task_id": taskID}, nil)
+
+	d := approvalManager.Wait(ctx, approval, t.timeout)
+
+	resolved := *approval
+	now := time.Now()
+	resolved.Status = d.status
+	resolved.Reason = d.reason
+	resolved.ResolvedAt = &now
+	if d.status == model.ApprovalEdited {
+		resolved.Arguments = d.arguments
+	}
+	saveApproval(&resolved)
+	t.progressManager.SendEvent("approval_resolved", "", fmt.Sprintf("▶️ 审批结果: %s %s\n", t.name, d.status),
+		map[string]interface{}{"approval": &resolved, "task_id": taskID}, nil)
+	logger.Infof("Approval %s for tool %s resolved: %s %s", approval.ID, t.name, d.status, d.reason)
+
+	switch d.status {
+	case model.ApprovalApproved:
+		return t.InvokableTool.InvokableRun(ctx, argumentsInJSON, opts...)
+	case model.ApprovalEdited:
+		return t.InvokableTool.InvokableRun(ctx, d.arguments, opts...)
+	default:
+		reason := d.reason
+		if reason == "" {
+			reason = "用户拒绝了该工具调用"
+		}
+		return toolRefusal(t.name, fmt.Sprintf("工具 %s 未获批准: %s", t.name, reason)), nil
+	}
+}
+
+// toolRefusal 与原子工具保持一致的失败返回格式，被拒绝的调用重试也不会通过
+func toolRefusal(toolName, message string) string {
+	return tools.NewErrorResult(toolName, tools.ErrCodeDenied, message, false).String()
+}
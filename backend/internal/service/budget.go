@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"glata-backend/internal/config"
+)
+
+// 未配置时的工具调用预算
+const (
+	defaultTaskToolCalls = 5
+	defaultRunToolCalls  = 40
+)
+
+// taskBudget 单个任务的工具调用预算
+type taskBudget struct {
+	category string
+	maxCalls int
+}
+
+// runBudget 单次运行的工具调用预算，由并行执行的任务共享
+type runBudget struct {
+	maxCalls int64
+	used     atomic.Int64
+}
+
+func newRunBudget() *runBudget {
+	maxCalls := defaultRunToolCalls
+	if cfg := config.Get(); cfg != nil && cfg.Agent.ToolBudget.MaxCallsPerRun > 0 {
+		maxCalls = cfg.Agent.ToolBudget.MaxCallsPerRun
+	}
+	return &runBudget{maxCalls: int64(maxCalls)}
+}
+
+// reserve 为即将发起的工具调用占用预算，超出上限时不占用并返回 false
+func (b *runBudget) reserve(calls int) bool {
+	for {
+		used := b.used.Load()
+		if used+int64(calls) > b.maxCalls {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+int64(calls)) {
+			return true
+		}
+	}
+}
+
+// classifyTask 按任务标题中的关键词确定任务类别及其工具调用上限
+func classifyTask(title string) taskBudget {
+	budget := taskBudget{category: "default", maxCalls: defaultTaskToolCalls}
+	cfg := config.Get()
+	if cfg == nil {
+		return budget
+	}
+
+	budgetCfg := cfg.Agent.ToolBudget
+	if budgetCfg.DefaultMaxCalls > 0 {
+		budget.maxCalls = budgetCfg.DefaultMaxCalls
+	}
+
+	lowerTitle := strings.ToLower(title)
+	for _, category := range budgetCfg.Categories {
+		if category.MaxCalls <= 0 {
+			continue
+		}
+		for _, keyword := range category.Keywords {
+			if keyword != "" && strings.Contains(lowerTitle, strings.ToLower(keyword)) {
+				return taskBudget{category: category.Name, maxCalls: category.MaxCalls}
+			}
+		}
+	}
+	return budget
+}
+
+// checkToolBudget 判断本次工具调用是否超出任务或运行的预算，超出时返回原因
+func checkToolBudget(state *myState, run *runBudget, calls int) string {
+	if state.toolCalls+calls > state.budget.maxCalls {
+		return fmt.Sprintf("任务工具调用预算已用完（类别 %s，上限 %d 次，已调用 %d 次）",
+			state.budget.category, state.budget.maxCalls, state.toolCalls)
+	}
+	if run != nil && !run.reserve(calls) {
+		return fmt.Sprintf("本次运行工具调用预算已用完（上限 %d 次）", run.maxCalls)
+	}
+	return ""
+}
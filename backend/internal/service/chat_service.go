@@ -9,16 +9,19 @@ import (
 
 	"glata-backend/internal/config"
 	"glata-backend/internal/model"
+	"glata-backend/internal/scheduler"
 	"glata-backend/internal/storage"
+	"glata-backend/internal/telemetry"
 	"glata-backend/pkg/logger"
 
 	"github.com/google/uuid"
 )
 
 type ChatService struct {
-	storage storage.Storage
-	mu      sync.RWMutex
-	config  *config.SessionConfig
+	storage      storage.Storage
+	mu           sync.RWMutex
+	config       *config.SessionConfig
+	runScheduler *scheduler.Scheduler
 }
 
 func NewChatService(cfg *config.Config) *ChatService {
@@ -37,13 +40,23 @@ func NewChatService(cfg *config.Config) *ChatService {
 	}
 
 	cs := &ChatService{
-		storage: store,
-		config:  &cfg.Session,
+		storage:      store,
+		config:       &cfg.Session,
+		runScheduler: newRunScheduler(cfg),
 	}
 
 	// 初始化Agent使用的存储
 	InitAgentStorage(store)
 
+	// 服务启动时标记上次未完成的运行和审批，并在对应的助手消息中提示
+	ExpirePendingApprovals()
+	for _, run := range RecoverInterruptedRuns() {
+		note := "\n\n> ⚠️ 服务重启，任务执行已中断，可以恢复执行或终止该任务。\n"
+		if err := cs.AppendMessageProgress(run.SessionID, run.MessageID, note); err != nil {
+			logger.Errorf("Failed to annotate interrupted run %s: %v", run.ID, err)
+		}
+	}
+
 	go cs.cleanupOldSessions()
 
 	return cs
@@ -153,16 +166,31 @@ func (s *ChatService) UpdateSessionTitle(sessionID, title string) error {
 	return nil
 }
 
-func (s *ChatService) StreamChat(sessionID, message string) (<-chan model.ChatResponse, <-chan error) {
+// StreamChat 在后台执行智能体并推送响应，运行不随请求取消，ctx 只用于关联请求的链路追踪
+// 会话中已有运行时按配置排队、取消正在执行的运行，或直接返回 ErrSessionBusy；userID 用于全局调度的按用户限流
+func (s *ChatService) StreamChat(ctx context.Context, userID, sessionID, message string) (<-chan model.ChatResponse, <-chan error, error) {
 	fmt.Println("=== StreamChat 方法开始执行 ===")
 	fmt.Printf("SessionID: %s, Message: %s\n", sessionID, message)
 
+	ticket, job, err := s.admitRun(userID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	respChan := make(chan model.ChatResponse, 1000) // 增加缓冲区容量
 	errChan := make(chan error, 1)
 
 	go func() {
 		defer close(respChan)
 		defer close(errChan)
+		// 运行开始后由运行自己在结束时交出执行权和调度名额，没有开始运行时在这里交出
+		finish := runFinisher(ticket, &job)
+		started := false
+		defer func() {
+			if !started {
+				finish()
+			}
+		}()
 
 		// 🛡️ 添加panic恢复机制
 		defer func() {
@@ -177,7 +205,7 @@ func (s *ChatService) StreamChat(sessionID, message string) (<-chan model.ChatRe
 		}()
 
 		fmt.Println("=== StreamChat goroutine 开始执行 ===")
-		ctx := context.Background()
+		ctx := telemetry.Detach(ctx)
 
 		// 验证会话和添加用户消息（保持不变）
 		if sessionID == "" {
@@ -193,6 +221,12 @@ func (s *ChatService) StreamChat(sessionID, message string) (<-chan model.ChatRe
 			return
 		}
 
+		// ⏳ 等前一个运行结束后再写入消息，历史中包含前一个运行的完整回复
+		if job, err = s.awaitRun(userID, sessionID, ticket, job, respChan); err != nil {
+			errChan <- err
+			return
+		}
+
 		fmt.Println("=== 添加用户消息 ===")
 		_, err = s.AddMessage(sessionID, "user", message)
 		if err != nil {
@@ -221,148 +255,493 @@ func (s *ChatService) StreamChat(sessionID, message string) (<-chan model.ChatRe
 		}
 
 		// 🎯 调用Agent获取进度通道和结果流
-		stream, progressChan, err := RunAgent(ctx, sessionID, message)
+		stream, progressChan, err := RunAgent(ctx, sessionID, messageID, message, finish)
 		if err != nil {
 			fmt.Printf("RunAgent 调用失败: %v\n", err)
 			errChan <- err
 			return
 		}
+		started = true
 		defer func() {
 			if stream != nil {
 				stream.Close()
 			}
 		}()
 
-		// 🎯 实时处理进度事件，动态检测DirectReply模式
-		fmt.Println("=== 处理进度事件并动态检测模式 ===")
-		var fullContent strings.Builder
-		var summaryContent strings.Builder // 🎯 新增：累积总结内容
-		var isDirectReplyMode bool = false  // 🎯 新增：检测是否为DirectReply模式
-		var firstChunkSent bool = false     // 🎯 新增：跟踪是否已发送第一个chunk
+		s.forwardProgress(sessionID, messageID, progressChan, respChan)
+	}()
+
+	return respChan, errChan, nil
+}
+
+// admitRun 申请会话的执行权；会话空闲时同时向全局调度器申请名额，调度队列已满时直接返回错误
+// 会话需要等待时先不占用调度队列，轮到该会话后再由 awaitRun 申请
+func (s *ChatService) admitRun(userID, sessionID string) (*sessionTicket, *scheduler.Ticket, error) {
+	ticket, err := sessionRuns.enter(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ticket.queued() {
+		return ticket, nil, nil
+	}
+
+	job, err := s.runScheduler.Submit(scheduler.Job{User: userID, Providers: runProviders()})
+	if err != nil {
+		ticket.release()
+		return nil, nil, err
+	}
+	return ticket, job, nil
+}
+
+// runFinisher 返回交出调度名额和会话执行权的函数，job 在等待名额后才会赋值，因此传入指针
+// 运行开始后由运行在结束时调用，转发进度的一方提前退出时不会提前交出
+func runFinisher(ticket *sessionTicket, job **scheduler.Ticket) func() {
+	return func() {
+		if *job != nil {
+			(*job).Done()
+		}
+		ticket.release()
+	}
+}
+
+// awaitRun 等待会话中前一个运行结束以及全局调度器的名额，等待期间向前端推送排队信息
+// 返回的调度凭证在运行结束后需要 Done，出错时也可能不为空
+func (s *ChatService) awaitRun(userID, sessionID string, ticket *sessionTicket, job *scheduler.Ticket, respChan chan<- model.ChatResponse) (*scheduler.Ticket, error) {
+	if ticket.queued() {
+		notice := "⏳ 当前会话有正在执行的任务，本次请求将在其结束后开始执行\n"
+		if ticket.policy == sessionRunInterrupt {
+			notice = "⛔ 正在取消当前会话中正在执行的任务，取消后开始执行本次请求\n"
+		}
+		s.sendQueued(sessionID, "queued", notice, nil, respChan)
+		if err := ticket.wait(); err != nil {
+			return job, err
+		}
+	}
+
+	if job == nil {
+		var err error
+		if job, err = s.runScheduler.Submit(scheduler.Job{User: userID, Providers: runProviders()}); err != nil {
+			return nil, err
+		}
+	}
+	if !job.Queued() {
+		return job, nil
+	}
+
+	logger.Infof("🚦 Run of session %s is waiting for a scheduler slot", sessionID)
+	err := job.Wait(context.Background(), func(status scheduler.Status) {
+		notice := fmt.Sprintf("⏳ 当前执行中的任务较多，已排队，前面还有 %d 个任务\n", status.Position-1)
+		if status.ETA > 0 {
+			notice = fmt.Sprintf("⏳ 当前执行中的任务较多，已排队，前面还有 %d 个任务，预计等待 %s\n",
+				status.Position-1, status.ETA.Round(time.Second))
+		}
+		s.sendQueued(sessionID, "queue_position", notice, queueStatus(status), respChan)
+	})
+	return job, err
+}
+
+// sendQueued 推送排队中的提示
+func (s *ChatService) sendQueued(sessionID, respType, notice string, queue *model.QueueStatus, respChan chan<- model.ChatResponse) {
+	select {
+	case respChan <- model.ChatResponse{
+		SessionID:   sessionID,
+		Content:     notice,
+		Role:        "assistant",
+		Timestamp:   time.Now().Unix(),
+		Type:        respType,
+		IsProgress:  true,
+		ContentType: "progress",
+		Phase:       "queued",
+		Queue:       queue,
+	}:
+	default:
+		logger.Warn("Response channel is full, cannot send queued notice")
+	}
+}
+
+// SchedulerMetrics 全局运行调度器的状态
+func (s *ChatService) SchedulerMetrics() scheduler.Metrics {
+	return s.runScheduler.Metrics()
+}
+
+// QueueRetryAfter 调度队列已满时建议客户端重试前等待的时间
+func (s *ChatService) QueueRetryAfter() time.Duration {
+	return s.runScheduler.RetryAfter()
+}
+
+// ResumeRun 从检查点恢复中断的运行，进度继续写入原来的助手消息；ctx 只用于关联请求的链路追踪
+// 与 StreamChat 一样需要先取得会话的执行权和全局调度的名额
+func (s *ChatService) ResumeRun(ctx context.Context, userID, runID string) (<-chan model.ChatResponse, <-chan error, error) {
+	record, err := s.storage.GetRun(runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ticket, job, err := s.admitRun(userID, record.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	respChan := make(chan model.ChatResponse, 1000)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(respChan)
+		defer close(errChan)
+		finish := runFinisher(ticket, &job)
+		started := false
+		defer func() {
+			if !started {
+				finish()
+			}
+		}()
+
+		// 🛡️ 添加panic恢复机制
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorf("ResumeRun goroutine panic recovered: %v", r)
+				select {
+				case errChan <- fmt.Errorf("internal server error: %v", r):
+				default:
+				}
+			}
+		}()
+
+		if job, err = s.awaitRun(userID, record.SessionID, ticket, job, respChan); err != nil {
+			errChan <- err
+			return
+		}
+
+		run, progressChan, err := ResumeAgent(telemetry.Detach(ctx), runID, finish)
+		if err != nil {
+			logger.Errorf("Failed to resume run %s: %v", runID, err)
+			errChan <- err
+			return
+		}
+		started = true
+
+		if err := s.AppendMessageProgress(run.SessionID, run.MessageID, "\n\n> 🔁 任务已恢复执行\n"); err != nil {
+			logger.Errorf("Failed to annotate resumed run %s: %v", run.ID, err)
+		}
+
+		s.forwardProgress(run.SessionID, run.MessageID, progressChan, respChan)
+	}()
+
+	return respChan, errChan, nil
+}
+
+// AbortRun 终止中断的运行
+// 终止会修改会话的计划，需要先取得会话的执行权；会话中有正在执行的运行时返回 ErrSessionBusy，不排队等待
+func (s *ChatService) AbortRun(runID string) (*model.RunRecord, error) {
+	run, err := s.storage.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	ticket, err := sessionRuns.tryEnter(run.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer ticket.release()
+
+	if err := abortRun(run); err != nil {
+		return nil, err
+	}
+
+	if err := s.AppendMessageProgress(run.SessionID, run.MessageID, "\n\n> ⛔ 任务已终止\n"); err != nil {
+		logger.Errorf("Failed to annotate aborted run %s: %v", run.ID, err)
+	}
+	return run, nil
+}
+
+// CancelRun 取消会话中正在执行的运行
+func (s *ChatService) CancelRun(sessionID, messageID string) ([]string, error) {
+	return CancelRun(sessionID, messageID)
+}
+
+// ListRuns 获取会话的运行记录
+func (s *ChatService) ListRuns(sessionID string) ([]*model.RunRecord, error) {
+	return s.storage.ListRuns(sessionID)
+}
+
+// GetPlan 获取会话最新版本的计划
+func (s *ChatService) GetPlan(sessionID string) (*model.Plan, error) {
+	return GetSessionPlan(sessionID)
+}
+
+// UpdatePlan 编辑会话的计划，写入为新版本
+func (s *ChatService) UpdatePlan(sessionID string, req *model.UpdatePlanRequest) (*model.Plan, error) {
+	return EditSessionPlan(sessionID, req)
+}
+
+// ListPlanVersions 列出会话计划的全部版本
+func (s *ChatService) ListPlanVersions(sessionID string) ([]model.PlanVersionSummary, error) {
+	return ListPlanVersions(sessionID)
+}
+
+// GetPlanVersion 获取会话计划的指定版本
+func (s *ChatService) GetPlanVersion(sessionID string, version int) (*model.Plan, error) {
+	return GetPlanVersion(sessionID, version)
+}
+
+// DiffPlan 比较会话计划的两个版本
+func (s *ChatService) DiffPlan(sessionID string, from, to int) (*model.PlanDiff, error) {
+	return DiffPlanVersions(sessionID, from, to)
+}
+
+// GetArtifact 获取被截断的工具输出的完整内容
+func (s *ChatService) GetArtifact(sessionID, artifactID string) (*model.Artifact, error) {
+	return s.storage.GetArtifact(sessionID, artifactID)
+}
+
+// GetMessageTrace 获取助手消息对应运行的执行追踪
+func (s *ChatService) GetMessageTrace(messageID string) (*model.Trace, error) {
+	return s.storage.GetTrace(messageID)
+}
+
+// forwardProgress 将Agent进度事件转换为聊天响应，并持久化到助手消息中
+func (s *ChatService) forwardProgress(sessionID, messageID string, progressChan <-chan ProgressEvent, respChan chan<- model.ChatResponse) {
+	// 🎯 实时处理进度事件，动态检测DirectReply模式
+	fmt.Println("=== 处理进度事件并动态检测模式 ===")
+	var fullContent strings.Builder
+	var summaryContent strings.Builder // 🎯 新增：累积总结内容
+	var isDirectReplyMode bool = false  // 🎯 新增：检测是否为DirectReply模式
+	var firstChunkSent bool = false     // 🎯 新增：跟踪是否已发送第一个chunk
+	var streamedReply strings.Builder  // 💬 已逐token推送的直接回复内容
+	var directReplyDone bool           // 💬 直接回复的完整内容已到达，之后的 token 不再推送
+	
+	for progressEvent := range progressChan {
+		// 🎯 提前检测DirectReply模式 - 通过图执行节点信息判断
+		if !isDirectReplyMode && (progressEvent.NodeName == "directReply" || 
+			(progressEvent.EventType == "completed" && progressEvent.Message == "直接回复完成")) {
+			isDirectReplyMode = true
+			fmt.Printf("🎯 检测到DirectReply模式: EventType=%s, NodeName=%s, Message=%s\n", 
+				progressEvent.EventType, progressEvent.NodeName, progressEvent.Message)
+		}
 		
-		for progressEvent := range progressChan {
-			// 🎯 提前检测DirectReply模式 - 通过图执行节点信息判断
-			if !isDirectReplyMode && (progressEvent.NodeName == "directReply" || 
-				(progressEvent.EventType == "completed" && progressEvent.Message == "直接回复完成")) {
-				isDirectReplyMode = true
-				fmt.Printf("🎯 检测到DirectReply模式: EventType=%s, NodeName=%s, Message=%s\n", 
-					progressEvent.EventType, progressEvent.NodeName, progressEvent.Message)
+		// 💬 模型输出的增量只推送不保存，节点完成后的事件仍然是最终结果
+		if progressEvent.EventType == "token" {
+			if progressEvent.NodeName == "directReply" && directReplyDone {
+				continue
 			}
-			
-			// 检查是否是结果消息
-			if progressEvent.EventType == "result_chunk" {
-				// 🎯 关键修复：使用专门的流式处理函数，保持markdown格式
-				filteredContent := removeThinkingTagsForStream(progressEvent.Message)
-				if filteredContent != "" {
+			resp := model.ChatResponse{
+				SessionID:   sessionID,
+				MessageID:   messageID,
+				Role:        "assistant",
+				Timestamp:   progressEvent.Timestamp.Unix(),
+				Type:        "token",
+				IsProgress:  true,
+				ContentType: "progress",
+				Phase:       "progress",
+				StreamType:  "real",
+				Node:        progressEvent.NodeName,
+				Delta:       progressEvent.Message,
+			}
+			if taskID, ok := progressEvent.Data["task_id"].(string); ok {
+				resp.TaskID = taskID
+			}
+			// 直接回复的增量就是回复正文，计入消息内容
+			if progressEvent.NodeName == "directReply" {
+				resp.Content = progressEvent.Message
+				resp.Mode = "DIRECT_REPLY"
+				streamedReply.WriteString(progressEvent.Message)
+			}
+			select {
+			case respChan <- resp:
+			default:
+				logger.Warn("Response channel is full, dropping token")
+			}
+			continue
+		}
+
+		// 检查是否是结果消息
+		if progressEvent.EventType == "result_chunk" {
+			// 🎯 关键修复：使用专门的流式处理函数，保持markdown格式
+			filteredContent := removeThinkingTagsForStream(progressEvent.Message)
+			// 💬 直接回复已逐token推送过，只补发还没有推送的部分，保存的仍是完整内容
+			if progressEvent.NodeName == "directReply" && !directReplyDone {
+				directReplyDone = true
+				if streamed := streamedReply.String(); streamed != "" {
 					fullContent.WriteString(filteredContent)
-					summaryContent.WriteString(filteredContent) // 累积到总结内容中
-					fmt.Printf("📤 接收总结片段: %s\n", filteredContent)
-					
-					// 🎯 新修复：实时流式发送每个字符/词到前端
-					// 根据模式决定是否添加前缀
-					var streamContent string
-					if isDirectReplyMode {
-						// DirectReply模式：直接发送内容，不添加任何前缀
-						streamContent = filteredContent
-					} else {
-						// 任务模式：只在第一次发送时添加标题前缀
-						if !firstChunkSent {
-							streamContent = "\n\n## 📋 任务总结\n\n" + filteredContent
-							firstChunkSent = true
-						} else {
-							streamContent = filteredContent
+					summaryContent.WriteString(filteredContent)
+					if !strings.HasPrefix(filteredContent, streamed) {
+						if !strings.HasPrefix(streamed, filteredContent) {
+							logger.Warnf("Streamed direct reply of message %s differs from final content", messageID)
 						}
+						continue
+					}
+					filteredContent = filteredContent[len(streamed):]
+					if filteredContent == "" {
+						continue
 					}
-					
-					// 实时发送流式内容到前端
 					select {
 					case respChan <- model.ChatResponse{
 						SessionID:   sessionID,
 						MessageID:   messageID,
-						Content:     streamContent,
+						Content:     filteredContent,
 						Role:        "assistant",
 						Timestamp:   progressEvent.Timestamp.Unix(),
-						IsProgress:  true,           // 🎯 关键：标记为进度消息
-						ContentType: "progress",     // 🎯 内容类型为进度
-						Phase:       "progress",     // 🎯 阶段为进度
+						IsProgress:  true,
+						ContentType: "progress",
+						Phase:       "progress",
 					}:
-						// 成功发送
 					default:
 						logger.Warn("Response channel is full, cannot send stream progress")
 					}
+					continue
 				}
-			} else if progressEvent.EventType == "completed" {
-				// 🎯 任务完成，发送完成的总结内容到存储（用于持久化）
-				if summaryContent.Len() > 0 {
-					fmt.Printf("📤 发送完整总结消息: %s\n", summaryContent.String())
-					
-					// 🎯 关键修复：DirectReply模式不添加"任务总结"标题
-					var completeSummary string
-					if isDirectReplyMode {
-						// DirectReply模式：直接使用AI回复内容，不添加标题
-						completeSummary = fmt.Sprintf("\n\n%s", summaryContent.String())
+			}
+			if filteredContent != "" {
+				fullContent.WriteString(filteredContent)
+				summaryContent.WriteString(filteredContent) // 累积到总结内容中
+				fmt.Printf("📤 接收总结片段: %s\n", filteredContent)
+				
+				// 🎯 新修复：实时流式发送每个字符/词到前端
+				// 根据模式决定是否添加前缀
+				var streamContent string
+				if isDirectReplyMode {
+					// DirectReply模式：直接发送内容，不添加任何前缀
+					streamContent = filteredContent
+				} else {
+					// 任务模式：只在第一次发送时添加标题前缀
+					if !firstChunkSent {
+						streamContent = "\n\n## 📋 任务总结\n\n" + filteredContent
+						firstChunkSent = true
 					} else {
-						// 普通任务模式：添加"任务总结"标题
-						completeSummary = fmt.Sprintf("\n\n## 📋 任务总结\n\n%s", summaryContent.String())
-					}
-					
-					// 更新存储中的消息内容（用于持久化）
-					err := s.AppendMessageProgress(sessionID, messageID, completeSummary)
-					if err != nil {
-						logger.Errorf("Failed to append summary progress: %v", err)
+						streamContent = filteredContent
 					}
 				}
 				
-				// 任务完成，发送完成信号
-				fmt.Println("=== 任务执行完成 ===")
-				select {
-				case respChan <- model.ChatResponse{
-					SessionID: sessionID,
-					MessageID: messageID,
-					Content:   "",
-					Role:      "assistant",
-					Timestamp: progressEvent.Timestamp.Unix(),
-					Phase:     "completed",
-				}:
-				default:
-					logger.Warn("Cannot send completion signal")
-				}
-				break // 结束处理
-			} else {
-				// 这是进度消息，按原来的方式处理
-				filteredMessage := removeThinkingTags(progressEvent.Message)
-				progressContent := fmt.Sprintf("%s %s", progressEvent.NodeName, filteredMessage)
-				
-				// 更新存储中的进度内容
-				err := s.SetMessageProgress(sessionID, messageID, progressContent)
-				if err != nil {
-					logger.Errorf("Failed to update progress: %v", err)
-				}
-
-				// 发送进度消息
+				// 实时发送流式内容到前端
 				select {
 				case respChan <- model.ChatResponse{
 					SessionID:   sessionID,
 					MessageID:   messageID,
-					Content:     progressContent,
+					Content:     streamContent,
 					Role:        "assistant",
 					Timestamp:   progressEvent.Timestamp.Unix(),
-					IsProgress:  true,
-					ContentType: "progress",
-					Phase:       "progress",
+					IsProgress:  true,           // 🎯 关键：标记为进度消息
+					ContentType: "progress",     // 🎯 内容类型为进度
+					Phase:       "progress",     // 🎯 阶段为进度
 				}:
-					fmt.Printf("📊 实时发送进度消息: %s (ID: %s)\n", progressContent, messageID)
+					// 成功发送
 				default:
-					logger.Warn("Response channel is full, cannot send progress")
-					return
+					logger.Warn("Response channel is full, cannot send stream progress")
+				}
+			}
+		} else if progressEvent.EventType == "cancelled" {
+			// ⛔ 运行被取消，保留已生成的内容并追加取消说明作为最终消息
+			cancelNote := "\n\n## ⛔ 任务已取消\n\n用户取消了本次执行，未完成的任务已停止。\n"
+			if summaryContent.Len() > 0 {
+				cancelNote = "\n\n" + summaryContent.String() + cancelNote
+			}
+			if err := s.AppendMessageProgress(sessionID, messageID, cancelNote); err != nil {
+				logger.Errorf("Failed to append cancel note: %v", err)
+			}
+
+			logger.Infof("⛔ Run of message %s in session %s was cancelled", messageID, sessionID)
+			select {
+			case respChan <- model.ChatResponse{
+				SessionID:   sessionID,
+				MessageID:   messageID,
+				Content:     cancelNote,
+				Role:        "assistant",
+				Timestamp:   progressEvent.Timestamp.Unix(),
+				IsProgress:  true,
+				ContentType: "progress",
+				Phase:       "cancelled",
+			}:
+			default:
+				logger.Warn("Cannot send cancel signal")
+			}
+			break // 结束处理
+		} else if progressEvent.EventType == "completed" {
+			// 🎯 任务完成，发送完成的总结内容到存储（用于持久化）
+			if summaryContent.Len() > 0 {
+				fmt.Printf("📤 发送完整总结消息: %s\n", summaryContent.String())
+				
+				// 🎯 关键修复：DirectReply模式不添加"任务总结"标题
+				var completeSummary string
+				if isDirectReplyMode {
+					// DirectReply模式：直接使用AI回复内容，不添加标题
+					completeSummary = fmt.Sprintf("\n\n%s", summaryContent.String())
+				} else {
+					// 普通任务模式：添加"任务总结"标题
+					completeSummary = fmt.Sprintf("\n\n## 📋 任务总结\n\n%s", summaryContent.String())
+				}
+				
+				// 更新存储中的消息内容（用于持久化）
+				err := s.AppendMessageProgress(sessionID, messageID, completeSummary)
+				if err != nil {
+					logger.Errorf("Failed to append summary progress: %v", err)
 				}
 			}
+			
+			// 🧠 异步从本次会话中提取长期记忆
+			go extractSessionMemories(sessionID)
+			
+			// 任务完成，发送完成信号
+			fmt.Println("=== 任务执行完成 ===")
+			select {
+			case respChan <- model.ChatResponse{
+				SessionID: sessionID,
+				MessageID: messageID,
+				Content:   "",
+				Role:      "assistant",
+				Timestamp: progressEvent.Timestamp.Unix(),
+				Phase:     "completed",
+			}:
+			default:
+				logger.Warn("Cannot send completion signal")
+			}
+			break // 结束处理
+		} else {
+			// 这是进度消息，按原来的方式处理
+			filteredMessage := removeThinkingTags(progressEvent.Message)
+			progressContent := fmt.Sprintf("%s %s", progressEvent.NodeName, filteredMessage)
+			
+			// 更新存储中的进度内容
+			err := s.SetMessageProgress(sessionID, messageID, progressContent)
+			if err != nil {
+				logger.Errorf("Failed to update progress: %v", err)
+			}
+
+			resp := model.ChatResponse{
+				SessionID:   sessionID,
+				MessageID:   messageID,
+				Content:     progressContent,
+				Role:        "assistant",
+				Timestamp:   progressEvent.Timestamp.Unix(),
+				IsProgress:  true,
+				ContentType: "progress",
+				Phase:       "progress",
+			}
+
+			// 🔐 工具审批事件携带审批信息，前端据此展示审批操作
+			if approval, ok := progressEvent.Data["approval"].(*model.ToolApproval); ok {
+				resp.Type = progressEvent.EventType
+				resp.Approval = approval
+			}
+			// ✏️ 用户编辑计划的事件携带新计划，前端据此刷新任务列表
+			if plan, ok := progressEvent.Data["plan"].(*model.Plan); ok && progressEvent.EventType == "plan_updated" {
+				resp.Type = progressEvent.EventType
+				resp.Plan = plan
+			}
+
+			// 发送进度消息
+			select {
+			case respChan <- resp:
+				fmt.Printf("📊 实时发送进度消息: %s (ID: %s)\n", progressContent, messageID)
+			default:
+				// 客户端断开后响应通道不再被读取，丢弃进度继续处理，不能提前结束
+				logger.Warn("Response channel is full, dropping progress")
+			}
 		}
+	}
 
-		fmt.Printf("=== 最终内容长度: %d 字符 ===\n", fullContent.Len())
-	}()
+	// 完成或取消事件之后的进度不再转发，读到通道关闭为止
+	for range progressChan {
+	}
 
-	return respChan, errChan
+	fmt.Printf("=== 最终内容长度: %d 字符 ===\n", fullContent.Len())
 }
 
 func (s *ChatService) cleanupOldSessions() {
@@ -557,6 +936,16 @@ func (s *ChatService) truncateString(str string, maxLen int) string {
 	return string(runes[:maxLen]) + "..."
 }
 
+// ResolveApproval 处理工具调用审批
+func (s *ChatService) ResolveApproval(approvalID string, req *model.ApprovalDecisionRequest) (*model.ToolApproval, error) {
+	return approvalManager.Resolve(approvalID, req)
+}
+
+// GetPendingApprovals 获取会话中等待审批的工具调用
+func (s *ChatService) GetPendingApprovals(sessionID string) []*model.ToolApproval {
+	return approvalManager.ListPending(sessionID)
+}
+
 // GetStorage 返回存储实例，用于其他服务共享
 func (s *ChatService) GetStorage() storage.Storage {
 	return s.storage
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"glata-backend/internal/config"
+	"glata-backend/pkg/logger"
+
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 未配置时的上下文预算
+const (
+	defaultContextTokens    = 32000
+	defaultReserveTokens    = 4096
+	defaultKeepRecent       = 6
+	messageOverheadTokens   = 4    // 每条消息的角色、分隔符等额外开销
+	maxFoldedMessageRunes   = 2000 // 压缩时单条消息最多保留的字符数
+	summaryBudgetPercentage = 20   // 摘要最多占用的输入预算比例
+)
+
+// contextManager 按token预算组装发送给模型的上下文，超出预算时将较早的消息压缩为滚动摘要
+type contextManager struct {
+	budgets      map[string]int // 各阶段可用于输入的token数，按阶段模型的上下文窗口计算
+	keepRecent   int
+	summaryModel einoModel.ChatModel
+	prompt       string
+}
+
+// newContextManager 根据各阶段模型的上下文窗口创建上下文管理器
+// 各节点按自己阶段的模型取预算，规划不会被更小的更新模型窗口限制
+func newContextManager(summaryModel einoModel.ChatModel) *contextManager {
+	m := &contextManager{
+		budgets:      make(map[string]int),
+		keepRecent:   defaultKeepRecent,
+		summaryModel: summaryModel,
+	}
+	cfg := config.Get()
+	if cfg == nil {
+		return m
+	}
+
+	for _, stage := range config.ModelStages {
+		_, modelCfg := cfg.StageModel(stage)
+		if modelCfg == nil {
+			continue
+		}
+		contextTokens := modelCfg.GetContextTokens()
+		if contextTokens <= 0 {
+			contextTokens = defaultContextTokens
+		}
+		reserve := modelCfg.GetMaxTokens()
+		if reserve <= 0 || reserve >= contextTokens {
+			reserve = defaultReserveTokens
+		}
+		m.budgets[stage] = contextTokens - reserve
+	}
+	if cfg.Agent.ContextKeepRecent > 0 {
+		m.keepRecent = cfg.Agent.ContextKeepRecent
+	}
+	m.prompt = cfg.Agent.ContextSummaryPrompt
+
+	logger.Infof("📏 Context budgets per stage: %v input tokens (keep recent %d messages)", m.budgets, m.keepRecent)
+	return m
+}
+
+// budget 返回阶段可用于输入的token数，未配置的阶段使用默认窗口
+func (m *contextManager) budget(stage string) int {
+	if budget, ok := m.budgets[stage]; ok {
+		return budget
+	}
+	return defaultContextTokens - defaultReserveTokens
+}
+
+// estimateTokens 粗略估算文本的token数：中日韩字符约每字1个token，其他字符约每4个字符1个token
+func estimateTokens(content string) int {
+	cjk, other := 0, 0
+	for _, r := range content {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+func estimateMessageTokens(msg *schema.Message) int {
+	tokens := messageOverheadTokens + estimateTokens(msg.Content)
+	for _, call := range msg.ToolCalls {
+		tokens += estimateTokens(call.Function.Name) + estimateTokens(call.Function.Arguments)
+	}
+	return tokens
+}
+
+func estimateMessagesTokens(messages []*schema.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateMessageTokens(msg)
+	}
+	return total
+}
+
+// build 按 stage 阶段模型的预算组装发送给模型的上下文：系统提示 + 滚动摘要 + 未压缩的历史消息
+// 超出预算时将较早的历史压缩进摘要，压缩进度记录在状态中，后续节点复用
+func (m *contextManager) build(ctx context.Context, stage string, state *myState, systemPrompt string) []*schema.Message {
+	if state.summarizedLen > len(state.history) {
+		state.summarizedLen = len(state.history)
+	}
+
+	budget := m.budget(stage)
+	messages := m.assemble(state, systemPrompt)
+	total := estimateMessagesTokens(messages)
+	if total <= budget {
+		return messages
+	}
+
+	cut := m.findCut(state, budget, estimateTokens(systemPrompt))
+	if cut > state.summarizedLen {
+		logger.Infof("📏 Context of session %s has ~%d tokens (%s budget %d), compressing %d older messages",
+			state.sessionID, total, stage, budget, cut-state.summarizedLen)
+		m.fold(ctx, state, cut, budget)
+		messages = m.assemble(state, systemPrompt)
+		total = estimateMessagesTokens(messages)
+		if total <= budget {
+			return messages
+		}
+	}
+
+	// 最近的消息本身就超出预算（通常是超长的工具输出），只能截断单条消息
+	logger.Warnf("📏 Context of session %s still has ~%d tokens after compression (%s budget %d), truncating long messages",
+		state.sessionID, total, stage, budget)
+	return truncateMessages(messages, budget)
+}
+
+// assemble 拼接系统提示、摘要和尚未压缩的历史
+func (m *contextManager) assemble(state *myState, systemPrompt string) []*schema.Message {
+	if state.contextSummary != "" {
+		systemPrompt += "\n\n**早期对话摘要**：\n" + state.contextSummary
+	}
+	recent := messageCleaner.CleanMessages(state.history[state.summarizedLen:])
+	return append([]*schema.Message{schema.SystemMessage(systemPrompt)}, recent...)
+}
+
+// findCut 从最新的消息往前保留，直到达到预算，返回需要压缩的历史的结束位置
+// 至少保留 keepRecent 条消息，且不会从工具结果消息处切开，避免工具调用和结果分离
+func (m *contextManager) findCut(state *myState, budget, systemTokens int) int {
+	available := budget - systemTokens - budget*summaryBudgetPercentage/100
+	history := state.history
+
+	cut := len(history)
+	used := 0
+	for i := len(history) - 1; i >= state.summarizedLen; i-- {
+		used += estimateMessageTokens(history[i])
+		if used > available && len(history)-i > m.keepRecent {
+			break
+		}
+		cut = i
+	}
+	for cut < len(history) && history[cut].Role == schema.Tool {
+		cut++
+	}
+	return cut
+}
+
+// fold 将 [summarizedLen, cut) 区间的历史合并进滚动摘要
+func (m *contextManager) fold(ctx context.Context, state *myState, cut, budget int) {
+	folded := state.history[state.summarizedLen:cut]
+
+	var transcript strings.Builder
+	for _, msg := range folded {
+		transcript.WriteString(renderMessageForSummary(msg))
+		transcript.WriteString("\n")
+	}
+
+	var sb strings.Builder
+	if state.contextSummary != "" {
+		sb.WriteString("## 之前的摘要\n")
+		sb.WriteString(state.contextSummary)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("## 需要合并的对话记录\n")
+	sb.WriteString(transcript.String())
+
+	summary, err := m.summarize(ctx, sb.String())
+	if err != nil {
+		// 摘要失败时保留之前的摘要和这部分历史的截断记录，摘要部分按最坏情况每个字符1个token限制在预算内
+		logger.Warnf("📏 Failed to summarize context of session %s, keeping truncated transcript of %d messages: %v", state.sessionID, len(folded), err)
+		summary = state.contextSummary
+		if summary != "" {
+			summary += "\n\n"
+		}
+		summary += fmt.Sprintf("（以下 %d 条较早的消息未能压缩，保留截断的原文）\n%s", len(folded), strings.TrimSpace(transcript.String()))
+		summary = truncateMiddle(summary, budget*summaryBudgetPercentage/100)
+	}
+
+	state.contextSummary = summary
+	state.summarizedLen = cut
+}
+
+func (m *contextManager) summarize(ctx context.Context, content string) (string, error) {
+	if m.summaryModel == nil {
+		return "", fmt.Errorf("no summary model configured")
+	}
+	prompt := m.prompt
+	if prompt == "" {
+		prompt = "请将以下对话记录压缩为简洁的摘要，保留用户需求、关键步骤、结果和未解决的问题。"
+	}
+
+	// 需要压缩的内容本身也不能超出总结模型的预算
+	if budget := m.budget(config.StageSummary); estimateTokens(content) > budget {
+		content = truncateRunes(content, budget)
+	}
+
+	resp, err := m.summaryModel.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(prompt),
+		schema.UserMessage(content),
+	})
+	if err != nil {
+		return "", err
+	}
+	summary := strings.TrimSpace(removeThinkingTags(resp.Content))
+	if summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return summary, nil
+}
+
+// renderMessageForSummary 将消息渲染为摘要模型可读的文本
+func renderMessageForSummary(msg *schema.Message) string {
+	content := truncateRunes(strings.TrimSpace(msg.Content), maxFoldedMessageRunes)
+	for _, call := range msg.ToolCalls {
+		content += fmt.Sprintf("\n调用工具 %s：%s", call.Function.Name, truncateRunes(call.Function.Arguments, 500))
+	}
+	return fmt.Sprintf("[%s] %s", msg.Role, content)
+}
+
+// truncateMessages 按比例截断超长的消息内容，返回新的消息切片，不修改原消息
+func truncateMessages(messages []*schema.Message, budget int) []*schema.Message {
+	if len(messages) == 0 {
+		return messages
+	}
+	perMessage := budget / len(messages)
+	result := make([]*schema.Message, len(messages))
+	for i, msg := range messages {
+		if estimateMessageTokens(msg) <= perMessage || i == 0 {
+			result[i] = msg
+			continue
+		}
+		cp := *msg
+		// 按最坏情况每个字符1个token截断
+		cp.Content = truncateRunes(msg.Content, perMessage) + "\n（内容过长已截断）"
+		result[i] = &cp
+	}
+	return result
+}
@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/pkg/logger"
+
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 意图路由的默认参数
+const (
+	generalIntent             = "general" // 未命中专用意图，使用通用的规划执行流程
+	defaultIntentToolCalls    = 5
+	intentContextMessages     = 4 // 意图分析时参考的最近消息数
+	maxIntentContextMsgRunes  = 500
+	defaultIntentAnalysisHint = `判断用户请求属于哪一类，只输出JSON：{"intent":"...","reason":"..."}`
+)
+
+// intentRouter 意图路由：识别请求的意图，命中时交给只使用受限工具的专用子图处理
+type intentRouter struct {
+	sessionID       string
+	routes          map[string]config.IntentRoute
+	order           []string
+	allTools        []tool.BaseTool
+	executeModel    einoModel.ChatModel
+	contextMgr      *contextManager
+	toolBudget      *runBudget
+	loopDetector    *loopDetector
+	progressManager *ProgressManager
+
+	mu      sync.Mutex
+	runners map[string]*intentRunner // 已构建的专用子图，每个意图在一次运行中只编译一次
+}
+
+// intentRunner 意图专用子图的构建结果，构建失败时记录错误
+type intentRunner struct {
+	runnable compose.Runnable[*intentInput, *schema.Message]
+	err      error
+}
+
+// intentInput 专用子图的输入，继承主图的上下文
+type intentInput struct {
+	Prompt         string
+	History        []*schema.Message
+	ContextSummary string
+	SummarizedLen  int
+}
+
+// intentOutput 意图分析模型的输出
+type intentOutput struct {
+	Intent string `json:"intent"`
+	Reason string `json:"reason"`
+}
+
+// newIntentRouter 根据配置创建意图路由，未启用或没有配置意图时返回 nil，所有请求使用通用流程
+func newIntentRouter(sessionID string, allTools []tool.BaseTool, executeModel einoModel.ChatModel, contextMgr *contextManager, toolBudget *runBudget, loopDetector *loopDetector, progressManager *ProgressManager) *intentRouter {
+	cfg := config.Get()
+	if cfg == nil || !cfg.Agent.IntentRouting.Enabled {
+		return nil
+	}
+
+	r := &intentRouter{
+		sessionID:       sessionID,
+		routes:          make(map[string]config.IntentRoute),
+		allTools:        allTools,
+		executeModel:    executeModel,
+		contextMgr:      contextMgr,
+		toolBudget:      toolBudget,
+		loopDetector:    loopDetector,
+		progressManager: progressManager,
+		runners:         make(map[string]*intentRunner),
+	}
+	for _, route := range cfg.Agent.IntentRouting.Routes {
+		name := strings.TrimSpace(route.Name)
+		if name == "" || name == generalIntent || route.Prompt == "" {
+			logger.Warnf("🧭 Ignoring invalid intent route %q", route.Name)
+			continue
+		}
+		if _, exists := r.routes[name]; !exists {
+			r.order = append(r.order, name)
+		}
+		r.routes[name] = route
+	}
+	if len(r.routes) == 0 {
+		return nil
+	}
+	return r
+}
+
+// classify 调用意图分析模型，返回命中的意图；未命中或分析失败时返回 general
+func (r *intentRouter) classify(ctx context.Context, classifier einoModel.ChatModel, messages []*schema.Message) (string, string) {
+	prompt := config.Get().Agent.IntentAnalysisPrompt
+	if prompt == "" {
+		prompt = defaultIntentAnalysisHint
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prompt)
+	sb.WriteString("\n\n## 可选的意图\n")
+	for _, name := range r.order {
+		sb.WriteString(fmt.Sprintf("- %s：%s\n", name, r.routes[name].Description))
+	}
+	sb.WriteString(fmt.Sprintf("- %s：不属于以上任何一类的请求\n", generalIntent))
+
+	recent := messageCleaner.CleanMessages(messages)
+	if len(recent) > intentContextMessages {
+		recent = recent[len(recent)-intentContextMessages:]
+	}
+	var conversation strings.Builder
+	for _, msg := range recent {
+		conversation.WriteString(fmt.Sprintf("[%s] %s\n", msg.Role, truncateRunes(msg.Content, maxIntentContextMsgRunes)))
+	}
+
+	resp, err := classifier.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(sb.String()),
+		schema.UserMessage(conversation.String()),
+	})
+	if err != nil {
+		logger.Warnf("🧭 Intent analysis failed for session %s, using general flow: %v", r.sessionID, err)
+		return generalIntent, "意图分析失败"
+	}
+
+	raw, err := extractJSONObject(removeThinkingTags(resp.Content))
+	if err != nil {
+		logger.Warnf("🧭 Invalid intent analysis output for session %s, using general flow: %v", r.sessionID, err)
+		return generalIntent, "意图分析结果无法解析"
+	}
+	var out intentOutput
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		logger.Warnf("🧭 Invalid intent analysis output for session %s, using general flow: %v", r.sessionID, err)
+		return generalIntent, "意图分析结果无法解析"
+	}
+
+	intent := strings.ToLower(strings.TrimSpace(out.Intent))
+	if _, ok := r.routes[intent]; !ok {
+		intent = generalIntent
+	}
+	return intent, strings.TrimSpace(out.Reason)
+}
+
+// toolsFor 取出意图允许使用的工具，配置中不存在的工具记录警告后忽略
+func (r *intentRouter) toolsFor(ctx context.Context, route config.IntentRoute) []tool.BaseTool {
+	byName := make(map[string]tool.BaseTool, len(r.allTools))
+	for _, t := range r.allTools {
+		info, err := t.Info(ctx)
+		if err != nil {
+			continue
+		}
+		byName[info.Name] = t
+	}
+
+	var routeTools []tool.BaseTool
+	for _, name := range route.Tools {
+		t, ok := byName[name]
+		if !ok {
+			logger.Warnf("🧭 Tool %s of intent %s is not available", name, route.Name)
+			continue
+		}
+		routeTools = append(routeTools, t)
+	}
+	return routeTools
+}
+
+// agentModel 从阶段配置的执行模型派生只绑定受限工具的新实例，录制和回放时同样生效
+// 执行模型不支持 WithTools 时返回错误，意图回退到通用的规划执行流程，不另外创建绕过阶段配置和回放的模型
+func (r *intentRouter) agentModel(ctx context.Context, routeTools []tool.BaseTool) (einoModel.BaseChatModel, error) {
+	toolCalling, ok := r.executeModel.(einoModel.ToolCallingChatModel)
+	if !ok {
+		return nil, fmt.Errorf("execute model %T does not support binding tools", r.executeModel)
+	}
+	infos, err := model.ToolInfos(ctx, routeTools)
+	if err != nil {
+		return nil, err
+	}
+	return toolCalling.WithTools(infos)
+}
+
+// runner 返回意图的专用子图，首次使用时构建并缓存；意图的工具都不可用等原因无法构建时返回错误
+func (r *intentRouter) runner(ctx context.Context, intent string) (compose.Runnable[*intentInput, *schema.Message], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cached, ok := r.runners[intent]; ok {
+		return cached.runnable, cached.err
+	}
+	route, ok := r.routes[intent]
+	if !ok {
+		return nil, fmt.Errorf("unknown intent %s", intent)
+	}
+	runnable, err := r.buildRunner(ctx, route)
+	r.runners[intent] = &intentRunner{runnable: runnable, err: err}
+	return runnable, err
+}
+
+// nextNode 意图分析后的去向：命中的意图能构建专用子图时交给子图处理，否则回退到通用的规划执行流程
+func (r *intentRouter) nextNode(ctx context.Context, intent string) string {
+	if r == nil || intent == "" {
+		return "planner"
+	}
+	if _, err := r.runner(ctx, intent); err != nil {
+		logger.Warnf("🧭 Intent %s of session %s cannot run its sub-graph, falling back to planner: %v", intent, r.sessionID, err)
+		return "planner"
+	}
+	return "intentAgent"
+}
+
+// buildRunner 构建意图的专用子图：agent ⇄ tools，工具调用同样受预算和循环检测约束
+func (r *intentRouter) buildRunner(ctx context.Context, route config.IntentRoute) (compose.Runnable[*intentInput, *schema.Message], error) {
+	routeTools := r.toolsFor(ctx, route)
+	if len(routeTools) == 0 {
+		return nil, fmt.Errorf("no available tools for intent %s", route.Name)
+	}
+	agentModel, err := r.agentModel(ctx, routeTools)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create model for intent %s: %w", route.Name, err)
+	}
+	tn, err := compose.NewToolNode(ctx, &compose.ToolsNodeConfig{Tools: routeTools})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tools node for intent %s: %w", route.Name, err)
+	}
+
+	maxCalls := defaultIntentToolCalls
+	if route.MaxToolCalls > 0 {
+		maxCalls = route.MaxToolCalls
+	}
+
+	g := compose.NewGraph[*intentInput, *schema.Message](compose.WithGenLocalState(func(ctx context.Context) *myState {
+		return &myState{sessionID: r.sessionID}
+	}))
+
+	var prompt string
+	_ = g.AddLambdaNode("intentStart", compose.InvokableLambda(func(ctx context.Context, in *intentInput) ([]*schema.Message, error) {
+		return nil, nil
+	}), compose.WithStatePreHandler(func(ctx context.Context, in *intentInput, state *myState) (*intentInput, error) {
+		state.budget = taskBudget{category: "intent:" + route.Name, maxCalls: maxCalls}
+		state.history = append(state.history, in.History...)
+		state.contextSummary = in.ContextSummary
+		state.summarizedLen = in.SummarizedLen
+		state.baseHistoryLen = len(state.history)
+		prompt = in.Prompt
+		return in, nil
+	}), compose.WithNodeName("intentStart"))
+
+	_ = g.AddChatModelNode("agent", agentModel, compose.WithStatePreHandler(func(ctx context.Context, input []*schema.Message, state *myState) ([]*schema.Message, error) {
+		state.history = append(state.history, messageCleaner.CleanMessages(input)...)
+		// 📏 按token预算组装上下文
+		return r.contextMgr.build(ctx, config.StageExecute, state, prompt), nil
+	}), compose.WithNodeName("intent_"+route.Name))
+
+	_ = g.AddToolsNode("tools", tn, compose.WithStatePreHandler(toolsPreHandle), compose.WithStatePostHandler(toolsPostHandle(r.progressManager)), compose.WithNodeName("tools"))
+	_ = g.AddLambdaNode("stopTools", createStopToolsLambda(r.progressManager), compose.WithNodeName("stopTools"))
+	_ = g.AddLambdaNode("loopCorrection", createLoopCorrectionLambda(r.progressManager), compose.WithNodeName("loopCorrection"))
+	_ = g.AddLambdaNode("stopReply", createIntentStopReplyLambda(), compose.WithNodeName("stopReply"))
+
+	_ = g.AddEdge(compose.START, "intentStart")
+	_ = g.AddEdge("intentStart", "agent")
+	_ = g.AddBranch("agent", compose.NewGraphBranch(routeToolCalls(r.loopDetector, r.toolBudget, compose.END),
+		map[string]bool{"tools": true, compose.END: true, "stopTools": true, "loopCorrection": true}))
+	_ = g.AddEdge("tools", "agent")
+	_ = g.AddEdge("loopCorrection", "agent")
+	_ = g.AddEdge("stopTools", "stopReply")
+	_ = g.AddEdge("stopReply", compose.END)
+
+	return g.Compile(ctx, compose.WithMaxRunSteps(100), compose.WithGraphName("intent_"+route.Name))
+}
+
+// createIntentStopReplyLambda 专用子图的回复直接返回给用户，工具调用被强制停止时把内部的执行状态换成给用户的说明
+func createIntentStopReplyLambda() *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (*schema.Message, error) {
+		var loopFailure string
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			loopFailure = state.loopFailure
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		content := "抱歉，这个请求需要的操作次数超出了单次处理的上限，已停止执行，请求没有完全完成。可以把请求拆分成更小的步骤后再试一次。"
+		if loopFailure != "" {
+			content = "抱歉，处理过程中反复进行相同的操作而没有进展，已停止执行，请求没有完成。可以补充更具体的信息后再试一次。"
+		}
+		return schema.AssistantMessage(content, nil), nil
+	})
+}
+
+// createIntentAnalysisLambda 创建意图分析节点，结果记录在状态中，消息原样传给后续节点
+func createIntentAnalysisLambda(router *intentRouter, classifier einoModel.ChatModel) *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input []*schema.Message) ([]*schema.Message, error) {
+		if router == nil {
+			return input, nil
+		}
+
+		intent, reason := router.classify(ctx, classifier, input)
+		logger.Infof("🧭 Session %s classified as intent %s: %s", router.sessionID, intent, reason)
+		router.progressManager.SendEvent("intent_classified", "", fmt.Sprintf("🧭 识别意图：%s\n\n", intent),
+			map[string]interface{}{"intent": intent, "reason": reason}, nil)
+
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			if intent != generalIntent {
+				state.intent = intent
+			}
+			return nil
+		})
+		return input, err
+	})
+}
+
+// createIntentAgentLambda 创建专用子图的执行节点，子图的最终回复作为直接回复返回给用户
+func createIntentAgentLambda(router *intentRouter) *compose.Lambda {
+	return compose.InvokableLambda(func(ctx context.Context, input []*schema.Message) (*schema.Message, error) {
+		var route config.IntentRoute
+		in := &intentInput{}
+		err := compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			route = router.routes[state.intent]
+			state.history = append(state.history, messageCleaner.CleanMessages(input)...)
+			in.History = append([]*schema.Message(nil), state.history...)
+			in.ContextSummary = state.contextSummary
+			in.SummarizedLen = state.summarizedLen
+			// 🧠 专用子图同样参考长期记忆，例如用户常用的会议室
+			in.Prompt = route.Prompt + buildMemoryPrompt(state.history)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		runner, err := router.runner(ctx, route.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Infof("🧭 Running intent sub-graph %s for session %s", route.Name, router.sessionID)
+		out, err := runner.Invoke(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("intent sub-graph %s failed: %w", route.Name, err)
+		}
+
+		err = compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+			state.history = append(state.history, out)
+			return nil
+		})
+		return out, err
+	})
+}
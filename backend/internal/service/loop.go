@@ -0,0 +1,128 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"glata-backend/internal/config"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 未配置时的循环检测参数
+const (
+	defaultLoopRepeatThreshold = 3
+	defaultLoopWindow          = 6
+	defaultLoopMaxCorrections  = 1
+)
+
+// loopDetector 检测执行模型反复发起相同或来回交替的工具调用
+type loopDetector struct {
+	enabled         bool
+	repeatThreshold int
+	window          int
+	maxCorrections  int
+}
+
+func newLoopDetector() *loopDetector {
+	d := &loopDetector{
+		repeatThreshold: defaultLoopRepeatThreshold,
+		window:          defaultLoopWindow,
+		maxCorrections:  defaultLoopMaxCorrections,
+	}
+	cfg := config.Get()
+	if cfg == nil {
+		return d
+	}
+
+	loopCfg := cfg.Agent.LoopDetection
+	d.enabled = loopCfg.Enabled
+	if loopCfg.RepeatThreshold > 1 {
+		d.repeatThreshold = loopCfg.RepeatThreshold
+	}
+	if loopCfg.Window > 0 {
+		d.window = loopCfg.Window
+	}
+	if loopCfg.MaxCorrections > 0 {
+		d.maxCorrections = loopCfg.MaxCorrections
+	}
+	return d
+}
+
+// toolCallSignature 生成工具调用的签名，参数按JSON规范化后比较，忽略键顺序、空白和大小写差异
+func toolCallSignature(call schema.ToolCall) string {
+	args := strings.TrimSpace(call.Function.Arguments)
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(args), &parsed); err == nil {
+		if normalized, err := json.Marshal(normalizeArgs(parsed)); err == nil {
+			args = string(normalized)
+		}
+	} else {
+		args = strings.ToLower(strings.Join(strings.Fields(args), " "))
+	}
+	return call.Function.Name + "(" + args + ")"
+}
+
+func normalizeArgs(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeArgs(item)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeArgs(item)
+		}
+		return val
+	case string:
+		return strings.ToLower(strings.Join(strings.Fields(val), " "))
+	default:
+		return val
+	}
+}
+
+// detect 将本次调用接在已执行的调用之后检测循环，返回循环的描述，没有循环时返回空字符串
+func (d *loopDetector) detect(history []string, calls []schema.ToolCall) string {
+	if !d.enabled || len(calls) == 0 {
+		return ""
+	}
+
+	sequence := append([]string(nil), history...)
+	for _, call := range calls {
+		sequence = append(sequence, toolCallSignature(call))
+	}
+	if len(sequence) > d.window {
+		sequence = sequence[len(sequence)-d.window:]
+	}
+
+	// 相同的调用在窗口内重复出现
+	counts := make(map[string]int)
+	for _, sig := range sequence {
+		counts[sig]++
+	}
+	for _, call := range calls {
+		sig := toolCallSignature(call)
+		if counts[sig] >= d.repeatThreshold {
+			return fmt.Sprintf("工具 %s 以相同参数被重复调用了 %d 次", call.Function.Name, counts[sig])
+		}
+	}
+
+	// 两个调用来回交替：A B A B
+	if n := len(sequence); n >= 4 {
+		a, b := sequence[n-2], sequence[n-1]
+		if a != b && sequence[n-4] == a && sequence[n-3] == b {
+			return fmt.Sprintf("工具调用在 %s 和 %s 之间来回交替", signatureName(a), signatureName(b))
+		}
+	}
+	return ""
+}
+
+// signatureName 取出签名中的工具名
+func signatureName(sig string) string {
+	if i := strings.Index(sig, "("); i >= 0 {
+		return sig[:i]
+	}
+	return sig
+}
@@ -0,0 +1,419 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/storage"
+	"glata-backend/pkg/logger"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+)
+
+// 长期记忆的默认参数
+const (
+	defaultMemoryMaxInject    = 8
+	memoryTranscriptMessages  = 20   // 提取记忆时最多参考的最近消息数
+	memoryMessageMaxRunes     = 1500 // 提取记忆时单条消息最多保留的字符数
+	memorySimilarityThreshold = 0.6  // 同类记忆的相似度超过该值时视为同一条记忆的更新
+	memoryExtractTimeout      = 2 * time.Minute
+)
+
+// ListMemories 列出长期记忆，可按类别和关键字过滤
+func (s *ChatService) ListMemories(category, query string) ([]*model.Memory, error) {
+	memories, err := s.storage.ListMemories()
+	if err != nil {
+		return nil, err
+	}
+	if category == "" && strings.TrimSpace(query) == "" {
+		return memories, nil
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	filtered := make([]*model.Memory, 0, len(memories))
+	for _, m := range memories {
+		if category != "" && string(m.Category) != category {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(memoryText(m)), query) {
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	return filtered, nil
+}
+
+// UpdateMemory 编辑长期记忆，未填写的字段保持不变
+func (s *ChatService) UpdateMemory(memoryID string, req *model.UpdateMemoryRequest) (*model.Memory, error) {
+	memory, err := s.storage.GetMemory(memoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Category != "" {
+		if !req.Category.IsValid() {
+			return nil, fmt.Errorf("%w: unknown memory category %q", storage.ErrInvalidData, req.Category)
+		}
+		memory.Category = req.Category
+	}
+	if content := strings.TrimSpace(req.Content); content != "" {
+		memory.Content = content
+	}
+	if req.Keywords != nil {
+		memory.Keywords = cleanKeywords(req.Keywords)
+	}
+	memory.UpdatedAt = time.Now()
+
+	if err := s.storage.SaveMemory(memory); err != nil {
+		return nil, err
+	}
+	return memory, nil
+}
+
+// DeleteMemory 删除长期记忆
+func (s *ChatService) DeleteMemory(memoryID string) error {
+	return s.storage.DeleteMemory(memoryID)
+}
+
+// memoryEnabled 是否启用长期记忆
+func memoryEnabled() bool {
+	cfg := config.Get()
+	return cfg != nil && cfg.Agent.EnableMemory && globalStorage != nil
+}
+
+// extractedMemory 记忆提取模型输出的单条记忆
+type extractedMemory struct {
+	Category string   `json:"category"`
+	Content  string   `json:"content"`
+	Keywords []string `json:"keywords"`
+}
+
+type memoryExtractOutput struct {
+	Memories []extractedMemory `json:"memories"`
+}
+
+// extractSessionMemories 从已完成的会话中提取长期记忆并合并进记忆库
+// 在会话完成后异步调用，失败只记录日志，不影响会话本身
+func extractSessionMemories(sessionID string) {
+	// 回放模式下不访问真实的模型
+	if !memoryEnabled() || replayMode() == replayModeReplay {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), memoryExtractTimeout)
+	defer cancel()
+
+	transcript, err := buildMemoryTranscript(sessionID)
+	if err != nil {
+		logger.Errorf("Failed to build memory transcript for session %s: %v", sessionID, err)
+		return
+	}
+	if transcript == "" {
+		return
+	}
+
+	existing, err := globalStorage.ListMemories()
+	if err != nil {
+		logger.Errorf("Failed to list memories: %v", err)
+		return
+	}
+
+	var sb strings.Builder
+	if len(existing) > 0 {
+		sb.WriteString("## 已有记忆\n")
+		sb.WriteString(formatMemories(existing))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("## 对话记录\n")
+	sb.WriteString(transcript)
+
+	prompt := config.Get().Agent.MemoryExtractPrompt
+	if prompt == "" {
+		prompt = `请从对话中提取关于用户的长期事实（所在楼宇、常用会议室、持有的设备、问题的解决方法、偏好），只输出JSON：{"memories":[{"category":"profile|location|device|resolution|preference","content":"...","keywords":["..."]}]}`
+	}
+
+	resp, err := model.NewSummaryModel(ctx).Generate(ctx, []*schema.Message{
+		schema.SystemMessage(prompt),
+		schema.UserMessage(sb.String()),
+	})
+	if err != nil {
+		logger.Errorf("Failed to extract memories from session %s: %v", sessionID, err)
+		return
+	}
+
+	out, err := parseMemoryExtractOutput(removeThinkingTags(resp.Content))
+	if err != nil {
+		logger.Warnf("🧠 Failed to parse memories extracted from session %s: %v", sessionID, err)
+		return
+	}
+
+	saved := mergeMemories(existing, out.Memories, sessionID)
+	logger.Infof("🧠 Extracted %d memories from session %s (%d saved)", len(out.Memories), sessionID, saved)
+}
+
+// buildMemoryTranscript 将会话最近的消息渲染为记忆提取模型可读的文本
+func buildMemoryTranscript(sessionID string) (string, error) {
+	messages, err := globalStorage.GetMessages(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if len(messages) > memoryTranscriptMessages {
+		messages = messages[len(messages)-memoryTranscriptMessages:]
+	}
+
+	var sb strings.Builder
+	for _, msg := range messages {
+		content := strings.TrimSpace(msg.Content)
+		if content == "" {
+			content = strings.TrimSpace(msg.ProgressContent)
+		}
+		if content == "" {
+			continue
+		}
+		// 助手消息的结论通常在末尾，保留尾部内容
+		if runes := []rune(content); len(runes) > memoryMessageMaxRunes {
+			content = "..." + string(runes[len(runes)-memoryMessageMaxRunes:])
+		}
+		sb.WriteString(fmt.Sprintf("[%s] %s\n", msg.Role, content))
+	}
+	return sb.String(), nil
+}
+
+func parseMemoryExtractOutput(content string) (*memoryExtractOutput, error) {
+	raw, err := extractJSONObject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var out memoryExtractOutput
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse memory json: %w", err)
+	}
+	return &out, nil
+}
+
+// mergeMemories 将提取的记忆合并进记忆库：内容相同的刷新时间，同类且相似的视为更新，其余作为新记忆保存
+func mergeMemories(existing []*model.Memory, extracted []extractedMemory, sessionID string) int {
+	saved := 0
+	now := time.Now()
+	for _, item := range extracted {
+		category := model.MemoryCategory(strings.ToLower(strings.TrimSpace(item.Category)))
+		content := strings.TrimSpace(item.Content)
+		if content == "" || !category.IsValid() {
+			continue
+		}
+
+		target := findSimilarMemory(existing, category, content)
+		if target == nil {
+			target = &model.Memory{
+				ID:        uuid.New().String(),
+				Category:  category,
+				CreatedAt: now,
+			}
+			existing = append(existing, target)
+		}
+		target.Content = content
+		if keywords := cleanKeywords(item.Keywords); len(keywords) > 0 {
+			target.Keywords = keywords
+		}
+		target.SourceSessionID = sessionID
+		target.UpdatedAt = now
+
+		if err := globalStorage.SaveMemory(target); err != nil {
+			logger.Errorf("Failed to save memory %s: %v", target.ID, err)
+			continue
+		}
+		saved++
+	}
+	return saved
+}
+
+// findSimilarMemory 查找内容相同或同类且相似的已有记忆
+func findSimilarMemory(memories []*model.Memory, category model.MemoryCategory, content string) *model.Memory {
+	normalized := normalizeMemoryContent(content)
+	terms := memoryTerms(content)
+	var best *model.Memory
+	bestScore := 0.0
+	for _, m := range memories {
+		if normalizeMemoryContent(m.Content) == normalized {
+			return m
+		}
+		if m.Category != category {
+			continue
+		}
+		if score := jaccard(terms, memoryTerms(m.Content)); score >= memorySimilarityThreshold && score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best
+}
+
+// retrieveMemories 检索与查询相关的记忆，用户的基本信息总是优先返回
+func retrieveMemories(query string, limit int) []*model.Memory {
+	memories, err := globalStorage.ListMemories()
+	if err != nil {
+		logger.Errorf("Failed to list memories: %v", err)
+		return nil
+	}
+	if limit <= 0 {
+		limit = defaultMemoryMaxInject
+	}
+
+	queryTerms := memoryTerms(query)
+	type scored struct {
+		memory *model.Memory
+		score  int
+	}
+	var candidates []scored
+	for _, m := range memories {
+		score := 0
+		text := strings.ToLower(memoryText(m))
+		for term := range queryTerms {
+			if strings.Contains(text, term) {
+				score++
+			}
+		}
+		for _, kw := range m.Keywords {
+			if kw != "" && strings.Contains(strings.ToLower(query), strings.ToLower(kw)) {
+				score += 2
+			}
+		}
+		if m.Category == model.MemoryProfile {
+			score += 1
+		}
+		if score > 0 {
+			candidates = append(candidates, scored{memory: m, score: score})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].memory.UpdatedAt.After(candidates[j].memory.UpdatedAt)
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	result := make([]*model.Memory, len(candidates))
+	for i, c := range candidates {
+		result[i] = c.memory
+	}
+	return result
+}
+
+// buildMemoryPrompt 检索与用户问题相关的记忆，拼接为注入规划上下文的内容
+func buildMemoryPrompt(history []*schema.Message) string {
+	memories := relevantMemories(history)
+	if len(memories) == 0 {
+		return ""
+	}
+	logger.Infof("🧠 Injecting %d memories into planner context", len(memories))
+	return "\n\n**关于用户的长期记忆**（来自以往会话，仅供参考，可能已过时）：\n" + formatMemories(memories)
+}
+
+// relevantMemories 检索与最近一条用户消息相关的记忆，未启用长期记忆时返回空
+func relevantMemories(history []*schema.Message) []*model.Memory {
+	if !memoryEnabled() {
+		return nil
+	}
+
+	var query string
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == schema.User {
+			query = history[i].Content
+			break
+		}
+	}
+	return retrieveMemories(query, config.Get().Agent.MemoryMaxInject)
+}
+
+func formatMemories(memories []*model.Memory) string {
+	var sb strings.Builder
+	for _, m := range memories {
+		sb.WriteString(fmt.Sprintf("- [%s] %s\n", m.Category, m.Content))
+	}
+	return sb.String()
+}
+
+func memoryText(m *model.Memory) string {
+	return m.Content + " " + strings.Join(m.Keywords, " ")
+}
+
+func cleanKeywords(keywords []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(keywords))
+	for _, kw := range keywords {
+		kw = strings.TrimSpace(kw)
+		if kw == "" || seen[strings.ToLower(kw)] {
+			continue
+		}
+		seen[strings.ToLower(kw)] = true
+		result = append(result, kw)
+	}
+	return result
+}
+
+// normalizeMemoryContent 去掉空白和标点并转为小写，用于判断内容是否相同
+func normalizeMemoryContent(content string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(content) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// memoryTerms 将文本切分为检索用的词项：英文和数字按单词切分，中文按相邻两字切分
+func memoryTerms(content string) map[string]bool {
+	terms := make(map[string]bool)
+	var word []rune
+	var prevHan rune
+	flush := func() {
+		if len(word) > 1 {
+			terms[string(word)] = true
+		}
+		word = word[:0]
+	}
+	for _, r := range strings.ToLower(content) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			if prevHan != 0 {
+				terms[string([]rune{prevHan, r})] = true
+			}
+			prevHan = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+		prevHan = 0
+	}
+	flush()
+	return terms
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	inter := 0
+	for term := range a {
+		if b[term] {
+			inter++
+		}
+	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
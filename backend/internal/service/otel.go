@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/telemetry"
+	"glata-backend/internal/tools"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 未配置时文本属性保存的最大字符数
+const defaultOtelAttributeChars = 2000
+
+// span 属性名，模型和工具相关的属性沿用 OpenTelemetry 的 GenAI 语义约定
+const (
+	otelAttrSessionID     = "session.id"
+	otelAttrRunID         = "run.id"
+	otelAttrRunQuery      = "run.query"
+	otelAttrRunResume     = "run.resume_count"
+	otelAttrRunStatus     = "run.status"
+	otelAttrComponent     = "eino.component"
+	otelAttrNodeType      = "eino.type"
+	otelAttrSpanType      = "eino.span_type"
+	otelAttrStreaming     = "eino.streaming"
+	otelAttrModel         = "gen_ai.request.model"
+	otelAttrInputTokens   = "gen_ai.usage.input_tokens"
+	otelAttrOutputTokens  = "gen_ai.usage.output_tokens"
+	otelAttrTotalTokens   = "gen_ai.usage.total_tokens"
+	otelAttrToolName      = "gen_ai.tool.name"
+	otelAttrToolArguments = "gen_ai.tool.arguments"
+	otelAttrToolStatus    = "gen_ai.tool.status"
+)
+
+// otelSpanKey 在 context 中标记由本处理器创建的 span，避免结束不属于当前组件的 span
+type otelSpanKey struct{}
+
+// runTelemetry 通过 eino 回调为一次运行的图节点、模型调用和工具调用创建 OpenTelemetry span
+// 运行的根 span 挂在发起运行的 HTTP 请求 span 下，所有 span 都带有会话ID和运行ID
+type runTelemetry struct {
+	tracer   trace.Tracer
+	parent   trace.SpanContext
+	attrs    []attribute.KeyValue
+	maxChars int
+
+	root    trace.Span
+	streams sync.WaitGroup
+
+	mu    sync.Mutex
+	usage model.TokenUsage
+}
+
+// newRunTelemetry 创建运行的链路追踪，未启用时返回 nil；ctx 中的 span 作为运行的父 span
+func newRunTelemetry(ctx context.Context, run *model.RunRecord) *runTelemetry {
+	cfg := config.Get()
+	if cfg == nil || !cfg.Telemetry.Enabled {
+		return nil
+	}
+
+	maxChars := defaultOtelAttributeChars
+	if cfg.Telemetry.MaxAttributeChars > 0 {
+		maxChars = cfg.Telemetry.MaxAttributeChars
+	}
+
+	return &runTelemetry{
+		tracer: telemetry.Tracer(),
+		parent: trace.SpanContextFromContext(ctx),
+		attrs: []attribute.KeyValue{
+			attribute.String(otelAttrSessionID, run.SessionID),
+			attribute.String(otelAttrRunID, run.ID),
+		},
+		maxChars: maxChars,
+	}
+}
+
+// start 创建运行的根 span，返回的 context 用于执行图
+func (t *runTelemetry) start(ctx context.Context, run *model.RunRecord) context.Context {
+	if t == nil {
+		return ctx
+	}
+	if t.parent.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, t.parent)
+	}
+	attrs := append([]attribute.KeyValue{
+		attribute.String(otelAttrRunQuery, truncateRunes(run.Query, t.maxChars)),
+		attribute.Int(otelAttrRunResume, run.ResumeCount),
+	}, t.attrs...)
+	ctx, t.root = t.tracer.Start(ctx, "agent.run", trace.WithAttributes(attrs...))
+	return ctx
+}
+
+// graphOptions 返回注册链路追踪回调的图执行选项
+func (t *runTelemetry) graphOptions() []compose.Option {
+	if t == nil {
+		return nil
+	}
+	handler := callbacks.NewHandlerBuilder().
+		OnStartFn(t.onStart).
+		OnEndFn(t.onEnd).
+		OnErrorFn(t.onError).
+		OnStartWithStreamInputFn(t.onStartWithStreamInput).
+		OnEndWithStreamOutputFn(t.onEndWithStreamOutput).
+		Build()
+	return []compose.Option{compose.WithCallbacks(handler)}
+}
+
+// finish 等待流式输出的 span 结束后，记录运行的最终状态和token用量并结束根 span
+func (t *runTelemetry) finish(status model.RunStatus, errMsg string) {
+	if t == nil || t.root == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(traceStreamWait):
+	}
+
+	t.mu.Lock()
+	usage := t.usage
+	t.mu.Unlock()
+
+	t.root.SetAttributes(
+		attribute.String(otelAttrRunStatus, string(status)),
+		attribute.Int(otelAttrInputTokens, usage.PromptTokens),
+		attribute.Int(otelAttrOutputTokens, usage.CompletionTokens),
+		attribute.Int(otelAttrTotalTokens, usage.TotalTokens),
+	)
+	if errMsg != "" {
+		t.root.SetStatus(codes.Error, errMsg)
+	}
+	t.root.End()
+}
+
+func (t *runTelemetry) onStart(ctx context.Context, info *callbacks.RunInfo, input callbacks.CallbackInput) context.Context {
+	ctx, span := t.startSpan(ctx, info, false)
+
+	switch spanType(info) {
+	case model.SpanChatModel:
+		if in := einoModel.ConvCallbackInput(input); in != nil && in.Config != nil && in.Config.Model != "" {
+			span.SetAttributes(attribute.String(otelAttrModel, in.Config.Model))
+		}
+	case model.SpanTool:
+		if in := tool.ConvCallbackInput(input); in != nil {
+			span.SetAttributes(attribute.String(otelAttrToolArguments, truncateRunes(in.ArgumentsInJSON, t.maxChars)))
+		}
+	}
+	return ctx
+}
+
+func (t *runTelemetry) onEnd(ctx context.Context, info *callbacks.RunInfo, output callbacks.CallbackOutput) context.Context {
+	span, ok := ctx.Value(otelSpanKey{}).(trace.Span)
+	if !ok {
+		return ctx
+	}
+
+	switch spanType(info) {
+	case model.SpanChatModel:
+		if out := einoModel.ConvCallbackOutput(output); out != nil {
+			modelName := ""
+			if out.Config != nil {
+				modelName = out.Config.Model
+			}
+			t.recordUsage(span, modelName, convertTokenUsage(out.TokenUsage))
+		}
+	case model.SpanTool:
+		if out := tool.ConvCallbackOutput(output); out != nil {
+			t.recordToolResult(span, out.Response)
+		}
+	}
+	span.End()
+	return ctx
+}
+
+func (t *runTelemetry) onError(ctx context.Context, info *callbacks.RunInfo, err error) context.Context {
+	span, ok := ctx.Value(otelSpanKey{}).(trace.Span)
+	if !ok {
+		return ctx
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+	return ctx
+}
+
+// onStartWithStreamInput 流式输入只用于创建 span，内容不记录
+func (t *runTelemetry) onStartWithStreamInput(ctx context.Context, info *callbacks.RunInfo, input *schema.StreamReader[callbacks.CallbackInput]) context.Context {
+	input.Close()
+	ctx, _ = t.startSpan(ctx, info, true)
+	return ctx
+}
+
+// onEndWithStreamOutput 流式输出在被消费完后才算结束，模型调用的token用量在流结束时记录
+func (t *runTelemetry) onEndWithStreamOutput(ctx context.Context, info *callbacks.RunInfo, output *schema.StreamReader[callbacks.CallbackOutput]) context.Context {
+	span, ok := ctx.Value(otelSpanKey{}).(trace.Span)
+	if !ok {
+		output.Close()
+		return ctx
+	}
+	span.SetAttributes(attribute.Bool(otelAttrStreaming, true))
+
+	t.streams.Add(1)
+	go func() {
+		defer t.streams.Done()
+		defer output.Close()
+		defer span.End()
+
+		var modelName string
+		var usage *model.TokenUsage
+		for {
+			chunk, err := output.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				break
+			}
+			if info == nil || info.Component != components.ComponentOfChatModel {
+				continue
+			}
+			out := einoModel.ConvCallbackOutput(chunk)
+			if out == nil {
+				continue
+			}
+			if out.Config != nil && out.Config.Model != "" {
+				modelName = out.Config.Model
+			}
+			if out.TokenUsage != nil {
+				usage = convertTokenUsage(out.TokenUsage)
+			}
+		}
+		t.recordUsage(span, modelName, usage)
+	}()
+
+	return ctx
+}
+
+// startSpan 创建组件的 span，父 span 取自 context
+func (t *runTelemetry) startSpan(ctx context.Context, info *callbacks.RunInfo, streaming bool) (context.Context, trace.Span) {
+	typ := spanType(info)
+	name := typ
+	attrs := append([]attribute.KeyValue{
+		attribute.String(otelAttrSpanType, typ),
+		attribute.Bool(otelAttrStreaming, streaming),
+	}, t.attrs...)
+	if info != nil {
+		attrs = append(attrs,
+			attribute.String(otelAttrComponent, string(info.Component)),
+			attribute.String(otelAttrNodeType, info.Type))
+		switch {
+		case info.Name != "":
+			name = typ + " " + info.Name
+		case info.Type != "":
+			name = typ + " " + info.Type
+		}
+		if typ == model.SpanTool {
+			attrs = append(attrs, attribute.String(otelAttrToolName, info.Name))
+		}
+	}
+
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, otelSpanKey{}, span), span
+}
+
+// recordUsage 记录模型调用的模型名和token用量，并累加到整个运行
+func (t *runTelemetry) recordUsage(span trace.Span, modelName string, usage *model.TokenUsage) {
+	if modelName != "" {
+		span.SetAttributes(attribute.String(otelAttrModel, modelName))
+	}
+	if usage == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.Int(otelAttrInputTokens, usage.PromptTokens),
+		attribute.Int(otelAttrOutputTokens, usage.CompletionTokens),
+		attribute.Int(otelAttrTotalTokens, usage.TotalTokens),
+	)
+
+	t.mu.Lock()
+	t.usage.Add(*usage)
+	t.mu.Unlock()
+}
+
+// recordToolResult 工具返回失败的 ToolResult 时将 span 标记为错误
+func (t *runTelemetry) recordToolResult(span trace.Span, response string) {
+	result, ok := tools.ParseToolResult(response)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.String(otelAttrToolStatus, string(result.Status)))
+	if result.IsError() {
+		span.SetStatus(codes.Error, result.ErrorCode+": "+truncateRunes(result.ErrorMessage, t.maxChars))
+	}
+}
@@ -0,0 +1,453 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"glata-backend/internal/model"
+	"glata-backend/internal/storage"
+	"glata-backend/pkg/logger"
+)
+
+var (
+	ErrPlanNotFound        = errors.New("plan not found")
+	ErrPlanVersionConflict = errors.New("plan version conflict")
+	ErrInvalidPlanEdit     = errors.New("invalid plan edit")
+)
+
+// 计划版本的来源：写入该版本的图节点，或在图之外修改计划的操作
+const (
+	planSourceWritePlan    = "writePlan"
+	planSourceScan         = "scanTodoList"
+	planSourceExecuteBatch = "executeBatch"
+	planSourceUpdate       = "writeUpdatedPlan"
+	planSourceReplan       = "writeReplan"
+	planSourceResume       = "resume"
+	planSourceAbort        = "abort"
+	planSourceCancel       = "cancel"
+	planSourceUser         = "user"
+)
+
+// 用户编辑计划时未填写原因使用的修订原因
+const defaultPlanEditReason = "用户编辑了计划"
+
+// planOutput 规划模型的结构化输出
+type planOutput struct {
+	Tasks []struct {
+		ID        string   `json:"id"`
+		Title     string   `json:"title"`
+		DependsOn []string `json:"depends_on"`
+	} `json:"tasks"`
+}
+
+// taskUpdateOutput 更新模型的结构化输出
+type taskUpdateOutput struct {
+	TaskID string           `json:"task_id"`
+	Status model.TaskStatus `json:"status"`
+	Result string           `json:"result"`
+}
+
+// extractJSONObject 从模型输出中提取JSON对象，兼容```json代码块和前后的多余文本
+func extractJSONObject(content string) (string, error) {
+	content = cleanModeIdentifiers(removeThinkingTags(content))
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end <= start {
+		return "", fmt.Errorf("no json object found in model output")
+	}
+	return content[start : end+1], nil
+}
+
+// parsePlanOutput 将规划模型输出解析为结构化计划
+func parsePlanOutput(sessionID, content string) (*model.Plan, error) {
+	raw, err := extractJSONObject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var out planOutput
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse plan json: %w", err)
+	}
+
+	plan := &model.Plan{
+		SessionID: sessionID,
+		CreatedAt: time.Now(),
+	}
+
+	// 🎯 由代码分配稳定且唯一的任务ID，模型给出的编号只作参考
+	seen := make(map[string]bool)
+	idMapping := make(map[string]string) // 模型给出的ID -> 实际分配的ID
+	var rawDeps [][]string
+	for i, t := range out.Tasks {
+		title := strings.TrimSpace(t.Title)
+		if title == "" {
+			continue
+		}
+		rawID := strings.TrimSpace(t.ID)
+		id := rawID
+		if id == "" || seen[id] {
+			id = strconv.Itoa(i + 1)
+		}
+		for n := 2; seen[id]; n++ {
+			id = fmt.Sprintf("%d-%d", i+1, n)
+		}
+		seen[id] = true
+		if _, ok := idMapping[rawID]; rawID != "" && !ok {
+			idMapping[rawID] = id
+		}
+
+		plan.Tasks = append(plan.Tasks, &model.Task{
+			ID:     id,
+			Title:  title,
+			Status: model.TaskPending,
+		})
+		rawDeps = append(rawDeps, t.DependsOn)
+	}
+
+	// 依赖只能指向前面出现过的任务，未知ID和自依赖直接丢弃，避免出现永远无法满足的依赖
+	position := make(map[string]int, len(plan.Tasks))
+	for i, task := range plan.Tasks {
+		position[task.ID] = i
+	}
+	for i, task := range plan.Tasks {
+		added := make(map[string]bool)
+		for _, rawDep := range rawDeps[i] {
+			dep, ok := idMapping[strings.TrimSpace(rawDep)]
+			if !ok || position[dep] >= i || added[dep] {
+				logger.Warnf("Dropping invalid dependency %q of task %s", rawDep, task.ID)
+				continue
+			}
+			added[dep] = true
+			task.DependsOn = append(task.DependsOn, dep)
+		}
+	}
+
+	if len(plan.Tasks) == 0 {
+		return nil, fmt.Errorf("plan contains no tasks")
+	}
+	return plan, nil
+}
+
+// replanOutput 重新规划模型的结构化输出，tasks 为调整后所有需要继续执行的任务
+type replanOutput struct {
+	Reason string `json:"reason"`
+	Tasks  []struct {
+		ID        string   `json:"id"`
+		Title     string   `json:"title"`
+		DependsOn []string `json:"depends_on"`
+	} `json:"tasks"`
+}
+
+// parseReplanOutput 解析重新规划模型的输出
+func parseReplanOutput(content string) (*replanOutput, error) {
+	raw, err := extractJSONObject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var out replanOutput
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse replan json: %w", err)
+	}
+	out.Reason = strings.TrimSpace(out.Reason)
+	if out.Reason == "" {
+		out.Reason = "根据执行情况调整剩余任务"
+	}
+	return &out, nil
+}
+
+// applyReplan 用重新规划的结果替换计划中剩余的任务
+// 已完成的任务保持不变；沿用原ID的未完成任务重置为待执行；未出现在新计划中的剩余任务标记为跳过；新任务追加到末尾
+// 依赖只能指向已完成的任务或新计划中排在前面的任务，保证不会出现环
+func applyReplan(plan *model.Plan, out *replanOutput) {
+	kept := make(map[string]bool)
+	for i, t := range out.Tasks {
+		title := strings.TrimSpace(t.Title)
+		if title == "" {
+			continue
+		}
+
+		id := strings.TrimSpace(t.ID)
+		task := plan.Task(id)
+		switch {
+		case task != nil && task.Status == model.TaskDone:
+			logger.Warnf("Replan tried to modify completed task %s, ignoring", id)
+			continue
+		case task != nil && kept[id]:
+			task = nil // 重复的ID按新任务处理
+			fallthrough
+		case task == nil:
+			if id == "" || plan.Task(id) != nil {
+				id = fmt.Sprintf("r%d-%d", plan.Version, i+1)
+			}
+			for n := 2; plan.Task(id) != nil; n++ {
+				id = fmt.Sprintf("r%d-%d-%d", plan.Version, i+1, n)
+			}
+			task = &model.Task{ID: id}
+			plan.Tasks = append(plan.Tasks, task)
+		}
+
+		task.Title = title
+		task.Status = model.TaskPending
+		task.Attempts = 0
+		task.Result = ""
+		task.DependsOn = nil
+		for _, rawDep := range t.DependsOn {
+			dep := strings.TrimSpace(rawDep)
+			depTask := plan.Task(dep)
+			if depTask == nil || dep == task.ID || (!kept[dep] && depTask.Status != model.TaskDone) {
+				logger.Warnf("Dropping invalid dependency %q of replanned task %s", rawDep, task.ID)
+				continue
+			}
+			task.DependsOn = append(task.DependsOn, dep)
+		}
+		kept[task.ID] = true
+	}
+
+	for _, t := range plan.Tasks {
+		if !kept[t.ID] && (t.Status == model.TaskPending || t.Status == model.TaskRunning) {
+			t.Status = model.TaskSkipped
+			t.Result = "重新规划后移除"
+		}
+	}
+	plan.Reason = out.Reason
+}
+
+// planTaskEditable 待执行和已跳过的任务可以被用户修改或移除，执行中和已结束的任务只能调整顺序
+func planTaskEditable(task *model.Task) bool {
+	return task.Status == model.TaskPending || task.Status == model.TaskSkipped
+}
+
+// applyPlanEdit 按用户编辑后的任务列表调整计划，列表顺序即新的执行顺序，未出现在列表中的任务被移除
+// 可编辑的任务可以改写标题和依赖，在待执行和已跳过之间切换；id 为空或不存在的任务作为新的待执行任务添加
+// 依赖只能指向列表中排在前面的任务，保证不会出现环；depends_on 不填时保留原来的依赖
+func applyPlanEdit(plan *model.Plan, edits []model.PlanTaskEdit) error {
+	if len(edits) == 0 {
+		return fmt.Errorf("%w: plan must contain at least one task", ErrInvalidPlanEdit)
+	}
+
+	tasks := make([]*model.Task, 0, len(edits))
+	position := make(map[string]int, len(edits))
+	for i, edit := range edits {
+		id := strings.TrimSpace(edit.ID)
+		title := strings.TrimSpace(edit.Title)
+		task := plan.Task(id)
+		if _, dup := position[id]; task != nil && dup {
+			return fmt.Errorf("%w: task %s appears more than once", ErrInvalidPlanEdit, id)
+		}
+
+		switch {
+		case task == nil:
+			if title == "" {
+				return fmt.Errorf("%w: new task at position %d has no title", ErrInvalidPlanEdit, i+1)
+			}
+			id = fmt.Sprintf("u%d-%d", plan.Version, i+1)
+			for n := 2; plan.Task(id) != nil; n++ {
+				id = fmt.Sprintf("u%d-%d-%d", plan.Version, i+1, n)
+			}
+			task = &model.Task{ID: id, Status: model.TaskPending}
+		case !planTaskEditable(task):
+			if (title != "" && title != task.Title) || (edit.Status != "" && edit.Status != task.Status) ||
+				(edit.DependsOn != nil && strings.Join(edit.DependsOn, ",") != strings.Join(task.DependsOn, ",")) {
+				return fmt.Errorf("%w: task %s is %s and can only be reordered", ErrInvalidPlanEdit, task.ID, task.Status)
+			}
+			position[task.ID] = i
+			tasks = append(tasks, task)
+			continue
+		}
+
+		if title != "" {
+			task.Title = title
+		}
+		switch edit.Status {
+		case "", task.Status:
+		case model.TaskPending:
+			task.Status = model.TaskPending
+			task.Result = ""
+		case model.TaskSkipped:
+			task.Status = model.TaskSkipped
+			task.Result = "用户跳过"
+		default:
+			return fmt.Errorf("%w: task %s can only be set to pending or skipped", ErrInvalidPlanEdit, task.ID)
+		}
+
+		deps := task.DependsOn
+		if edit.DependsOn != nil {
+			deps = edit.DependsOn
+		}
+		task.DependsOn = nil
+		for _, rawDep := range deps {
+			dep := strings.TrimSpace(rawDep)
+			if _, ok := position[dep]; !ok {
+				return fmt.Errorf("%w: task %s depends on %q which is not listed before it", ErrInvalidPlanEdit, task.ID, rawDep)
+			}
+			task.DependsOn = append(task.DependsOn, dep)
+		}
+
+		position[task.ID] = i
+		tasks = append(tasks, task)
+	}
+
+	for _, t := range plan.Tasks {
+		if _, kept := position[t.ID]; !kept && !planTaskEditable(t) {
+			return fmt.Errorf("%w: task %s is %s and cannot be removed", ErrInvalidPlanEdit, t.ID, t.Status)
+		}
+	}
+	plan.Tasks = tasks
+	return nil
+}
+
+// parseTaskUpdate 解析更新模型输出的任务状态
+func parseTaskUpdate(content string) (*taskUpdateOutput, error) {
+	raw, err := extractJSONObject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var out taskUpdateOutput
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse task update json: %w", err)
+	}
+	if out.Status != model.TaskDone && out.Status != model.TaskFailed {
+		return nil, fmt.Errorf("invalid task status in update: %q", out.Status)
+	}
+	return &out, nil
+}
+
+// planLocks 每个会话一把锁，保证并行任务对计划的读-改-写不会相互覆盖
+var planLocks sync.Map
+
+func planLock(sessionID string) *sync.Mutex {
+	lock, _ := planLocks.LoadOrStore(sessionID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// errPlanUnchanged 由 updatePlan 的回调返回，表示计划没有变化，不需要写入新版本
+var errPlanUnchanged = errors.New("plan unchanged")
+
+// updatePlan 在会话锁内读取最新计划，修改后作为 source 写入的新版本保存，返回写入后的计划副本
+func updatePlan(sessionID, source string, fn func(plan *model.Plan) error) (*model.Plan, error) {
+	lock := planLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	plan, err := readLatestPlan(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	// 修订原因和来源只属于写入它的那个版本
+	plan.Reason = ""
+	plan.Source = source
+	if err := fn(plan); err != nil {
+		if errors.Is(err, errPlanUnchanged) {
+			return plan.Clone(), nil
+		}
+		return nil, err
+	}
+	if _, err := savePlan(sessionID, plan); err != nil {
+		return nil, err
+	}
+	return plan.Clone(), nil
+}
+
+// writeNewPlan 在会话锁内写入新生成的计划，避免夹在其他节点对计划的读-改-写之间
+func writeNewPlan(sessionID, source string, plan *model.Plan) (int, error) {
+	lock := planLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	plan.Source = source
+	return savePlan(sessionID, plan)
+}
+
+// snapshotPlan 在会话锁内读取最新计划，避免读到并行任务写了一半的版本
+func snapshotPlan(sessionID string) (*model.Plan, error) {
+	lock := planLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+	return readLatestPlan(sessionID)
+}
+
+// GetSessionPlan 读取会话最新版本的计划，会话没有生成计划（如直接回复）时返回错误
+func GetSessionPlan(sessionID string) (*model.Plan, error) {
+	return snapshotPlan(sessionID)
+}
+
+// EditSessionPlan 用户编辑计划，基于的版本不是最新版本时返回 ErrPlanVersionConflict
+// 编辑写入为新版本，正在执行的运行在下一次扫描计划时按新版本领取任务，并收到 plan_updated 事件
+func EditSessionPlan(sessionID string, req *model.UpdatePlanRequest) (*model.Plan, error) {
+	plan, err := updatePlan(sessionID, planSourceUser, func(plan *model.Plan) error {
+		if plan.Version != req.BaseVersion {
+			return fmt.Errorf("%w: based on v%d but latest is v%d", ErrPlanVersionConflict, req.BaseVersion, plan.Version)
+		}
+		if err := applyPlanEdit(plan, req.Tasks); err != nil {
+			return err
+		}
+		plan.Reason = strings.TrimSpace(req.Reason)
+		if plan.Reason == "" {
+			plan.Reason = defaultPlanEditReason
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("✏️ Plan of session %s edited by user, now v%d with %d tasks", sessionID, plan.Version, len(plan.Tasks))
+	markdown := plan.Markdown()
+	activeRuns.notify(sessionID, "plan_updated", "#### ✏️ 计划已更新: \n", markdown+"\n\n",
+		map[string]interface{}{"plan": plan, "version": plan.Version})
+	return plan, nil
+}
+
+// savePlan 将计划作为新版本保存到存储中，返回写入的版本号
+func savePlan(sessionID string, plan *model.Plan) (int, error) {
+	if plan == nil || len(plan.Tasks) == 0 {
+		logger.Warn("Empty plan, skipping write")
+		return 0, nil
+	}
+	if globalStorage == nil {
+		return 0, fmt.Errorf("storage is not initialized")
+	}
+
+	plan.SessionID = sessionID
+	version, err := globalStorage.SavePlan(plan)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save plan: %w", err)
+	}
+
+	counts := plan.CountByStatus()
+	logger.Infof("Successfully wrote plan version v%d for session %s (%d tasks: %d done, %d failed, %d pending)",
+		version, sessionID, len(plan.Tasks), counts[model.TaskDone], counts[model.TaskFailed], counts[model.TaskPending])
+	return version, nil
+}
+
+// readLatestPlan 读取最新版本的结构化计划
+func readLatestPlan(sessionID string) (*model.Plan, error) {
+	if globalStorage == nil {
+		return nil, fmt.Errorf("%w: storage is not initialized", ErrPlanNotFound)
+	}
+	plan, err := globalStorage.GetLatestPlan(sessionID)
+	if errors.Is(err, storage.ErrPlanNotFound) {
+		return nil, fmt.Errorf("%w: no todo list found for session %s", ErrPlanNotFound, sessionID)
+	}
+	return plan, err
+}
+
+// readPlanVersions 读取计划的全部版本，按版本号升序排列
+func readPlanVersions(sessionID string) ([]*model.Plan, error) {
+	if globalStorage == nil {
+		return nil, fmt.Errorf("%w: storage is not initialized", ErrPlanNotFound)
+	}
+	versions, err := globalStorage.ListPlanVersions(sessionID)
+	if errors.Is(err, storage.ErrPlanNotFound) {
+		return nil, fmt.Errorf("%w: no todo list found for session %s", ErrPlanNotFound, sessionID)
+	}
+	return versions, err
+}
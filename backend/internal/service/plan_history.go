@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"glata-backend/internal/model"
+)
+
+var (
+	ErrPlanVersionNotFound = errors.New("plan version not found")
+	ErrInvalidPlanRange    = errors.New("invalid plan version range")
+)
+
+// ListPlanVersions 列出会话计划的全部版本概要，按版本号升序排列
+func ListPlanVersions(sessionID string) ([]model.PlanVersionSummary, error) {
+	versions, err := snapshotPlanVersions(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]model.PlanVersionSummary, len(versions))
+	for i, plan := range versions {
+		summaries[i] = model.PlanVersionSummary{
+			Version:   plan.Version,
+			CreatedAt: plan.CreatedAt,
+			Source:    plan.Source,
+			Reason:    plan.Reason,
+			TaskCount: len(plan.Tasks),
+			Counts:    plan.CountByStatus(),
+		}
+	}
+	return summaries, nil
+}
+
+// GetPlanVersion 读取计划的指定版本
+func GetPlanVersion(sessionID string, version int) (*model.Plan, error) {
+	versions, err := snapshotPlanVersions(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, plan := range versions {
+		if plan.Version == version {
+			return plan, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: v%d", ErrPlanVersionNotFound, version)
+}
+
+// DiffPlanVersions 比较计划的两个版本，from 为 0 时取 to 的前一个版本，to 为 0 时取最新版本
+// 除了两个版本之间的差异，还会按顺序列出期间每个任务的每一次状态变化以及引起变化的节点
+func DiffPlanVersions(sessionID string, from, to int) (*model.PlanDiff, error) {
+	versions, err := snapshotPlanVersions(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if to == 0 {
+		to = versions[len(versions)-1].Version
+	}
+	if from == 0 {
+		from = to - 1
+		if from < versions[0].Version {
+			from = versions[0].Version
+		}
+	}
+	if from > to {
+		return nil, fmt.Errorf("%w: from v%d is after to v%d", ErrInvalidPlanRange, from, to)
+	}
+
+	// 区间内的版本，首尾必须存在，中间缺失的版本（如损坏）直接跳过
+	var window []*model.Plan
+	for _, plan := range versions {
+		if plan.Version >= from && plan.Version <= to {
+			window = append(window, plan)
+		}
+	}
+	if len(window) == 0 || window[0].Version != from {
+		return nil, fmt.Errorf("%w: v%d", ErrPlanVersionNotFound, from)
+	}
+	if window[len(window)-1].Version != to {
+		return nil, fmt.Errorf("%w: v%d", ErrPlanVersionNotFound, to)
+	}
+
+	fromPlan, toPlan := window[0], window[len(window)-1]
+	transitions := statusTransitions(window)
+
+	diff := &model.PlanDiff{SessionID: sessionID, From: from, To: to}
+	for _, task := range toPlan.Tasks {
+		d := model.TaskDiff{TaskID: task.ID, Title: task.Title, ToStatus: task.Status, Transitions: transitions[task.ID]}
+		old := fromPlan.Task(task.ID)
+		if old == nil {
+			d.Change = model.TaskAdded
+			diff.Tasks = append(diff.Tasks, d)
+			continue
+		}
+
+		d.FromStatus = old.Status
+		d.Change = model.TaskUnchanged
+		if old.Title != task.Title {
+			d.FromTitle = old.Title
+			d.Change = model.TaskModified
+		}
+		if strings.Join(old.DependsOn, ",") != strings.Join(task.DependsOn, ",") {
+			d.FromDepends, d.ToDepends = old.DependsOn, task.DependsOn
+			d.Change = model.TaskModified
+		}
+		if old.Status != task.Status || old.Result != task.Result || old.Attempts != task.Attempts {
+			d.Change = model.TaskModified
+		}
+		diff.Tasks = append(diff.Tasks, d)
+	}
+	for _, old := range fromPlan.Tasks {
+		if toPlan.Task(old.ID) == nil {
+			diff.Tasks = append(diff.Tasks, model.TaskDiff{TaskID: old.ID, Title: old.Title, Change: model.TaskRemoved,
+				FromStatus: old.Status, Transitions: transitions[old.ID]})
+		}
+	}
+	return diff, nil
+}
+
+// statusTransitions 按版本顺序找出每个任务的状态变化，第一个版本作为起点不计入
+func statusTransitions(versions []*model.Plan) map[string][]model.StatusTransition {
+	transitions := make(map[string][]model.StatusTransition)
+	prev := make(map[string]model.Task)
+	for i, plan := range versions {
+		for _, task := range plan.Tasks {
+			last, seen := prev[task.ID]
+			prev[task.ID] = *task
+			if i == 0 || (seen && last.Status == task.Status) {
+				continue
+			}
+			transitions[task.ID] = append(transitions[task.ID], model.StatusTransition{
+				Version: plan.Version,
+				Source:  plan.Source,
+				From:    last.Status,
+				To:      task.Status,
+				Result:  task.Result,
+				At:      plan.CreatedAt,
+			})
+		}
+	}
+	return transitions
+}
+
+// snapshotPlanVersions 在会话锁内读取计划的全部版本，避免读到写了一半的版本
+func snapshotPlanVersions(sessionID string) ([]*model.Plan, error) {
+	lock := planLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+	return readPlanVersions(sessionID)
+}
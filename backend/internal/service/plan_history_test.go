@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"glata-backend/internal/model"
+	"glata-backend/internal/storage"
+)
+
+// savePlanVersions 按顺序写入计划的各个版本，每个版本为 "source|id:status:依赖:标题 ..."
+func savePlanVersions(t *testing.T, sessionID string, versions ...string) {
+	t.Helper()
+	for _, v := range versions {
+		source, specs, _ := strings.Cut(v, "|")
+		plan := &model.Plan{SessionID: sessionID, Source: source}
+		for _, spec := range strings.Fields(specs) {
+			parts := strings.SplitN(spec, ":", 4)
+			task := &model.Task{ID: parts[0], Status: model.TaskStatus(parts[1]), Title: parts[3]}
+			if parts[2] != "" {
+				task.DependsOn = strings.Split(parts[2], ",")
+			}
+			plan.Tasks = append(plan.Tasks, task)
+		}
+		if _, err := globalStorage.SavePlan(plan); err != nil {
+			t.Fatalf("failed to save plan: %v", err)
+		}
+	}
+}
+
+// diffSummary 按顺序列出每个任务的 "id:变化:状态变化的版本"
+func diffSummary(diff *model.PlanDiff) string {
+	parts := make([]string, len(diff.Tasks))
+	for i, d := range diff.Tasks {
+		var versions []string
+		for _, tr := range d.Transitions {
+			versions = append(versions, fmt.Sprintf("v%d%s>%s", tr.Version, tr.From, tr.To))
+		}
+		parts[i] = d.TaskID + ":" + d.Change + ":" + strings.Join(versions, ",")
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestDiffPlanVersions(t *testing.T) {
+	InitAgentStorage(storage.NewMemoryStorage())
+	const sessionID = "diff-session"
+	savePlanVersions(t, sessionID,
+		"writePlan|1:pending::查询 2:pending:1:报修 3:pending::通知",
+		"scanTodoList|1:running::查询 2:pending:1:报修 3:pending::通知",
+		"writeUpdatedPlan|1:done::查询 2:pending:1:报修 3:pending::通知",
+		"user|1:done::查询 2:pending::电话报修 4:pending:1:确认",
+	)
+
+	tests := []struct {
+		name     string
+		from, to int
+		want     string
+		wantErr  error
+	}{
+		{
+			name: "默认比较最新版本和前一个版本",
+			want: "1:unchanged: 2:modified: 4:added:v4>pending 3:removed:",
+		},
+		{
+			name: "区间内每一次状态变化",
+			from: 1, to: 3,
+			want: "1:modified:v2pending>running,v3running>done 2:unchanged: 3:unchanged:",
+		},
+		{
+			name: "只指定 to 时与前一个版本比较",
+			to:   2,
+			want: "1:modified:v2pending>running 2:unchanged: 3:unchanged:",
+		},
+		{
+			name: "第一个版本与自身比较",
+			to:   1,
+			want: "1:unchanged: 2:unchanged: 3:unchanged:",
+		},
+		{
+			name: "跨越全部版本",
+			from: 1, to: 4,
+			want: "1:modified:v2pending>running,v3running>done 2:modified: 4:added:v4>pending 3:removed:",
+		},
+		{
+			name: "from 在 to 之后",
+			from: 3, to: 1,
+			wantErr: ErrInvalidPlanRange,
+		},
+		{
+			name: "版本不存在",
+			from: 1, to: 9,
+			wantErr: ErrPlanVersionNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffPlanVersions(sessionID, tt.from, tt.to)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DiffPlanVersions() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DiffPlanVersions() error = %v", err)
+			}
+			if got := diffSummary(diff); got != tt.want {
+				t.Errorf("diff = %s\nwant   %s", got, tt.want)
+			}
+		})
+	}
+
+	// 标题和依赖的变化带有原来的值，状态变化记录引起变化的节点
+	diff, err := DiffPlanVersions(sessionID, 1, 4)
+	if err != nil {
+		t.Fatalf("DiffPlanVersions() error = %v", err)
+	}
+	for _, d := range diff.Tasks {
+		switch d.TaskID {
+		case "1":
+			if sources := []string{d.Transitions[0].Source, d.Transitions[1].Source}; sources[0] != planSourceScan || sources[1] != planSourceUpdate {
+				t.Errorf("task 1 transition sources = %v", sources)
+			}
+		case "2":
+			if d.FromTitle != "报修" || strings.Join(d.FromDepends, ",") != "1" || len(d.ToDepends) != 0 {
+				t.Errorf("task 2 diff = %+v, want title and dependency changes", d)
+			}
+		case "3":
+			if d.FromStatus != model.TaskPending || d.ToStatus != "" {
+				t.Errorf("removed task 3 statuses = %s -> %s", d.FromStatus, d.ToStatus)
+			}
+		}
+	}
+}
+
+func TestDiffPlanVersionsWithoutPlan(t *testing.T) {
+	InitAgentStorage(storage.NewMemoryStorage())
+	if _, err := DiffPlanVersions("empty-session", 0, 0); !errors.Is(err, ErrPlanNotFound) {
+		t.Errorf("DiffPlanVersions() error = %v, want ErrPlanNotFound", err)
+	}
+}
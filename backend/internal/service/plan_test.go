@@ -0,0 +1,446 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"glata-backend/internal/model"
+	"glata-backend/internal/storage"
+)
+
+// taskIDs 按顺序列出计划中的任务ID
+func taskIDs(plan *model.Plan) string {
+	ids := make([]string, len(plan.Tasks))
+	for i, t := range plan.Tasks {
+		ids[i] = t.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestParsePlanOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantIDs string
+		wantErr bool
+	}{
+		{
+			name:    "模式标识和模型给出的ID",
+			content: `[MODE:TODO_LIST]` + "\n" + `{"tasks":[{"id":"1","title":"查询设备"},{"id":"2","title":"提交报修"}]}`,
+			wantIDs: "1,2",
+		},
+		{
+			name:    "代码块和思考过程",
+			content: "<think>先拆分任务</think>\n```json\n{\"tasks\":[{\"id\":\"a\",\"title\":\"查询设备\"}]}\n```",
+			wantIDs: "a",
+		},
+		{
+			name:    "重复的ID按位置重新分配",
+			content: `{"tasks":[{"id":"1","title":"A"},{"id":"1","title":"B"}]}`,
+			wantIDs: "1,2",
+		},
+		{
+			name:    "按位置分配的ID已被占用",
+			content: `{"tasks":[{"id":"2","title":"A"},{"id":"","title":"B"}]}`,
+			wantIDs: "2,2-2",
+		},
+		{
+			name:    "跳过标题为空的任务",
+			content: `{"tasks":[{"id":"1","title":"  "},{"id":"2","title":"B"}]}`,
+			wantIDs: "2",
+		},
+		{
+			name:    "没有任务",
+			content: `{"tasks":[{"id":"1","title":""}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "不是JSON",
+			content: "1. 查询设备\n2. 提交报修",
+			wantErr: true,
+		},
+		{
+			name:    "JSON格式错误",
+			content: `{"tasks":[{"id":"1","title":"A"},]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parsePlanOutput("s1", tt.content)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsePlanOutput() = %v, want error", taskIDs(plan))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePlanOutput() error = %v", err)
+			}
+			if got := taskIDs(plan); got != tt.wantIDs {
+				t.Errorf("task ids = %s, want %s", got, tt.wantIDs)
+			}
+			if plan.SessionID != "s1" {
+				t.Errorf("session id = %q, want s1", plan.SessionID)
+			}
+			for _, task := range plan.Tasks {
+				if task.Status != model.TaskPending {
+					t.Errorf("task %s is %s, want %s", task.ID, task.Status, model.TaskPending)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePlanOutputDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string // 任务ID -> 保留的依赖
+	}{
+		{
+			name:    "依赖前面的任务",
+			content: `{"tasks":[{"id":"a","title":"A"},{"id":"b","title":"B","depends_on":["a"]},{"id":"c","title":"C","depends_on":["b","a"]}]}`,
+			want:    map[string]string{"a": "", "b": "a", "c": "b,a"},
+		},
+		{
+			name:    "丢弃重复、自依赖、未知和指向后面的依赖",
+			content: `{"tasks":[{"id":"a","title":"A","depends_on":["b"]},{"id":"b","title":"B","depends_on":["a","a","b","x"]}]}`,
+			want:    map[string]string{"a": "", "b": "a"},
+		},
+		{
+			name:    "环只保留指向前面的一条边",
+			content: `{"tasks":[{"id":"a","title":"A","depends_on":["c"]},{"id":"b","title":"B","depends_on":["a"]},{"id":"c","title":"C","depends_on":["b"]}]}`,
+			want:    map[string]string{"a": "", "b": "a", "c": "b"},
+		},
+		{
+			name:    "依赖按模型给出的ID映射到重新分配的ID",
+			content: `{"tasks":[{"id":"1","title":"A"},{"id":"1","title":"B","depends_on":["1"]},{"id":"3","title":"C","depends_on":["1"," 1 "]}]}`,
+			want:    map[string]string{"1": "", "2": "1", "3": "1"},
+		},
+		{
+			name:    "依赖被跳过的空任务时丢弃",
+			content: `{"tasks":[{"id":"a","title":""},{"id":"b","title":"B","depends_on":["a"]}]}`,
+			want:    map[string]string{"b": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parsePlanOutput("s1", tt.content)
+			if err != nil {
+				t.Fatalf("parsePlanOutput() error = %v", err)
+			}
+			if len(plan.Tasks) != len(tt.want) {
+				t.Fatalf("task ids = %s, want %d tasks", taskIDs(plan), len(tt.want))
+			}
+			for id, want := range tt.want {
+				task := plan.Task(id)
+				if task == nil {
+					t.Fatalf("task %s not found in %s", id, taskIDs(plan))
+				}
+				if got := strings.Join(task.DependsOn, ","); got != want {
+					t.Errorf("task %s depends on %q, want %q", id, got, want)
+				}
+			}
+			// 解析结果中不会有环，第一批可执行的任务不为空
+			if len(plan.ReadyTasks()) == 0 {
+				t.Errorf("no ready task in parsed plan")
+			}
+		})
+	}
+}
+
+// planState 按顺序列出任务的 "id:status:依赖"
+func planState(plan *model.Plan) string {
+	parts := make([]string, len(plan.Tasks))
+	for i, t := range plan.Tasks {
+		parts[i] = t.ID + ":" + string(t.Status) + ":" + strings.Join(t.DependsOn, ",")
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestApplyReplan(t *testing.T) {
+	// 1 已完成，2 失败，3 依赖 2 待执行，4 执行中
+	base := func() *model.Plan {
+		return &model.Plan{SessionID: "s1", Version: 3, Tasks: []*model.Task{
+			{ID: "1", Title: "查询设备", Status: model.TaskDone, Attempts: 1, Result: "ok"},
+			{ID: "2", Title: "提交报修", Status: model.TaskFailed, Attempts: 2, Result: "接口超时"},
+			{ID: "3", Title: "通知用户", Status: model.TaskPending, DependsOn: []string{"2"}},
+			{ID: "4", Title: "记录工单", Status: model.TaskRunning, Attempts: 1},
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "重试失败任务并追加新任务，未保留的剩余任务跳过",
+			output: `{"reason":"换一种方式报修","tasks":[{"id":"2","title":"通过工单系统报修"},{"title":"电话确认","depends_on":["1","2"]}]}`,
+			want:   "1:done: 2:pending: 3:skipped:2 4:skipped: r3-2:pending:1,2",
+		},
+		{
+			name:   "不能修改已完成的任务",
+			output: `{"reason":"r","tasks":[{"id":"1","title":"重新查询"},{"id":"3","title":"通知用户"}]}`,
+			want:   "1:done: 2:failed: 3:pending: 4:skipped:",
+		},
+		{
+			name:   "重复的ID按新任务处理",
+			output: `{"reason":"r","tasks":[{"id":"3","title":"A"},{"id":"3","title":"B","depends_on":["3"]}]}`,
+			want:   "1:done: 2:failed: 3:pending: 4:skipped: r3-2:pending:3",
+		},
+		{
+			name:   "依赖只能指向已完成或排在前面的任务",
+			output: `{"reason":"r","tasks":[{"id":"3","title":"C","depends_on":["4","3","x","1","2"]},{"id":"4","title":"D","depends_on":["3"]}]}`,
+			want:   "1:done: 2:failed: 3:pending:1 4:pending:3",
+		},
+		{
+			name:   "模型给出的新ID未被占用时沿用",
+			output: `{"reason":"r","tasks":[{"id":"n1","title":"新任务"},{"id":"","title":"  "}]}`,
+			want:   "1:done: 2:failed: 3:skipped:2 4:skipped: n1:pending:",
+		},
+		{
+			name:   "没有剩余任务",
+			output: `{"reason":"r","tasks":[]}`,
+			want:   "1:done: 2:failed: 3:skipped:2 4:skipped:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := parseReplanOutput(tt.output)
+			if err != nil {
+				t.Fatalf("parseReplanOutput() error = %v", err)
+			}
+			plan := base()
+			applyReplan(plan, out)
+			if got := planState(plan); got != tt.want {
+				t.Errorf("plan = %s\nwant   %s", got, tt.want)
+			}
+			if plan.Reason != out.Reason {
+				t.Errorf("reason = %q, want %q", plan.Reason, out.Reason)
+			}
+			if done := plan.Task("1"); done.Title != "查询设备" || done.Result != "ok" {
+				t.Errorf("completed task changed: %+v", done)
+			}
+			for _, task := range plan.Tasks {
+				if task.Status == model.TaskPending && (task.Attempts != 0 || task.Result != "") {
+					t.Errorf("kept task %s not reset: attempts %d, result %q", task.ID, task.Attempts, task.Result)
+				}
+			}
+			// 重新规划后的计划中不会有环
+			plan.SkipBlocked()
+			if !plan.IsFinished() && len(plan.ReadyTasks()) == 0 {
+				t.Errorf("replanned plan has pending tasks but none is ready: %s", planState(plan))
+			}
+		})
+	}
+}
+
+func TestParseReplanOutputDefaultReason(t *testing.T) {
+	out, err := parseReplanOutput(`{"tasks":[{"id":"1","title":"A"}]}`)
+	if err != nil {
+		t.Fatalf("parseReplanOutput() error = %v", err)
+	}
+	if out.Reason == "" {
+		t.Errorf("reason is empty, want default reason")
+	}
+}
+
+func TestApplyPlanEdit(t *testing.T) {
+	// 1 已完成，2 待执行，3 依赖 2 已跳过，4 执行中
+	base := func() *model.Plan {
+		return &model.Plan{SessionID: "s1", Version: 2, Tasks: []*model.Task{
+			{ID: "1", Title: "查询设备", Status: model.TaskDone},
+			{ID: "2", Title: "提交报修", Status: model.TaskPending},
+			{ID: "3", Title: "通知用户", Status: model.TaskSkipped, Result: "依赖任务 2 未完成", DependsOn: []string{"2"}},
+			{ID: "4", Title: "记录工单", Status: model.TaskRunning},
+		}}
+	}
+	edit := func(id, title string, status model.TaskStatus, deps ...string) model.PlanTaskEdit {
+		return model.PlanTaskEdit{ID: id, Title: title, Status: status, DependsOn: deps}
+	}
+
+	tests := []struct {
+		name    string
+		edits   []model.PlanTaskEdit
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "调整顺序并恢复跳过的任务",
+			edits: []model.PlanTaskEdit{edit("4", "", ""), edit("1", "", ""), edit("2", "改为电话报修", ""), edit("3", "", model.TaskPending)},
+			want:  "4:running: 1:done: 2:pending: 3:pending:2",
+		},
+		{
+			name:  "添加新任务",
+			edits: []model.PlanTaskEdit{edit("1", "", ""), edit("4", "", ""), edit("2", "", ""), edit("", "电话确认", "", "1", "2")},
+			want:  "1:done: 4:running: 2:pending: u2-4:pending:1,2",
+		},
+		{
+			name:  "跳过待执行的任务并移除跳过的任务",
+			edits: []model.PlanTaskEdit{edit("1", "", ""), edit("2", "", model.TaskSkipped), edit("4", "", "")},
+			want:  "1:done: 2:skipped: 4:running:",
+		},
+		{
+			name:  "空数组清除依赖",
+			edits: []model.PlanTaskEdit{edit("1", "", ""), edit("4", "", ""), {ID: "3", Status: model.TaskPending, DependsOn: []string{}}, edit("2", "", "")},
+			want:  "1:done: 4:running: 3:pending: 2:pending:",
+		},
+		{
+			name:  "不存在的ID作为新任务添加",
+			edits: []model.PlanTaskEdit{edit("1", "", ""), edit("2", "", ""), edit("4", "", ""), edit("9", "新任务", "")},
+			want:  "1:done: 2:pending: 4:running: u2-4:pending:",
+		},
+		{
+			name:    "依赖排在后面的任务",
+			edits:   []model.PlanTaskEdit{edit("1", "", ""), edit("3", "", "", "2"), edit("2", "", ""), edit("4", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "依赖不在列表中的任务",
+			edits:   []model.PlanTaskEdit{edit("1", "", ""), edit("2", "", "", "x"), edit("4", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "移除已完成的任务",
+			edits:   []model.PlanTaskEdit{edit("2", "", ""), edit("4", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "修改已完成任务的标题",
+			edits:   []model.PlanTaskEdit{edit("1", "重新查询", ""), edit("2", "", ""), edit("4", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "修改执行中任务的依赖",
+			edits:   []model.PlanTaskEdit{edit("1", "", ""), edit("4", "", "", "1"), edit("2", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "把任务标记为已完成",
+			edits:   []model.PlanTaskEdit{edit("1", "", ""), edit("2", "", model.TaskDone), edit("4", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "重复的任务",
+			edits:   []model.PlanTaskEdit{edit("1", "", ""), edit("2", "", ""), edit("2", "", ""), edit("4", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "新任务没有标题",
+			edits:   []model.PlanTaskEdit{edit("1", "", ""), edit("2", "", ""), edit("4", "", ""), edit("", "", "")},
+			wantErr: true,
+		},
+		{
+			name:    "空列表",
+			edits:   nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := base()
+			err := applyPlanEdit(plan, tt.edits)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPlanEdit) {
+					t.Fatalf("applyPlanEdit() error = %v, want ErrInvalidPlanEdit", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyPlanEdit() error = %v", err)
+			}
+			if got := planState(plan); got != tt.want {
+				t.Errorf("plan = %s\nwant   %s", got, tt.want)
+			}
+			for _, task := range plan.Tasks {
+				if task.Status == model.TaskPending && task.Result != "" {
+					t.Errorf("pending task %s keeps result %q", task.ID, task.Result)
+				}
+			}
+		})
+	}
+}
+
+func TestEditSessionPlanBaseVersion(t *testing.T) {
+	InitAgentStorage(storage.NewMemoryStorage())
+	const sessionID = "edit-session"
+	if _, err := writeNewPlan(sessionID, planSourceWritePlan, &model.Plan{Tasks: []*model.Task{
+		{ID: "1", Title: "查询设备", Status: model.TaskPending},
+		{ID: "2", Title: "提交报修", Status: model.TaskPending, DependsOn: []string{"1"}},
+	}}); err != nil {
+		t.Fatalf("failed to write plan: %v", err)
+	}
+
+	steps := []struct {
+		name        string
+		req         *model.UpdatePlanRequest
+		wantErr     error
+		wantVersion int // 编辑后最新的版本
+	}{
+		{
+			name:        "基于最新版本",
+			req:         &model.UpdatePlanRequest{BaseVersion: 1, Tasks: []model.PlanTaskEdit{{ID: "1"}, {ID: "2", Status: model.TaskSkipped}}},
+			wantVersion: 2,
+		},
+		{
+			name:        "基于过期的版本",
+			req:         &model.UpdatePlanRequest{BaseVersion: 1, Tasks: []model.PlanTaskEdit{{ID: "1"}}},
+			wantErr:     ErrPlanVersionConflict,
+			wantVersion: 2,
+		},
+		{
+			name:        "基于不存在的版本",
+			req:         &model.UpdatePlanRequest{BaseVersion: 5, Tasks: []model.PlanTaskEdit{{ID: "1"}}},
+			wantErr:     ErrPlanVersionConflict,
+			wantVersion: 2,
+		},
+		{
+			name:        "编辑不合法时不写入新版本",
+			req:         &model.UpdatePlanRequest{BaseVersion: 2, Tasks: []model.PlanTaskEdit{{ID: "1", Status: model.TaskDone}}},
+			wantErr:     ErrInvalidPlanEdit,
+			wantVersion: 2,
+		},
+		{
+			name:        "基于新版本继续编辑",
+			req:         &model.UpdatePlanRequest{BaseVersion: 2, Tasks: []model.PlanTaskEdit{{ID: "2", Status: model.TaskPending, DependsOn: []string{}}, {ID: "1"}}, Reason: "先报修"},
+			wantVersion: 3,
+		},
+	}
+
+	for _, step := range steps {
+		plan, err := EditSessionPlan(sessionID, step.req)
+		if step.wantErr != nil {
+			if !errors.Is(err, step.wantErr) {
+				t.Fatalf("%s: error = %v, want %v", step.name, err, step.wantErr)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: error = %v", step.name, err)
+		} else if plan.Version != step.wantVersion || plan.Source != planSourceUser || plan.Reason == "" {
+			t.Errorf("%s: got v%d source %q reason %q, want v%d from %s with reason",
+				step.name, plan.Version, plan.Source, plan.Reason, step.wantVersion, planSourceUser)
+		}
+
+		latest, err := readLatestPlan(sessionID)
+		if err != nil {
+			t.Fatalf("%s: failed to read plan: %v", step.name, err)
+		}
+		if latest.Version != step.wantVersion {
+			t.Errorf("%s: latest version = %d, want %d", step.name, latest.Version, step.wantVersion)
+		}
+	}
+
+	latest, _ := readLatestPlan(sessionID)
+	if got := planState(latest); got != "2:pending: 1:pending:" {
+		t.Errorf("final plan = %s", got)
+	}
+	if latest.Reason != "先报修" {
+		t.Errorf("reason = %q, want 先报修", latest.Reason)
+	}
+}
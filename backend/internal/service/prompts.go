@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/prompt"
+	"glata-backend/pkg/logger"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 提示注册表中的提示名，对应提示目录下的文件名
+const (
+	promptPlan           = "plan"
+	promptExecute        = "execute"
+	promptUpdateTodoList = "update_todo_list"
+	promptSummary        = "summary"
+)
+
+// configPromptVersion 提示目录中没有对应提示、使用配置中的提示时记录的版本
+const configPromptVersion = "config"
+
+// 未配置时的提示目录和检查间隔
+const (
+	defaultPromptDir            = "./prompts"
+	defaultPromptReloadInterval = 10 * time.Second
+)
+
+var promptRegistry *prompt.Registry
+
+// InitPromptRegistry 加载提示目录并在后台定期检查文件变化，直到 ctx 结束
+// 提示只在提示目录中维护，目录中缺少且配置中也没有的提示返回错误，服务不应在缺少提示时启动
+func InitPromptRegistry(ctx context.Context) error {
+	cfg := config.Get()
+	dir := defaultPromptDir
+	interval := defaultPromptReloadInterval
+	if cfg != nil && cfg.Agent.Prompts.Dir != "" {
+		dir = cfg.Agent.Prompts.Dir
+	}
+	if cfg != nil && cfg.Agent.Prompts.ReloadInterval > 0 {
+		interval = cfg.Agent.Prompts.ReloadInterval
+	}
+
+	registry := prompt.New(dir)
+	registry.Require(requiredPrompts(cfg)...)
+	if _, err := registry.Reload(); err != nil {
+		return fmt.Errorf("failed to load prompts from %s: %w", dir, err)
+	}
+	logger.Infof("📝 Loaded prompts from %s: %v", dir, registry.Names())
+
+	promptRegistry = registry
+	go registry.Watch(ctx, interval)
+	return nil
+}
+
+// requiredPrompts 提示目录中必须存在的提示；配置中单独指定了内容的提示可以缺少
+func requiredPrompts(cfg *config.Config) []string {
+	configured := map[string]bool{}
+	if cfg != nil {
+		configured[promptPlan] = cfg.Agent.PlanPrompt != ""
+		configured[promptExecute] = cfg.Agent.ExecutePrompt != ""
+		configured[promptUpdateTodoList] = cfg.Agent.UpdateTodoListPrompt != ""
+		configured[promptSummary] = cfg.Agent.SummaryPrompt != ""
+	}
+	var required []string
+	for _, name := range []string{promptPlan, promptExecute, promptUpdateTodoList, promptSummary} {
+		if !configured[name] {
+			required = append(required, name)
+		}
+	}
+	return required
+}
+
+// promptTool 提示模板中可用工具的信息
+type promptTool struct {
+	Name string
+	Desc string
+}
+
+// promptData 提示模板可以使用的变量，当前计划和用户画像只在模板用到时才读取
+type promptData struct {
+	sessionID string
+	history   []*schema.Message
+
+	Tools []promptTool
+
+	// 以下变量只在 update_todo_list 中有值
+	Task          *model.Task
+	TaskOutcome   string
+	OutcomeReason string
+}
+
+// Plan 当前会话最新计划的 Markdown，没有计划时为空
+func (d *promptData) Plan() string {
+	plan, err := readLatestPlan(d.sessionID)
+	if err != nil {
+		return ""
+	}
+	return plan.Markdown()
+}
+
+// Profile 与用户问题相关的长期记忆，未启用长期记忆或没有相关记忆时为空
+func (d *promptData) Profile() string {
+	memories := relevantMemories(d.history)
+	if len(memories) == 0 {
+		return ""
+	}
+	return formatMemories(memories)
+}
+
+// runPrompts 一次运行使用的提示版本，在运行开始时按会话选定，热加载不影响正在执行的运行
+type runPrompts struct {
+	sessionID string
+	tools     []promptTool
+	variants  map[string]*prompt.Variant
+}
+
+// newRunPrompts 为运行选定各提示的版本；恢复的运行沿用原来的版本，版本已被删除时重新选择
+// 同一会话在提示不变时总是选到同一个版本，多轮对话不会在 A/B 版本之间来回切换
+func newRunPrompts(ctx context.Context, run *model.RunRecord, allTools []tool.BaseTool) *runPrompts {
+	p := &runPrompts{sessionID: run.SessionID, variants: make(map[string]*prompt.Variant)}
+	for _, t := range allTools {
+		info, err := t.Info(ctx)
+		if err != nil || info == nil {
+			continue
+		}
+		p.tools = append(p.tools, promptTool{Name: info.Name, Desc: info.Desc})
+	}
+
+	if promptRegistry == nil {
+		return p
+	}
+	for _, name := range []string{promptPlan, promptExecute, promptUpdateTodoList, promptSummary} {
+		if v := pinnedVariant(name, run.PromptVersions[name]); v != nil {
+			p.variants[name] = v
+			continue
+		}
+		if v := promptRegistry.Select(name, run.SessionID); v != nil {
+			p.variants[name] = v
+		}
+	}
+	return p
+}
+
+// pinnedVariant 查找指定版本的提示，版本为空或已不存在时返回 nil
+func pinnedVariant(name, version string) *prompt.Variant {
+	if version == "" || version == configPromptVersion {
+		return nil
+	}
+	for _, v := range promptRegistry.Variants(name) {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// data 创建提示模板的变量
+func (p *runPrompts) data(history []*schema.Message) *promptData {
+	return &promptData{sessionID: p.sessionID, history: history, Tools: p.tools}
+}
+
+// render 渲染选定版本的提示，返回的 bool 表示是否使用了注册表中的提示
+// 没有选定版本或渲染失败时返回配置中的提示 fallback
+func (p *runPrompts) render(name, fallback string, data *promptData) (string, bool) {
+	v := p.variants[name]
+	if v == nil {
+		return fallback, false
+	}
+	text, err := v.Render(data)
+	if err != nil {
+		logger.Errorf("Failed to render prompt, falling back to configured prompt: %v", err)
+		return fallback, false
+	}
+	return text, true
+}
+
+// versions 各提示使用的版本，使用配置中的提示时记为 config
+func (p *runPrompts) versions() map[string]string {
+	versions := make(map[string]string)
+	for _, name := range []string{promptPlan, promptExecute, promptUpdateTodoList, promptSummary} {
+		if v := p.variants[name]; v != nil {
+			versions[name] = v.Version
+		} else {
+			versions[name] = configPromptVersion
+		}
+	}
+	return versions
+}
+
+// recordPromptVersions 把运行使用的提示版本写入对应的助手消息，便于按版本对比回答效果
+// 在开始转发进度之前调用，避免与进度内容的写入相互覆盖
+func recordPromptVersions(run *model.RunRecord) {
+	if globalStorage == nil || run.MessageID == "" {
+		return
+	}
+	session, err := globalStorage.GetSession(run.SessionID)
+	if err != nil {
+		logger.Errorf("Failed to get session %s for prompt versions: %v", run.SessionID, err)
+		return
+	}
+	for i := range session.Messages {
+		if session.Messages[i].ID == run.MessageID {
+			session.Messages[i].PromptVersions = run.PromptVersions
+			if err := globalStorage.UpdateSession(session); err != nil {
+				logger.Errorf("Failed to record prompt versions on message %s: %v", run.MessageID, err)
+			}
+			return
+		}
+	}
+}
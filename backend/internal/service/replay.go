@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/replay"
+	"glata-backend/pkg/logger"
+
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+)
+
+// 录制与回放模式
+const (
+	replayModeOff    = "off"
+	replayModeRecord = "record"
+	replayModeReplay = "replay"
+)
+
+// runReplay 一次运行的录制器或回放器，为 nil 时使用真实的模型和工具
+type runReplay struct {
+	recorder *replay.Recorder
+	player   *replay.Player
+}
+
+// replayMode 当前配置的录制与回放模式
+func replayMode() string {
+	cfg := config.Get()
+	if cfg == nil {
+		return replayModeOff
+	}
+	mode := strings.ToLower(strings.TrimSpace(cfg.Agent.Replay.Mode))
+	if mode == "" {
+		return replayModeOff
+	}
+	return mode
+}
+
+// cassetteDir 录音目录，未配置时放在数据目录下
+func cassetteDir(cfg *config.Config) string {
+	if cfg.Agent.Replay.Dir != "" {
+		return cfg.Agent.Replay.Dir
+	}
+	return filepath.Join(cfg.Storage.DataDir, "cassettes")
+}
+
+// newRunReplay 按配置创建运行的录制器或回放器；恢复的运行单独录制，不覆盖中断前的录音
+func newRunReplay(run *model.RunRecord) (*runReplay, error) {
+	cfg := config.Get()
+	switch replayMode() {
+	case replayModeOff:
+		return nil, nil
+	case replayModeRecord:
+		name := run.ID
+		if run.ResumeCount > 0 {
+			name = fmt.Sprintf("%s_resume%d", run.ID, run.ResumeCount)
+		}
+		recorder := replay.NewRecorder(filepath.Join(cassetteDir(cfg), name+".json"))
+		logger.Infof("📼 Recording run %s to %s", run.ID, recorder.Path())
+		return &runReplay{recorder: recorder}, nil
+	case replayModeReplay:
+		if cfg.Agent.Replay.Cassette == "" {
+			return nil, fmt.Errorf("replay mode requires agent.replay.cassette")
+		}
+		path := filepath.Join(cassetteDir(cfg), cfg.Agent.Replay.Cassette)
+		player, err := replay.LoadPlayer(path, cfg.Agent.Replay.Strict)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("📼 Replaying run %s from %s", run.ID, path)
+		return &runReplay{player: player}, nil
+	default:
+		return nil, fmt.Errorf("unsupported replay mode: %s", cfg.Agent.Replay.Mode)
+	}
+}
+
+// tools 运行使用的工具：回放时使用录音中的假工具，录制时包装真实的工具
+func (r *runReplay) tools(ctx context.Context) []tool.BaseTool {
+	if r == nil {
+		return getTools()
+	}
+	if r.player != nil {
+		return r.player.Tools()
+	}
+	return r.recorder.WrapTools(ctx, getTools())
+}
+
+// models 各阶段使用的模型：回放时使用按录音回复的假模型，录制时包装真实的模型
+func (r *runReplay) models(ctx context.Context, tools []tool.BaseTool) (plan, execute, update, summary einoModel.ChatModel) {
+	if r != nil && r.player != nil {
+		return r.player.Model(config.StagePlan), r.player.Model(config.StageExecute),
+			r.player.Model(config.StageUpdate), r.player.Model(config.StageSummary)
+	}
+
+	plan = model.NewPlanModel(ctx, tools)
+	execute = model.NewExecuteModel(ctx, tools)
+	update = model.NewUpdateModel(ctx, tools)
+	summary = model.NewSummaryModel(ctx)
+	if r != nil {
+		plan = r.recorder.WrapModel(config.StagePlan, plan)
+		execute = r.recorder.WrapModel(config.StageExecute, execute)
+		update = r.recorder.WrapModel(config.StageUpdate, update)
+		summary = r.recorder.WrapModel(config.StageSummary, summary)
+	}
+	return plan, execute, update, summary
+}
+
+// finish 运行结束后保存录音
+func (r *runReplay) finish() {
+	if r == nil || r.recorder == nil {
+		return
+	}
+	if err := r.recorder.Save(); err != nil {
+		logger.Errorf("Failed to save cassette %s: %v", r.recorder.Path(), err)
+		return
+	}
+	logger.Infof("📼 Saved %d interactions to %s", len(r.recorder.Cassette().Interactions), r.recorder.Path())
+}
+
+// ReplayResult 离线回放一次运行的结果
+type ReplayResult struct {
+	Output *schema.Message
+	Events []ProgressEvent
+	Unused []*replay.Interaction // 录音中没有被回放的交互
+}
+
+// ReplayRun 用回放器离线执行一次完整的运行，不访问模型服务和工具接口，用于回归测试
+// 调用前需要加载配置并通过 InitAgentStorage 设置存储；工具审批不生效
+func ReplayRun(ctx context.Context, player *replay.Player, sessionID, query string, history []*schema.Message) (*ReplayResult, error) {
+	progressManager := NewProgressManager(sessionID)
+	result := &ReplayResult{}
+	done := make(chan struct{})
+	go func() {
+		for event := range progressManager.GetProgressChannel() {
+			result.Events = append(result.Events, event)
+		}
+		close(done)
+	}()
+
+	tools := player.Tools()
+	run := newRunRecord(sessionID, uuid.New().String(), query)
+	graph, err := composeGraph[*UserMessage, *schema.Message](ctx,
+		player.Model(config.StagePlan), player.Model(config.StageExecute), player.Model(config.StageUpdate), player.Model(config.StageSummary),
+		tools, newToolsNode(ctx, tools), sessionID, progressManager, newRunCheckpoint(run), newRunPrompts(ctx, run, tools))
+	if err != nil {
+		progressManager.Close()
+		<-done
+		return nil, fmt.Errorf("failed to compose graph: %w", err)
+	}
+
+	result.Output, err = graph.Invoke(ctx, &UserMessage{ID: sessionID, Query: query, History: history})
+	progressManager.Close()
+	<-done
+	result.Unused = player.Unused()
+	return result, err
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/replay"
+	"glata-backend/internal/storage"
+)
+
+// TestReplayRun 离线回放录制的运行：两件设备分两个任务归还，计划全部完成并输出总结
+func TestReplayRun(t *testing.T) {
+	t.Setenv("DASHSCOPE_API_KEY", "test")
+	cfg, err := config.Load("../../configs/config.yaml")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	cfg.Storage.DataDir = t.TempDir()
+	cfg.Agent.Prompts.Dir = "../../prompts"
+	InitAgentStorage(storage.NewMemoryStorage())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := InitPromptRegistry(ctx); err != nil {
+		t.Fatalf("failed to load prompts: %v", err)
+	}
+
+	player, err := replay.LoadPlayer("testdata/return_devices.json", false)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+
+	const sessionID = "replay-session"
+	res, err := ReplayRun(ctx, player, sessionID, "帮我把显示器和键盘都退了", nil)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	for _, miss := range player.Misses() {
+		t.Logf("replayed by order: %s", miss)
+	}
+
+	plan, err := readLatestPlan(sessionID)
+	if err != nil {
+		t.Fatalf("failed to read plan: %v", err)
+	}
+	if len(plan.Tasks) != 2 {
+		t.Fatalf("plan has %d tasks, want 2", len(plan.Tasks))
+	}
+	for _, task := range plan.Tasks {
+		if task.Status != model.TaskDone {
+			t.Errorf("task %s %q is %s, want %s", task.ID, task.Title, task.Status, model.TaskDone)
+		}
+	}
+
+	if res.Output == nil {
+		t.Fatal("replay returned no output")
+	}
+	for _, want := range []string{"## 处理结果", "return_req_15", "return_req_12"} {
+		if !strings.Contains(res.Output.Content, want) {
+			t.Errorf("summary %q does not contain %q", res.Output.Content, want)
+		}
+	}
+
+	if len(res.Unused) != 0 {
+		for _, interaction := range res.Unused {
+			t.Logf("unused interaction #%d %s %s", interaction.Seq, interaction.Kind, interaction.Name)
+		}
+		t.Errorf("%d recorded interactions were not replayed", len(res.Unused))
+	}
+}
@@ -0,0 +1,462 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"glata-backend/internal/model"
+	"glata-backend/internal/storage"
+	"glata-backend/pkg/logger"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+var (
+	ErrRunNotResumable = errors.New("run is not resumable")
+	ErrRunNotActive    = errors.New("no active run for session")
+)
+
+// activeRun 正在执行的运行，持有取消函数和进度管理器
+type activeRun struct {
+	run       *model.RunRecord
+	cancel    context.CancelFunc
+	progress  *ProgressManager
+	cancelled bool
+}
+
+// runRegistry 登记当前进程中正在执行的运行，按运行ID（即助手消息ID）索引
+type runRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*activeRun
+}
+
+var activeRuns = &runRegistry{runs: make(map[string]*activeRun)}
+
+func (r *runRegistry) register(run *model.RunRecord, cancel context.CancelFunc, progress *ProgressManager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[run.ID] = &activeRun{run: run, cancel: cancel, progress: progress}
+}
+
+func (r *runRegistry) unregister(runID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.runs, runID)
+}
+
+// isCancelled 判断运行是否是被用户取消的（而不是超时或出错）
+func (r *runRegistry) isCancelled(runID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.runs[runID]
+	return ok && a.cancelled
+}
+
+// cancel 取消会话中正在执行的运行，messageID 为空时取消该会话的所有运行
+func (r *runRegistry) cancel(sessionID, messageID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var cancelled []string
+	for id, a := range r.runs {
+		if a.run.SessionID != sessionID || (messageID != "" && a.run.MessageID != messageID) {
+			continue
+		}
+		a.cancelled = true
+		a.cancel()
+		cancelled = append(cancelled, id)
+	}
+	if len(cancelled) == 0 {
+		return nil, ErrRunNotActive
+	}
+	return cancelled, nil
+}
+
+// notify 向会话中正在执行的运行发送进度事件，用于运行之外发生的变化（如用户编辑计划）
+func (r *runRegistry) notify(sessionID, eventType, nodeName, message string, data map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, a := range r.runs {
+		if a.run.SessionID == sessionID && a.progress != nil {
+			a.progress.SendEvent(eventType, nodeName, message, data, nil)
+		}
+	}
+}
+
+// CancelRun 取消会话中正在执行的运行，返回被取消的运行ID
+func CancelRun(sessionID, messageID string) ([]string, error) {
+	ids, err := activeRuns.cancel(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("⛔ Cancelling runs %v of session %s", ids, sessionID)
+	return ids, nil
+}
+
+// cancelPlanTasks 运行取消后将计划中未完成的任务标记为已取消
+func cancelPlanTasks(sessionID string) {
+	_, err := updatePlan(sessionID, planSourceCancel, func(plan *model.Plan) error {
+		changed := false
+		for _, t := range plan.Tasks {
+			if t.Status.IsTerminal() {
+				continue
+			}
+			if t.Status == model.TaskRunning {
+				t.Result = "执行中被取消"
+			} else {
+				t.Result = "未执行，运行已取消"
+			}
+			t.Status = model.TaskCancelled
+			changed = true
+		}
+		if !changed {
+			return errPlanUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warnf("Failed to mark tasks of session %s as cancelled: %v", sessionID, err)
+	}
+}
+
+// runCheckpoint 负责将运行状态持久化到存储，每个主图节点完成后保存一次，任务子图每批工具调用完成后保存任务的检查点
+type runCheckpoint struct {
+	mu  sync.Mutex
+	run *model.RunRecord
+}
+
+// newRunRecord 创建新的运行记录，运行ID与助手消息ID一致
+func newRunRecord(sessionID, messageID, query string) *model.RunRecord {
+	now := time.Now()
+	return &model.RunRecord{
+		ID:        messageID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		Query:     query,
+		Status:    model.RunRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func newRunCheckpoint(run *model.RunRecord) *runCheckpoint {
+	return &runCheckpoint{run: run}
+}
+
+// save 保存主图节点完成后的检查点；executeBatch 完成后本批任务的结果已并入上下文，清除任务的检查点
+func (c *runCheckpoint) save(node string, state *myState) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.run.LastNode = node
+	c.run.History = append([]*schema.Message(nil), state.history...)
+	c.run.State = &model.RunState{
+		ContextSummary:   state.contextSummary,
+		SummarizedLen:    state.summarizedLen,
+		FailedTaskIDs:    append([]string(nil), state.failedTaskIDs...),
+		ToolOutputs:      append([]string(nil), state.toolOutputs...),
+		TasksSinceReplan: state.tasksSinceReplan,
+		ReplanCount:      state.replanCount,
+	}
+	if node == "executeBatch" {
+		c.run.Tasks = nil
+	}
+	c.persist()
+}
+
+// saveTask 工具执行完成后保存任务子图的检查点，toolMessages 为刚完成的一批工具结果，还没有进入状态中的上下文
+func (c *runCheckpoint) saveTask(state *myState, toolMessages []*schema.Message) {
+	if c == nil || state.currentTaskID == "" {
+		return
+	}
+	recordToolResults(state, toolMessages)
+	history := append([]*schema.Message(nil), state.history[state.baseHistoryLen:]...)
+	history = append(history, toolMessages...)
+
+	completed := make(map[string][]string, len(state.toolResults))
+	for sig, results := range state.toolResults {
+		completed[sig] = append([]string(nil), results...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.run.Tasks == nil {
+		c.run.Tasks = make(map[string]*model.TaskCheckpoint)
+	}
+	c.run.Tasks[state.currentTaskID] = &model.TaskCheckpoint{
+		TaskID:          state.currentTaskID,
+		History:         history,
+		ToolCalls:       state.toolCalls,
+		ToolSignatures:  append([]string(nil), state.toolSignatures...),
+		LoopCorrections: state.loopCorrections,
+		CompletedCalls:  completed,
+		UpdatedAt:       time.Now(),
+	}
+	c.persist()
+}
+
+// recordToolResults 按调用签名记录这批工具调用的结果，相同的调用按顺序保存多次的结果
+func recordToolResults(state *myState, toolMessages []*schema.Message) {
+	signatures := make(map[string]string, len(state.pendingCalls))
+	for _, call := range state.pendingCalls {
+		signatures[call.ID] = toolCallSignature(call)
+	}
+	if state.toolResults == nil {
+		state.toolResults = make(map[string][]string)
+	}
+	for _, msg := range toolMessages {
+		if sig, ok := signatures[msg.ToolCallID]; ok {
+			state.toolResults[sig] = append(state.toolResults[sig], msg.Content)
+		}
+	}
+	state.pendingCalls = nil
+}
+
+// task 返回任务在中断前保存的检查点，没有时返回 nil
+func (c *runCheckpoint) task(taskID string) *model.TaskCheckpoint {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.run.Tasks[taskID]
+}
+
+// finish 记录运行的最终状态
+func (c *runCheckpoint) finish(status model.RunStatus, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.run.Status = status
+	if err != nil {
+		c.run.Error = err.Error()
+	}
+	c.persist()
+}
+
+func (c *runCheckpoint) persist() {
+	if globalStorage == nil {
+		return
+	}
+	c.run.UpdatedAt = time.Now()
+	if err := globalStorage.SaveRun(c.run); err != nil {
+		logger.Errorf("Failed to save checkpoint of run %s: %v", c.run.ID, err)
+	}
+}
+
+// restoreState 恢复主图中与上下文一起保存的状态
+func restoreState(state *myState, saved *model.RunState) {
+	if saved == nil {
+		return
+	}
+	state.contextSummary = saved.ContextSummary
+	state.summarizedLen = saved.SummarizedLen
+	state.failedTaskIDs = append([]string(nil), saved.FailedTaskIDs...)
+	state.toolOutputs = append([]string(nil), saved.ToolOutputs...)
+	state.tasksSinceReplan = saved.TasksSinceReplan
+	state.replanCount = saved.ReplanCount
+}
+
+// restoreTask 任务从检查点继续：接上中断前的上下文和工具调用计数，记录已完成的工具调用结果
+func restoreTask(state *myState, cp *model.TaskCheckpoint) {
+	state.history = append(state.history, cp.History...)
+	state.toolCalls = cp.ToolCalls
+	state.toolSignatures = append([]string(nil), cp.ToolSignatures...)
+	state.loopCorrections = cp.LoopCorrections
+	state.toolResults = make(map[string][]string, len(cp.CompletedCalls))
+	state.completedCalls = make(map[string][]string, len(cp.CompletedCalls))
+	for sig, results := range cp.CompletedCalls {
+		state.toolResults[sig] = append([]string(nil), results...)
+		state.completedCalls[sig] = append([]string(nil), results...)
+	}
+}
+
+// resumedTool 恢复的运行中，与中断前已完成的调用相同的工具调用直接返回记录的结果，不再重复预订、分配等操作
+type resumedTool struct {
+	tool.InvokableTool
+	name string
+}
+
+// wrapToolsForResume 包装恢复的运行使用的工具，无法包装的工具原样返回
+func wrapToolsForResume(ctx context.Context, tools []tool.BaseTool) []tool.BaseTool {
+	wrapped := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		info, err := t.Info(ctx)
+		invokable, ok := t.(tool.InvokableTool)
+		if err != nil || !ok {
+			logger.Warnf("Tool cannot skip completed calls on resume, using it as is: %v", err)
+			wrapped = append(wrapped, t)
+			continue
+		}
+		wrapped = append(wrapped, &resumedTool{InvokableTool: invokable, name: info.Name})
+	}
+	return wrapped
+}
+
+func (t *resumedTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	sig := toolCallSignature(schema.ToolCall{Function: schema.FunctionCall{Name: t.name, Arguments: argumentsInJSON}})
+
+	var result string
+	var found bool
+	_ = compose.ProcessState[*myState](ctx, func(ctx context.Context, state *myState) error {
+		if results := state.completedCalls[sig]; len(results) > 0 {
+			result, found = results[0], true
+			state.completedCalls[sig] = results[1:]
+		}
+		return nil
+	})
+	if found {
+		logger.Infof("♻️ Tool call %s already completed before the run was interrupted, returning recorded result", sig)
+		return result, nil
+	}
+	return t.InvokableTool.InvokableRun(ctx, argumentsInJSON, opts...)
+}
+
+// checkpointAfter 创建在节点完成后保存检查点的状态后处理器
+func checkpointAfter[O any](cp *runCheckpoint, node string) compose.StatePostHandler[O, *myState] {
+	return func(ctx context.Context, out O, state *myState) (O, error) {
+		cp.save(node, state)
+		return out, nil
+	}
+}
+
+// RecoverInterruptedRuns 启动时将仍处于执行中的运行标记为中断，返回被标记的运行
+func RecoverInterruptedRuns() []*model.RunRecord {
+	if globalStorage == nil {
+		return nil
+	}
+
+	runs, err := globalStorage.ListRuns("")
+	if err != nil {
+		logger.Errorf("Failed to list runs for recovery: %v", err)
+		return nil
+	}
+
+	var interrupted []*model.RunRecord
+	for _, run := range runs {
+		if run.Status != model.RunRunning {
+			continue
+		}
+		run.Status = model.RunInterrupted
+		run.UpdatedAt = time.Now()
+		if err := globalStorage.SaveRun(run); err != nil {
+			logger.Errorf("Failed to mark run %s as interrupted: %v", run.ID, err)
+			continue
+		}
+		logger.Warnf("⚠️ Run %s of session %s was interrupted at node %q", run.ID, run.SessionID, run.LastNode)
+		interrupted = append(interrupted, run)
+	}
+	return interrupted
+}
+
+// getRun 读取运行记录
+func getRun(runID string) (*model.RunRecord, error) {
+	if globalStorage == nil {
+		return nil, storage.ErrRunNotFound
+	}
+	return globalStorage.GetRun(runID)
+}
+
+// newerRun 返回会话中比 run 更晚开始的运行，会话最新的计划此时属于更晚的运行
+func newerRun(run *model.RunRecord) (*model.RunRecord, error) {
+	runs, err := globalStorage.ListRuns(run.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range runs {
+		if other.ID != run.ID && other.CreatedAt.After(run.CreatedAt) {
+			return other, nil
+		}
+	}
+	return nil, nil
+}
+
+// prepareResume 恢复前将计划中执行到一半的任务放回待执行队列
+// 会话中已经有更晚的运行时不能恢复，否则会把新运行的计划当作自己的计划修改
+func prepareResume(run *model.RunRecord) error {
+	if !run.IsResumable() {
+		return fmt.Errorf("%w: status is %s", ErrRunNotResumable, run.Status)
+	}
+	newer, err := newerRun(run)
+	if err != nil {
+		return fmt.Errorf("failed to check newer runs of session %s: %w", run.SessionID, err)
+	}
+	if newer != nil {
+		return fmt.Errorf("%w: session has a newer run %s", ErrRunNotResumable, newer.ID)
+	}
+
+	_, err = updatePlan(run.SessionID, planSourceResume, func(plan *model.Plan) error {
+		reset := false
+		for _, t := range plan.Tasks {
+			if t.Status == model.TaskRunning {
+				t.Status = model.TaskPending
+				// 中断的那次执行没有产生结果，不计入已执行次数，恢复后不会因此少一次重试
+				if t.Attempts > 0 {
+					t.Attempts--
+				}
+				reset = true
+			}
+		}
+		if !reset {
+			return errPlanUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		// 没有计划说明中断发生在规划之前，恢复时会重新规划
+		logger.Warnf("Failed to reset running tasks of run %s: %v", run.ID, err)
+	}
+
+	run.Status = model.RunRunning
+	run.ResumeCount++
+	run.Error = ""
+	run.UpdatedAt = time.Now()
+	return globalStorage.SaveRun(run)
+}
+
+// abortRun 终止中断的运行，未完成的任务标记为跳过
+// 会话中已经有更晚的运行时，最新的计划不属于该运行，只把运行标记为终止
+func abortRun(run *model.RunRecord) error {
+	if !run.IsResumable() {
+		return fmt.Errorf("%w: status is %s", ErrRunNotResumable, run.Status)
+	}
+	newer, err := newerRun(run)
+	if err != nil {
+		return fmt.Errorf("failed to check newer runs of session %s: %w", run.SessionID, err)
+	}
+
+	if newer != nil {
+		logger.Infof("Run %s is superseded by run %s, aborting without changing the plan", run.ID, newer.ID)
+	} else {
+		_, err := updatePlan(run.SessionID, planSourceAbort, func(plan *model.Plan) error {
+			for _, t := range plan.Tasks {
+				if !t.Status.IsTerminal() {
+					t.Status = model.TaskSkipped
+					t.Result = "运行已终止"
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Warnf("Failed to skip remaining tasks of run %s: %v", run.ID, err)
+		}
+	}
+
+	run.Status = model.RunAborted
+	run.UpdatedAt = time.Now()
+	return globalStorage.SaveRun(run)
+}
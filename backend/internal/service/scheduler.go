@@ -0,0 +1,69 @@
+package service
+
+import (
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/internal/scheduler"
+	"glata-backend/pkg/logger"
+)
+
+// 未配置时的调度限制
+const (
+	defaultSchedulerWorkers      = 8
+	defaultSchedulerQueueSize    = 100
+	defaultSchedulerQueueTimeout = 10 * time.Minute
+)
+
+// newRunScheduler 按配置创建全局运行调度器
+func newRunScheduler(cfg *config.Config) *scheduler.Scheduler {
+	sc := cfg.Agent.Scheduler
+	limits := scheduler.Config{
+		Workers:      defaultSchedulerWorkers,
+		QueueSize:    defaultSchedulerQueueSize,
+		PerUser:      sc.PerUser,
+		PerProvider:  sc.PerProvider,
+		QueueTimeout: defaultSchedulerQueueTimeout,
+	}
+	if sc.Workers > 0 {
+		limits.Workers = sc.Workers
+	}
+	if sc.QueueSize > 0 {
+		limits.QueueSize = sc.QueueSize
+	}
+	if sc.QueueTimeout > 0 {
+		limits.QueueTimeout = sc.QueueTimeout
+	}
+
+	logger.Infof("🚦 Run scheduler: %d workers, queue size %d, per user %d, per provider %v",
+		limits.Workers, limits.QueueSize, limits.PerUser, limits.PerProvider)
+	return scheduler.New(limits)
+}
+
+// runProviders 一次运行会用到的模型提供商，各阶段可以配置不同的提供商
+func runProviders() []string {
+	cfg := config.Get()
+	if cfg == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var providers []string
+	for _, stage := range config.ModelStages {
+		provider, _ := cfg.StageModel(stage)
+		if provider != "" && !seen[provider] {
+			seen[provider] = true
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// queueStatus 转换为推送给前端的排队信息
+func queueStatus(status scheduler.Status) *model.QueueStatus {
+	return &model.QueueStatus{
+		Position:   status.Position,
+		ETASeconds: int(status.ETA.Round(time.Second) / time.Second),
+	}
+}
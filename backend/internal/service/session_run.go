@@ -0,0 +1,187 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/pkg/logger"
+)
+
+var ErrSessionBusy = errors.New("session has an active run")
+
+// 会话中已有运行时新请求的处理方式
+const (
+	sessionRunQueue     = "queue"
+	sessionRunReject    = "reject"
+	sessionRunInterrupt = "interrupt"
+)
+
+// 未配置时排队等待的最长时间
+const defaultSessionQueueTimeout = 10 * time.Minute
+
+// interruptRetryInterval interrupt 策略下重复取消的间隔，正在执行的运行可能还没有登记到运行注册表
+const interruptRetryInterval = 200 * time.Millisecond
+
+// sessionTicket 会话执行权的凭证，ready 关闭时表示轮到该请求执行
+type sessionTicket struct {
+	gate      *sessionGate
+	sessionID string
+	policy    string
+	ready     chan struct{}
+	once      sync.Once
+}
+
+// sessionQueue 会话当前持有执行权的请求和按到达顺序排队的请求
+type sessionQueue struct {
+	holder  *sessionTicket
+	waiting []*sessionTicket
+}
+
+// sessionGate 保证同一会话同时只有一个运行，对话和恢复请求在开始运行前都要先取得执行权
+type sessionGate struct {
+	mu     sync.Mutex
+	queues map[string]*sessionQueue
+}
+
+var sessionRuns = &sessionGate{queues: make(map[string]*sessionQueue)}
+
+// sessionRunPolicy 读取配置的处理方式，未配置或无法识别时为 queue
+func sessionRunPolicy() (string, time.Duration) {
+	policy := sessionRunQueue
+	timeout := defaultSessionQueueTimeout
+	cfg := config.Get()
+	if cfg == nil {
+		return policy, timeout
+	}
+	switch cfg.Agent.SessionRun.Policy {
+	case sessionRunReject, sessionRunInterrupt:
+		policy = cfg.Agent.SessionRun.Policy
+	case "", sessionRunQueue:
+	default:
+		logger.Warnf("Unknown session run policy %q, falling back to %s", cfg.Agent.SessionRun.Policy, sessionRunQueue)
+	}
+	if cfg.Agent.SessionRun.QueueTimeout > 0 {
+		timeout = cfg.Agent.SessionRun.QueueTimeout
+	}
+	return policy, timeout
+}
+
+// enter 申请会话的执行权：空闲时直接取得，否则按策略排队或返回 ErrSessionBusy
+// 返回的凭证在运行结束后必须 release
+func (g *sessionGate) enter(sessionID string) (*sessionTicket, error) {
+	policy, _ := sessionRunPolicy()
+	t := &sessionTicket{gate: g, sessionID: sessionID, policy: policy, ready: make(chan struct{})}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	q, ok := g.queues[sessionID]
+	if !ok {
+		q = &sessionQueue{holder: t}
+		g.queues[sessionID] = q
+		close(t.ready)
+		return t, nil
+	}
+	if policy == sessionRunReject {
+		return nil, fmt.Errorf("%w: session %s", ErrSessionBusy, sessionID)
+	}
+	q.waiting = append(q.waiting, t)
+	return t, nil
+}
+
+// tryEnter 会话空闲时取得执行权，否则不排队直接返回 ErrSessionBusy，用于终止运行等不需要等待的操作
+// 返回的凭证在操作完成后必须 release
+func (g *sessionGate) tryEnter(sessionID string) (*sessionTicket, error) {
+	t := &sessionTicket{gate: g, sessionID: sessionID, policy: sessionRunReject, ready: make(chan struct{})}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.queues[sessionID]; ok {
+		return nil, fmt.Errorf("%w: session %s", ErrSessionBusy, sessionID)
+	}
+	g.queues[sessionID] = &sessionQueue{holder: t}
+	close(t.ready)
+	return t, nil
+}
+
+// queued 是否需要等待前面的运行结束
+func (t *sessionTicket) queued() bool {
+	select {
+	case <-t.ready:
+		return false
+	default:
+		return true
+	}
+}
+
+// wait 等待轮到该请求执行，interrupt 策略下会不断取消会话中正在执行的运行，超时后退出队列
+func (t *sessionTicket) wait() error {
+	if !t.queued() {
+		return nil
+	}
+	_, timeout := sessionRunPolicy()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var retry <-chan time.Time
+	if t.policy == sessionRunInterrupt {
+		ticker := time.NewTicker(interruptRetryInterval)
+		defer ticker.Stop()
+		retry = ticker.C
+		t.interrupt()
+	}
+
+	logger.Infof("⏳ Session %s has an active run, request queued (policy: %s)", t.sessionID, t.policy)
+	for {
+		select {
+		case <-t.ready:
+			return nil
+		case <-retry:
+			t.interrupt()
+		case <-timer.C:
+			t.release()
+			return fmt.Errorf("%w: session %s, waited %s", ErrSessionBusy, t.sessionID, timeout)
+		}
+	}
+}
+
+// interrupt 取消会话中正在执行的运行，还没有登记的运行会在下一次重试时取消
+func (t *sessionTicket) interrupt() {
+	if _, err := CancelRun(t.sessionID, ""); err != nil && !errors.Is(err, ErrRunNotActive) {
+		logger.Errorf("Failed to interrupt active run of session %s: %v", t.sessionID, err)
+	}
+}
+
+// release 交出执行权给下一个排队的请求；还在排队时退出队列。可以重复调用
+func (t *sessionTicket) release() {
+	t.once.Do(func() {
+		g := t.gate
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		q, ok := g.queues[t.sessionID]
+		if !ok {
+			return
+		}
+		if q.holder != t {
+			for i, w := range q.waiting {
+				if w == t {
+					q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+					break
+				}
+			}
+			return
+		}
+		if len(q.waiting) == 0 {
+			delete(g.queues, t.sessionID)
+			return
+		}
+		q.holder = q.waiting[0]
+		q.waiting = q.waiting[1:]
+		close(q.holder.ready)
+	})
+}
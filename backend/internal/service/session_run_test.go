@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+)
+
+// loadSessionRunConfig 加载配置并设置会话中已有运行时的处理方式
+func loadSessionRunConfig(t *testing.T, policy string, timeout time.Duration) {
+	t.Helper()
+	t.Setenv("DASHSCOPE_API_KEY", "test")
+	cfg, err := config.Load("../../configs/config.yaml")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	cfg.Agent.SessionRun.Policy = policy
+	cfg.Agent.SessionRun.QueueTimeout = timeout
+}
+
+// waitAsync 在后台等待轮到该请求执行
+func waitAsync(ticket *sessionTicket) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- ticket.wait() }()
+	return done
+}
+
+func TestSessionGatePolicies(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		wantBusy   bool // 第二个请求直接返回 ErrSessionBusy
+		wantCancel bool // 第二个请求取消正在执行的运行
+	}{
+		{name: "排队等待", policy: sessionRunQueue},
+		{name: "未配置时排队", policy: ""},
+		{name: "无法识别时排队", policy: "unknown"},
+		{name: "拒绝", policy: sessionRunReject, wantBusy: true},
+		{name: "取消当前运行", policy: sessionRunInterrupt, wantCancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadSessionRunConfig(t, tt.policy, time.Minute)
+			gate := &sessionGate{queues: make(map[string]*sessionQueue)}
+			sessionID := "gate-" + tt.policy
+
+			holder, err := gate.enter(sessionID)
+			if err != nil || holder.queued() {
+				t.Fatalf("first enter error = %v, want to hold the session", err)
+			}
+
+			// 正在执行的运行被取消时交出执行权
+			var cancelled atomic.Bool
+			run := &model.RunRecord{ID: "run-" + sessionID, SessionID: sessionID, MessageID: "run-" + sessionID}
+			activeRuns.register(run, func() {
+				if cancelled.CompareAndSwap(false, true) {
+					holder.release()
+				}
+			}, nil)
+			defer activeRuns.unregister(run.ID)
+
+			second, err := gate.enter(sessionID)
+			if tt.wantBusy {
+				if !errors.Is(err, ErrSessionBusy) {
+					t.Fatalf("second enter error = %v, want ErrSessionBusy", err)
+				}
+				holder.release()
+				if next, err := gate.enter(sessionID); err != nil || next.queued() {
+					t.Fatalf("enter after release = %v; want to hold the session", err)
+				} else {
+					next.release()
+				}
+				return
+			}
+			if err != nil || !second.queued() {
+				t.Fatalf("second enter error = %v, want queued", err)
+			}
+
+			done := waitAsync(second)
+			if !tt.wantCancel {
+				select {
+				case err := <-done:
+					t.Fatalf("queued request started before the run finished: %v", err)
+				case <-time.After(50 * time.Millisecond):
+				}
+				holder.release()
+			}
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("wait() error = %v", err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("queued request did not start")
+			}
+			if cancelled.Load() != tt.wantCancel {
+				t.Errorf("active run cancelled = %v, want %v", cancelled.Load(), tt.wantCancel)
+			}
+
+			// 轮到的请求持有执行权，结束后会话空闲
+			if _, err := gate.tryEnter(sessionID); !errors.Is(err, ErrSessionBusy) {
+				t.Errorf("tryEnter while queued request runs: err = %v, want ErrSessionBusy", err)
+			}
+			second.release()
+			if len(gate.queues) != 0 {
+				t.Errorf("gate still has %d sessions after all runs released", len(gate.queues))
+			}
+		})
+	}
+}
+
+func TestSessionGateQueueOrderAndTimeout(t *testing.T) {
+	loadSessionRunConfig(t, sessionRunQueue, 100*time.Millisecond)
+	gate := &sessionGate{queues: make(map[string]*sessionQueue)}
+
+	holder, _ := gate.enter("s1")
+	first, _ := gate.enter("s1")
+	second, _ := gate.enter("s1")
+
+	// 排队超时后退出队列，不影响后面的请求
+	if err := first.wait(); !errors.Is(err, ErrSessionBusy) {
+		t.Fatalf("wait() after timeout error = %v, want ErrSessionBusy", err)
+	}
+	holder.release()
+	if second.queued() {
+		t.Fatal("second request still queued after the timed out request left")
+	}
+
+	// 重复 release 不会把执行权交出两次
+	third, _ := gate.enter("s1")
+	second.release()
+	second.release()
+	fourth, _ := gate.enter("s1")
+	if third.queued() || !fourth.queued() {
+		t.Errorf("after double release: third queued %v, fourth queued %v; want only fourth queued", third.queued(), fourth.queued())
+	}
+	third.release()
+	fourth.release()
+	if len(gate.queues) != 0 {
+		t.Errorf("gate still has %d sessions", len(gate.queues))
+	}
+}
+
+func TestSessionGateTryEnter(t *testing.T) {
+	loadSessionRunConfig(t, sessionRunQueue, time.Minute)
+	gate := &sessionGate{queues: make(map[string]*sessionQueue)}
+
+	ticket, err := gate.tryEnter("s1")
+	if err != nil {
+		t.Fatalf("tryEnter() on idle session error = %v", err)
+	}
+	// 其他会话不受影响，同一会话的新请求排在后面
+	if other, err := gate.tryEnter("s2"); err != nil {
+		t.Fatalf("tryEnter() on another session error = %v", err)
+	} else {
+		other.release()
+	}
+	if _, err := gate.tryEnter("s1"); !errors.Is(err, ErrSessionBusy) {
+		t.Fatalf("tryEnter() on busy session error = %v, want ErrSessionBusy", err)
+	}
+	next, err := gate.enter("s1")
+	if err != nil || !next.queued() {
+		t.Fatalf("enter() while tryEnter holds the session = %v, want queued", err)
+	}
+	ticket.release()
+	if next.queued() {
+		t.Error("queued request did not start after tryEnter released")
+	}
+	next.release()
+}
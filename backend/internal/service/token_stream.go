@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+// tokenStreamNodes 逐token推送输出的模型节点，summary 的输出已经作为图的结果流推送，不在此列
+var tokenStreamNodes = map[string]bool{
+	"planner":   true,
+	"replanner": true,
+	"execute":   true,
+}
+
+// 发送最终结果前等待 token 推送完成的最长时间
+const tokenStreamWait = 5 * time.Second
+
+// tokenTaskKey 在 context 中传递正在执行的任务ID，并行执行的任务据此区分各自的 token
+type tokenTaskKey struct{}
+
+// withTokenTask 标记 ctx 中的模型调用属于哪个任务
+func withTokenTask(ctx context.Context, taskID string) context.Context {
+	return context.WithValue(ctx, tokenTaskKey{}, taskID)
+}
+
+// runTokenStream 通过 eino 回调把模型节点的流式输出以 token 事件推送给前端，用户不必等到节点完成才看到进展
+// 只推送增量，节点完成后的 node_complete / result_chunk 事件仍然是最终结果
+type runTokenStream struct {
+	progressManager *ProgressManager
+}
+
+// newRunTokenStream 创建运行的 token 推送
+func newRunTokenStream(progressManager *ProgressManager) *runTokenStream {
+	return &runTokenStream{progressManager: progressManager}
+}
+
+// graphOptions 返回注册 token 推送回调的图执行选项
+func (t *runTokenStream) graphOptions() []compose.Option {
+	handler := callbacks.NewHandlerBuilder().
+		OnEndWithStreamOutputFn(t.onEndWithStreamOutput).
+		Build()
+	return []compose.Option{compose.WithCallbacks(handler)}
+}
+
+// onEndWithStreamOutput 回调收到的是模型输出流的副本，在后台读取，不影响图消费原始的流
+func (t *runTokenStream) onEndWithStreamOutput(ctx context.Context, info *callbacks.RunInfo, output *schema.StreamReader[callbacks.CallbackOutput]) context.Context {
+	if info == nil || info.Component != components.ComponentOfChatModel || !tokenStreamNodes[info.Name] {
+		output.Close()
+		return ctx
+	}
+
+	f := &tokenForwarder{
+		progressManager: t.progressManager,
+		node:            info.Name,
+		detectMode:      info.Name == "planner",
+	}
+	if taskID, ok := ctx.Value(tokenTaskKey{}).(string); ok {
+		f.data = map[string]interface{}{"task_id": taskID}
+	}
+
+	t.progressManager.tokenStreams.Add(1)
+	go func() {
+		defer t.progressManager.tokenStreams.Done()
+		defer output.Close()
+		for {
+			chunk, err := output.Recv()
+			if err != nil {
+				break
+			}
+			out := einoModel.ConvCallbackOutput(chunk)
+			if out == nil || out.Message == nil || out.Message.Content == "" {
+				continue
+			}
+			f.push(out.Message.Content)
+		}
+		f.finish()
+	}()
+
+	return ctx
+}
+
+// tokenForwarder 转发一次模型调用的输出增量
+// 规划模型的输出要先识别模式标识：直接回复的内容属于 directReply 节点，去掉标识后作为回复正文推送
+type tokenForwarder struct {
+	progressManager *ProgressManager
+	node            string
+	data            map[string]interface{}
+
+	detectMode bool            // 还在识别模式标识
+	head       strings.Builder // 识别模式标识前缓存的输出
+	trimLeft   bool            // 直接回复去掉标识后开头的空白
+	pending    strings.Builder // 通道繁忙时没有发送的增量
+}
+
+func (f *tokenForwarder) push(delta string) {
+	if f.detectMode {
+		f.head.WriteString(delta)
+		rest, ok := f.resolveMode(f.head.String())
+		if !ok {
+			return
+		}
+		delta = rest
+	}
+	if f.trimLeft {
+		delta = strings.TrimLeftFunc(delta, unicode.IsSpace)
+		if delta == "" {
+			return
+		}
+		f.trimLeft = false
+	}
+
+	f.pending.WriteString(delta)
+	if f.progressManager.SendToken(f.node, f.pending.String(), f.data) {
+		f.pending.Reset()
+	}
+}
+
+// finish 输出结束时发送剩余的内容，没有识别出模式标识的规划输出按规划节点的输出推送
+func (f *tokenForwarder) finish() {
+	if f.detectMode {
+		f.detectMode = false
+		f.pending.WriteString(f.head.String())
+	}
+	if f.pending.Len() > 0 {
+		f.progressManager.SendToken(f.node, f.pending.String(), f.data)
+	}
+}
+
+// resolveMode 根据已缓存的输出判断模式，标识还没有完整输出时返回 false 继续缓存
+func (f *tokenForwarder) resolveMode(head string) (string, bool) {
+	trimmed := strings.TrimLeftFunc(head, unicode.IsSpace)
+	switch {
+	case strings.HasPrefix(trimmed, modeDirectReplyMarker):
+		f.node = "directReply"
+		f.trimLeft = true
+		trimmed = trimmed[len(modeDirectReplyMarker):]
+	case strings.HasPrefix(trimmed, modeTodoListMarker):
+		trimmed = trimmed[len(modeTodoListMarker):]
+	case trimmed == "" || strings.HasPrefix(modeDirectReplyMarker, trimmed) || strings.HasPrefix(modeTodoListMarker, trimmed):
+		return "", false
+	default:
+		// 没有模式标识，是否为直接回复要等完整输出后才能判断，按规划节点的输出推送
+		trimmed = head
+	}
+	f.detectMode = false
+	return trimmed, true
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+
+	"glata-backend/pkg/logger"
+
+	"github.com/cloudwego/eino/components/tool"
+)
+
+// ToolInterceptor 在真实的工具执行前拦截调用，handled 为 true 时直接返回 result，不执行真实的工具
+// 评测时用来模拟工具的返回结果并记录模型调用了哪些工具
+type ToolInterceptor func(ctx context.Context, toolName, argumentsInJSON string) (result string, handled bool, err error)
+
+type toolInterceptorKey struct{}
+
+// WithToolInterceptor 返回带有工具拦截器的 context，用它调用 RunAgent 时本次运行的工具调用都会经过拦截器
+// 拦截器可能被并行执行的任务同时调用
+func WithToolInterceptor(ctx context.Context, interceptor ToolInterceptor) context.Context {
+	return context.WithValue(ctx, toolInterceptorKey{}, interceptor)
+}
+
+// interceptTools 用 context 中的拦截器包装工具，没有拦截器时原样返回
+func interceptTools(ctx context.Context, tools []tool.BaseTool) []tool.BaseTool {
+	interceptor, ok := ctx.Value(toolInterceptorKey{}).(ToolInterceptor)
+	if !ok || interceptor == nil {
+		return tools
+	}
+
+	wrapped := make([]tool.BaseTool, 0, len(tools))
+	for _, t := range tools {
+		info, err := t.Info(ctx)
+		invokable, ok := t.(tool.InvokableTool)
+		if err != nil || !ok {
+			logger.Warnf("Tool cannot be intercepted, using it as is: %v", err)
+			wrapped = append(wrapped, t)
+			continue
+		}
+		wrapped = append(wrapped, &interceptedTool{InvokableTool: invokable, name: info.Name, interceptor: interceptor})
+	}
+	return wrapped
+}
+
+// interceptedTool 先交给拦截器处理，未处理的调用执行真实的工具
+type interceptedTool struct {
+	tool.InvokableTool
+	name        string
+	interceptor ToolInterceptor
+}
+
+func (t *interceptedTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	result, handled, err := t.interceptor(ctx, t.name, argumentsInJSON)
+	if handled || err != nil {
+		return result, err
+	}
+	return t.InvokableTool.InvokableRun(ctx, argumentsInJSON, opts...)
+}
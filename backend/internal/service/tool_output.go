@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/pkg/logger"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+)
+
+// 未配置时的工具输出限制
+const (
+	defaultMaxModelChars  = 4000
+	defaultMaxStreamChars = 500
+)
+
+// toolOutputLimit 获取工具的输出限制，工具未配置的字段使用默认值
+func toolOutputLimit(toolName string) config.ToolOutputLimit {
+	limit := config.ToolOutputLimit{
+		MaxModelChars:  defaultMaxModelChars,
+		MaxStreamChars: defaultMaxStreamChars,
+	}
+	cfg := config.Get()
+	if cfg == nil {
+		return limit
+	}
+
+	outputCfg := cfg.Agent.ToolOutput
+	if outputCfg.Default.MaxModelChars > 0 {
+		limit.MaxModelChars = outputCfg.Default.MaxModelChars
+	}
+	if outputCfg.Default.MaxStreamChars > 0 {
+		limit.MaxStreamChars = outputCfg.Default.MaxStreamChars
+	}
+	if toolLimit, ok := outputCfg.Tools[toolName]; ok {
+		if toolLimit.MaxModelChars > 0 {
+			limit.MaxModelChars = toolLimit.MaxModelChars
+		}
+		if toolLimit.MaxStreamChars > 0 {
+			limit.MaxStreamChars = toolLimit.MaxStreamChars
+		}
+	}
+	return limit
+}
+
+// processedToolOutput 工具输出处理结果
+type processedToolOutput struct {
+	message    *schema.Message // 进入模型上下文的消息
+	stream     string          // 推送给前端的内容
+	artifactID string          // 完整结果的引用，未超出限制时为空
+	size       int             // 完整结果的字符数
+}
+
+// processToolOutput 处理单条工具结果：超出限制时完整结果保存为 artifact，模型只看到首尾截断的内容和引用
+func processToolOutput(sessionID, taskID string, msg *schema.Message) *processedToolOutput {
+	limit := toolOutputLimit(msg.ToolName)
+	size := utf8.RuneCountInString(msg.Content)
+	out := &processedToolOutput{message: msg, stream: msg.Content, size: size}
+
+	if size > limit.MaxModelChars {
+		artifact := &model.Artifact{
+			ID:         uuid.New().String(),
+			SessionID:  sessionID,
+			TaskID:     taskID,
+			ToolName:   msg.ToolName,
+			ToolCallID: msg.ToolCallID,
+			Content:    msg.Content,
+			Size:       size,
+			CreatedAt:  time.Now(),
+		}
+
+		reference := fmt.Sprintf("[输出过长已截断：完整结果共 %d 字符，已保存为 artifact %s]", size, artifact.ID)
+		if globalStorage == nil {
+			reference = fmt.Sprintf("[输出过长已截断：完整结果共 %d 字符]", size)
+		} else if err := globalStorage.SaveArtifact(artifact); err != nil {
+			logger.Errorf("Failed to save output of tool %s as artifact: %v", msg.ToolName, err)
+			reference = fmt.Sprintf("[输出过长已截断：完整结果共 %d 字符，保存失败]", size)
+		} else {
+			out.artifactID = artifact.ID
+		}
+
+		logger.Infof("✂️ Output of tool %s truncated from %d to %d chars for model context (artifact: %s)",
+			msg.ToolName, size, limit.MaxModelChars, out.artifactID)
+
+		cp := *msg
+		cp.Content = truncateMiddle(msg.Content, limit.MaxModelChars) + "\n\n" + reference
+		out.message = &cp
+	}
+
+	if size > limit.MaxStreamChars {
+		out.stream = truncateRunes(msg.Content, limit.MaxStreamChars)
+		if out.artifactID != "" {
+			out.stream += fmt.Sprintf("（共 %d 字符，完整结果见 artifact %s）", size, out.artifactID)
+		} else {
+			out.stream += fmt.Sprintf("（共 %d 字符）", size)
+		}
+	}
+	return out
+}
+
+// truncateMiddle 保留开头和结尾的内容，截断中间部分，错误信息和结论通常出现在输出末尾
+func truncateMiddle(content string, maxRunes int) string {
+	runes := []rune(content)
+	if len(runes) <= maxRunes {
+		return content
+	}
+	head := maxRunes * 7 / 10
+	tail := maxRunes - head
+	return string(runes[:head]) + fmt.Sprintf("\n\n...（中间省略 %d 字符）...\n\n", len(runes)-head-tail) + string(runes[len(runes)-tail:])
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"glata-backend/internal/config"
+	"glata-backend/internal/model"
+	"glata-backend/pkg/logger"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
+	einoModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 未配置时单个字段保存的最大字符数
+const defaultTraceFieldChars = 8000
+
+// 运行结束时等待流式输出记录完成的最长时间
+const traceStreamWait = 5 * time.Second
+
+// traceSpanKey 在 context 中传递当前片段，嵌套的节点、模型和工具调用以它作为父片段
+type traceSpanKey struct{}
+
+// runTrace 通过 eino 回调记录一次运行的执行追踪，运行结束时保存
+// 回调处理器随 context 传递给子图和节点内直接调用的模型，嵌套的调用同样会被记录
+type runTrace struct {
+	mu       sync.Mutex
+	trace    *model.Trace
+	maxChars int
+	streams  sync.WaitGroup
+}
+
+// newRunTrace 创建运行的追踪，未启用时返回 nil；恢复的运行沿用已有的追踪，新片段追加在后面
+func newRunTrace(run *model.RunRecord) *runTrace {
+	cfg := config.Get()
+	if cfg == nil || !cfg.Agent.Trace.Enabled {
+		return nil
+	}
+
+	maxChars := defaultTraceFieldChars
+	if cfg.Agent.Trace.MaxFieldChars > 0 {
+		maxChars = cfg.Agent.Trace.MaxFieldChars
+	}
+
+	trace := &model.Trace{
+		ID:        run.ID,
+		SessionID: run.SessionID,
+		MessageID: run.MessageID,
+		Query:     run.Query,
+		StartedAt: time.Now(),
+	}
+	if run.ResumeCount > 0 && globalStorage != nil {
+		if existing, err := globalStorage.GetTrace(run.ID); err == nil {
+			trace = existing
+			trace.Error = ""
+			trace.EndedAt = nil
+		}
+	}
+	trace.Status = model.RunRunning
+
+	return &runTrace{trace: trace, maxChars: maxChars}
+}
+
+// graphOptions 返回注册追踪回调的图执行选项
+func (t *runTrace) graphOptions() []compose.Option {
+	if t == nil {
+		return nil
+	}
+	handler := callbacks.NewHandlerBuilder().
+		OnStartFn(t.onStart).
+		OnEndFn(t.onEnd).
+		OnErrorFn(t.onError).
+		OnStartWithStreamInputFn(t.onStartWithStreamInput).
+		OnEndWithStreamOutputFn(t.onEndWithStreamOutput).
+		Build()
+	return []compose.Option{compose.WithCallbacks(handler)}
+}
+
+// finish 记录运行的最终状态并保存追踪
+func (t *runTrace) finish(status model.RunStatus, errMsg string) {
+	if t == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(traceStreamWait):
+		logger.Warnf("🧾 Timed out waiting for streamed spans of trace %s", t.trace.ID)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.trace.Status = status
+	t.trace.Error = errMsg
+	t.trace.EndedAt = &now
+	t.trace.DurationMs = now.Sub(t.trace.StartedAt).Milliseconds()
+
+	if globalStorage == nil {
+		return
+	}
+	if err := globalStorage.SaveTrace(t.trace); err != nil {
+		logger.Errorf("Failed to save trace of run %s: %v", t.trace.ID, err)
+		return
+	}
+	logger.Infof("🧾 Trace of run %s saved: %d spans, %d tokens", t.trace.ID, len(t.trace.Spans), t.trace.TokenUsage.TotalTokens)
+}
+
+func (t *runTrace) onStart(ctx context.Context, info *callbacks.RunInfo, input callbacks.CallbackInput) context.Context {
+	span := t.startSpan(ctx, info, false)
+	modelName, content := t.formatInput(info, input)
+
+	t.mu.Lock()
+	span.Input = content
+	if modelName != "" {
+		span.Model = modelName
+	}
+	t.mu.Unlock()
+
+	return context.WithValue(ctx, traceSpanKey{}, span)
+}
+
+func (t *runTrace) onEnd(ctx context.Context, info *callbacks.RunInfo, output callbacks.CallbackOutput) context.Context {
+	span, ok := ctx.Value(traceSpanKey{}).(*model.Span)
+	if !ok {
+		return ctx
+	}
+	modelName, content, usage := t.formatOutput(info, output)
+	t.endSpan(span, modelName, content, usage, nil)
+	return ctx
+}
+
+func (t *runTrace) onError(ctx context.Context, info *callbacks.RunInfo, err error) context.Context {
+	span, ok := ctx.Value(traceSpanKey{}).(*model.Span)
+	if !ok {
+		return ctx
+	}
+	t.endSpan(span, "", "", nil, err)
+	return ctx
+}
+
+func (t *runTrace) onStartWithStreamInput(ctx context.Context, info *callbacks.RunInfo, input *schema.StreamReader[callbacks.CallbackInput]) context.Context {
+	span := t.startSpan(ctx, info, true)
+
+	t.streams.Add(1)
+	go func() {
+		defer t.streams.Done()
+		defer input.Close()
+
+		var chunks []callbacks.CallbackInput
+		for {
+			chunk, err := input.Recv()
+			if err != nil {
+				break
+			}
+			chunks = append(chunks, chunk)
+		}
+
+		content := t.format(concatChunks(chunks))
+		t.mu.Lock()
+		span.Input = content
+		t.mu.Unlock()
+	}()
+
+	return context.WithValue(ctx, traceSpanKey{}, span)
+}
+
+// onEndWithStreamOutput 流式输出在被消费完后才算结束，耗时和token用量在流结束时记录
+func (t *runTrace) onEndWithStreamOutput(ctx context.Context, info *callbacks.RunInfo, output *schema.StreamReader[callbacks.CallbackOutput]) context.Context {
+	span, ok := ctx.Value(traceSpanKey{}).(*model.Span)
+	if !ok {
+		output.Close()
+		return ctx
+	}
+
+	t.mu.Lock()
+	span.Streaming = true
+	t.mu.Unlock()
+
+	t.streams.Add(1)
+	go func() {
+		defer t.streams.Done()
+		defer output.Close()
+
+		var chunks []callbacks.CallbackOutput
+		var streamErr error
+		for {
+			chunk, err := output.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					streamErr = err
+				}
+				break
+			}
+			chunks = append(chunks, chunk)
+		}
+
+		if info != nil && info.Component == components.ComponentOfChatModel {
+			var messages []*schema.Message
+			var modelName string
+			var usage *model.TokenUsage
+			for _, chunk := range chunks {
+				out := einoModel.ConvCallbackOutput(chunk)
+				if out == nil {
+					continue
+				}
+				if out.Message != nil {
+					messages = append(messages, out.Message)
+				}
+				if out.Config != nil && out.Config.Model != "" {
+					modelName = out.Config.Model
+				}
+				if out.TokenUsage != nil {
+					usage = convertTokenUsage(out.TokenUsage)
+				}
+			}
+			content := ""
+			if len(messages) > 0 {
+				if msg, err := schema.ConcatMessages(messages); err == nil {
+					content = t.format(msg)
+				} else {
+					content = t.format(messages)
+				}
+			}
+			t.endSpan(span, modelName, content, usage, streamErr)
+			return
+		}
+
+		t.endSpan(span, "", t.format(concatChunks(chunks)), nil, streamErr)
+	}()
+
+	return ctx
+}
+
+// startSpan 创建片段，父片段取自 context
+func (t *runTrace) startSpan(ctx context.Context, info *callbacks.RunInfo, streaming bool) *model.Span {
+	span := &model.Span{
+		Type:      spanType(info),
+		Streaming: streaming,
+		StartedAt: time.Now(),
+	}
+	if info != nil {
+		span.Name = info.Name
+		span.Component = string(info.Component)
+		if span.Name == "" {
+			span.Name = info.Type
+		}
+	}
+	if span.Name == "" {
+		span.Name = span.Component
+	}
+	if parent, ok := ctx.Value(traceSpanKey{}).(*model.Span); ok {
+		span.ParentID = parent.ID
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span.ID = strconv.Itoa(len(t.trace.Spans) + 1)
+	t.trace.Spans = append(t.trace.Spans, span)
+	return span
+}
+
+// endSpan 记录片段的输出、耗时和token用量，模型调用的用量同时累加到整个追踪
+func (t *runTrace) endSpan(span *model.Span, modelName, output string, usage *model.TokenUsage, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if span.EndedAt != nil {
+		return
+	}
+	now := time.Now()
+	span.EndedAt = &now
+	span.DurationMs = now.Sub(span.StartedAt).Milliseconds()
+	if modelName != "" {
+		span.Model = modelName
+	}
+	if output != "" {
+		span.Output = output
+	}
+	if err != nil {
+		span.Error = err.Error()
+	}
+	if usage != nil {
+		span.TokenUsage = usage
+		t.trace.TokenUsage.Add(*usage)
+	}
+}
+
+// formatInput 模型调用记录请求的消息，工具调用记录参数，其他节点记录输入的JSON
+func (t *runTrace) formatInput(info *callbacks.RunInfo, input callbacks.CallbackInput) (string, string) {
+	switch spanType(info) {
+	case model.SpanChatModel:
+		if in := einoModel.ConvCallbackInput(input); in != nil {
+			modelName := ""
+			if in.Config != nil {
+				modelName = in.Config.Model
+			}
+			return modelName, t.format(in.Messages)
+		}
+	case model.SpanTool:
+		if in := tool.ConvCallbackInput(input); in != nil {
+			return "", t.format(in.ArgumentsInJSON)
+		}
+	}
+	return "", t.format(input)
+}
+
+// formatOutput 模型调用记录回复和token用量，工具调用记录结果，其他节点记录输出的JSON
+func (t *runTrace) formatOutput(info *callbacks.RunInfo, output callbacks.CallbackOutput) (string, string, *model.TokenUsage) {
+	switch spanType(info) {
+	case model.SpanChatModel:
+		if out := einoModel.ConvCallbackOutput(output); out != nil {
+			modelName := ""
+			if out.Config != nil {
+				modelName = out.Config.Model
+			}
+			return modelName, t.format(out.Message), convertTokenUsage(out.TokenUsage)
+		}
+	case model.SpanTool:
+		if out := tool.ConvCallbackOutput(output); out != nil {
+			return "", t.format(out.Response), nil
+		}
+	}
+	return "", t.format(output), nil
+}
+
+// format 将输入输出转换为文本，超出限制时截断
+func (t *runTrace) format(v interface{}) string {
+	var content string
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		content = val
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			content = fmt.Sprintf("%+v", val)
+		} else {
+			content = string(data)
+		}
+	}
+	return truncateRunes(content, t.maxChars)
+}
+
+// spanType 根据 eino 组件类型判断片段类型
+func spanType(info *callbacks.RunInfo) string {
+	if info == nil {
+		return model.SpanNode
+	}
+	switch info.Component {
+	case components.ComponentOfChatModel:
+		return model.SpanChatModel
+	case components.ComponentOfTool:
+		return model.SpanTool
+	case compose.ComponentOfToolsNode:
+		return model.SpanToolsNode
+	case compose.ComponentOfGraph, compose.ComponentOfChain, compose.ComponentOfWorkflow:
+		return model.SpanGraph
+	default:
+		return model.SpanNode
+	}
+}
+
+// concatChunks 合并流式的分片：消息按 eino 的规则拼接，文本直接拼接，其他类型保留分片列表
+func concatChunks[T any](chunks []T) interface{} {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	var messages []*schema.Message
+	var texts []string
+	for _, chunk := range chunks {
+		switch c := any(chunk).(type) {
+		case *schema.Message:
+			messages = append(messages, c)
+		case string:
+			texts = append(texts, c)
+		}
+	}
+	if len(messages) == len(chunks) {
+		if msg, err := schema.ConcatMessages(messages); err == nil {
+			return msg
+		}
+	}
+	if len(texts) == len(chunks) {
+		return strings.Join(texts, "")
+	}
+	if len(chunks) == 1 {
+		return chunks[0]
+	}
+	return chunks
+}
+
+func convertTokenUsage(usage *einoModel.TokenUsage) *model.TokenUsage {
+	if usage == nil {
+		return nil
+	}
+	return &model.TokenUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -53,6 +54,12 @@ func (d *DiskStorage) createDirectories() error {
 		filepath.Join(d.dataDir, "sessions"),
 		filepath.Join(d.dataDir, "messages"),
 		filepath.Join(d.dataDir, "backup"),
+		filepath.Join(d.dataDir, "runs"),
+		filepath.Join(d.dataDir, "artifacts"),
+		filepath.Join(d.dataDir, "approvals"),
+		filepath.Join(d.dataDir, "traces"),
+		filepath.Join(d.dataDir, "memories"),
+		filepath.Join(d.dataDir, "todolists"),
 	}
 	
 	for _, dir := range dirs {
@@ -289,6 +296,37 @@ func (d *DiskStorage) DeleteSession(sessionID string) error {
 	
 	delete(d.cache, sessionID)
 	
+	runs, err := d.listRunsFromDisk(sessionID)
+	if err != nil {
+		logger.Errorf("Failed to list runs of deleted session %s: %v", sessionID, err)
+	}
+	for _, run := range runs {
+		if err := os.Remove(d.runPath(run.ID)); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Failed to delete run %s: %v", run.ID, err)
+		}
+		if err := os.Remove(d.tracePath(run.ID)); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Failed to delete trace %s: %v", run.ID, err)
+		}
+	}
+	
+	artifactFiles, _ := filepath.Glob(filepath.Join(d.dataDir, "artifacts", sessionID+"_*.json"))
+	for _, file := range artifactFiles {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Failed to delete artifact %s: %v", file, err)
+		}
+	}
+	
+	approvalFiles, _ := filepath.Glob(filepath.Join(d.dataDir, "approvals", sessionID+"_*.json"))
+	for _, file := range approvalFiles {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Failed to delete approval %s: %v", file, err)
+		}
+	}
+	
+	if err := os.Remove(d.planPath(sessionID)); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("Failed to delete plan of session %s: %v", sessionID, err)
+	}
+	
 	return d.updateSessionIndex()
 }
 
@@ -368,6 +406,479 @@ func (d *DiskStorage) GetMessages(sessionID string) ([]*model.Message, error) {
 	return messages, nil
 }
 
+func (d *DiskStorage) runPath(runID string) string {
+	return filepath.Join(d.dataDir, "runs", runID+".json")
+}
+
+func (d *DiskStorage) SaveRun(run *model.RunRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	runPath := d.runPath(run.ID)
+	tempPath := runPath + ".tmp"
+	
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	if err := os.Rename(tempPath, runPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	return nil
+}
+
+func (d *DiskStorage) GetRun(runID string) (*model.RunRecord, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	return d.loadRunFromFile(runID)
+}
+
+func (d *DiskStorage) loadRunFromFile(runID string) (*model.RunRecord, error) {
+	data, err := os.ReadFile(d.runPath(runID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRunNotFound
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	var run model.RunRecord
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	return &run, nil
+}
+
+func (d *DiskStorage) ListRuns(sessionID string) ([]*model.RunRecord, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	return d.listRunsFromDisk(sessionID)
+}
+
+func (d *DiskStorage) listRunsFromDisk(sessionID string) ([]*model.RunRecord, error) {
+	files, err := os.ReadDir(filepath.Join(d.dataDir, "runs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*model.RunRecord{}, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	runs := make([]*model.RunRecord, 0)
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		
+		run, err := d.loadRunFromFile(file.Name()[:len(file.Name())-5])
+		if err != nil {
+			logger.Errorf("Failed to load run %s: %v", file.Name(), err)
+			continue
+		}
+		
+		if sessionID == "" || run.SessionID == sessionID {
+			runs = append(runs, run)
+		}
+	}
+	
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+	
+	return runs, nil
+}
+
+// tracePath 追踪与运行一一对应，删除会话时随运行记录一起清理
+func (d *DiskStorage) tracePath(messageID string) string {
+	return filepath.Join(d.dataDir, "traces", messageID+".json")
+}
+
+func (d *DiskStorage) SaveTrace(trace *model.Trace) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	tracePath := d.tracePath(trace.ID)
+	tempPath := tracePath + ".tmp"
+	
+	data, err := json.Marshal(trace)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	if err := os.Rename(tempPath, tracePath); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	return nil
+}
+
+func (d *DiskStorage) GetTrace(messageID string) (*model.Trace, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	data, err := os.ReadFile(d.tracePath(messageID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrTraceNotFound
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	var trace model.Trace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	return &trace, nil
+}
+
+// artifactPath 工具输出按会话ID前缀命名，删除会话时按前缀清理
+func (d *DiskStorage) artifactPath(sessionID, artifactID string) string {
+	return filepath.Join(d.dataDir, "artifacts", sessionID+"_"+artifactID+".json")
+}
+
+func (d *DiskStorage) SaveArtifact(artifact *model.Artifact) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	artifactPath := d.artifactPath(artifact.SessionID, artifact.ID)
+	tempPath := artifactPath + ".tmp"
+	
+	data, err := json.Marshal(artifact)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	if err := os.Rename(tempPath, artifactPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	return nil
+}
+
+func (d *DiskStorage) GetArtifact(sessionID, artifactID string) (*model.Artifact, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	data, err := os.ReadFile(d.artifactPath(sessionID, artifactID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrArtifactNotFound
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	var artifact model.Artifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	return &artifact, nil
+}
+
+// approvalPath 审批记录按会话ID前缀命名，删除会话时按前缀清理
+func (d *DiskStorage) approvalPath(sessionID, approvalID string) string {
+	return filepath.Join(d.dataDir, "approvals", sessionID+"_"+approvalID+".json")
+}
+
+func (d *DiskStorage) SaveApproval(approval *model.ToolApproval) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	approvalPath := d.approvalPath(approval.SessionID, approval.ID)
+	tempPath := approvalPath + ".tmp"
+	
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	if err := os.Rename(tempPath, approvalPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	return nil
+}
+
+func (d *DiskStorage) ListApprovals(sessionID string) ([]*model.ToolApproval, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	prefix := "*"
+	if sessionID != "" {
+		prefix = sessionID + "_*"
+	}
+	files, err := filepath.Glob(filepath.Join(d.dataDir, "approvals", prefix+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	approvals := make([]*model.ToolApproval, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.Errorf("Failed to read approval %s: %v", file, err)
+			continue
+		}
+		var approval model.ToolApproval
+		if err := json.Unmarshal(data, &approval); err != nil {
+			logger.Errorf("Failed to parse approval %s: %v", file, err)
+			continue
+		}
+		approvals = append(approvals, &approval)
+	}
+	
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].CreatedAt.Before(approvals[j].CreatedAt)
+	})
+	
+	return approvals, nil
+}
+
+// planJSONPrefix 每个版本末尾嵌入的结构化计划（HTML注释，渲染markdown时不可见）
+const planJSONPrefix = "<!-- plan:"
+
+// planReasonLabels 按写入版本的节点或操作区分修订原因的标题
+var planReasonLabels = map[string]string{
+	"writeReplan": "🧭 重新规划",
+	"user":        "✏️ 用户修改",
+	"resume":      "🔁 恢复运行",
+	"abort":       "🛑 终止运行",
+	"cancel":      "⛔ 取消运行",
+}
+
+func planReasonLabel(source string) string {
+	if label, ok := planReasonLabels[source]; ok {
+		return label
+	}
+	return "📝 修订说明"
+}
+
+// planPath 计划的全部版本按时间顺序追加在同一个 markdown 文件中
+func (d *DiskStorage) planPath(sessionID string) string {
+	return filepath.Join(d.dataDir, "todolists", sessionID+".md")
+}
+
+// SavePlan 将计划作为新版本追加到文件末尾，分配版本号和写入在同一把锁内完成
+// 每个版本包含 markdown 渲染结果以及嵌入的结构化JSON，读取时只依赖JSON
+func (d *DiskStorage) SavePlan(plan *model.Plan) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	planPath := d.planPath(plan.SessionID)
+	content, err := os.ReadFile(planPath)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	// 按嵌入JSON中的最大版本号分配，markdown 标题和任务内容可能包含任意文本，不作为依据
+	maxVersion := 0
+	for _, saved := range parsePlanVersions(planPath, content) {
+		if saved.Version > maxVersion {
+			maxVersion = saved.Version
+		}
+	}
+	plan.Version = maxVersion + 1
+	plan.CreatedAt = time.Now()
+	
+	planJSON, err := json.Marshal(plan)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	var sb strings.Builder
+	if len(content) == 0 {
+		sb.WriteString(fmt.Sprintf("# TODO List for Session: %s\n\nThis file contains versioned TODO lists generated by the AI agent.\n", plan.SessionID))
+	}
+	sb.WriteString(fmt.Sprintf("\n## Version v%d - %s\n\n", plan.Version, plan.CreatedAt.Format("2006-01-02 15:04:05")))
+	if plan.Reason != "" {
+		sb.WriteString(fmt.Sprintf("> %s：%s\n\n", planReasonLabel(plan.Source), plan.Reason))
+	}
+	sb.WriteString(fmt.Sprintf("%s\n\n%s%s -->\n", plan.Markdown(), planJSONPrefix, planJSON))
+	
+	// 整个文件写入临时文件后替换，写到一半中断也不会留下半个版本
+	tempPath := planPath + ".tmp"
+	if err := os.WriteFile(tempPath, append(content, sb.String()...), 0644); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	if err := os.Rename(tempPath, planPath); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	return plan.Version, nil
+}
+
+func (d *DiskStorage) GetLatestPlan(sessionID string) (*model.Plan, error) {
+	versions, err := d.ListPlanVersions(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	
+	return versions[len(versions)-1], nil
+}
+
+func (d *DiskStorage) ListPlanVersions(sessionID string) ([]*model.Plan, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	planPath := d.planPath(sessionID)
+	content, err := os.ReadFile(planPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	versions := parsePlanVersions(planPath, content)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: no valid plan version in %s", ErrInvalidData, planPath)
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	
+	return versions, nil
+}
+
+// parsePlanVersions 解析计划文件中每个版本嵌入的JSON，跳过损坏的版本
+func parsePlanVersions(planPath string, content []byte) []*model.Plan {
+	var versions []*model.Plan
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, planJSONPrefix) || !strings.HasSuffix(line, "-->") {
+			continue
+		}
+		raw := strings.TrimSuffix(strings.TrimPrefix(line, planJSONPrefix), "-->")
+		
+		var plan model.Plan
+		if err := json.Unmarshal([]byte(raw), &plan); err != nil {
+			logger.Warnf("Skipping corrupted plan version in %s: %v", planPath, err)
+			continue
+		}
+		versions = append(versions, &plan)
+	}
+	return versions
+}
+
+func (d *DiskStorage) memoryPath(memoryID string) string {
+	return filepath.Join(d.dataDir, "memories", memoryID+".json")
+}
+
+func (d *DiskStorage) SaveMemory(memory *model.Memory) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	memoryPath := d.memoryPath(memory.ID)
+	tempPath := memoryPath + ".tmp"
+	
+	data, err := json.MarshalIndent(memory, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	if err := os.Rename(tempPath, memoryPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	return nil
+}
+
+func (d *DiskStorage) GetMemory(memoryID string) (*model.Memory, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	return d.loadMemoryFromFile(memoryID)
+}
+
+func (d *DiskStorage) loadMemoryFromFile(memoryID string) (*model.Memory, error) {
+	data, err := os.ReadFile(d.memoryPath(memoryID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrMemoryNotFound
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	var memory model.Memory
+	if err := json.Unmarshal(data, &memory); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	
+	return &memory, nil
+}
+
+func (d *DiskStorage) ListMemories() ([]*model.Memory, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	
+	files, err := os.ReadDir(filepath.Join(d.dataDir, "memories"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*model.Memory{}, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	memories := make([]*model.Memory, 0)
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		
+		memory, err := d.loadMemoryFromFile(file.Name()[:len(file.Name())-5])
+		if err != nil {
+			logger.Errorf("Failed to load memory %s: %v", file.Name(), err)
+			continue
+		}
+		memories = append(memories, memory)
+	}
+	
+	sort.Slice(memories, func(i, j int) bool {
+		return memories[i].UpdatedAt.After(memories[j].UpdatedAt)
+	})
+	
+	return memories, nil
+}
+
+func (d *DiskStorage) DeleteMemory(memoryID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	if err := os.Remove(d.memoryPath(memoryID)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrMemoryNotFound
+		}
+		return fmt.Errorf("%w: %v", ErrFileOperation, err)
+	}
+	
+	return nil
+}
+
 func (d *DiskStorage) updateSessionIndex() error {
 	sessionsDir := filepath.Join(d.dataDir, "sessions")
 	
@@ -444,7 +955,7 @@ func (d *DiskStorage) Backup() error {
 		return fmt.Errorf("%w: %v", ErrFileOperation, err)
 	}
 	
-	sourceDirs := []string{"sessions", "messages"}
+	sourceDirs := []string{"sessions", "messages", "runs", "artifacts", "approvals", "traces", "memories", "todolists"}
 	for _, dir := range sourceDirs {
 		srcDir := filepath.Join(d.dataDir, dir)
 		dstDir := filepath.Join(backupDir, dir)
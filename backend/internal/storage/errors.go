@@ -3,9 +3,14 @@ package storage
 import "errors"
 
 var (
-	ErrSessionNotFound = errors.New("session not found")
-	ErrMessageNotFound = errors.New("message not found")
-	ErrInvalidData     = errors.New("invalid data")
-	ErrStorageInit     = errors.New("storage initialization failed")
-	ErrFileOperation   = errors.New("file operation failed")
-)
\ No newline at end of file
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrRunNotFound      = errors.New("run not found")
+	ErrArtifactNotFound = errors.New("artifact not found")
+	ErrMemoryNotFound   = errors.New("memory not found")
+	ErrTraceNotFound    = errors.New("trace not found")
+	ErrPlanNotFound     = errors.New("plan not found")
+	ErrInvalidData      = errors.New("invalid data")
+	ErrStorageInit      = errors.New("storage initialization failed")
+	ErrFileOperation    = errors.New("file operation failed")
+)
@@ -16,6 +16,34 @@ type Storage interface {
 	AddMessage(sessionID string, message *model.Message) error
 	GetMessages(sessionID string) ([]*model.Message, error)
 	
+	// 运行记录管理
+	SaveRun(run *model.RunRecord) error
+	GetRun(runID string) (*model.RunRecord, error)
+	ListRuns(sessionID string) ([]*model.RunRecord, error) // sessionID 为空时返回全部
+	
+	// 执行追踪管理，追踪ID与助手消息ID一致
+	SaveTrace(trace *model.Trace) error
+	GetTrace(messageID string) (*model.Trace, error)
+	
+	// 工具输出管理
+	SaveArtifact(artifact *model.Artifact) error
+	GetArtifact(sessionID, artifactID string) (*model.Artifact, error)
+	
+	// 工具调用审批管理，删除会话时一并删除
+	SaveApproval(approval *model.ToolApproval) error
+	ListApprovals(sessionID string) ([]*model.ToolApproval, error) // sessionID 为空时返回全部
+	
+	// 计划管理，每次修改都追加为新版本，删除会话时一并删除
+	SavePlan(plan *model.Plan) (int, error) // 追加为 plan.SessionID 的新版本，写回并返回分配的版本号
+	GetLatestPlan(sessionID string) (*model.Plan, error)
+	ListPlanVersions(sessionID string) ([]*model.Plan, error) // 按版本号升序
+	
+	// 长期记忆管理，记忆不属于某个会话，删除会话时保留
+	SaveMemory(memory *model.Memory) error
+	GetMemory(memoryID string) (*model.Memory, error)
+	ListMemories() ([]*model.Memory, error)
+	DeleteMemory(memoryID string) error
+	
 	// 存储管理
 	Init() error
 	Close() error
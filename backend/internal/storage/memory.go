@@ -2,17 +2,31 @@ package storage
 
 import (
 	"glata-backend/internal/model"
+	"sort"
 	"sync"
+	"time"
 )
 
 type MemoryStorage struct {
-	sessions map[string]*model.Session
-	mu       sync.RWMutex
+	sessions  map[string]*model.Session
+	runs      map[string]*model.RunRecord
+	traces    map[string]*model.Trace
+	artifacts map[string]*model.Artifact
+	approvals map[string]*model.ToolApproval
+	memories  map[string]*model.Memory
+	plans     map[string][]*model.Plan
+	mu        sync.RWMutex
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		sessions: make(map[string]*model.Session),
+		sessions:  make(map[string]*model.Session),
+		runs:      make(map[string]*model.RunRecord),
+		traces:    make(map[string]*model.Trace),
+		artifacts: make(map[string]*model.Artifact),
+		approvals: make(map[string]*model.ToolApproval),
+		memories:  make(map[string]*model.Memory),
+		plans:     make(map[string][]*model.Plan),
 	}
 }
 
@@ -69,6 +83,27 @@ func (m *MemoryStorage) DeleteSession(sessionID string) error {
 	}
 	
 	delete(m.sessions, sessionID)
+	for id, run := range m.runs {
+		if run.SessionID == sessionID {
+			delete(m.runs, id)
+		}
+	}
+	for id, trace := range m.traces {
+		if trace.SessionID == sessionID {
+			delete(m.traces, id)
+		}
+	}
+	for id, artifact := range m.artifacts {
+		if artifact.SessionID == sessionID {
+			delete(m.artifacts, id)
+		}
+	}
+	for id, approval := range m.approvals {
+		if approval.SessionID == sessionID {
+			delete(m.approvals, id)
+		}
+	}
+	delete(m.plans, sessionID)
 	return nil
 }
 
@@ -115,3 +150,205 @@ func (m *MemoryStorage) GetMessages(sessionID string) ([]*model.Message, error)
 }
 
 // ✅ 约束2：更新单个消息渲染结果，严格验证会话ID
+
+// SaveRun 运行记录由调用方继续修改，保存和读取的都是副本
+func (m *MemoryStorage) SaveRun(run *model.RunRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	m.runs[run.ID] = run.Clone()
+	return nil
+}
+
+func (m *MemoryStorage) GetRun(runID string) (*model.RunRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	run, exists := m.runs[runID]
+	if !exists {
+		return nil, ErrRunNotFound
+	}
+	
+	return run.Clone(), nil
+}
+
+func (m *MemoryStorage) ListRuns(sessionID string) ([]*model.RunRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	runs := make([]*model.RunRecord, 0)
+	for _, run := range m.runs {
+		if sessionID == "" || run.SessionID == sessionID {
+			runs = append(runs, run.Clone())
+		}
+	}
+	
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+	
+	return runs, nil
+}
+
+// SaveTrace 追踪由调用方继续追加片段，保存和读取的都是副本
+func (m *MemoryStorage) SaveTrace(trace *model.Trace) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	m.traces[trace.ID] = trace.Clone()
+	return nil
+}
+
+func (m *MemoryStorage) GetTrace(messageID string) (*model.Trace, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	trace, exists := m.traces[messageID]
+	if !exists {
+		return nil, ErrTraceNotFound
+	}
+	
+	return trace.Clone(), nil
+}
+
+func (m *MemoryStorage) SaveArtifact(artifact *model.Artifact) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	m.artifacts[artifact.ID] = artifact
+	return nil
+}
+
+func (m *MemoryStorage) GetArtifact(sessionID, artifactID string) (*model.Artifact, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	artifact, exists := m.artifacts[artifactID]
+	if !exists || artifact.SessionID != sessionID {
+		return nil, ErrArtifactNotFound
+	}
+	
+	return artifact, nil
+}
+
+func (m *MemoryStorage) SaveApproval(approval *model.ToolApproval) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	saved := *approval
+	m.approvals[approval.ID] = &saved
+	return nil
+}
+
+func (m *MemoryStorage) ListApprovals(sessionID string) ([]*model.ToolApproval, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	approvals := make([]*model.ToolApproval, 0)
+	for _, approval := range m.approvals {
+		if sessionID == "" || approval.SessionID == sessionID {
+			saved := *approval
+			approvals = append(approvals, &saved)
+		}
+	}
+	
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].CreatedAt.Before(approvals[j].CreatedAt)
+	})
+	
+	return approvals, nil
+}
+
+// SavePlan 计划由调用方继续修改，保存和读取的都是副本
+func (m *MemoryStorage) SavePlan(plan *model.Plan) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	versions := m.plans[plan.SessionID]
+	plan.Version = 1
+	if len(versions) > 0 {
+		plan.Version = versions[len(versions)-1].Version + 1
+	}
+	plan.CreatedAt = time.Now()
+	
+	m.plans[plan.SessionID] = append(versions, plan.Clone())
+	return plan.Version, nil
+}
+
+func (m *MemoryStorage) GetLatestPlan(sessionID string) (*model.Plan, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	versions := m.plans[sessionID]
+	if len(versions) == 0 {
+		return nil, ErrPlanNotFound
+	}
+	
+	return versions[len(versions)-1].Clone(), nil
+}
+
+func (m *MemoryStorage) ListPlanVersions(sessionID string) ([]*model.Plan, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	versions := m.plans[sessionID]
+	if len(versions) == 0 {
+		return nil, ErrPlanNotFound
+	}
+	
+	plans := make([]*model.Plan, len(versions))
+	for i, plan := range versions {
+		plans[i] = plan.Clone()
+	}
+	
+	return plans, nil
+}
+
+// SaveMemory 记忆会被调用方合并修改，保存和读取的都是副本
+func (m *MemoryStorage) SaveMemory(memory *model.Memory) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	m.memories[memory.ID] = memory.Clone()
+	return nil
+}
+
+func (m *MemoryStorage) GetMemory(memoryID string) (*model.Memory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	memory, exists := m.memories[memoryID]
+	if !exists {
+		return nil, ErrMemoryNotFound
+	}
+	
+	return memory.Clone(), nil
+}
+
+func (m *MemoryStorage) ListMemories() ([]*model.Memory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	memories := make([]*model.Memory, 0, len(m.memories))
+	for _, memory := range m.memories {
+		memories = append(memories, memory.Clone())
+	}
+	
+	sort.Slice(memories, func(i, j int) bool {
+		return memories[i].UpdatedAt.After(memories[j].UpdatedAt)
+	})
+	
+	return memories, nil
+}
+
+func (m *MemoryStorage) DeleteMemory(memoryID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	if _, exists := m.memories[memoryID]; !exists {
+		return ErrMemoryNotFound
+	}
+	
+	delete(m.memories, memoryID)
+	return nil
+}
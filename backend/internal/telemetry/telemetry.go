@@ -0,0 +1,170 @@
+// Package telemetry 初始化 OpenTelemetry 链路追踪，并提供 HTTP 请求的追踪中间件
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"glata-backend/internal/config"
+	"glata-backend/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName 本服务创建 span 使用的 tracer 名称
+const TracerName = "glata-backend"
+
+// 未配置时使用的服务名
+const defaultServiceName = "glata-backend"
+
+// Tracer 返回全局的 tracer，未初始化时为不记录任何内容的 noop 实现
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// Init 按配置创建导出器并设置全局的 TracerProvider，返回退出前用于刷新剩余 span 的关闭函数
+// 未启用时不做任何设置，返回的关闭函数为空操作
+func Init(cfg config.TelemetryConfig) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if !cfg.Enabled {
+		return noop, nil
+	}
+
+	exporter, closeExporter, err := newExporter(cfg)
+	if err != nil {
+		return noop, err
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return noop, fmt.Errorf("failed to create telemetry resource: %w", err)
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	logger.Infof("🔭 OpenTelemetry tracing enabled: exporter=%s service=%s", exporterName(cfg), serviceName)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		closeExporter()
+		return err
+	}, nil
+}
+
+func exporterName(cfg config.TelemetryConfig) string {
+	if cfg.Exporter == "" {
+		return "otlp"
+	}
+	return strings.ToLower(cfg.Exporter)
+}
+
+// newExporter 创建 span 导出器，file 导出器额外返回关闭文件的函数
+func newExporter(cfg config.TelemetryConfig) (sdktrace.SpanExporter, func(), error) {
+	switch exporterName(cfg) {
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+		}
+		return exporter, func() {}, nil
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		return exporter, func() {}, nil
+	case "file":
+		if cfg.FilePath == "" {
+			return nil, nil, fmt.Errorf("telemetry.file_path is required for file exporter")
+		}
+		if err := os.MkdirAll(filepath.Dir(cfg.FilePath), 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create telemetry directory: %w", err)
+		}
+		file, err := os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open telemetry file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		return exporter, func() { file.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported telemetry exporter: %s, supported exporters: [otlp stdout file]", cfg.Exporter)
+	}
+}
+
+// Detach 返回不随请求取消的 context，保留其中的 span，后台执行的运行仍然挂在发起它的请求下
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// Middleware 为每个 HTTP 请求创建 server span，请求头中带有 traceparent 时接入上游的链路
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := Tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+		if len(c.Errors) > 0 {
+			span.RecordError(c.Errors.Last())
+		}
+	}
+}
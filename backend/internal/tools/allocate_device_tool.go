@@ -29,25 +29,22 @@ func (t *AllocateDeviceTool) Info(ctx context.Context) (*schema.ToolInfo, error)
 func (t *AllocateDeviceTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("allocate_device", err)
 	}
 
 	intention, _ := params["intention"].(string)
 
 	// TODO: Implement actual device allocation logic
 	// This would typically call an external service API
-	result := map[string]interface{}{
-		"success": true,
+	result := NewSuccessResult("allocate_device", map[string]interface{}{
 		"message": fmt.Sprintf("设备申请请求已提交: %s", intention),
 		"data": map[string]interface{}{
 			"request_id": "dev_req_" + fmt.Sprintf("%d", len(intention)),
 			"status":     "pending",
 			"intention":  intention,
 		},
-	}
-
-	resultBytes, _ := json.Marshal(result)
-	return string(resultBytes), nil
+	})
+	return result.String(), nil
 }
 
 // GetAllocateDeviceTool returns the device allocation tool
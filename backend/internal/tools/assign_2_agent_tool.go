@@ -3,7 +3,6 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
@@ -49,7 +48,7 @@ func (t *Assign2AgentTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
 func (t *Assign2AgentTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("assign_2_agent", err)
 	}
 
 	chatId, _ := params["chat_id"].(string)
@@ -87,16 +86,7 @@ func (t *Assign2AgentTool) InvokableRun(ctx context.Context, argumentsInJSON str
 		RequestBody: string(requestBodyBytes),
 	}
 
-	response, err := makeToolHTTPRequest(ctx, baseReq)
-	if err != nil {
-		return fmt.Sprintf(`{"success": false, "error": "%s"}`, err.Error()), nil
-	}
-
-	resultBytes, _ := json.Marshal(map[string]interface{}{
-		"success": true,
-		"data":    response.Result,
-	})
-	return string(resultBytes), nil
+	return callAtomicAbility(ctx, "assign_2_agent", baseReq), nil
 }
 
 // GetAssign2AgentTool returns the assign to agent tool
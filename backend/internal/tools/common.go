@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 )
@@ -30,15 +32,16 @@ type BaseResponse struct {
 }
 
 // HTTP client for tool calls
+// 返回的错误均为 *ToolError，按失败原因区分错误码和是否可重试
 func makeToolHTTPRequest(ctx context.Context, params BaseRequest) (*BaseResponse, error) {
 	data, err := json.Marshal(params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, newToolError(ErrCodeInternal, false, "failed to marshal request: %v", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", TOOL_URL, bytes.NewBuffer(data))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, newToolError(ErrCodeInternal, false, "failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -47,23 +50,55 @@ func makeToolHTTPRequest(ctx context.Context, params BaseRequest) (*BaseResponse
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil, newToolError(ErrCodeTimeout, true, "request timed out: %v", err)
+		}
+		return nil, newToolError(ErrCodeNetwork, true, "failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, httpStatusError(resp.StatusCode, string(body))
 	}
 
 	var response BaseResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, newToolError(ErrCodeInvalidResponse, false, "failed to decode response: %v", err)
 	}
 
 	if response.BaseResp.StatusCode != 0 {
-		return nil, fmt.Errorf("tool call failed: %s", response.BaseResp.StatusMessage)
+		return nil, newToolError(ErrCodeUpstreamRejected, false, "tool call failed: %s", response.BaseResp.StatusMessage)
 	}
 
 	return &response, nil
-}
\ No newline at end of file
+}
+
+// httpStatusError 按HTTP状态码区分错误类型：限流和服务端错误可以重试，其他客户端错误重试也不会成功
+func httpStatusError(statusCode int, body string) *ToolError {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return newToolError(ErrCodeUnauthorized, false, "request failed with status %d: %s", statusCode, body)
+	case statusCode == http.StatusNotFound:
+		return newToolError(ErrCodeNotFound, false, "request failed with status %d: %s", statusCode, body)
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return newToolError(ErrCodeUpstreamError, true, "request failed with status %d: %s", statusCode, body)
+	default:
+		return newToolError(ErrCodeUpstreamRejected, false, "request failed with status %d: %s", statusCode, body)
+	}
+}
+
+// callAtomicAbility 调用原子能力接口，并将结果包装为统一的工具结果
+func callAtomicAbility(ctx context.Context, toolName string, params BaseRequest) string {
+	response, err := makeToolHTTPRequest(ctx, params)
+	if err != nil {
+		return ErrorResultFrom(toolName, err).String()
+	}
+	return NewSuccessResult(toolName, response.Result).String()
+}
+
+// invalidArgumentsResult 参数解析失败时的结果，模型修正参数后可以重试
+func invalidArgumentsResult(toolName string, err error) (string, error) {
+	return NewErrorResult(toolName, ErrCodeInvalidArguments, fmt.Sprintf("failed to parse arguments: %v", err), true).String(), nil
+}
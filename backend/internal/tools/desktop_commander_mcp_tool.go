@@ -149,7 +149,7 @@ func loadDesktopCommanderTools() ([]tool.BaseTool, error) {
 		toolsChan <- struct {
 			tools []tool.BaseTool
 			err   error
-		}{mcpTools, err}
+		}{WrapMCPTools(ctx, mcpTools), err}
 	}()
 
 	// 等待工具获取完成或超时
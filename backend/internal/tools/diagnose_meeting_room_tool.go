@@ -3,7 +3,6 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
@@ -39,7 +38,7 @@ func (t *DiagnoseMeetingRoomTool) Info(ctx context.Context) (*schema.ToolInfo, e
 func (t *DiagnoseMeetingRoomTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("diagnose_meeting_room", err)
 	}
 
 	meetingRoomIds, _ := params["meeting_room_ids"].([]interface{})
@@ -66,16 +65,7 @@ func (t *DiagnoseMeetingRoomTool) InvokableRun(ctx context.Context, argumentsInJ
 		RequestBody: string(requestBodyBytes),
 	}
 
-	response, err := makeToolHTTPRequest(ctx, baseReq)
-	if err != nil {
-		return fmt.Sprintf(`{"success": false, "error": "%s"}`, err.Error()), nil
-	}
-
-	resultBytes, _ := json.Marshal(map[string]interface{}{
-		"success": true,
-		"data":    response.Result,
-	})
-	return string(resultBytes), nil
+	return callAtomicAbility(ctx, "diagnose_meeting_room", baseReq), nil
 }
 
 // GetDiagnoseMeetingRoomTool returns the meeting room diagnosis tool
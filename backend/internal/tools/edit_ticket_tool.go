@@ -3,7 +3,6 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
@@ -44,7 +43,7 @@ func (t *EditTicketTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
 func (t *EditTicketTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("edit_ticket", err)
 	}
 
 	operator, _ := params["operator"].(string)
@@ -72,16 +71,7 @@ func (t *EditTicketTool) InvokableRun(ctx context.Context, argumentsInJSON strin
 		RequestBody: string(requestBodyBytes),
 	}
 
-	response, err := makeToolHTTPRequest(ctx, baseReq)
-	if err != nil {
-		return fmt.Sprintf(`{"success": false, "error": "%s"}`, err.Error()), nil
-	}
-
-	resultBytes, _ := json.Marshal(map[string]interface{}{
-		"success": true,
-		"data":    response.Result,
-	})
-	return string(resultBytes), nil
+	return callAtomicAbility(ctx, "edit_ticket", baseReq), nil
 }
 
 // GetEditTicketTool returns the ticket editing tool
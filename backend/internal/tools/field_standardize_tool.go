@@ -3,7 +3,6 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
@@ -54,7 +53,7 @@ func (t *FieldStandardizeTool) Info(ctx context.Context) (*schema.ToolInfo, erro
 func (t *FieldStandardizeTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("field_standardize", err)
 	}
 
 	location, _ := params["location"].(string)
@@ -87,16 +86,7 @@ func (t *FieldStandardizeTool) InvokableRun(ctx context.Context, argumentsInJSON
 		RequestBody: string(requestBodyBytes),
 	}
 
-	response, err := makeToolHTTPRequest(ctx, baseReq)
-	if err != nil {
-		return fmt.Sprintf(`{"success": false, "error": "%s"}`, err.Error()), nil
-	}
-
-	resultBytes, _ := json.Marshal(map[string]interface{}{
-		"success": true,
-		"data":    response.Result,
-	})
-	return string(resultBytes), nil
+	return callAtomicAbility(ctx, "field_standardize", baseReq), nil
 }
 
 // GetFieldStandardizeTool returns the field standardization tool
@@ -33,5 +33,5 @@ func GetGaodeMapMCPTool() []tool.BaseTool {
 		Cli:                   cli,
 		ToolCallResultHandler: CreateMCPErrorHandler(),
 	})
-	return mcpTools
+	return WrapMCPTools(ctx, mcpTools)
 }
@@ -3,7 +3,6 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
@@ -39,7 +38,7 @@ func (t *HandOverHelpdeskTool) Info(ctx context.Context) (*schema.ToolInfo, erro
 func (t *HandOverHelpdeskTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("hand_over_helpdesk", err)
 	}
 
 	operator, _ := params["operator"].(string)
@@ -64,16 +63,7 @@ func (t *HandOverHelpdeskTool) InvokableRun(ctx context.Context, argumentsInJSON
 		RequestBody: string(requestBodyBytes),
 	}
 
-	response, err := makeToolHTTPRequest(ctx, baseReq)
-	if err != nil {
-		return fmt.Sprintf(`{"success": false, "error": "%s"}`, err.Error()), nil
-	}
-
-	resultBytes, _ := json.Marshal(map[string]interface{}{
-		"success": true,
-		"data":    response.Result,
-	})
-	return string(resultBytes), nil
+	return callAtomicAbility(ctx, "hand_over_helpdesk", baseReq), nil
 }
 
 // GetHandOverHelpdeskTool returns the handover to helpdesk tool
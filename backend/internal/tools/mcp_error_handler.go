@@ -3,61 +3,34 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 
+	"github.com/cloudwego/eino/components/tool"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
-// MCPErrorResult 定义MCP工具错误结果的统一格式
-type MCPErrorResult struct {
-	Success        bool        `json:"success"`
-	Error          bool        `json:"error"`
-	ErrorMessage   string      `json:"error_message"`
-	ToolName       string      `json:"tool_name"`
-	OriginalResult interface{} `json:"original_result,omitempty"`
-}
-
-// CreateMCPErrorHandler 创建统一的MCP错误处理器
-// 该处理器会将MCP工具执行错误转换为正常的结果，避免Graph执行中断
+// CreateMCPErrorHandler 创建统一的MCP结果处理器
+// 该处理器会将MCP工具的结果转换为统一的 ToolResult 格式，执行错误也转换为正常的结果，避免Graph执行中断
 func CreateMCPErrorHandler() func(ctx context.Context, name string, result *mcp.CallToolResult) (*mcp.CallToolResult, error) {
 	return func(ctx context.Context, name string, result *mcp.CallToolResult) (*mcp.CallToolResult, error) {
-		// 如果工具执行成功，直接返回原结果
-		if !result.IsError {
-			return result, nil
+		var toolResult *ToolResult
+		if result == nil || result.IsError {
+			// 记录工具执行错误日志
+			log.Printf("MCP工具 '%s' 执行失败，转换为错误结果格式", name)
+			// MCP工具的错误多为临时状态或参数问题，允许重试
+			toolResult = NewErrorResult(name, ErrCodeMCPError, extractErrorMessage(result), true)
+		} else {
+			toolResult = NewSuccessResult(name, extractPayload(result))
 		}
 
-		// 记录工具执行错误日志
-		log.Printf("MCP工具 '%s' 执行失败，转换为错误结果格式", name)
-
-		// 构造错误结果
-		errorResult := MCPErrorResult{
-			Success:        false,
-			Error:          true,
-			ErrorMessage:   extractErrorMessage(result),
-			ToolName:       name,
-			OriginalResult: result,
-		}
-
-		// 将错误结果序列化为JSON
-		errorJSON, err := json.Marshal(errorResult)
-		if err != nil {
-			log.Printf("序列化MCP错误结果失败: %v", err)
-			// 如果序列化失败，创建一个简单的错误消息
-			errorJSON = []byte(`{
-				"success": false,
-				"error": true,
-				"error_message": "工具执行失败且无法序列化错误信息",
-				"tool_name": "` + name + `"
-			}`)
-		}
-
-		// 返回标记为成功的结果，但内容包含错误信息
+		// 返回标记为成功的结果，执行状态记录在内容中
 		// 关键：设置IsError为false，避免Graph层面的中断
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				&mcp.TextContent{
 					Type: "text",
-					Text: string(errorJSON),
+					Text: toolResult.String(),
 				},
 			},
 			IsError: false, // 关键：设置为false避免Graph中断执行
@@ -65,6 +38,22 @@ func CreateMCPErrorHandler() func(ctx context.Context, name string, result *mcp.
 	}
 }
 
+// extractPayload 从MCP结果中提取内容，只有一段文本时直接使用文本
+func extractPayload(result *mcp.CallToolResult) interface{} {
+	var texts []string
+	for _, content := range result.Content {
+		textContent, ok := content.(*mcp.TextContent)
+		if !ok {
+			return result.Content
+		}
+		texts = append(texts, textContent.Text)
+	}
+	if len(texts) == 1 {
+		return texts[0]
+	}
+	return texts
+}
+
 // extractErrorMessage 从MCP结果中提取错误信息
 func extractErrorMessage(result *mcp.CallToolResult) string {
 	if result == nil {
@@ -85,20 +74,52 @@ func extractErrorMessage(result *mcp.CallToolResult) string {
 	return "MCP工具执行失败"
 }
 
-// IsMCPErrorResult 检查工具结果是否为MCP错误结果
-// 此函数可用于update节点中识别失败的工具调用
-func IsMCPErrorResult(resultText string) (bool, *MCPErrorResult) {
-	var errorResult MCPErrorResult
-	
-	err := json.Unmarshal([]byte(resultText), &errorResult)
-	if err != nil {
-		return false, nil
+// mcpResultTool 包装MCP工具：调用失败时返回统一的错误结果而不是中断Graph，成功时去掉MCP协议的外层结构，直接返回 ToolResult
+type mcpResultTool struct {
+	tool.InvokableTool
+	name string
+}
+
+// WrapMCPTools 包装MCP工具，使其与原子工具一样直接返回 ToolResult
+func WrapMCPTools(ctx context.Context, mcpTools []tool.BaseTool) []tool.BaseTool {
+	wrapped := make([]tool.BaseTool, 0, len(mcpTools))
+	for _, t := range mcpTools {
+		invokable, ok := t.(tool.InvokableTool)
+		if !ok {
+			wrapped = append(wrapped, t)
+			continue
+		}
+		info, err := t.Info(ctx)
+		if err != nil {
+			wrapped = append(wrapped, t)
+			continue
+		}
+		wrapped = append(wrapped, &mcpResultTool{InvokableTool: invokable, name: info.Name})
 	}
+	return wrapped
+}
 
-	// 检查是否为错误结果格式
-	if errorResult.Error && !errorResult.Success {
-		return true, &errorResult
+func (t *mcpResultTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	output, err := t.InvokableTool.InvokableRun(ctx, argumentsInJSON, opts...)
+	if err != nil {
+		log.Printf("MCP工具 '%s' 调用失败: %v", t.name, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return NewErrorResult(t.name, ErrCodeTimeout, err.Error(), true).String(), nil
+		}
+		return NewErrorResult(t.name, ErrCodeMCPError, err.Error(), true).String(), nil
 	}
 
-	return false, nil
-}
\ No newline at end of file
+	// 结果处理器已将内容转换为 ToolResult，这里去掉 CallToolResult 的外层结构
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err == nil && len(result.Content) == 1 {
+		if _, ok := ParseToolResult(result.Content[0].Text); ok {
+			return result.Content[0].Text, nil
+		}
+	}
+	return output, nil
+}
@@ -3,7 +3,6 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
@@ -49,7 +48,7 @@ func (t *RepairMeetingRoomTool) Info(ctx context.Context) (*schema.ToolInfo, err
 func (t *RepairMeetingRoomTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("repair_meeting_room", err)
 	}
 
 	meetingRoomId, _ := params["meeting_room_id"].(string)
@@ -72,16 +71,7 @@ func (t *RepairMeetingRoomTool) InvokableRun(ctx context.Context, argumentsInJSO
 		RequestBody: string(requestBodyBytes),
 	}
 
-	response, err := makeToolHTTPRequest(ctx, baseReq)
-	if err != nil {
-		return fmt.Sprintf(`{"success": false, "error": "%s"}`, err.Error()), nil
-	}
-
-	resultBytes, _ := json.Marshal(map[string]interface{}{
-		"success": true,
-		"data":    response.Result,
-	})
-	return string(resultBytes), nil
+	return callAtomicAbility(ctx, "repair_meeting_room", baseReq), nil
 }
 
 // GetRepairMeetingRoomTool returns the meeting room repair tool
@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ToolStatus 工具执行状态
+type ToolStatus string
+
+const (
+	ToolStatusSuccess ToolStatus = "success"
+	ToolStatusError   ToolStatus = "error"
+)
+
+// 工具错误码
+const (
+	ErrCodeInvalidArguments = "invalid_arguments" // 参数无法解析或不合法
+	ErrCodeTimeout          = "timeout"           // 调用超时
+	ErrCodeNetwork          = "network_error"     // 网络不可达、连接失败
+	ErrCodeUnauthorized     = "unauthorized"      // 认证或授权失败
+	ErrCodeNotFound         = "not_found"         // 请求的资源不存在
+	ErrCodeUpstreamError    = "upstream_error"    // 服务端错误，可能是临时故障
+	ErrCodeUpstreamRejected = "upstream_rejected" // 服务端拒绝了请求或返回业务错误
+	ErrCodeInvalidResponse  = "invalid_response"  // 服务端返回的内容无法解析
+	ErrCodeMCPError         = "mcp_error"         // MCP工具返回错误
+	ErrCodeDenied           = "denied"            // 被审批策略禁止或用户拒绝
+	ErrCodeInternal         = "internal_error"    // 本地处理出错
+)
+
+// ToolResult 所有工具返回给模型的统一结果格式
+// Status 和 ErrorCode 供任务更新节点判断成功与否，Retryable 决定失败后是否值得重试
+type ToolResult struct {
+	Status       ToolStatus  `json:"status"`
+	ToolName     string      `json:"tool_name,omitempty"`
+	ErrorCode    string      `json:"error_code,omitempty"`
+	ErrorMessage string      `json:"error_message,omitempty"`
+	Retryable    bool        `json:"retryable"`
+	Payload      interface{} `json:"payload,omitempty"`
+}
+
+// IsError 工具是否执行失败
+func (r *ToolResult) IsError() bool {
+	return r.Status == ToolStatusError
+}
+
+// String 序列化为返回给模型的JSON
+func (r *ToolResult) String() string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		data, _ = json.Marshal(&ToolResult{
+			Status:       ToolStatusError,
+			ToolName:     r.ToolName,
+			ErrorCode:    ErrCodeInternal,
+			ErrorMessage: fmt.Sprintf("failed to marshal tool result: %v", err),
+		})
+	}
+	return string(data)
+}
+
+// NewSuccessResult 构造成功结果
+func NewSuccessResult(toolName string, payload interface{}) *ToolResult {
+	return &ToolResult{Status: ToolStatusSuccess, ToolName: toolName, Payload: payload}
+}
+
+// NewErrorResult 构造失败结果
+func NewErrorResult(toolName, code, message string, retryable bool) *ToolResult {
+	return &ToolResult{
+		Status:       ToolStatusError,
+		ToolName:     toolName,
+		ErrorCode:    code,
+		ErrorMessage: message,
+		Retryable:    retryable,
+	}
+}
+
+// ErrorResultFrom 将错误转换为失败结果，ToolError 保留错误码和是否可重试，其他错误视为可重试的内部错误
+func ErrorResultFrom(toolName string, err error) *ToolResult {
+	var toolErr *ToolError
+	if errors.As(err, &toolErr) {
+		return NewErrorResult(toolName, toolErr.Code, toolErr.Message, toolErr.Retryable)
+	}
+	return NewErrorResult(toolName, ErrCodeInternal, err.Error(), true)
+}
+
+// ParseToolResult 解析工具返回的内容，不是统一结果格式时返回 false
+func ParseToolResult(content string) (*ToolResult, bool) {
+	var result ToolResult
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		return nil, false
+	}
+	if result.Status != ToolStatusSuccess && result.Status != ToolStatusError {
+		return nil, false
+	}
+	return &result, true
+}
+
+// ToolError 带错误码的工具错误
+type ToolError struct {
+	Code      string
+	Message   string
+	Retryable bool
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newToolError(code string, retryable bool, format string, args ...interface{}) *ToolError {
+	return &ToolError{Code: code, Message: fmt.Sprintf(format, args...), Retryable: retryable}
+}
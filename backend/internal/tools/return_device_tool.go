@@ -29,25 +29,22 @@ func (t *ReturnDeviceTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
 func (t *ReturnDeviceTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
 	var params map[string]interface{}
 	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
-		return "", fmt.Errorf("failed to parse arguments: %w", err)
+		return invalidArgumentsResult("return_device", err)
 	}
 
 	intention, _ := params["intention"].(string)
 
 	// TODO: Implement actual device return logic
 	// This would typically call an external service API
-	result := map[string]interface{}{
-		"success": true,
+	result := NewSuccessResult("return_device", map[string]interface{}{
 		"message": fmt.Sprintf("设备退还请求已提交: %s", intention),
 		"data": map[string]interface{}{
 			"request_id": "return_req_" + fmt.Sprintf("%d", len(intention)),
 			"status":     "pending",
 			"intention":  intention,
 		},
-	}
-
-	resultBytes, _ := json.Marshal(result)
-	return string(resultBytes), nil
+	})
+	return result.String(), nil
 }
 
 // GetReturnDeviceTool returns the device return tool